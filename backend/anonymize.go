@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Rows rewritten per table, and the tables emptied
+type AnonymizeReport struct {
+	Tables    map[string]int `json:"tables"`
+	Truncated []string       `json:"truncated"`
+}
+
+// Tables emptied rather than rewritten: tokens and the audit trail, and the
+// key/value entries, which hold replayed responses with user data in them
+var truncatedTables = []string{"refresh_tokens", "audit_log", "kv_entries"}
+
+// anonymize-db subcommand
+// Rewrites every PII column from the redaction registry with deterministic
+// fake values, so the same original always maps to the same fake one.
+func runAnonymize(config Config, args []string) {
+	fs := flag.NewFlagSet("anonymize-db", flag.ExitOnError)
+	batchSize := fs.Int("batch-size", 1000, "rows updated per transaction")
+	fs.Parse(args)
+
+	key := os.Getenv("ANONYMIZE_KEY")
+	if key == "" {
+		log.Fatal("ANONYMIZE_KEY must be set to anonymize the database")
+	}
+	if err := checkAnonymizeTarget(config.DatabaseURL); err != nil {
+		log.Fatalf("Refusing to anonymize: %v", err)
+	}
+
+	db := ConnectDatabase(config)
+	defer db.Close()
+
+	report, err := anonymizeDatabase(db, []byte(key), *batchSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	json.NewEncoder(os.Stdout).Encode(report)
+}
+
+// Why a database may not be anonymized, nil when it may
+// PRODUCTION_DB_IDENTIFIERS has to be set, without it production cannot be
+// told apart, and the URL must not contain any of them in any case.
+func checkAnonymizeTarget(databaseURL string) error {
+	var identifiers []string
+	for _, id := range strings.Split(os.Getenv("PRODUCTION_DB_IDENTIFIERS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			identifiers = append(identifiers, id)
+		}
+	}
+	if len(identifiers) == 0 {
+		return errors.New("PRODUCTION_DB_IDENTIFIERS must be set so production can be told apart")
+	}
+	for _, id := range identifiers {
+		if strings.Contains(strings.ToLower(databaseURL), strings.ToLower(id)) {
+			return fmt.Errorf("DATABASE_URL matches the production identifier %q", id)
+		}
+	}
+	return nil
+}
+
+// Rewrite the PII of every table and empty the tables that are not kept
+func anonymizeDatabase(db *sql.DB, key []byte, batchSize int) (AnonymizeReport, error) {
+	report := AnonymizeReport{Tables: map[string]int{}}
+	for table, columns := range piiColumnsByTable() {
+		n, err := anonymizeTable(db, key, table, columns, batchSize)
+		if err != nil {
+			return report, fmt.Errorf("anonymizing %s failed after %d rows: %w", table, n, err)
+		}
+		report.Tables[table] = n
+	}
+
+	quoted := make([]string, len(truncatedTables))
+	for i, table := range truncatedTables {
+		quoted[i] = pq.QuoteIdentifier(table)
+	}
+	if _, err := db.Exec("TRUNCATE " + strings.Join(quoted, ", ")); err != nil {
+		return report, fmt.Errorf("emptying %s: %w", strings.Join(truncatedTables, ", "), err)
+	}
+	report.Truncated = truncatedTables
+	return report, nil
+}
+
+// PII columns grouped by table
+func piiColumnsByTable() map[string][]handlers.PIIColumn {
+	tables := map[string][]handlers.PIIColumn{}
+	for _, col := range handlers.Redactions.Columns() {
+		tables[col.Table] = append(tables[col.Table], col)
+	}
+	return tables
+}
+
+// Rewrite the PII columns of one table in id-ordered batches
+func anonymizeTable(db *sql.DB, key []byte, table string, columns []handlers.PIIColumn, batchSize int) (int, error) {
+	names := make([]string, len(columns))
+	sets := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = pq.QuoteIdentifier(col.Column)
+		sets[i] = fmt.Sprintf("%s = $%d", names[i], i+2)
+	}
+	selectSQL := fmt.Sprintf("SELECT id, %s FROM %s WHERE id > $1 ORDER BY id LIMIT $2", strings.Join(names, ", "), pq.QuoteIdentifier(table))
+	updateSQL := fmt.Sprintf("UPDATE %s SET %s WHERE id = $1", pq.QuoteIdentifier(table), strings.Join(sets, ", "))
+
+	total := 0
+	var lastId models.ID
+	for {
+		tx, err := db.Begin()
+		if err != nil {
+			return total, err
+		}
+
+		rows, err := tx.Query(selectSQL, lastId, batchSize)
+		if err != nil {
+			tx.Rollback()
+			return total, err
+		}
+
+		var updates [][]interface{}
+		for rows.Next() {
+			var id models.ID
+			values := make([]sql.NullString, len(columns))
+			dest := []interface{}{&id}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return total, err
+			}
+
+			args := []interface{}{id}
+			for i, col := range columns {
+				args = append(args, fakeValue(key, col.Kind, values[i]))
+			}
+			updates = append(updates, args)
+			lastId = id
+		}
+		rows.Close()
+
+		for _, args := range updates {
+			if _, err := tx.Exec(updateSQL, args...); err != nil {
+				tx.Rollback()
+				return total, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return total, err
+		}
+
+		total += len(updates)
+		if len(updates) < batchSize {
+			return total, nil
+		}
+		log.Printf("Anonymized %d rows of %s", total, table)
+	}
+}
+
+// Deterministic stand-in for a PII value
+// Free text is dropped, everything else is derived from an HMAC of the original.
+func fakeValue(key []byte, kind string, original sql.NullString) sql.NullString {
+	if !original.Valid || kind == "free_text" {
+		return sql.NullString{}
+	}
+
+	input := original.String
+	if kind == "email" {
+		input = strings.ToLower(strings.TrimSpace(input))
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(kind + ":" + input))
+	digest := hex.EncodeToString(mac.Sum(nil))[:16]
+
+	switch kind {
+	case "email":
+		return sql.NullString{String: "user-" + digest + "@example.invalid", Valid: true}
+	case "name":
+		return sql.NullString{String: "User " + digest[:8], Valid: true}
+	default:
+		return sql.NullString{String: digest, Valid: true}
+	}
+}
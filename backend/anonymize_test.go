@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+func valid(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
+
+func TestFakeValuesAreDeterministic(t *testing.T) {
+	key := []byte("staging-key")
+	email := fakeValue(key, "email", valid("Ada.Lovelace@Example.com"))
+	if again := fakeValue(key, "email", valid("Ada.Lovelace@Example.com")); again != email {
+		t.Fatalf("same email mapped to %q and %q", email.String, again.String)
+	}
+	// Emails are compared like the unique index does, so duplicates stay duplicates
+	if spelled := fakeValue(key, "email", valid("  ada.lovelace@example.COM ")); spelled != email {
+		t.Fatalf("the same email spelled differently mapped to %q, want %q", spelled.String, email.String)
+	}
+	if other := fakeValue([]byte("another-key"), "email", valid("Ada.Lovelace@Example.com")); other == email {
+		t.Fatal("another key gave the same fake email")
+	}
+	if !strings.HasSuffix(email.String, "@example.invalid") {
+		t.Fatalf("fake email %q is not on example.invalid", email.String)
+	}
+	// The same text is another value as a name than as an email
+	if name := fakeValue(key, "name", valid("ada.lovelace@example.com")); name.String == email.String || !strings.HasPrefix(name.String, "User ") {
+		t.Fatalf("fake name = %q", name.String)
+	}
+
+	if v := fakeValue(key, "email", sql.NullString{}); v.Valid {
+		t.Fatalf("NULL became %q", v.String)
+	}
+	if v := fakeValue(key, "free_text", valid("Likes analytical engines")); v.Valid {
+		t.Fatalf("free text became %q, want NULL", v.String)
+	}
+}
+
+// Distinct originals stay distinct, so unique indexes still hold
+func TestFakeValuesAreUnique(t *testing.T) {
+	key := []byte("staging-key")
+	seen := map[string]string{}
+	for i := 0; i < 10000; i++ {
+		original := fmt.Sprintf("user%d@example.com", i)
+		fake := fakeValue(key, "email", valid(original)).String
+		if previous, ok := seen[fake]; ok {
+			t.Fatalf("%s and %s both became %s", previous, original, fake)
+		}
+		seen[fake] = original
+		if strings.Contains(fake, fmt.Sprintf("user%d", i)) || strings.Contains(fake, "example.com") {
+			t.Fatalf("fake email %s gives away %s", fake, original)
+		}
+	}
+}
+
+func TestAnonymizeRefusesProductionDatabases(t *testing.T) {
+	tests := []struct {
+		name        string
+		identifiers string
+		url         string
+		allowed     bool
+	}{
+		{"staging", "prod-db.internal,live", "postgres://app@staging-db.internal/app", true},
+		{"production host", "prod-db.internal,live", "postgres://app@prod-db.internal/app", false},
+		{"second identifier", "prod-db.internal, live", "postgres://app@db/live", false},
+		{"other case", "prod-db.internal", "postgres://app@PROD-DB.internal/app", false},
+		{"identifiers unset", "", "postgres://app@staging-db.internal/app", false},
+		{"only separators", " , ", "postgres://app@staging-db.internal/app", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PRODUCTION_DB_IDENTIFIERS", tt.identifiers)
+			if err := checkAnonymizeTarget(tt.url); (err == nil) != tt.allowed {
+				t.Fatalf("checkAnonymizeTarget(%q) = %v, want allowed %t", tt.url, err, tt.allowed)
+			}
+		})
+	}
+}
+
+// Runs against TEST_DATABASE_URL, skipped without one
+// It rewrites every user, so it works in a schema of its own.
+func TestAnonymizeDatabase(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if _, err := admin.Exec("DROP SCHEMA IF EXISTS anonymize_test CASCADE; CREATE SCHEMA anonymize_test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA IF EXISTS anonymize_test CASCADE") })
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("postgres", url+separator+"search_path=anonymize_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := store.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the words fit in the hex and fixed words of the fake values
+	originals := []string{"Augusta King <augusta@king.test>", "Grace Hopper <grace@hopper.test>", "Alan Turing <alan@turing.test>"}
+	for i, original := range originals {
+		name, email, _ := strings.Cut(original, " <")
+		email = strings.TrimSuffix(email, ">")
+		var id int64
+		if err := db.QueryRow("INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id", name, email).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO audit_log (entity, entity_id, action, new_value, actor) VALUES ('user', $1, 'create', $2, 'admin')", id, fmt.Sprintf(`{"email":%q}`, email)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO refresh_tokens (token_hash, user_id, family, expires_at) VALUES ($1, $2, $1, now() + interval '1 hour')", fmt.Sprint("token-", i), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Batches smaller than the table, to go through more than one
+	report, err := anonymizeDatabase(db, []byte("staging-key"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tables["users"] != len(originals) || len(report.Truncated) != len(truncatedTables) {
+		t.Fatalf("report = %+v", report)
+	}
+
+	var dump string
+	if err := db.QueryRow("SELECT coalesce(string_agg(concat_ws(' ', name, email), ' '), '') FROM users").Scan(&dump); err != nil {
+		t.Fatal(err)
+	}
+	for _, original := range originals {
+		for _, part := range strings.FieldsFunc(original, func(r rune) bool { return strings.ContainsRune(" <>@.", r) }) {
+			if strings.Contains(strings.ToLower(dump), strings.ToLower(part)) {
+				t.Fatalf("%q of %q survived in %s", part, original, dump)
+			}
+		}
+	}
+	for _, table := range truncatedTables {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil || n != 0 {
+			t.Fatalf("%s has %d rows (%v), want it emptied", table, n, err)
+		}
+	}
+	// Every email became the value derived from it, as in any other run
+	for _, original := range originals {
+		_, email, _ := strings.Cut(strings.TrimSuffix(original, ">"), " <")
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM users WHERE email = $1", fakeValue([]byte("staging-key"), "email", valid(email)).String).Scan(&n); err != nil || n != 1 {
+			t.Fatalf("%d users with the fake email of %s (%v), want 1", n, email, err)
+		}
+	}
+}
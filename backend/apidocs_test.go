@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/openapi"
+)
+
+// Serve the fault and receipt routes too
+func withOptionalRoutes(t *testing.T) func(*app) {
+	seed := make([]byte, ed25519.SeedSize)
+	t.Setenv("RECEIPT_SIGNING_KEY", "test:"+base64.StdEncoding.EncodeToString(seed))
+	receipts, err := handlers.NewReceiptSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(a *app) {
+		a.faults = handlers.NewFaultInjector()
+		a.api.receipts = receipts
+	}
+}
+
+// Every route needs an operation of its own in the OpenAPI document
+func TestEveryRouteIsDocumented(t *testing.T) {
+	a := newTestApp(t, withOptionalRoutes(t))
+	w := a.do(t, httptest.NewRequest("GET", "/api/go/openapi.json", nil), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("openapi.json = %d %s", w.Code, w.Body)
+	}
+	var doc openapi.Document
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range a.routes.Routes() {
+		method := route.Method
+		if method == "*" {
+			method = http.MethodGet
+		}
+		item := doc.Paths[route.Path]
+		if item == nil {
+			t.Errorf("%s %s: no path in the OpenAPI document", route.Method, route.Path)
+			continue
+		}
+		op := (*item)[strings.ToLower(method)]
+		switch {
+		case op == nil:
+			t.Errorf("%s %s: no operation in the OpenAPI document", route.Method, route.Path)
+		case op.Summary == "Undocumented":
+			t.Errorf("%s %s: missing from the route docs", route.Method, route.Path)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Bearer token of a user, signed by the app
+func bearer(t *testing.T, a *testApp, id models.ID) string {
+	t.Helper()
+	token, _, err := a.auth.Sign(id, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+// Send a JSON request with the given headers, as name/value pairs
+func (a *testApp) send(t *testing.T, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := http.Header{}
+	if body != "" {
+		h.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		h.Set(header[i], header[i+1])
+	}
+	return a.do(t, httptest.NewRequest(method, path, strings.NewReader(body)), h)
+}
+
+// Decode a JSON response body, failing the test when it does not decode
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("response %d %q is not JSON: %v", w.Code, w.Body, err)
+	}
+	return v
+}
+
+func TestWritesRefuseMissingAndBadTokens(t *testing.T) {
+	a := newTestApp(t)
+	a.createUser(t, "Ada Lovelace", "ada@example.com", "analytical engine")
+	valid := strings.TrimPrefix(bearer(t, a, 1), "Bearer ")
+	parts := strings.Split(valid, ".")
+
+	expired, _, err := a.auth.Sign(1, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := a.auth.Sign(2, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_SECRET", "another-secret-another-secret-another")
+	foreign, _, err := handlers.NewAuth(nil).Sign(1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		message       string
+	}{
+		{"no header", "", "missing bearer token"},
+		{"another scheme", "Basic YWRhOnNlY3JldA==", "missing bearer token"},
+		{"empty token", "Bearer ", "missing bearer token"},
+		{"not a JWT", "Bearer not-a-token", "invalid token"},
+		{"expired", "Bearer " + expired, "token expired"},
+		{"signed with another secret", "Bearer " + foreign, "invalid token"},
+		{"signature of another token", "Bearer " + parts[0] + "." + parts[1] + "." + strings.Split(other, ".")[2], "invalid token"},
+		{"claims changed after signing", "Bearer " + parts[0] + "." + strings.Split(other, ".")[1] + "." + parts[2], "invalid token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header []string
+			if tt.authorization != "" {
+				header = []string{"Authorization", tt.authorization}
+			}
+			w := a.send(t, "PATCH", "/api/v1/users/1", `{"name":"Ada King"}`, header...)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d %s, want 401", w.Code, w.Body)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("401 without WWW-Authenticate")
+			}
+			if !strings.Contains(w.Body.String(), tt.message) {
+				t.Fatalf("body = %s, want %q", w.Body, tt.message)
+			}
+		})
+	}
+
+	if w := a.send(t, "PATCH", "/api/v1/users/1", `{"name":"Ada King"}`, "Authorization", "Bearer "+valid); w.Code != http.StatusOK {
+		t.Fatalf("PATCH with a valid token = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUsersCanOnlyChangeThemselves(t *testing.T) {
+	a := newTestApp(t)
+	ada := a.createUser(t, "Ada Lovelace", "ada@example.com", "analytical engine")
+	alan := a.createUser(t, "Alan Turing", "alan@example.com", "universal machine")
+	asAda := bearer(t, a, ada.Id)
+	alanPath := "/api/go/users/" + alan.Id.String()
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{"PUT", alanPath, `{"name":"Alan","email":"alan@example.com"}`},
+		{"PATCH", alanPath, `{"name":"Alan"}`},
+		{"DELETE", alanPath, ""},
+		{"POST", alanPath + "/avatar", ""},
+		{"DELETE", alanPath + "/avatar", ""},
+		{"PATCH", "/api/v1/users/" + alan.Id.String(), `{"name":"Alan"}`},
+		{"DELETE", "/api/go/users/999999", ""},
+		// The id is checked before the body, so nothing about it is validated
+		{"PUT", alanPath, `{"name":"","email":"not an email"}`},
+		{"PATCH", alanPath, `{"name":null}`},
+		{"PATCH", alanPath, `not json`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := a.send(t, tt.method, tt.path, tt.body, "Authorization", asAda)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d %s, want 403", w.Code, w.Body)
+			}
+		})
+	}
+	if w := a.send(t, "GET", alanPath, ""); !strings.Contains(w.Body.String(), `"name":"Alan Turing"`) {
+		t.Fatalf("Alan after the refused writes = %s", w.Body)
+	}
+
+	if w := a.send(t, "PATCH", "/api/go/users/"+ada.Id.String(), `{"name":"Ada King"}`, "Authorization", asAda); w.Code != http.StatusOK {
+		t.Fatalf("Ada changing herself = %d %s", w.Code, w.Body)
+	}
+	if w := a.send(t, "PATCH", alanPath, `{"name":"Alan M. Turing"}`, "X-API-Key", testAdminKey); w.Code != http.StatusOK {
+		t.Fatalf("the admin changing Alan = %d %s", w.Code, w.Body)
+	}
+	// Signing up is open, so a fresh account gets no further than Ada
+	w := a.send(t, "POST", "/api/go/auth/register", `{"name":"Eve","email":"eve@example.com","password":"correct horse"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("register = %d %s", w.Code, w.Body)
+	}
+	w = a.send(t, "POST", "/api/go/auth/login", `{"email":"eve@example.com","password":"correct horse"}`)
+	token := decodeBody[handlers.TokenResponse](t, w).Token
+	if w := a.send(t, "DELETE", alanPath, "", "Authorization", "Bearer "+token); w.Code != http.StatusForbidden {
+		t.Fatalf("a new account deleting Alan = %d %s, want 403", w.Code, w.Body)
+	}
+}
+
+// Register through the API and log in, returning the tokens
+func registerAndLogin(t *testing.T, a *testApp, name, email, password string) (models.User, handlers.TokenResponse) {
+	t.Helper()
+	body, _ := json.Marshal(handlers.RegisterRequest{Name: name, Email: email, Password: password})
+	w := a.send(t, "POST", "/api/go/auth/register", string(body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("register = %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "password") {
+		t.Fatalf("registration answered with the password: %s", w.Body)
+	}
+	user := decodeBody[models.User](t, w)
+
+	body, _ = json.Marshal(handlers.LoginRequest{Email: email, Password: password})
+	w = a.send(t, "POST", "/api/go/auth/login", string(body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	return user, decodeBody[handlers.TokenResponse](t, w)
+}
+
+// Refresh a token, returning the response
+func refresh(t *testing.T, a *testApp, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	return a.send(t, "POST", "/api/go/auth/refresh", string(refreshBody(token)))
+}
+
+func TestLoginIssuesAccessAndRefreshTokens(t *testing.T) {
+	a := newTestApp(t)
+	user, tokens := registerAndLogin(t, a, "Ada Lovelace", "ada@example.com", "analytical engine")
+
+	if tokens.Token == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" || tokens.UserId != user.Id {
+		t.Fatalf("login answered %+v", tokens)
+	}
+	if id, err := a.auth.Verify(tokens.Token, time.Now()); err != nil || id != user.Id {
+		t.Fatalf("access token is for %d (%v), want %d", id, err, user.Id)
+	}
+	for _, field := range []string{tokens.ExpiresAt, tokens.RefreshExpiresAt} {
+		if _, err := time.Parse(time.RFC3339, field); err != nil {
+			t.Fatalf("expiry %q is not RFC 3339", field)
+		}
+	}
+	path := "/api/go/users/" + user.Id.String()
+	if w := a.send(t, "PATCH", path, `{"name":"Ada King"}`, "Authorization", "Bearer "+tokens.Token); w.Code != http.StatusOK {
+		t.Fatalf("PATCH with the access token = %d %s", w.Code, w.Body)
+	}
+
+	w := a.send(t, "POST", "/api/go/auth/login", `{"email":"ada@example.com","password":"wrong password"}`)
+	if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "token") {
+		t.Fatalf("login with a wrong password = %d %s, want 401 without tokens", w.Code, w.Body)
+	}
+}
+
+func TestRefreshTokensAreSingleUse(t *testing.T) {
+	a := newTestApp(t)
+	user, first := registerAndLogin(t, a, "Ada Lovelace", "ada@example.com", "analytical engine")
+	_, otherLogin := registerAndLogin(t, a, "Ada Lovelace", "ada2@example.com", "analytical engine")
+
+	w := refresh(t, a, first.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first refresh = %d %s", w.Code, w.Body)
+	}
+	second := decodeBody[handlers.TokenResponse](t, w)
+	if second.RefreshToken == first.RefreshToken || second.UserId != user.Id {
+		t.Fatalf("refresh answered %+v", second)
+	}
+	if id, err := a.auth.Verify(second.Token, time.Now()); err != nil || id != user.Id {
+		t.Fatalf("refreshed access token is for %d (%v), want %d", id, err, user.Id)
+	}
+
+	// The first token again is a reuse, which logs the family out
+	if w := refresh(t, a, first.RefreshToken); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "refresh token already used") {
+		t.Fatalf("reusing a refresh token = %d %s, want 401", w.Code, w.Body)
+	}
+	if w := refresh(t, a, second.RefreshToken); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "invalid refresh token") {
+		t.Fatalf("token handed out before the reuse = %d %s, want 401", w.Code, w.Body)
+	}
+	// Another user's login is left alone
+	if w := refresh(t, a, otherLogin.RefreshToken); w.Code != http.StatusOK {
+		t.Fatalf("refresh of another login = %d %s", w.Code, w.Body)
+	}
+	if w := refresh(t, a, "never-issued"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh with an unknown token = %d, want 401", w.Code)
+	}
+}
+
+// The password hash is stored with the user but never sent
+func TestUserResponsesHaveNoPasswordHash(t *testing.T) {
+	a := newTestApp(t)
+	user, tokens := registerAndLogin(t, a, "Ada Lovelace", "ada@example.com", "analytical engine")
+	path := "/api/go/users/" + user.Id.String()
+	auth := []string{"Authorization", "Bearer " + tokens.Token}
+
+	for _, w := range []*httptest.ResponseRecorder{
+		a.send(t, "GET", path, ""),
+		a.send(t, "GET", "/api/go/users", ""),
+		a.send(t, "GET", "/api/v1/users/"+user.Id.String(), ""),
+		a.send(t, "PUT", path, `{"name":"Ada King","email":"ada@example.com"}`, auth...),
+		a.send(t, "PATCH", path, `{"name":"Ada Lovelace"}`, auth...),
+		a.send(t, "GET", "/api/go/users/export?format=ndjson", "", "X-API-Key", testAdminKey),
+	} {
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d %s", w.Code, w.Body)
+		}
+		if body := w.Body.String(); strings.Contains(body, "password") || strings.Contains(body, "$2a$") {
+			t.Fatalf("response carries the password hash: %s", body)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// The whole API under BASE_PATH, and every link it hands out with it
+func TestBasePath(t *testing.T) {
+	for _, base := range []string{"", "/backend"} {
+		t.Run("base path "+base, func(t *testing.T) {
+			t.Setenv("BASE_PATH", base+"/")
+			a := newTestApp(t)
+			a.handler = handlers.StripBasePath(a.handler)
+			a.createUser(t, "Ada Lovelace", "ada@example.com", "correct horse")
+
+			if w := a.send(t, "GET", base+"/api/v1/users/1", ""); w.Code != http.StatusOK {
+				t.Fatalf("GET %s/api/v1/users/1 = %d %s", base, w.Code, w.Body)
+			}
+			// Probes reach the pod without the prefix
+			if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusOK {
+				t.Fatalf("GET without the prefix = %d", w.Code)
+			}
+			if base != "" {
+				if w := a.send(t, "GET", base+"-other/api/v1/users/1", ""); w.Code != http.StatusNotFound {
+					t.Fatalf("GET under a longer prefix = %d, want 404", w.Code)
+				}
+			}
+
+			server := base
+			if server == "" {
+				server = "/"
+			}
+			for _, forwarded := range []string{"", "/edge/"} {
+				prefix := base
+				header := []string{}
+				if forwarded != "" {
+					prefix, server = "/edge", "/edge"
+					header = []string{"X-Forwarded-Prefix", forwarded}
+				}
+
+				w := a.send(t, "GET", base+"/api/go/users/1", "", header...)
+				if link := w.Header().Get("Link"); !strings.Contains(link, "<"+prefix+"/api/v1/users/1>") || !strings.Contains(link, "<"+prefix+"/api/versions>") {
+					t.Fatalf("Link = %q, want links under %q", link, prefix)
+				}
+				doc := decodeBody[struct {
+					Servers []struct {
+						URL string `json:"url"`
+					} `json:"servers"`
+				}](t, a.send(t, "GET", base+"/api/go/openapi.json", "", header...))
+				if len(doc.Servers) != 1 || doc.Servers[0].URL != server {
+					t.Fatalf("OpenAPI servers = %+v, want %q", doc.Servers, server)
+				}
+				if page := a.send(t, "GET", base+"/api/go/docs", "", header...).Body.String(); !strings.Contains(page, `spec-url="`+prefix+`/api/go/openapi.json"`) {
+					t.Fatalf("docs page does not load the spec under %q: %s", prefix, page)
+				}
+				examples := decodeBody[map[string][]handlers.Example](t, a.send(t, "GET", base+"/api/go/docs/examples", "", header...))
+				if len(examples) == 0 {
+					t.Fatal("no examples")
+				}
+				for route, list := range examples {
+					for _, example := range list {
+						if !strings.HasPrefix(example.Path, prefix+"/api/") {
+							t.Fatalf("example %q of %s has the path %s, want it under %q", example.Name, route, example.Path, prefix)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Database connection pool limits
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// How long startup keeps trying to reach the database
+type ConnectConfig struct {
+	Retries int           // attempts after the first
+	Timeout time.Duration // for all attempts together
+}
+
+// HTTP server timeouts and the shutdown sequence
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	DrainPeriod       time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+// Where rate limits and idempotency keys are kept
+type KVConfig struct {
+	Backend       string // memory, postgres or redis
+	RedisURL      string
+	PurgeInterval time.Duration // of expired keys, in memory and Postgres
+}
+
+// Settings the service needs before it can start
+type Config struct {
+	Port        string
+	DatabaseURL string
+	AutoMigrate bool // false only checks the schema, the migrate command applies it
+	Pool        PoolConfig
+	Connect     ConnectConfig
+	Server      ServerConfig
+	CORS        handlers.CORSConfig
+	Probe       handlers.ProbeConfig
+	KV          KVConfig
+	RateLimits  handlers.RateLimitConfig
+	Growth      handlers.GrowthConfig
+	// Take client IPs from X-Forwarded-For, only behind a proxy that sets it
+	TrustProxy bool
+}
+
+// Read the configuration from the environment
+// Every missing or invalid variable is reported in one error.
+func LoadConfig() (Config, error) {
+	p := &env.Parser{}
+	c := Config{
+		Port:        p.String("PORT", "8080"),
+		DatabaseURL: p.Required("DATABASE_URL"),
+		AutoMigrate: p.Bool("AUTO_MIGRATE", true),
+		Pool: PoolConfig{
+			MaxOpenConns:    p.Int("DB_MAX_OPEN_CONNS", 20, 1),
+			MaxIdleConns:    p.Int("DB_MAX_IDLE_CONNS", 10, 0),
+			ConnMaxLifetime: p.Duration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+			ConnMaxIdleTime: p.Duration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+		},
+		Connect: ConnectConfig{
+			Retries: p.Int("DB_CONNECT_RETRIES", 10, 0),
+			Timeout: p.Duration("DB_CONNECT_TIMEOUT", time.Minute),
+		},
+		Server: ServerConfig{
+			ReadHeaderTimeout: p.Duration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+			ReadTimeout:       p.Duration("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:      p.Duration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:       p.Duration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			DrainPeriod:       time.Duration(p.Int("DRAIN_SECONDS", 10, 0)) * time.Second,
+			ShutdownTimeout:   p.Duration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		},
+		CORS:  handlers.LoadCORSConfig(p),
+		Probe: handlers.LoadProbeConfig(p),
+		KV: KVConfig{
+			RedisURL:      p.String("REDIS_URL", ""),
+			PurgeInterval: p.Duration("KV_PURGE_INTERVAL", time.Minute),
+		},
+		RateLimits: handlers.LoadRateLimitConfig(p),
+		Growth:     handlers.LoadGrowthConfig(p),
+		TrustProxy: p.Bool("TRUST_PROXY", false),
+	}
+
+	// Replicas share limits through Redis when there is one, like cache
+	// invalidations, and through Postgres otherwise
+	kvDefault := store.KVPostgres
+	if c.KV.RedisURL != "" {
+		kvDefault = store.KVRedis
+	}
+	c.KV.Backend = p.String("KV_BACKEND", kvDefault)
+	switch c.KV.Backend {
+	case store.KVMemory, store.KVPostgres:
+	case store.KVRedis:
+		if c.KV.RedisURL == "" {
+			p.Invalid("KV_BACKEND=redis needs REDIS_URL")
+		}
+	default:
+		p.Invalid("KV_BACKEND=%q must be memory, postgres or redis", c.KV.Backend)
+	}
+	if c.KV.PurgeInterval == 0 {
+		p.Invalid("KV_PURGE_INTERVAL must be more than 0")
+	}
+
+	if c.Pool.MaxIdleConns > c.Pool.MaxOpenConns {
+		p.Invalid("DB_MAX_IDLE_CONNS (%d) is more than DB_MAX_OPEN_CONNS (%d)", c.Pool.MaxIdleConns, c.Pool.MaxOpenConns)
+	}
+	return c, p.Err()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Variables LoadConfig reads that the tests set, cleared before each case
+var configVariables = []string{
+	"DATABASE_URL", "DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "KV_BACKEND", "REDIS_URL", "KV_PURGE_INTERVAL",
+	"RATE_LIMIT_RPS", "RATE_LIMIT_BURST", "RATE_LIMIT_WRITE_RPS", "RATE_LIMIT_WRITE_BURST", "RATE_LIMIT_LEGACY_HEADERS",
+	"TRUST_PROXY", "USERS_SOFT_LIMIT", "USERS_HARD_LIMIT", "GROWTH_ENFORCE", "GROWTH_REFRESH",
+}
+
+func setConfigEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for _, name := range configVariables {
+		t.Setenv(name, "")
+	}
+	t.Setenv("DATABASE_URL", "postgres://localhost/test")
+	for name, value := range vars {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setConfigEnv(t, nil)
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := c.RateLimits; r.ReadRate != 20 || r.ReadBurst != 40 || r.WriteRate != 5 || r.WriteBurst != 10 || r.LegacyHeaders {
+		t.Fatalf("rate limits = %+v, want the defaults", r)
+	}
+	if g := c.Growth; g.Soft != 0 || g.Hard != 0 || !g.Enforce || g.Refresh != time.Minute {
+		t.Fatalf("growth = %+v, want the defaults", g)
+	}
+	if c.TrustProxy {
+		t.Fatal("TRUST_PROXY is on by default")
+	}
+}
+
+func TestLoadConfigReadsLimits(t *testing.T) {
+	setConfigEnv(t, map[string]string{
+		"RATE_LIMIT_RPS":            "0.5",
+		"RATE_LIMIT_BURST":          "3",
+		"RATE_LIMIT_WRITE_RPS":      "0",
+		"RATE_LIMIT_WRITE_BURST":    "1",
+		"RATE_LIMIT_LEGACY_HEADERS": "true",
+		"TRUST_PROXY":               "true",
+		"USERS_SOFT_LIMIT":          "900",
+		"USERS_HARD_LIMIT":          "1000",
+		"GROWTH_ENFORCE":            "false",
+		"GROWTH_REFRESH":            "10s",
+	})
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := c.RateLimits; r.ReadRate != 0.5 || r.ReadBurst != 3 || r.WriteRate != 0 || r.WriteBurst != 1 || !r.LegacyHeaders {
+		t.Fatalf("rate limits = %+v", r)
+	}
+	if g := c.Growth; g.Soft != 900 || g.Hard != 1000 || g.Enforce || g.Refresh != 10*time.Second {
+		t.Fatalf("growth = %+v", g)
+	}
+	if !c.TrustProxy {
+		t.Fatal("TRUST_PROXY=true was not read")
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want string
+	}{
+		{"read rate not a number", map[string]string{"RATE_LIMIT_RPS": "fast"}, `RATE_LIMIT_RPS="fast" must be a number of at least 0`},
+		{"negative write rate", map[string]string{"RATE_LIMIT_WRITE_RPS": "-1"}, `RATE_LIMIT_WRITE_RPS="-1" must be a number of at least 0`},
+		{"infinite rate", map[string]string{"RATE_LIMIT_RPS": "Inf"}, `RATE_LIMIT_RPS="Inf"`},
+		{"burst of zero", map[string]string{"RATE_LIMIT_BURST": "0"}, `RATE_LIMIT_BURST="0" must be an integer of at least 1`},
+		{"write burst not a number", map[string]string{"RATE_LIMIT_WRITE_BURST": "ten"}, `RATE_LIMIT_WRITE_BURST="ten"`},
+		{"legacy headers not a boolean", map[string]string{"RATE_LIMIT_LEGACY_HEADERS": "sometimes"}, `RATE_LIMIT_LEGACY_HEADERS="sometimes" must be true or false`},
+		{"trust proxy not a boolean", map[string]string{"TRUST_PROXY": "yes please"}, `TRUST_PROXY="yes please" must be true or false`},
+		{"negative soft limit", map[string]string{"USERS_SOFT_LIMIT": "-5"}, `USERS_SOFT_LIMIT="-5" must be an integer of at least 0`},
+		{"soft limit above the hard one", map[string]string{"USERS_SOFT_LIMIT": "2000", "USERS_HARD_LIMIT": "1000"}, "USERS_SOFT_LIMIT (2000) is more than USERS_HARD_LIMIT (1000)"},
+		{"enforce not a boolean", map[string]string{"GROWTH_ENFORCE": "strict"}, `GROWTH_ENFORCE="strict" must be true or false`},
+		{"refresh of zero", map[string]string{"GROWTH_REFRESH": "0s"}, "GROWTH_REFRESH must be more than 0"},
+		{"refresh not a duration", map[string]string{"GROWTH_REFRESH": "often"}, `GROWTH_REFRESH="often" is not a valid duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfigEnv(t, tt.vars)
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("LoadConfig = %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Every problem is reported at once, not only the first
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	setConfigEnv(t, map[string]string{"RATE_LIMIT_RPS": "-1", "TRUST_PROXY": "maybe", "GROWTH_REFRESH": "0s"})
+	t.Setenv("DATABASE_URL", "")
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig succeeded")
+	}
+	for _, want := range []string{"DATABASE_URL is not set", "RATE_LIMIT_RPS", "TRUST_PROXY", "GROWTH_REFRESH"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
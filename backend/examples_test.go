@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// Request an example is sent as, changed by its setup
+type exampleRequest struct {
+	body   []byte
+	header http.Header
+}
+
+// What an example needs besides Ada and Alan
+type exampleSetup struct {
+	// Start without any users
+	empty   bool
+	prepare func(t *testing.T, a *testApp, req *exampleRequest)
+}
+
+// Setups by example name, for examples that do not run on the fixture
+// users as they are
+var exampleSetups = map[string]exampleSetup{
+	"create a user":                   {empty: true},
+	"create users in one transaction": {empty: true},
+	"rows that stop the batch":        {empty: true},
+	"register with a password":        {empty: true},
+	"refresh an expired bearer token": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		req.body = refreshBody(login(t, a))
+	}},
+	"refresh with a token already used": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		req.body = refreshBody(login(t, a))
+		a.call(t, "POST", "/api/go/auth/refresh", req.body)
+	}},
+	"update a user someone else changed first": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		a.call(t, "PUT", "/api/go/users/1", []byte(`{"name":"Ada King","email":"ada@example.com","version":1}`))
+	}},
+	"restore a deleted user": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		a.call(t, "DELETE", "/api/go/users/1", nil)
+	}},
+	"list deleted users too, with the admin API key": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		a.call(t, "DELETE", "/api/go/users/2", nil, "X-API-Key", testAdminKey)
+	}},
+	"history of a user": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		a.call(t, "DELETE", "/api/go/users/1", nil)
+		a.call(t, "POST", "/api/go/users/1/restore", nil, "X-API-Key", testAdminKey)
+	}},
+	"upload a PNG": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		avatarForm(t, req, "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	}},
+	"upload a text file named avatar.png": {prepare: func(t *testing.T, a *testApp, req *exampleRequest) {
+		avatarForm(t, req, "not an image")
+	}},
+}
+
+// Run every documented example against the routes and compare the status
+// and the shape of the body with what the docs show
+func TestDocumentedExamples(t *testing.T) {
+	all := handlers.Examples.All()
+	if len(all) == 0 {
+		t.Fatal("no examples registered")
+	}
+	routes := map[string]bool{}
+	for _, route := range newTestApp(t).routes.Routes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+
+	names := map[string]bool{}
+	for route, examples := range all {
+		if !routes[route] {
+			t.Errorf("examples registered for %s, which is not a route", route)
+		}
+		for _, ex := range examples {
+			if names[ex.Name] {
+				t.Errorf("two examples are named %q", ex.Name)
+			}
+			names[ex.Name] = true
+			t.Run(ex.Name, func(t *testing.T) { runExample(t, ex) })
+		}
+	}
+	for name := range exampleSetups {
+		if !names[name] {
+			t.Errorf("setup for %q, which is not an example", name)
+		}
+	}
+}
+
+func runExample(t *testing.T, ex handlers.Example) {
+	a := newTestApp(t)
+	setup := exampleSetups[ex.Name]
+	if !setup.empty {
+		a.createUser(t, "Ada Lovelace", "ada@example.com", "analytical engine")
+		a.createUser(t, "Alan Turing", "alan@example.com", "universal machine")
+	}
+
+	req := &exampleRequest{body: ex.Request, header: http.Header{}}
+	if len(req.body) > 0 {
+		req.header.Set("Content-Type", "application/json")
+	}
+	token, _, err := a.auth.Sign(1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.header.Set("Authorization", "Bearer "+token)
+	for name, value := range ex.Header {
+		req.header.Set(name, strings.ReplaceAll(value, handlers.ExampleAdminKey, testAdminKey))
+	}
+	if setup.prepare != nil {
+		setup.prepare(t, a, req)
+	}
+
+	w := a.do(t, httptest.NewRequest(ex.Method, ex.Path, bytes.NewReader(req.body)), req.header)
+	if w.Code != ex.Status {
+		t.Fatalf("%s %s = %d %s, documented %d", ex.Method, ex.Path, w.Code, w.Body, ex.Status)
+	}
+	if len(ex.Response) == 0 {
+		if w.Body.Len() > 0 {
+			t.Fatalf("documented without a body, got %s", w.Body)
+		}
+		return
+	}
+	var want, got any
+	if err := json.Unmarshal(ex.Response, &want); err != nil {
+		t.Fatalf("documented response is not JSON: %v", err)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body)
+	}
+	if err := sameShape("body", want, got); err != nil {
+		t.Fatalf("%v\ndocumented: %s\ngot:        %s", err, ex.Response, w.Body)
+	}
+}
+
+// Whether got has the shape of want: objects with the same keys, and
+// values of the same JSON types, all the way down
+// Every element of an array must have the shape of one documented
+// element, so examples can show fewer or other rows than the fixtures.
+func sameShape(path string, want, got any) error {
+	switch want := want.(type) {
+	case map[string]any:
+		got, ok := got.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: documented an object, got %s", path, jsonType(got))
+		}
+		if w, g := keys(want), keys(got); !slices.Equal(w, g) {
+			return fmt.Errorf("%s: documented keys %v, got %v", path, w, g)
+		}
+		for key, value := range want {
+			if err := sameShape(path+"."+key, value, got[key]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		got, ok := got.([]any)
+		if !ok {
+			return fmt.Errorf("%s: documented an array, got %s", path, jsonType(got))
+		}
+		if len(want) == 0 {
+			return nil
+		}
+		for i, element := range got {
+			var err error
+			for _, documented := range want {
+				if err = sameShape(fmt.Sprintf("%s[%d]", path, i), documented, element); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		if jsonType(want) != jsonType(got) {
+			return fmt.Errorf("%s: documented %s, got %s", path, jsonType(want), jsonType(got))
+		}
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	}
+	return "an object"
+}
+
+func keys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Send a request a setup needs, failing unless it succeeds
+// Bearer of Ada unless header pairs say otherwise.
+func (a *testApp) call(t *testing.T, method, path string, body []byte, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, _, err := a.auth.Sign(1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{"Authorization": {"Bearer " + token}, "Content-Type": {"application/json"}}
+	for i := 0; i+1 < len(header); i += 2 {
+		h.Set(header[i], header[i+1])
+	}
+	w := a.do(t, httptest.NewRequest(method, path, bytes.NewReader(body)), h)
+	if w.Code >= 300 {
+		t.Fatalf("setup %s %s = %d %s", method, path, w.Code, w.Body)
+	}
+	return w
+}
+
+// Refresh token of Ada, from logging in
+func login(t *testing.T, a *testApp) string {
+	t.Helper()
+	w := a.call(t, "POST", "/api/go/auth/login", []byte(`{"email":"ada@example.com","password":"analytical engine"}`))
+	var tokens handlers.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens.RefreshToken
+}
+
+func refreshBody(token string) []byte {
+	body, _ := json.Marshal(map[string]string{"refresh_token": token})
+	return body
+}
+
+// Send the example as a form with an avatar.png file
+func avatarForm(t *testing.T, req *exampleRequest, content string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+	req.body = body.Bytes()
+	req.header.Set("Content-Type", form.FormDataContentType())
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+func TestFaultsOffByDefault(t *testing.T) {
+	tests := []struct {
+		enabled, appEnv string
+		want            bool
+	}{
+		{"", "", false},
+		{"false", "staging", false},
+		{"true", "staging", true},
+		{"true", "", true},
+		// Never in production, whatever FAULTS_ENABLED says
+		{"true", "production", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("FAULTS_ENABLED", tt.enabled)
+		t.Setenv("APP_ENV", tt.appEnv)
+		if got := handlers.FaultsEnabled(); got != tt.want {
+			t.Fatalf("FaultsEnabled with FAULTS_ENABLED=%q APP_ENV=%q = %t, want %t", tt.enabled, tt.appEnv, got, tt.want)
+		}
+	}
+
+	// Without an injector there is no middleware and no admin API
+	a := newTestApp(t)
+	for _, route := range a.routes.Routes() {
+		for _, name := range route.Middleware {
+			if name == "faults" {
+				t.Fatalf("%s %s runs fault injection while it is off", route.Method, route.Path)
+			}
+		}
+	}
+	w := a.send(t, "POST", "/api/go/admin/faults", `{"route":"*","type":"error_500","percent":100}`, "X-API-Key", testAdminKey)
+	if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("adding a fault while off = %d %s, want no such route", w.Code, w.Body)
+	}
+}
+
+// App with fault injection on, its dice and clock set by the test
+func newFaultApp(t *testing.T) (*testApp, *handlers.FaultInjector, *float64, *time.Time) {
+	t.Helper()
+	roll, now := 0.0, time.Now()
+	faults := handlers.NewFaultInjector()
+	faults.Rand = func() float64 { return roll }
+	faults.Now = func() time.Time { return now }
+	a := newTestApp(t, func(a *app) { a.faults = faults })
+	a.createUser(t, "Ada Lovelace", "ada@example.com", "correct horse")
+	return a, faults, &roll, &now
+}
+
+// Add a fault rule through the admin API
+func addFault(t *testing.T, a *testApp, rule string) handlers.FaultRule {
+	t.Helper()
+	w := a.send(t, "POST", "/api/go/admin/faults", rule, "X-API-Key", testAdminKey)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("adding %s = %d %s", rule, w.Code, w.Body)
+	}
+	return decodeBody[handlers.FaultRule](t, w)
+}
+
+func TestFaultsTriggerOnlyForTheirRules(t *testing.T) {
+	a, _, roll, now := newFaultApp(t)
+	if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusOK || w.Header().Get("X-Fault-Injected") != "" {
+		t.Fatalf("GET without rules = %d, fault %q", w.Code, w.Header().Get("X-Fault-Injected"))
+	}
+
+	rule := addFault(t, a, `{"route":"/api/v1/users/{id}","type":"error_503","percent":50,"ttl_seconds":60}`)
+	*roll = 0.49
+	w := a.send(t, "GET", "/api/v1/users/1", "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("X-Fault-Injected") != handlers.FaultError503 {
+		t.Fatalf("GET with a firing rule = %d, fault %q", w.Code, w.Header().Get("X-Fault-Injected"))
+	}
+	// Outside the percentage, on another route, or on the admin API, nothing
+	*roll = 0.5
+	if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET outside the fault percentage = %d", w.Code)
+	}
+	*roll = 0
+	if w := a.send(t, "GET", "/api/v1/users", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET of another route = %d", w.Code)
+	}
+	addFault(t, a, `{"route":"/api/go/admin/*","type":"error_500","percent":100}`)
+	if w := a.send(t, "GET", "/api/go/admin/faults", "", "X-API-Key", testAdminKey); w.Code != http.StatusOK {
+		t.Fatalf("admin API with a rule on it = %d, want it never faulted", w.Code)
+	}
+
+	// Rules end when removed or when they expire
+	if w := a.send(t, "DELETE", "/api/go/admin/faults/"+rule.Id, "", "X-API-Key", testAdminKey); w.Code != http.StatusNoContent {
+		t.Fatalf("removing the rule = %d", w.Code)
+	}
+	if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET after removing the rule = %d", w.Code)
+	}
+	addFault(t, a, `{"route":"/api/v1/users/{id}","type":"error_500","percent":100,"ttl_seconds":60}`)
+	if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("GET with an error_500 rule = %d", w.Code)
+	}
+	*now = now.Add(time.Minute)
+	if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET after the rule expired = %d", w.Code)
+	}
+}
+
+func TestFaultTypes(t *testing.T) {
+	a, _, _, _ := newFaultApp(t)
+	clean := a.send(t, "GET", "/api/v1/users/1", "")
+
+	addFault(t, a, `{"route":"/api/v1/users/{id}","type":"latency","latency_ms":50,"percent":100}`)
+	start := time.Now()
+	if w := a.send(t, "GET", "/api/v1/users/1", ""); w.Code != http.StatusOK || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("latency fault = %d after %s, want 200 after 50ms", w.Code, time.Since(start))
+	}
+
+	a, _, _, _ = newFaultApp(t)
+	addFault(t, a, `{"route":"/api/v1/users/{id}","type":"truncate","percent":100}`)
+	w := a.send(t, "GET", "/api/v1/users/1", "")
+	if body := w.Body.String(); len(body) != clean.Body.Len()/2 || !strings.HasPrefix(clean.Body.String(), body) {
+		t.Fatalf("truncated body = %q, want the first half of %q", body, clean.Body)
+	}
+
+	a, _, _, _ = newFaultApp(t)
+	addFault(t, a, `{"route":"/api/v1/users/{id}","type":"reset","percent":100}`)
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Fatalf("reset fault panicked with %v, want http.ErrAbortHandler", recovered)
+			}
+		}()
+		a.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/users/1", nil))
+	}()
+
+	for _, bad := range []string{
+		`{"route":"/api/v1/users","type":"explode","percent":100}`,
+		`{"route":"/api/v1/users","type":"error_500","percent":0}`,
+		`{"route":"/api/v1/users","type":"error_500","percent":101}`,
+		`{"route":"[","type":"error_500","percent":100}`,
+		`{"route":"/api/v1/users","type":"latency","percent":100}`,
+	} {
+		if w := a.send(t, "POST", "/api/go/admin/faults", bad, "X-API-Key", testAdminKey); w.Code != http.StatusBadRequest {
+			t.Fatalf("adding %s = %d, want 400", bad, w.Code)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// App whose users table has the given growth limits
+func newGrowthApp(t *testing.T, guard *handlers.GrowthGuard) *testApp {
+	t.Helper()
+	guard.Table = "users"
+	return newTestApp(t, func(a *app) { a.api.growth = guard })
+}
+
+// Create a user through the API as the admin
+func createNumbered(t *testing.T, a *testApp, n int) *httptest.ResponseRecorder {
+	t.Helper()
+	body := fmt.Sprintf(`{"name":"User %d","email":"user%d@example.com"}`, n, n)
+	return a.send(t, "POST", "/api/go/users", body, "X-API-Key", testAdminKey)
+}
+
+// Webhook counting the alerts posted to it
+func alertWebhook(t *testing.T) *atomic.Int32 {
+	t.Helper()
+	var alerts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alerts.Add(1)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("ALERT_WEBHOOK_URL", server.URL)
+	return &alerts
+}
+
+// Wait for the alerts, which are sent in the background, to reach want
+func waitForAlerts(t *testing.T, alerts *atomic.Int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for alerts.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Long enough for any extra alert to arrive too
+	time.Sleep(50 * time.Millisecond)
+	if n := alerts.Load(); n != want {
+		t.Fatalf("%d growth alerts sent, want %d", n, want)
+	}
+}
+
+func TestGrowthSoftLimitAlertsOnce(t *testing.T) {
+	alerts := alertWebhook(t)
+	a := newGrowthApp(t, &handlers.GrowthGuard{Soft: 3})
+
+	for i := 1; i <= 2; i++ {
+		createNumbered(t, a, i)
+	}
+	waitForAlerts(t, alerts, 0)
+	// Crossing the limit alerts, staying past it does not again
+	for i := 3; i <= 6; i++ {
+		if w := createNumbered(t, a, i); w.Code != http.StatusOK {
+			t.Fatalf("create %d past the soft limit = %d %s, want 200", i, w.Code, w.Body)
+		}
+	}
+	waitForAlerts(t, alerts, 1)
+
+	// Soft-deleted users still count, erasing them brings the table back
+	// under the limit and arms the alert again
+	for id := 1; id <= 4; id++ {
+		if w := a.send(t, "DELETE", fmt.Sprintf("/api/go/users/%d?hard=true", id), "", "X-API-Key", testAdminKey); w.Code >= 300 {
+			t.Fatalf("delete %d = %d %s", id, w.Code, w.Body)
+		}
+	}
+	waitForAlerts(t, alerts, 1)
+	createNumbered(t, a, 7)
+	waitForAlerts(t, alerts, 2)
+}
+
+func TestGrowthHardLimit(t *testing.T) {
+	for _, enforce := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enforce %t", enforce), func(t *testing.T) {
+			a := newGrowthApp(t, &handlers.GrowthGuard{Hard: 2, Enforce: enforce})
+			for i := 1; i <= 2; i++ {
+				if w := createNumbered(t, a, i); w.Code != http.StatusOK {
+					t.Fatalf("create %d under the hard limit = %d %s", i, w.Code, w.Body)
+				}
+			}
+
+			w := createNumbered(t, a, 3)
+			bulk := a.send(t, "POST", "/api/go/users/bulk", `[{"name":"User 4","email":"user4@example.com"}]`, "X-API-Key", testAdminKey)
+			if !enforce {
+				if w.Code != http.StatusOK || bulk.Code >= 300 {
+					t.Fatalf("writes past the limit without enforcement = %d and %d, want them through", w.Code, bulk.Code)
+				}
+				return
+			}
+			for _, w := range []*httptest.ResponseRecorder{w, bulk} {
+				if w.Code != http.StatusInsufficientStorage {
+					t.Fatalf("write past the hard limit = %d %s, want 507", w.Code, w.Body)
+				}
+				if code := decodeBody[handlers.ErrorResponse](t, w).Error.Code; code != "capacity_limit" {
+					t.Fatalf("error code = %q, want capacity_limit", code)
+				}
+			}
+		})
+	}
+}
+
+func TestGrowthOverride(t *testing.T) {
+	a := newGrowthApp(t, &handlers.GrowthGuard{Hard: 1, Enforce: true})
+	createNumbered(t, a, 1)
+	if w := createNumbered(t, a, 2); w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("create past the hard limit = %d, want 507", w.Code)
+	}
+
+	// Only the admin can lift the limit
+	if w := a.send(t, "POST", "/api/go/admin/growth/override", `{"minutes":5}`, "Authorization", bearer(t, a, 1)); w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+		t.Fatalf("override by a user = %d %s, want it refused", w.Code, w.Body)
+	}
+	if w := a.send(t, "POST", "/api/go/admin/growth/override", `{"minutes":0}`, "X-API-Key", testAdminKey); w.Code != http.StatusBadRequest {
+		t.Fatalf("override of 0 minutes = %d, want 400", w.Code)
+	}
+	w := a.send(t, "POST", "/api/go/admin/growth/override", `{"minutes":5}`, "X-API-Key", testAdminKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("override = %d %s", w.Code, w.Body)
+	}
+	until := decodeBody[struct {
+		OverrideUntil time.Time `json:"override_until"`
+	}](t, w).OverrideUntil
+	if d := time.Until(until); d < 4*time.Minute || d > 5*time.Minute {
+		t.Fatalf("override until %s, want in 5 minutes", until)
+	}
+
+	for i := 2; i <= 3; i++ {
+		if w := createNumbered(t, a, i); w.Code != http.StatusOK {
+			t.Fatalf("create %d during the override = %d %s, want 200", i, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Users in the store of an app, deleted ones too
+func userCount(t *testing.T, a *testApp) int64 {
+	t.Helper()
+	_, total, err := a.store.List(context.Background(), store.ListOptions{Limit: 1, Sort: "id", IncludeDeleted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+func TestCreateUserReplaysIdempotencyKey(t *testing.T) {
+	a := newTestApp(t)
+	body := `{"name":"Ada Lovelace","email":"ada@example.com"}`
+
+	first := a.send(t, "POST", "/api/go/users", body, "X-API-Key", testAdminKey, "Idempotency-Key", "create-ada")
+	if first.Code != http.StatusOK || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first create = %d %s, replayed %q", first.Code, first.Body, first.Header().Get("Idempotent-Replayed"))
+	}
+	created := decodeBody[models.User](t, first)
+
+	retry := a.send(t, "POST", "/api/go/users", body, "X-API-Key", testAdminKey, "Idempotency-Key", "create-ada")
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry = %d %s, replayed %q, want the stored 200", retry.Code, retry.Body, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Fatalf("replayed body = %s, want %s", retry.Body, first.Body)
+	}
+	for _, name := range []string{"Content-Type", "Location", "ETag"} {
+		if got, want := retry.Header().Get(name), first.Header().Get(name); got != want {
+			t.Fatalf("replayed %s = %q, want %q", name, got, want)
+		}
+	}
+	if n := userCount(t, a); n != 1 {
+		t.Fatalf("%d users after the retry, want 1", n)
+	}
+
+	// The same key for another body is refused and creates nothing
+	other := a.send(t, "POST", "/api/go/users", `{"name":"Grace Hopper","email":"grace@example.com"}`, "X-API-Key", testAdminKey, "Idempotency-Key", "create-ada")
+	if other.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused for another body = %d %s, want 422", other.Code, other.Body)
+	}
+	if code := decodeBody[handlers.ErrorResponse](t, other).Error.Code; code != "idempotency_key_reused" {
+		t.Fatalf("error code = %q, want idempotency_key_reused", code)
+	}
+	if n := userCount(t, a); n != 1 {
+		t.Fatalf("%d users after reusing the key, want 1", n)
+	}
+
+	// Keys are per caller, another one starts fresh
+	w := a.send(t, "POST", "/api/go/users", `{"name":"Grace Hopper","email":"grace@example.com"}`, "Authorization", bearer(t, a, created.Id), "Idempotency-Key", "create-ada")
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("same key from another caller = %d %s, want a new user", w.Code, w.Body)
+	}
+}
+
+// Retries racing the first request never create a second user
+func TestConcurrentIdempotentCreatesMakeOneUser(t *testing.T) {
+	a := newTestApp(t)
+	body := `{"name":"Ada Lovelace","email":"ada@example.com"}`
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := a.send(t, "POST", "/api/go/users", body, "X-API-Key", testAdminKey, "Idempotency-Key", "create-ada")
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[w.Code]++
+		}()
+	}
+	wg.Wait()
+
+	if n := userCount(t, a); n != 1 {
+		t.Fatalf("%d users from one idempotency key, want 1 (statuses %v)", n, statuses)
+	}
+	// Every request got the created user or was told to retry
+	if statuses[http.StatusOK] < 1 || statuses[http.StatusOK]+statuses[http.StatusConflict] != 20 {
+		t.Fatalf("statuses %v, want only 200 and 409", statuses)
+	}
+}
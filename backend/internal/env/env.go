@@ -0,0 +1,165 @@
+// Settings read from the environment
+package env
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Read a duration from the environment, falling back to a default
+func Duration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// Read an integer from the environment, falling back to a default
+func Int(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// Read a boolean from the environment, falling back to a default
+func Bool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %t", name, v, def)
+		return def
+	}
+	return b
+}
+
+// Read a float from the environment, falling back to a default
+func Float(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %g", name, v, def)
+		return def
+	}
+	return f
+}
+
+// Reads settings like the functions above, but collects every missing or
+// invalid variable instead of falling back, so they can be reported together
+type Parser struct {
+	problems []string
+}
+
+func (p *Parser) problem(format string, args ...interface{}) {
+	p.problems = append(p.problems, fmt.Sprintf(format, args...))
+}
+
+// A variable that must be set
+func (p *Parser) Required(name string) string {
+	v := os.Getenv(name)
+	if v == "" {
+		p.problem("%s is not set", name)
+	}
+	return v
+}
+
+// A string, falling back to a default when unset
+func (p *Parser) String(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// A duration, which must not be negative
+func (p *Parser) Duration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		p.problem("%s=%q is not a valid duration, like 30s or 5m", name, v)
+		return def
+	}
+	return d
+}
+
+// An integer of at least min
+func (p *Parser) Int(name string, def, min int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		p.problem("%s=%q must be an integer of at least %d", name, v, min)
+		return def
+	}
+	return n
+}
+
+// A float of at least min
+func (p *Parser) Float(name string, def, min float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < min {
+		p.problem("%s=%q must be a number of at least %g", name, v, min)
+		return def
+	}
+	return f
+}
+
+// A boolean
+func (p *Parser) Bool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.problem("%s=%q must be true or false", name, v)
+		return def
+	}
+	return b
+}
+
+// Record a problem found while validating the parsed values
+func (p *Parser) Invalid(format string, args ...interface{}) {
+	p.problem(format, args...)
+}
+
+// Every problem found, nil when there was none
+func (p *Parser) Err() error {
+	if len(p.problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(p.problems, "\n  "))
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Read the API key sent with a request
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Whether a request carries the ADMIN_API_KEY
+func isAdminRequest(r *http.Request) bool {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	return adminKey != "" && subtle.ConstantTimeCompare([]byte(requestAPIKey(r)), []byte(adminKey)) == 1
+}
+
+// Admin middleware
+// Only lets requests through when they carry the ADMIN_API_KEY.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		if adminKey == "" {
+			writeJSONError(w, http.StatusForbidden, "admin API key is not configured")
+			return
+		}
+
+		key := requestAPIKey(r)
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Concurrency limit with a short FIFO wait queue for one class of endpoints
+type AdmissionClass struct {
+	Name      string
+	Limit     int
+	QueueSize int
+	MaxWait   time.Duration
+
+	mu     sync.Mutex
+	active int
+	queue  []chan struct{}
+}
+
+// Admission class for expensive endpoints like exports and snapshots
+func NewHeavyAdmission() *AdmissionClass {
+	return &AdmissionClass{
+		Name:      "heavy",
+		Limit:     env.Int("HEAVY_CONCURRENCY", 2),
+		QueueSize: env.Int("HEAVY_QUEUE_SIZE", 8),
+		MaxWait:   env.Duration("HEAVY_MAX_WAIT", 10*time.Second),
+	}
+}
+
+// Take a slot right away, or join the queue
+// Returns a channel closed when a slot is handed over, and the queue position.
+func (a *AdmissionClass) enter() (chan struct{}, int, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.active < a.Limit && len(a.queue) == 0 {
+		a.active++
+		return nil, 0, true
+	}
+	if len(a.queue) >= a.QueueSize {
+		return nil, 0, false
+	}
+
+	ch := make(chan struct{})
+	a.queue = append(a.queue, ch)
+	return ch, len(a.queue), true
+}
+
+// Leave the queue, false if a slot was already handed to us
+func (a *AdmissionClass) abandon(ch chan struct{}) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, waiting := range a.queue {
+		if waiting == ch {
+			a.queue = append(a.queue[:i], a.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Give the slot to the next in line, or free it
+func (a *AdmissionClass) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.queue) > 0 {
+		next := a.queue[0]
+		a.queue = a.queue[1:]
+		close(next)
+		return
+	}
+	a.active--
+}
+
+// Answer 503 and ask the client to come back later
+func (a *AdmissionClass) reject(w http.ResponseWriter, r *http.Request, reason string) {
+	logRequest(r, slog.LevelWarn, "Rejected %s %s from the %s class: %s", r.Method, routeName(r), a.Name, reason)
+	admissionRejections.WithLabelValues(a.Name, reason).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(a.MaxWait.Seconds())+1))
+	writeJSONError(w, http.StatusServiceUnavailable, "server is busy, retry later")
+}
+
+// Admission control middleware
+func (a *AdmissionClass) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch, position, ok := a.enter()
+		if !ok {
+			a.reject(w, r, "queue full")
+			return
+		}
+
+		if ch != nil {
+			w.Header().Set("X-Queue-Position", strconv.Itoa(position))
+			start := time.Now()
+			timer := time.NewTimer(a.MaxWait)
+			defer timer.Stop()
+
+			select {
+			case <-ch:
+				admissionWaits.WithLabelValues(a.Name).Observe(time.Since(start).Seconds())
+				logRequest(r, slog.LevelInfo, "%s %s waited %s for a %s slot", r.Method, routeName(r), time.Since(start), a.Name)
+			case <-timer.C:
+				if a.abandon(ch) {
+					a.reject(w, r, "timed out in queue")
+					return
+				}
+			case <-r.Context().Done():
+				if a.abandon(ch) {
+					return
+				}
+				// The slot arrived as the client left, hand it on
+				a.release()
+				return
+			}
+		}
+		defer a.release()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Handler that holds every request until released, counting those inside
+type gate struct {
+	inside  atomic.Int32
+	most    atomic.Int32
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGate() *gate {
+	return &gate{entered: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (g *gate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := g.inside.Add(1)
+	defer g.inside.Add(-1)
+	for most := g.most.Load(); n > most && !g.most.CompareAndSwap(most, n); most = g.most.Load() {
+	}
+	g.entered <- struct{}{}
+	<-g.release
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Serve a request in the background, its response on the channel
+func admit(ctx context.Context, h http.Handler) chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/export", nil).WithContext(ctx))
+		done <- w
+	}()
+	return done
+}
+
+// No slots or queue places left behind
+func assertIdle(t *testing.T, a *AdmissionClass) {
+	t.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active != 0 || len(a.queue) != 0 {
+		t.Fatalf("%d active and %d queued after every request ended", a.active, len(a.queue))
+	}
+}
+
+func TestAdmissionRejectsOverTheLimit(t *testing.T) {
+	a := &AdmissionClass{Name: "heavy", Limit: 1, QueueSize: 0, MaxWait: 4 * time.Second}
+	g := newGate()
+	h := a.Middleware(g)
+
+	first := admit(context.Background(), h)
+	<-g.entered
+	w := <-admit(context.Background(), h)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Fatalf("request over the limit = %d, Retry-After %q, want 503 and 5", w.Code, w.Header().Get("Retry-After"))
+	}
+	if code := errorCode(t, w); code != "service_unavailable" {
+		t.Fatalf("error code = %q", code)
+	}
+
+	close(g.release)
+	if w := <-first; w.Code != http.StatusNoContent {
+		t.Fatalf("admitted request = %d", w.Code)
+	}
+	// The slot is free again
+	if w := <-admit(context.Background(), h); w.Code != http.StatusNoContent {
+		t.Fatalf("request after the slot was released = %d, want it admitted", w.Code)
+	}
+	assertIdle(t, a)
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	a := &AdmissionClass{Name: "heavy", Limit: 1, QueueSize: 1, MaxWait: 10 * time.Second}
+	g := newGate()
+	h := a.Middleware(g)
+
+	first := admit(context.Background(), h)
+	<-g.entered
+	queued := admit(context.Background(), h)
+	// Wait until the second request is in the queue
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		a.mu.Lock()
+		n := len(a.queue)
+		a.mu.Unlock()
+		if n == 1 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if w := <-admit(context.Background(), h); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request with the queue full = %d, want 503", w.Code)
+	}
+
+	g.release <- struct{}{}
+	<-first
+	<-g.entered
+	close(g.release)
+	w := <-queued
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Queue-Position") != "1" {
+		t.Fatalf("queued request = %d at position %q, want it served from 1", w.Code, w.Header().Get("X-Queue-Position"))
+	}
+	assertIdle(t, a)
+}
+
+func TestAdmissionQueueTimeoutAndCancel(t *testing.T) {
+	a := &AdmissionClass{Name: "heavy", Limit: 1, QueueSize: 2, MaxWait: 20 * time.Millisecond}
+	g := newGate()
+	h := a.Middleware(g)
+
+	first := admit(context.Background(), h)
+	<-g.entered
+	w := <-admit(context.Background(), h)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("request timing out in the queue = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// A client giving up leaves the queue without taking a slot
+	a.MaxWait = 10 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	gone := admit(ctx, h)
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	<-gone
+
+	close(g.release)
+	<-first
+	assertIdle(t, a)
+}
+
+// Slots come back whatever happens to the request
+func TestAdmissionReleasesAfterPanics(t *testing.T) {
+	a := &AdmissionClass{Name: "heavy", Limit: 1, QueueSize: 0, MaxWait: time.Second}
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("export failed") }))
+	for i := 0; i < 3; i++ {
+		func() {
+			defer func() { recover() }()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/export", nil))
+		}()
+	}
+	assertIdle(t, a)
+}
+
+// Run with -race: many requests never get more than Limit slots at once
+func TestAdmissionUnderLoad(t *testing.T) {
+	a := &AdmissionClass{Name: "heavy", Limit: 3, QueueSize: 100, MaxWait: 10 * time.Second}
+	g := newGate()
+	close(g.release)
+	h := a.Middleware(g)
+
+	var wg sync.WaitGroup
+	var served atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := <-admit(context.Background(), h); w.Code == http.StatusNoContent {
+				served.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if served.Load() != 50 || g.most.Load() > 3 {
+		t.Fatalf("%d of 50 served, at most %d at once, want all with no more than 3", served.Load(), g.most.Load())
+	}
+	assertIdle(t, a)
+}
@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/openapi"
+)
+
+// What the OpenAPI document says about a route beyond the route table
+// Statuses every route can answer with, like 429 and 500, are added for it.
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Query       []openapi.Parameter
+	Headers     []openapi.Parameter
+	Request     interface{} // JSON body, nil without one
+	Upload      string      // form field of a multipart file upload instead
+	Response    interface{} // JSON body of the success response, nil without one
+	Status      int         // success status, 200 when 0
+	ContentType string      // of the success response when it is not JSON
+	Errors      []int       // error statuses of this route
+}
+
+// A page of a list response with items like item
+type pageOf struct {
+	item interface{}
+}
+
+// Documented shape of a UserPatch, whose fields are raw so a null can be
+// told apart from a field left out
+type userPatchDoc struct {
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Version int64  `json:"version,omitempty"`
+}
+
+// Query parameter with a schema of the given type
+func queryParam(name, typ, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: typ}}
+}
+
+// Query parameter taking one of a few values
+func queryEnum(name, description string, values ...string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string", Enum: values}}
+}
+
+var (
+	dryRunParam = queryParam("dry_run", "boolean", "run in a transaction that is rolled back and answer with the plan, also set with X-Dry-Run")
+	listParams  = []openapi.Parameter{
+		queryParam("limit", "integer", "page size, 1 to 500, 25 by default"),
+		queryParam("offset", "integer", "users to skip"),
+		queryParam("page", "integer", "page number from 1, instead of offset"),
+		queryParam("after_id", "integer", "keyset pagination, only when sorting by id without an offset"),
+		queryEnum("sort", "column to sort by, id by default", "id", "name", "email", "created_at"),
+		queryEnum("order", "sort direction", "asc", "desc"),
+		queryParam("collation", "string", "collation to sort names with, implies sort=name"),
+		queryParam("q", "string", "case-insensitive substring of the name or email, at most 100 characters"),
+		queryParam("name", "string", "exact name"),
+		queryParam("email", "string", "exact email, case-insensitive"),
+		queryParam("filter[name]", "string", "same as name"),
+		queryParam("filter[email]", "string", "same as email"),
+		queryParam("include_deleted", "boolean", "include soft-deleted users, needs the admin API key"),
+	}
+	auditParams = []openapi.Parameter{
+		queryParam("limit", "integer", "page size, 1 to 500, 25 by default"),
+		queryParam("offset", "integer", "entries to skip"),
+		queryEnum("action", "kind of change", "create", "update", "delete", "hard_delete", "restore", "anonymize"),
+		queryParam("actor", "string", "who made the change, like admin, user:1 or anonymous"),
+		{Name: "since", In: "query", Description: "entries at or after this time", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+		{Name: "until", In: "query", Description: "entries before this time", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	}
+)
+
+// Documentation of every route, keyed like "GET /api/go/users"
+var routeDocs = map[string]RouteDoc{
+	"* /": {
+		Summary:  "Welcome message",
+		Response: map[string]string{},
+	},
+	"POST /api/go/auth/register": {
+		Summary:  "Register a user that can log in",
+		Query:    []openapi.Parameter{dryRunParam},
+		Request:  RegisterRequest{},
+		Response: models.User{},
+		Errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusInsufficientStorage},
+	},
+	"POST /api/go/auth/login": {
+		Summary:     "Log in for a bearer and a refresh token",
+		Description: "Writes to users need the token in Authorization: Bearer.",
+		Request:     LoginRequest{},
+		Response:    TokenResponse{},
+		Errors:      []int{http.StatusUnauthorized, http.StatusForbidden},
+	},
+	"POST /api/go/auth/refresh": {
+		Summary:     "Trade a refresh token for new tokens",
+		Description: "Refresh tokens work once. Using one again revokes every token from the same login.",
+		Request:     RefreshRequest{},
+		Response:    TokenResponse{},
+		Errors:      []int{http.StatusUnauthorized, http.StatusForbidden},
+	},
+	"GET /api/go/users": {
+		Summary:  "List users",
+		Query:    listParams,
+		Response: pageOf{models.User{}},
+		Errors:   []int{http.StatusForbidden},
+	},
+	"POST /api/go/users": {
+		Summary:  "Create a user",
+		Query:    []openapi.Parameter{dryRunParam, queryParam("bypass_email_policy", "boolean", "skip the email policy, needs the admin API key")},
+		Request:  models.User{},
+		Response: models.User{},
+		Errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusInsufficientStorage},
+	},
+	"POST /api/go/users/bulk": {
+		Summary:     "Create up to 1000 users at once",
+		Description: "Either every user is created or none is, and the rows that stopped the batch are listed in the error details.",
+		Query:       []openapi.Parameter{dryRunParam, queryParam("bypass_email_policy", "boolean", "skip the email policy, needs the admin API key")},
+		Request:     []models.User{},
+		Response:    []models.User{},
+		Errors:      []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusInsufficientStorage},
+	},
+	"GET /api/go/users/snapshot": {
+		Summary:     "Dump every user as NDJSON",
+		Description: "The first line is the snapshot metadata, every other line a user. Each request is consistent as of its snapshot_at. Resume an interrupted dump with after_id: its rows are read as they are at that point, marked resumed, so keep the cursor of the first part and replay the change feed from it, skipping changes with a version not above the stored row's.",
+		Query:       []openapi.Parameter{queryParam("after_id", "integer", "resume after this id")},
+		ContentType: "application/x-ndjson",
+		Errors:      []int{http.StatusServiceUnavailable},
+	},
+	"GET /api/go/users/events": {
+		Summary:     "Stream user changes as Server-Sent Events",
+		Description: "Events are user.created, user.updated and user.deleted with the user as data. Reconnect with Last-Event-ID to get missed events; a resync event means they are gone and the list should be reloaded.",
+		Headers:     []openapi.Parameter{{Name: "Last-Event-ID", In: "header", Description: "id of the last event received", Schema: &openapi.Schema{Type: "string"}}},
+		ContentType: "text/event-stream",
+		Errors:      []int{http.StatusBadRequest, http.StatusNotImplemented},
+	},
+	"GET /api/go/users/export": {
+		Summary:     "Export the users matching the list filters",
+		Description: "Streams CSV with a header row, or NDJSON, in id order.",
+		Query:       append([]openapi.Parameter{queryEnum("format", "csv by default, or from Accept", "csv", "ndjson")}, listParams[5:]...),
+		ContentType: "text/csv",
+		Errors:      []int{http.StatusForbidden, http.StatusServiceUnavailable},
+	},
+	"GET /api/go/users/{id}": {
+		Summary:  "Get a user",
+		Response: models.User{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"PATCH /api/go/users/{id}": {
+		Summary:     "Update some fields of a user",
+		Description: "A JSON Merge Patch, as application/merge-patch+json or application/json. Fields left out are kept, name and email cannot be null. Versions work as for PUT.",
+		Query:       []openapi.Parameter{dryRunParam},
+		Headers:     []openapi.Parameter{{Name: "If-Match", In: "header", Description: "ETag the update is based on", Schema: &openapi.Schema{Type: "string"}}},
+		Request:     userPatchDoc{},
+		Response:    models.User{},
+		Errors:      []int{http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusUnsupportedMediaType},
+	},
+	"PUT /api/go/users/{id}": {
+		Summary:     "Replace a user",
+		Description: "Send the ETag of the user in If-Match, or its version in the body. A 412 answers with the current user.",
+		Query:       []openapi.Parameter{dryRunParam},
+		Headers:     []openapi.Parameter{{Name: "If-Match", In: "header", Description: "ETag the update is based on", Schema: &openapi.Schema{Type: "string"}}},
+		Request:     models.User{},
+		Response:    models.User{},
+		Errors:      []int{http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+	},
+	"DELETE /api/go/users/{id}": {
+		Summary:     "Delete a user",
+		Description: "Users are soft-deleted and can be restored. Answers 200 with the signed receipt when receipts are configured.",
+		Query:       []openapi.Parameter{dryRunParam, queryParam("hard", "boolean", "erase the row for good, needs the admin API key")},
+		Status:      http.StatusNoContent,
+		Errors:      []int{http.StatusNotFound},
+	},
+	"POST /api/go/users/{id}/restore": {
+		Summary:  "Restore a soft-deleted user",
+		Query:    []openapi.Parameter{dryRunParam},
+		Response: models.User{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"GET /api/go/users/{id}/audit": {
+		Summary:     "History of a user, newest first",
+		Description: "Hard-deleted users keep their history.",
+		Query:       auditParams,
+		Response:    pageOf{models.AuditEntry{}},
+	},
+	"POST /api/go/users/{id}/avatar": {
+		Summary:     "Upload the avatar of a user",
+		Description: "A JPEG, PNG or WebP image in the avatar field of a multipart/form-data body, at most AVATAR_MAX_BYTES. The type is taken from the image itself, not the Content-Type sent.",
+		Query:       []openapi.Parameter{dryRunParam},
+		Upload:      avatarField,
+		Response:    models.User{},
+		Errors:      []int{http.StatusNotFound},
+	},
+	"GET /api/go/users/{id}/avatar": {
+		Summary:     "Avatar of a user",
+		Description: "Links from avatar_url are versioned and cached for good. Users without an avatar get a 404, or a placeholder image with AVATAR_PLACEHOLDER=true.",
+		ContentType: "image/*",
+		Errors:      []int{http.StatusNotFound},
+	},
+	"DELETE /api/go/users/{id}/avatar": {
+		Summary: "Remove the avatar of a user",
+		Query:   []openapi.Parameter{dryRunParam},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"GET /api/go/audit": {
+		Summary:  "Audit log of every change, newest first",
+		Query:    auditParams,
+		Response: pageOf{models.AuditEntry{}},
+	},
+	"GET /api/go/stats/users": {
+		Summary:     "User numbers for the dashboard",
+		Description: "Total users, signups on each UTC day of the window including users deleted since, and the newest users. Cached for STATS_CACHE_TTL.",
+		Query: []openapi.Parameter{
+			queryParam("days", "integer", fmt.Sprintf("days of signups up to today, 30 by default, at most %d", maxStatsDays)),
+			queryParam("newest", "integer", fmt.Sprintf("newest users listed, 5 by default, at most %d", maxStatsNewest)),
+		},
+		Response: UserStats{},
+		Errors:   []int{http.StatusBadRequest},
+	},
+	"POST /api/go/admin/retention/run": {
+		Summary:     "Start a retention sweep now",
+		Description: "Anonymizes or deletes users unchanged for RETENTION_DAYS, in the background. 409 when the sweep is off or already running.",
+		Response:    RetentionStatus{},
+		Status:      http.StatusAccepted,
+		Errors:      []int{http.StatusConflict},
+	},
+	"GET /api/go/admin/retention/status": {
+		Summary:  "Retention settings and the last sweep",
+		Response: RetentionStatus{},
+	},
+	"GET /api/go/admin/faults": {
+		Summary:  "List fault injection rules",
+		Response: []FaultRule{},
+	},
+	"POST /api/go/admin/faults": {
+		Summary:  "Add a fault injection rule",
+		Request:  FaultRule{},
+		Response: FaultRule{},
+		Status:   http.StatusCreated,
+	},
+	"DELETE /api/go/admin/faults/{id}": {
+		Summary: "Remove a fault injection rule",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"GET /healthz": {
+		Summary:  "Liveness",
+		Response: map[string]string{},
+	},
+	"GET /livez": {
+		Summary:  "Liveness",
+		Response: map[string]string{},
+	},
+	"GET /readyz": {
+		Summary:     "Readiness, failing while draining or when the database is down",
+		Description: "The database is pinged with a 2s timeout. While queries still fail on dropped connections the status is degraded but the probe passes.",
+		Response:    map[string]interface{}{},
+		Errors:      []int{http.StatusServiceUnavailable},
+	},
+	"GET /api/go/health": {
+		Summary:  "Health of the service and its dependencies",
+		Response: map[string]interface{}{},
+		Errors:   []int{http.StatusServiceUnavailable},
+	},
+	"GET /api/go/.well-known/receipts-key": {
+		Summary:  "Public keys deletion receipts are signed with",
+		Response: map[string]interface{}{},
+	},
+	"GET /api/go/admin/receipts/{id}": {
+		Summary:  "Get a deletion receipt",
+		Response: SignedReceipt{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"POST /api/go/admin/growth/override": {
+		Summary:  "Lift the row limit for a while",
+		Request:  map[string]int{},
+		Response: map[string]interface{}{},
+	},
+	"GET /api/go/admin/shapes": {
+		Summary:  "How often each request body shape is used",
+		Response: map[string]int64{},
+	},
+	"GET /api/go/docs/examples": {
+		Summary:  "Request and response examples, by route",
+		Response: map[string][]Example{},
+	},
+	"GET /api/go/openapi.json": {
+		Summary:  "This document",
+		Response: map[string]interface{}{},
+	},
+	"GET /api/go/docs": {
+		Summary:     "API reference page",
+		ContentType: "text/html",
+	},
+	"GET /api/versions": {
+		Summary:     "Versions of the API",
+		Description: "Each version with its prefix and status. Deprecated versions have a sunset date after which they are removed.",
+		Response:    map[string][]APIVersion{},
+	},
+	"GET /api/go/routes": {
+		Summary:  "Every route with its middleware stack",
+		Response: []RouteInfo{},
+	},
+}
+
+// Routes without an entry in routeDocs
+func UndocumentedRoutes(routes *RouteTable) []string {
+	missing := []string{}
+	for _, route := range routes.Routes() {
+		if _, ok := routeDocs[docKey(route.Method, route.Path)]; !ok {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	return missing
+}
+
+// Warn about routes the OpenAPI document only knows the path of
+func LogUndocumentedRoutes(routes *RouteTable) {
+	for _, route := range UndocumentedRoutes(routes) {
+		log.Printf("Warning: route %s is missing from the OpenAPI document", route)
+	}
+}
+
+// Build the OpenAPI document of every registered route
+func BuildOpenAPI(routes *RouteTable, serverURL string) *openapi.Document {
+	doc := openapi.New("Users API", Version)
+	doc.Servers = []openapi.Server{{URL: serverURL}}
+	if models.IdsAsStrings {
+		doc.Override(models.ID(0), &openapi.Schema{Type: "string", Description: "user id, a decimal string"})
+	} else {
+		doc.Override(models.ID(0), &openapi.Schema{Type: "integer", Format: "int64"})
+	}
+	doc.Components.SecuritySchemes["bearer"] = &openapi.SecurityScheme{Type: "http", Scheme: "bearer"}
+	doc.Components.SecuritySchemes["apiKey"] = &openapi.SecurityScheme{Type: "apiKey", Name: "X-API-Key", In: "header"}
+	errorSchema := doc.SchemaOf(ErrorResponse{})
+	problemSchema := doc.SchemaOf(ProblemDetails{})
+
+	examples := Examples.All()
+	for _, route := range routes.Routes() {
+		key := docKey(route.Method, route.Path)
+		rd, ok := routeDocs[key]
+		if !ok {
+			rd.Summary = "Undocumented"
+		}
+		method := route.Method
+		if method == "*" {
+			method = http.MethodGet
+		}
+
+		op := &openapi.Operation{
+			Summary:     rd.Summary,
+			Description: rd.Description,
+			Tags:        []string{routeTag(route.Path)},
+			Responses:   map[string]*openapi.Response{},
+		}
+		for _, name := range pathParams(route.Path) {
+			op.Parameters = append(op.Parameters, openapi.Parameter{Name: name, In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}})
+		}
+		op.Parameters = append(append(op.Parameters, rd.Query...), rd.Headers...)
+
+		errs := append([]int{}, rd.Errors...)
+		if isAPIPath(route.Path) {
+			errs = append(errs, http.StatusTooManyRequests, http.StatusInternalServerError)
+		}
+		if len(pathParams(route.Path)) > 0 {
+			errs = append(errs, http.StatusBadRequest)
+		}
+		if rd.Request != nil {
+			errs = append(errs, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType)
+			op.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+				"application/json": {Schema: doc.SchemaOf(rd.Request)},
+			}}
+		}
+		if rd.Upload != "" {
+			errs = append(errs, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType)
+			op.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+				"multipart/form-data": {Schema: &openapi.Schema{
+					Type:       "object",
+					Properties: map[string]*openapi.Schema{rd.Upload: {Type: "string", Format: "binary"}},
+					Required:   []string{rd.Upload},
+				}},
+			}}
+		}
+		for _, mw := range route.Middleware {
+			switch mw {
+			case "admin":
+				op.Security = []map[string][]string{{"apiKey": {}}}
+				errs = append(errs, http.StatusUnauthorized, http.StatusForbidden)
+			case "auth":
+				op.Security = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+				errs = append(errs, http.StatusUnauthorized, http.StatusForbidden)
+			case "auth_reads":
+				op.Security = []map[string][]string{{"bearer": {}}, {"apiKey": {}}, {}}
+				errs = append(errs, http.StatusUnauthorized)
+			case "heavy_admission":
+				errs = append(errs, http.StatusServiceUnavailable)
+			case "idempotency":
+				op.Parameters = append(op.Parameters, openapi.Parameter{Name: "Idempotency-Key", In: "header", Description: "retries with the same key get the first response back", Schema: &openapi.Schema{Type: "string"}})
+				errs = append(errs, http.StatusConflict, http.StatusUnprocessableEntity)
+			case "deprecated":
+				op.Deprecated = true
+			}
+		}
+
+		status := rd.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := &openapi.Response{Description: http.StatusText(status)}
+		switch {
+		case rd.ContentType != "":
+			success.Content = map[string]*openapi.MediaType{rd.ContentType: {Schema: &openapi.Schema{Type: "string"}}}
+		case rd.Response != nil:
+			success.Content = map[string]*openapi.MediaType{"application/json": {Schema: responseSchema(doc, rd.Response)}}
+		}
+		op.Responses[strconv.Itoa(status)] = success
+		for _, code := range errs {
+			op.Responses[strconv.Itoa(code)] = &openapi.Response{
+				Description: http.StatusText(code),
+				Content:     map[string]*openapi.MediaType{"application/json": {Schema: errorSchema}, "application/problem+json": {Schema: problemSchema}},
+			}
+		}
+
+		attachExamples(op, examples[key])
+		doc.AddOperation(route.Path, method, op)
+	}
+	return doc
+}
+
+// Schema of a success body, with pages of a list spelled out
+func responseSchema(doc *openapi.Document, v interface{}) *openapi.Schema {
+	page, ok := v.(pageOf)
+	if !ok {
+		return doc.SchemaOf(v)
+	}
+	integer := &openapi.Schema{Type: "integer"}
+	return &openapi.Schema{
+		Type: "object",
+		Properties: map[string]*openapi.Schema{
+			"data":          {Type: "array", Items: doc.SchemaOf(page.item)},
+			"total":         integer,
+			"limit":         integer,
+			"offset":        integer,
+			"page":          integer,
+			"per_page":      integer,
+			"next_after_id": doc.SchemaOf(models.ID(0)),
+		},
+		Required: []string{"data", "total", "limit", "offset", "page", "per_page"},
+	}
+}
+
+// Put the registered examples of a route on its request and responses
+// Examples for statuses the route does not declare add the status.
+func attachExamples(op *openapi.Operation, examples []Example) {
+	for i, ex := range examples {
+		name := "example" + strconv.Itoa(i+1)
+		if ex.Request != nil && op.RequestBody != nil {
+			media := op.RequestBody.Content["application/json"]
+			if media.Examples == nil {
+				media.Examples = map[string]*openapi.Example{}
+			}
+			media.Examples[name] = &openapi.Example{Summary: ex.Name, Value: ex.Request}
+		}
+		if ex.Response == nil {
+			continue
+		}
+
+		code := strconv.Itoa(ex.Status)
+		resp, ok := op.Responses[code]
+		if !ok {
+			resp = &openapi.Response{Description: http.StatusText(ex.Status)}
+			op.Responses[code] = resp
+		}
+		if resp.Content == nil {
+			resp.Content = map[string]*openapi.MediaType{"application/json": {}}
+		}
+		media, ok := resp.Content["application/json"]
+		if !ok {
+			continue
+		}
+		if media.Examples == nil {
+			media.Examples = map[string]*openapi.Example{}
+		}
+		media.Examples[name] = &openapi.Example{Summary: ex.Name, Value: ex.Response}
+	}
+}
+
+// Names of the {variables} of a route template
+func pathParams(path string) []string {
+	names := []string{}
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			name, _, _ := strings.Cut(part[1:len(part)-1], ":")
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Key of a route in routeDocs and Examples
+// Versions serving the same handlers share the docs written for /api/go.
+func docKey(method, path string) string {
+	if rest, ok := strings.CutPrefix(path, "/api/v1/"); ok {
+		path = "/api/go/" + rest
+	}
+	return method + " " + path
+}
+
+// Tag grouping a route, the section after the version prefix or probes
+// outside the API
+func routeTag(path string) string {
+	if path == "/api/versions" {
+		return "versions"
+	}
+	for _, prefix := range apiPrefixes {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			section, _, _ := strings.Cut(rest, "/")
+			return strings.TrimPrefix(section, ".")
+		}
+	}
+	return "probes"
+}
+
+// Serve the OpenAPI document of the routes
+func OpenAPIHandler(routes *RouteTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := publicPrefix(r)
+		if server == "" {
+			server = "/"
+		}
+		writeJSON(w, http.StatusOK, BuildOpenAPI(routes, server))
+	}
+}
+
+// Reference page rendering the OpenAPI document with Redoc
+func DocsPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Users API</title>
+</head>
+<body>
+<redoc spec-url="%s"></redoc>
+<script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`, html.EscapeString(publicURL(r, "/api/go/openapi.json")))
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Actions recorded for users
+var auditActions = map[string]bool{
+	"create":      true,
+	"update":      true,
+	"delete":      true,
+	"hard_delete": true,
+	"restore":     true,
+	"anonymize":   true,
+}
+
+// Audit entry for a change to a user, nil before for creates and nil after
+// for hard deletes
+// Values are the user as the API serves it, so a password hash can never end
+// up in the log.
+func userAuditEntry(r *http.Request, action string, before, after *models.User) (models.AuditEntry, error) {
+	return auditEntryBy(requestActor(r), requestID(r.Context()), action, before, after)
+}
+
+// Audit entry for a change made outside a request, or by a given actor
+func auditEntryBy(actor, requestId, action string, before, after *models.User) (models.AuditEntry, error) {
+	entry := models.AuditEntry{
+		Entity:    "users",
+		Action:    action,
+		Actor:     actor,
+		RequestId: requestId,
+	}
+	var err error
+	if before != nil {
+		entry.EntityId = before.Id
+		if entry.OldValue, err = json.Marshal(before); err != nil {
+			return entry, err
+		}
+	}
+	if after != nil {
+		entry.EntityId = after.Id
+		if entry.NewValue, err = json.Marshal(after); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// Record a change to a user, in the transaction that made it
+func recordUserChange(ctx context.Context, r *http.Request, audit store.AuditStore, action string, before, after *models.User) error {
+	entry, err := userAuditEntry(r, action, before, after)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, entry)
+}
+
+// Read ?limit, ?offset, ?action, ?actor, ?since and ?until
+func parseAuditParams(query url.Values) (store.AuditListOptions, error) {
+	opts := store.AuditListOptions{
+		Limit:  defaultPageLimit,
+		Action: query.Get("action"),
+		Actor:  query.Get("actor"),
+	}
+	if opts.Action != "" && !auditActions[opts.Action] {
+		return opts, errors.New("action must be one of create, update, delete, hard_delete, restore or anonymize")
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		opts.Limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("offset must be a non-negative integer")
+		}
+		opts.Offset = n
+	}
+	if v := query.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return opts, errors.New("since must be an RFC 3339 time")
+		}
+		opts.Since = t
+	}
+	if v := query.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return opts, errors.New("until must be an RFC 3339 time")
+		}
+		opts.Until = t
+	}
+	return opts, nil
+}
+
+// Serve a page of the audit log, newest first
+func writeAuditPage(w http.ResponseWriter, r *http.Request, audit store.AuditStore, opts store.AuditListOptions) {
+	entries, total, err := audit.List(r.Context(), opts)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(data, total, opts.Limit, opts.Offset))
+}
+
+// Get the audit log, filtered by ?action, ?actor and a ?since/?until range
+func GetAuditLog(audit store.AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseAuditParams(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAuditPage(w, r, audit, opts)
+	}
+}
+
+// Get the history of one user, hard-deleted ones included
+func GetUserAudit(audit store.AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userIdVar(w, r)
+		if !ok {
+			return
+		}
+		opts, err := parseAuditParams(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Entity = "users"
+		opts.EntityId = id
+		writeAuditPage(w, r, audit, opts)
+	}
+}
@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Password length limits, bcrypt ignores anything past 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// Reasons a bearer token is refused
+var (
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+)
+
+// Header of every token, the only algorithm accepted
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// Claims carried by a token
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// HS256 JWTs for users that logged in, with refresh tokens to get new ones
+type Auth struct {
+	secret      []byte
+	ttl         time.Duration
+	refreshTTL  time.Duration
+	publicReads bool
+	cost        int
+	tokens      store.RefreshTokenStore
+
+	dummyOnce sync.Once
+	dummyHash []byte
+}
+
+// Set up tokens from JWT_SECRET, JWT_TTL and JWT_REFRESH_TTL, 30 days by
+// default
+// Reads stay public unless AUTH_PUBLIC_READS=false. Without a secret nobody
+// can log in and protected routes only accept the admin key.
+func NewAuth(tokens store.RefreshTokenStore) *Auth {
+	a := &Auth{
+		secret:      []byte(os.Getenv("JWT_SECRET")),
+		ttl:         env.Duration("JWT_TTL", time.Hour),
+		refreshTTL:  env.Duration("JWT_REFRESH_TTL", 30*24*time.Hour),
+		publicReads: env.Bool("AUTH_PUBLIC_READS", true),
+		cost:        env.Int("BCRYPT_COST", bcrypt.DefaultCost),
+		tokens:      tokens,
+	}
+	switch {
+	case len(a.secret) == 0:
+		log.Println("Warning: JWT_SECRET is not set, user routes only accept the admin API key")
+	case len(a.secret) < 32:
+		log.Println("Warning: JWT_SECRET is shorter than 32 bytes")
+	}
+	return a
+}
+
+// Sign a token for a user, returning when it expires
+func (a *Auth) Sign(id models.ID, now time.Time) (string, time.Time, error) {
+	expires := now.Add(a.ttl)
+	claims, err := json.Marshal(tokenClaims{
+		Subject:   id.String(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(jwtHeader)) + "." + enc.EncodeToString(claims)
+	return unsigned + "." + enc.EncodeToString(a.mac(unsigned)), expires, nil
+}
+
+// Check a token and return the user it was signed for
+func (a *Auth) Verify(token string, now time.Time) (models.ID, error) {
+	parts := strings.Split(token, ".")
+	if len(a.secret) == 0 || len(parts) != 3 {
+		return 0, errTokenInvalid
+	}
+
+	enc := base64.RawURLEncoding
+	signature, err := enc.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, a.mac(parts[0]+"."+parts[1])) {
+		return 0, errTokenInvalid
+	}
+	// Only after the signature, so a forged header cannot pick the algorithm
+	header, err := enc.DecodeString(parts[0])
+	if err != nil || string(header) != jwtHeader {
+		return 0, errTokenInvalid
+	}
+
+	var claims tokenClaims
+	body, err := enc.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(body, &claims) != nil {
+		return 0, errTokenInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return 0, errTokenExpired
+	}
+	id, err := models.ParseID(claims.Subject)
+	if err != nil {
+		return 0, errTokenInvalid
+	}
+	return id, nil
+}
+
+// Purge expired refresh tokens every interval until ctx is done
+func (a *Auth) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := a.tokens.Purge(ctx)
+				if err != nil {
+					log.Printf("Error purging refresh tokens: %v", err)
+				} else if purged > 0 {
+					log.Printf("Purged %d expired refresh tokens", purged)
+				}
+			}
+		}
+	}()
+}
+
+// A new random refresh token and the hash it is stored under
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign an access token and wrap it with its refresh token
+func (a *Auth) tokenResponse(id models.ID, refresh string, now time.Time) (TokenResponse, error) {
+	token, expires, err := a.Sign(id, now)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return TokenResponse{
+		Token:            token,
+		TokenType:        "Bearer",
+		ExpiresAt:        expires.UTC().Format(time.RFC3339),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: now.Add(a.refreshTTL).UTC().Format(time.RFC3339),
+		UserId:           id,
+	}, nil
+}
+
+func (a *Auth) mac(unsigned string) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(unsigned))
+	return m.Sum(nil)
+}
+
+// Hash compared against when an email is unknown, so both cases take as long
+func (a *Auth) dummy() []byte {
+	a.dummyOnce.Do(func() {
+		b := make([]byte, 16)
+		rand.Read(b)
+		a.dummyHash, _ = bcrypt.GenerateFromPassword(b, a.cost)
+	})
+	return a.dummyHash
+}
+
+type authUserKey struct{}
+
+// User a request was authenticated as, false for admin or anonymous requests
+func authUserID(ctx context.Context) (models.ID, bool) {
+	id, ok := ctx.Value(authUserKey{}).(models.ID)
+	return id, ok
+}
+
+// Refuse a request without a usable token
+func writeUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	writeJSONError(w, http.StatusUnauthorized, msg)
+}
+
+// Auth middleware
+// Needs a valid Authorization: Bearer token and puts its user in the
+// request context. Requests with the admin API key also pass. Which users a
+// token can change is up to the handlers, see ownUserIdVar.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(a.secret) == 0 {
+			writeJSONError(w, http.StatusForbidden, "authentication is not configured")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeUnauthorized(w, "missing bearer token")
+			return
+		}
+		id, err := a.Verify(token, time.Now())
+		if err != nil {
+			writeUnauthorized(w, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authUserKey{}, id)))
+	})
+}
+
+// Auth middleware for reads, a no-op while AUTH_PUBLIC_READS is on
+func (a *Auth) ReadMiddleware(next http.Handler) http.Handler {
+	if a.publicReads {
+		return next
+	}
+	return a.Middleware(next)
+}
+
+// Check a password, returning a field error
+func validatePassword(password string) string {
+	switch {
+	case len([]rune(password)) < minPasswordLength:
+		return "too short"
+	case len(password) > maxPasswordBytes:
+		return "too long"
+	}
+	return ""
+}
+
+// Body of a registration
+type RegisterRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Body of a login
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Body of a token refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Tokens handed out by a login or a refresh
+// The refresh token can be used once, for the next pair of tokens.
+type TokenResponse struct {
+	Token            string    `json:"token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresAt        string    `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt string    `json:"refresh_expires_at"`
+	UserId           models.ID `json:"user_id"`
+}
+
+// Create a user with a password they can log in with
+func Register(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub, growth *GrowthGuard, emails *EmailPolicy, auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !growth.Allow() {
+			writeCapacityLimit(w, growth.Table)
+			return
+		}
+
+		var body RegisterRequest
+		if err := decodeJSONBody(r, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		user := models.User{Name: body.Name, Email: body.Email}
+		errs := validateUser(&user)
+		if reason := validatePassword(body.Password); reason != "" {
+			if errs == nil {
+				errs = FieldErrors{}
+			}
+			errs["password"] = reason
+		}
+		if errs != nil {
+			writeFieldErrors(w, errs)
+			return
+		}
+		if reason := emails.Check(user.Email); reason != "" {
+			writeEmailNotAllowed(w, reason)
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), auth.cost)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		plan, err := runWrite(r.Context(), r, txs, "register_user", func(tx store.Tx, plan *DryRunPlan) error {
+			var err error
+			user, err = tx.Credentials.CreateWithPassword(r.Context(), user, string(hash))
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", user.Id)
+			return recordUserChange(r.Context(), r, tx.Audit, "create", nil, &user)
+		})
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "email_taken", "email already exists")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		growth.Added(1)
+		countUserOperation("create", 1)
+		cache.Invalidate(context.WithoutCancel(r.Context()), "users:")
+		userCache.Invalidate(context.WithoutCancel(r.Context()), user.Id)
+		events.Publish(EventUserCreated, user)
+
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+// Exchange an email and password for a token
+func Login(users store.CredentialStore, auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(auth.secret) == 0 {
+			writeJSONError(w, http.StatusForbidden, "authentication is not configured")
+			return
+		}
+
+		var body LoginRequest
+		if err := decodeJSONBody(r, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		id, hash, err := users.PasswordHash(r.Context(), strings.TrimSpace(body.Email))
+		if errors.Is(err, store.ErrNotFound) {
+			bcrypt.CompareHashAndPassword(auth.dummy(), []byte(body.Password))
+			writeUnauthorized(w, "invalid email or password")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(body.Password)) != nil {
+			writeUnauthorized(w, "invalid email or password")
+			return
+		}
+
+		now := time.Now()
+		refresh, hash, err := newRefreshToken()
+		if err == nil {
+			// The family is named after its first token
+			err = auth.tokens.Create(r.Context(), id, hash, hash, now.Add(auth.refreshTTL))
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		response, err := auth.tokenResponse(id, refresh, now)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// Exchange a refresh token for a new access and refresh token
+// Each refresh token works once. Using one again revokes every token
+// descended from the same login, as it was likely stolen.
+func Refresh(auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(auth.secret) == 0 {
+			writeJSONError(w, http.StatusForbidden, "authentication is not configured")
+			return
+		}
+
+		var body RefreshRequest
+		if err := decodeJSONBody(r, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if body.RefreshToken == "" {
+			writeFieldErrors(w, FieldErrors{"refresh_token": "required"})
+			return
+		}
+
+		now := time.Now()
+		refresh, hash, err := newRefreshToken()
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		id, err := auth.tokens.Rotate(r.Context(), hashRefreshToken(body.RefreshToken), hash, now.Add(auth.refreshTTL))
+		switch {
+		case errors.Is(err, store.ErrRefreshTokenReused):
+			logRequest(r, slog.LevelWarn, "Refresh token of user %s was used twice, revoking its family", id)
+			writeUnauthorized(w, err.Error())
+			return
+		case errors.Is(err, store.ErrRefreshTokenInvalid):
+			writeUnauthorized(w, err.Error())
+			return
+		case err != nil:
+			serverError(w, r, err)
+			return
+		}
+		response, err := auth.tokenResponse(id, refresh, now)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
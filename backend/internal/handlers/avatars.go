@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Form field an avatar is uploaded in
+const avatarField = "avatar"
+
+// Room for the multipart boundaries and headers around the image
+const multipartOverhead = 16 << 10
+
+// Served with AVATAR_PLACEHOLDER=true for users without an avatar
+const avatarPlaceholder = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64"><rect width="64" height="64" fill="#d4d4d8"/><circle cx="32" cy="24" r="12" fill="#a1a1aa"/><path d="M10 60c2-13 11-20 22-20s20 7 22 20z" fill="#a1a1aa"/></svg>`
+
+// Image types avatars may have, by their leading bytes
+// The Content-Type a client sends is never trusted.
+func sniffImage(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	}
+	return ""
+}
+
+// Storage key of an avatar, from the user id and image hash only
+func avatarKey(id models.ID, avatar models.Avatar) string {
+	return "avatar-" + id.String() + "-" + avatar.ETag
+}
+
+// Remove a stored avatar that nothing points at anymore
+func removeAvatar(ctx context.Context, uploads store.Storage, id models.ID, avatar *models.Avatar) {
+	if avatar == nil {
+		return
+	}
+	if err := uploads.Delete(ctx, avatarKey(id, *avatar)); err != nil {
+		log.Printf("Error removing avatar of user %s: %v", id, err)
+	}
+}
+
+// Read the image in the avatar field of a multipart body
+// Refuses images over limit bytes and anything but JPEG, PNG or WebP.
+func readAvatar(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, &RequestBodyError{Status: http.StatusUnsupportedMediaType, Code: "unsupported_media_type", Message: "Content-Type must be multipart/form-data"}
+	}
+	tooLarge := &RequestBodyError{Status: http.StatusRequestEntityTooLarge, Code: "avatar_too_large", Message: fmt.Sprintf("avatar must be at most %d bytes", limit)}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return nil, &RequestBodyError{Code: "invalid_multipart", Message: err.Error()}
+	}
+	for {
+		part, err := parts.NextPart()
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return nil, tooLarge
+		}
+		if err == io.EOF {
+			return nil, &RequestBodyError{Code: "avatar_missing", Message: "the form has no " + avatarField + " file"}
+		}
+		if err != nil {
+			return nil, &RequestBodyError{Code: "invalid_multipart", Message: "invalid multipart body"}
+		}
+		if part.FormName() != avatarField {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		if errors.As(err, &maxBytes) || int64(len(data)) > limit {
+			return nil, tooLarge
+		}
+		if err != nil {
+			return nil, &RequestBodyError{Code: "invalid_multipart", Message: "invalid multipart body"}
+		}
+		if len(data) == 0 {
+			return nil, &RequestBodyError{Code: "avatar_missing", Message: "the " + avatarField + " file is empty"}
+		}
+		return data, nil
+	}
+}
+
+// Whether an If-None-Match header lists etag
+func etagListed(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Upload the avatar of a user as multipart/form-data
+// Images are capped at AVATAR_MAX_BYTES, 2MB by default. Answers with the
+// user and its new avatar_url.
+func UploadAvatar(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub, uploads store.Storage) http.HandlerFunc {
+	limit := int64(env.Int("AVATAR_MAX_BYTES", 2<<20))
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := ownUserIdVar(w, r)
+		if !ok {
+			return
+		}
+		data, err := readAvatar(w, r, limit)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		contentType := sniffImage(data)
+		if contentType == "" {
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported_image", "avatar must be a JPEG, PNG or WebP image")
+			return
+		}
+		sum := sha256.Sum256(data)
+		avatar := models.Avatar{ETag: hex.EncodeToString(sum[:16]), ContentType: contentType}
+
+		var before, user models.User
+		found := true
+		stored := false
+		plan, err := runWrite(r.Context(), r, txs, "upload_avatar", func(tx store.Tx, plan *DryRunPlan) error {
+			var err error
+			before, err = tx.Users.Lock(r.Context(), id)
+			if errors.Is(err, store.ErrNotFound) || before.DeletedAt != nil {
+				found = false
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// Stored before the row points at it, under a key of its own so
+			// the previous avatar is served until the commit
+			if !plan.DryRun {
+				if err := uploads.Put(r.Context(), avatarKey(id, avatar), data); err != nil {
+					return err
+				}
+				stored = true
+			}
+			user, err = tx.Users.SetAvatar(r.Context(), id, &avatar)
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", id)
+			return recordUserChange(r.Context(), r, tx.Audit, "update", &before, &user)
+		})
+		unchanged := before.Avatar != nil && *before.Avatar == avatar
+		if err != nil {
+			if stored && !unchanged {
+				removeAvatar(context.WithoutCancel(r.Context()), uploads, id, &avatar)
+			}
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		countUserOperation("upload_avatar", 1)
+		sideCtx := context.WithoutCancel(r.Context())
+		if !unchanged {
+			removeAvatar(sideCtx, uploads, id, before.Avatar)
+		}
+		cache.Invalidate(sideCtx, "users:")
+		userCache.Invalidate(sideCtx, id)
+		events.Publish(EventUserUpdated, user)
+
+		writeUser(w, http.StatusOK, user)
+	}
+}
+
+// Serve the avatar of a user
+// Versioned links from avatar_url are cached for good, the bare path is
+// revalidated with its ETag. Users without one get a 404, or a placeholder
+// image with AVATAR_PLACEHOLDER=true.
+func GetAvatar(users store.UserStore, userCache *UserCache, uploads store.Storage) http.HandlerFunc {
+	placeholder := env.Bool("AVATAR_PLACEHOLDER", false)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userIdVar(w, r)
+		if !ok {
+			return
+		}
+		user, err := userCache.Get(id, func() (models.User, error) {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), userLoadTimeout)
+			defer cancel()
+			return users.Get(ctx, id)
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if user.Avatar == nil {
+			if !placeholder {
+				writeJSONError(w, http.StatusNotFound, "user has no avatar")
+				return
+			}
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Header().Set("Cache-Control", "private, no-cache")
+			w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+			w.Write([]byte(avatarPlaceholder))
+			return
+		}
+
+		etag := `"` + user.Avatar.ETag + `"`
+		w.Header().Set("ETag", etag)
+		if r.URL.Query().Get("v") == user.Avatar.ETag {
+			w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "private, no-cache")
+		}
+		if etagListed(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		data, err := uploads.Get(r.Context(), avatarKey(id, *user.Avatar))
+		if errors.Is(err, store.ErrUploadNotFound) {
+			logRequest(r, slog.LevelWarn, "Avatar %s of user %s is missing from the upload storage", user.Avatar.ETag, id)
+			writeJSONError(w, http.StatusNotFound, "user has no avatar")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", user.Avatar.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}
+}
+
+// Remove the avatar of a user
+func DeleteAvatar(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub, uploads store.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := ownUserIdVar(w, r)
+		if !ok {
+			return
+		}
+
+		var before, user models.User
+		plan, err := runWrite(r.Context(), r, txs, "delete_avatar", func(tx store.Tx, plan *DryRunPlan) error {
+			var err error
+			before, err = tx.Users.Lock(r.Context(), id)
+			if err != nil || before.DeletedAt != nil || before.Avatar == nil {
+				return err
+			}
+			user, err = tx.Users.SetAvatar(r.Context(), id, nil)
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", id)
+			return recordUserChange(r.Context(), r, tx.Audit, "update", &before, &user)
+		})
+		if errors.Is(err, store.ErrNotFound) || err == nil && before.DeletedAt != nil {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		if before.Avatar == nil {
+			writeJSONError(w, http.StatusNotFound, "user has no avatar")
+			return
+		}
+		countUserOperation("delete_avatar", 1)
+		sideCtx := context.WithoutCancel(r.Context())
+		removeAvatar(sideCtx, uploads, id, before.Avatar)
+		cache.Invalidate(sideCtx, "users:")
+		userCache.Invalidate(sideCtx, id)
+		events.Publish(EventUserUpdated, user)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
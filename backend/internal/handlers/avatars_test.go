@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Test server with the avatar routes, keeping uploads in a temporary
+// directory and taking images of up to limit bytes
+func newAvatarServer(t *testing.T, limit string) *testServer {
+	t.Helper()
+	t.Setenv("AVATAR_MAX_BYTES", limit)
+	uploads, err := store.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t)
+	cache, userCache, events := NewResponseCache(time.Minute, 0, 100), NewUserCache(), NewEventHub()
+	t.Cleanup(events.Close)
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}/avatar", UploadAvatar(s.store, cache, userCache, events, uploads)).Methods("POST")
+	router.HandleFunc("/users/{id}/avatar", GetAvatar(s.store, userCache, uploads)).Methods("GET")
+	router.NotFoundHandler = s.handler
+	s.handler = router
+	return s
+}
+
+// Encoded PNG of a small image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Upload data as the file in field, sent with the given Content-Type
+func (s *testServer) uploadAvatar(t *testing.T, path, field, contentType string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="avatar"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	r := httptest.NewRequest("POST", path, &body)
+	r.Header.Set("X-API-Key", testAdminKey)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestUploadAvatar(t *testing.T) {
+	s := newAvatarServer(t, "4096")
+	path := "/users/" + s.createUser(t, "Ada", "ada@example.com").Id.String() + "/avatar"
+	picture := testPNG(t)
+
+	if w := s.uploadAvatar(t, path, "avatar", "image/jpeg", picture); w.Code != http.StatusOK {
+		t.Fatalf("upload of a PNG = %d %s, want 200", w.Code, w.Body)
+	}
+	// Served as what the bytes are, not what the client called them
+	w := s.do(t, "GET", path, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), picture) {
+		t.Fatalf("GET avatar = %d %s, %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+}
+
+func TestUploadAvatarRefused(t *testing.T) {
+	tests := []struct {
+		name        string
+		field       string
+		contentType string
+		data        []byte
+		status      int
+		code        string
+	}{
+		{"text called a PNG", "avatar", "image/png", []byte("just some text, not an image"), http.StatusUnsupportedMediaType, "unsupported_image"},
+		{"PNG header only", "avatar", "image/png", []byte("\x89PNG"), http.StatusUnsupportedMediaType, "unsupported_image"},
+		{"over the limit", "avatar", "image/png", append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 4096)...), http.StatusRequestEntityTooLarge, "avatar_too_large"},
+		{"far over the limit", "avatar", "image/png", make([]byte, 1<<20), http.StatusRequestEntityTooLarge, "avatar_too_large"},
+		{"another field", "picture", "image/png", nil, http.StatusBadRequest, "avatar_missing"},
+		{"empty file", "avatar", "image/png", []byte{}, http.StatusBadRequest, "avatar_missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAvatarServer(t, "4096")
+			path := "/users/" + s.createUser(t, "Ada", "ada@example.com").Id.String() + "/avatar"
+			data := tt.data
+			if tt.field != "avatar" {
+				data = testPNG(t)
+			}
+			w := s.uploadAvatar(t, path, tt.field, tt.contentType, data)
+			if w.Code != tt.status || errorCode(t, w) != tt.code {
+				t.Fatalf("upload = %d %s, want %d %s", w.Code, w.Body, tt.status, tt.code)
+			}
+			if w := s.do(t, "GET", path, ""); w.Code != http.StatusNotFound {
+				t.Fatalf("GET avatar after a refused upload = %d, want 404", w.Code)
+			}
+		})
+	}
+
+	s := newAvatarServer(t, "4096")
+	path := "/users/" + s.createUser(t, "Ada", "ada@example.com").Id.String() + "/avatar"
+	if w := s.do(t, "POST", path, `{"avatar":"x"}`); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("upload as JSON = %d %s, want 415", w.Code, w.Body)
+	}
+}
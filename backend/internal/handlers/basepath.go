@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Path prefix the service is mounted under, from BASE_PATH
+// Normalised to a leading slash and no trailing one, empty when unset.
+func BasePath() string {
+	p := strings.Trim(os.Getenv("BASE_PATH"), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// Prefix clients reach us under, preferring what the proxy reports
+func publicPrefix(r *http.Request) string {
+	if prefix := strings.TrimRight(r.Header.Get("X-Forwarded-Prefix"), "/"); prefix != "" {
+		return prefix
+	}
+	return BasePath()
+}
+
+// URL a client should use for a path of this service
+// Every generated link goes through here so it respects the mount prefix.
+func publicURL(r *http.Request, path string) string {
+	return publicPrefix(r) + path
+}
+
+// Base path middleware
+// Strips BASE_PATH from incoming paths. Paths without it are served as they
+// are, so probes hitting the pod directly keep working.
+func StripBasePath(next http.Handler) http.Handler {
+	prefix := BasePath()
+	if prefix == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = strings.TrimPrefix(p, prefix)
+			if r2.URL.Path == "" {
+				r2.URL.Path = "/"
+			}
+			r2.URL.RawPath = ""
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Budget shares for handlers that write to the database and then notify other replicas
+const (
+	dbBudgetShare         = 0.8
+	sideEffectBudgetShare = 0.15
+	minSideEffectBudget   = 500 * time.Millisecond
+)
+
+// Split of a request's time between the phases of a handler
+// Each phase gets a share of the time left when the budget was made, so a
+// slow early phase cannot starve the later ones.
+type Budget struct {
+	r     *http.Request
+	total time.Duration
+}
+
+// Budget for a request, using its deadline or REQUEST_BUDGET when it has none
+func NewBudget(r *http.Request) *Budget {
+	total := env.Duration("REQUEST_BUDGET", 10*time.Second)
+	if deadline, ok := r.Context().Deadline(); ok {
+		total = time.Until(deadline)
+	}
+	return &Budget{r: r, total: total}
+}
+
+// Context for one phase, with share of the budget but never less than min
+func (b *Budget) Phase(ctx context.Context, share float64, min time.Duration) (context.Context, context.CancelFunc) {
+	d := time.Duration(float64(b.total) * share)
+	if d < min {
+		d = min
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// Log and count when a phase failed because it ran out of budget
+func (b *Budget) Check(phase string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		budgetOverruns.WithLabelValues(metricsRoute(b.r), phase).Inc()
+		logRequest(b.r, slog.LevelWarn, "%s %s exhausted its %s budget (request budget %s)", b.r.Method, routeName(b.r), phase, b.total)
+	}
+	return err
+}
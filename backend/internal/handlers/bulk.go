@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Most users created by one bulk request
+const maxBulkUsers = 1000
+
+// Why one row of a bulk request was rejected
+type RowError struct {
+	Index  int         `json:"index"`
+	Reason string      `json:"reason"`
+	Fields FieldErrors `json:"fields,omitempty"`
+}
+
+// Reject a bulk request because of some of its rows
+func writeRowErrors(w http.ResponseWriter, errs []RowError) {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	writeErrorDetails(w, http.StatusUnprocessableEntity, "invalid_rows", "some rows cannot be created", errs)
+}
+
+// Create users from a JSON array in one transaction
+// Either every row is created or none is; the rows that stopped the batch are
+// reported by index.
+func CreateUsersBulk(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub, growth *GrowthGuard, emails *EmailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !growth.Allow() {
+			writeCapacityLimit(w, growth.Table)
+			return
+		}
+
+		var rows []json.RawMessage
+		if err := decodeJSONBody(r, &rows); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if len(rows) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "at least one user is required")
+			return
+		}
+		if len(rows) > maxBulkUsers {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d users can be created at once", maxBulkUsers))
+			return
+		}
+
+		bypassed := emailPolicyBypassed(r)
+		batch := make([]models.User, len(rows))
+		rowErrs := []RowError{}
+		seen := map[string]int{}
+		for i, row := range rows {
+			if _, err := userShapes.convert(r, row, &batch[i]); err != nil {
+				rowErrs = append(rowErrs, RowError{Index: i, Reason: err.Error()})
+				continue
+			}
+			if errs := validateUser(&batch[i]); errs != nil {
+				rowErrs = append(rowErrs, RowError{Index: i, Reason: "invalid fields", Fields: errs})
+				continue
+			}
+			if first, ok := seen[batch[i].Email]; ok {
+				rowErrs = append(rowErrs, RowError{Index: i, Reason: fmt.Sprintf("email already used by row %d", first)})
+				continue
+			}
+			seen[batch[i].Email] = i
+			if reason := emails.Check(batch[i].Email); reason != "" {
+				if !bypassed {
+					rowErrs = append(rowErrs, RowError{Index: i, Reason: "email_not_allowed: " + reason})
+					continue
+				}
+				logRequest(r, slog.LevelInfo, "AUDIT: email policy (%s) bypassed by admin for %s from %s", reason, batch[i].Email, r.RemoteAddr)
+			}
+		}
+		if len(rowErrs) > 0 {
+			writeRowErrors(w, rowErrs)
+			return
+		}
+
+		var created []models.User
+		budget := NewBudget(r)
+		dbCtx, cancelDB := budget.Phase(r.Context(), dbBudgetShare, 0)
+		defer cancelDB()
+
+		plan, err := runWrite(dbCtx, r, txs, "bulk_create_users", func(tx store.Tx, plan *DryRunPlan) error {
+			var err error
+			created, err = tx.Users.CreateMany(dbCtx, batch)
+			if err != nil {
+				return err
+			}
+			entries := make([]models.AuditEntry, len(created))
+			for i := range created {
+				plan.Touch("users", created[i].Id)
+				if entries[i], err = userAuditEntry(r, "create", nil, &created[i]); err != nil {
+					return err
+				}
+			}
+			return tx.Audit.Record(dbCtx, entries...)
+		})
+		var storeErrs store.RowErrors
+		if errors.As(err, &storeErrs) {
+			for i, err := range storeErrs {
+				rowErrs = append(rowErrs, RowError{Index: i, Reason: err.Error()})
+			}
+			writeRowErrors(w, rowErrs)
+			return
+		}
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "email_taken", "email already exists")
+			return
+		}
+		if budget.Check("db", err) != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		growth.Added(int64(len(created)))
+		countUserOperation("create", len(created))
+		sideCtx, cancelSide := budget.Phase(context.WithoutCancel(r.Context()), sideEffectBudgetShare, minSideEffectBudget)
+		defer cancelSide()
+		budget.Check("side effects", cache.Invalidate(sideCtx, "users:"))
+		userCache.Invalidate(sideCtx, plan.IdsTouched["users"]...)
+		events.Publish(EventUserCreated, created...)
+
+		writeJSON(w, http.StatusOK, created)
+	}
+}
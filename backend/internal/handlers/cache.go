@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Cache states reported in the X-Cache header
+const (
+	CacheHit   = "HIT"
+	CacheStale = "STALE"
+	CacheMiss  = "MISS"
+)
+
+// In-process response cache with stale-while-revalidate
+// Entries are fresh for FreshTTL, then served stale for up to MaxStale while
+// a single background load refreshes them. Past that, requests wait for the load.
+// The least recently used entries are evicted past MaxEntries.
+type ResponseCache struct {
+	FreshTTL   time.Duration
+	MaxStale   time.Duration
+	MaxEntries int
+	Now        func() time.Time
+
+	bus InvalidationBus
+	keyedCache[[]byte]
+}
+
+// Loads the value for a cache key
+type CacheLoader func() ([]byte, error)
+
+// Create a response cache holding at most maxEntries bodies
+func NewResponseCache(freshTTL, maxStale time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		FreshTTL:   freshTTL,
+		MaxStale:   maxStale,
+		MaxEntries: maxEntries,
+		Now:        time.Now,
+		keyedCache: newKeyedCache[[]byte](),
+	}
+}
+
+// Get a cached body, loading it when missing or too stale
+// Returns the body, its age, and the cache state.
+func (c *ResponseCache) Get(key string, load CacheLoader) ([]byte, time.Duration, string, error) {
+	c.mu.Lock()
+	if el, entry, ok := c.lookup(key); ok {
+		c.lru.MoveToFront(el)
+		age := c.Now().Sub(entry.storedAt)
+		if age < c.FreshTTL {
+			c.mu.Unlock()
+			return entry.value, age, CacheHit, nil
+		}
+		if age < c.FreshTTL+c.MaxStale {
+			if call, started := c.join(key); started {
+				go c.runLoad(key, call, load)
+			}
+			c.mu.Unlock()
+			return entry.value, age, CacheStale, nil
+		}
+	}
+
+	call, started := c.join(key)
+	c.mu.Unlock()
+	if started {
+		c.runLoad(key, call, load)
+	} else {
+		<-call.done
+	}
+	return call.value, 0, CacheMiss, call.err
+}
+
+// Run a load and store its result unless the cache was purged meanwhile
+func (c *ResponseCache) runLoad(key string, call *cacheLoad[[]byte], load CacheLoader) {
+	c.run(key, call, load, func(body []byte, err error) {
+		if err != nil {
+			log.Printf("Error refreshing cache key %s: %v", key, err)
+			return
+		}
+		c.store(key, body, c.Now(), c.MaxEntries)
+	})
+}
+
+// Purge a prefix here and on every other replica
+func (c *ResponseCache) Invalidate(ctx context.Context, prefix string) error {
+	c.Purge(prefix)
+	if c.bus == nil {
+		return nil
+	}
+
+	msg := InvalidationMessage{Prefix: prefix, Origin: replicaId, SentAt: time.Now()}
+	err := c.bus.Publish(ctx, msg)
+	if err != nil {
+		log.Printf("Error publishing cache invalidation for %q: %v", prefix, err)
+	}
+	return err
+}
+
+// Drop every entry whose key starts with prefix
+func (c *ResponseCache) Purge(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge(prefix)
+}
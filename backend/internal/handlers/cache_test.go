@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Response cache on a clock the test moves by hand
+func newFakeClockCache(fresh, stale time.Duration, maxEntries int) (*ResponseCache, *time.Time) {
+	now := time.Unix(1_700_000_000, 0)
+	c := NewResponseCache(fresh, stale, maxEntries)
+	c.Now = func() time.Time { return now }
+	return c, &now
+}
+
+// Loader returning the body for its call count, counting calls
+func countingLoader(calls *atomic.Int32) CacheLoader {
+	return func() ([]byte, error) {
+		return []byte(fmt.Sprint(calls.Add(1))), nil
+	}
+}
+
+func TestResponseCacheFreshStaleExpired(t *testing.T) {
+	c, now := newFakeClockCache(5*time.Second, 30*time.Second, 10)
+	var calls atomic.Int32
+	load := countingLoader(&calls)
+
+	if body, _, state, _ := c.Get("k", load); state != CacheMiss || string(body) != "1" {
+		t.Fatalf("first Get = %s %s, want MISS 1", body, state)
+	}
+
+	*now = now.Add(4 * time.Second)
+	body, age, state, _ := c.Get("k", load)
+	if state != CacheHit || string(body) != "1" || age != 4*time.Second {
+		t.Fatalf("fresh Get = %s %s age %s, want HIT 1 age 4s", body, state, age)
+	}
+
+	// Stale entries are served while one load refreshes them in the background
+	*now = now.Add(2 * time.Second)
+	if body, _, state, _ := c.Get("k", load); state != CacheStale || string(body) != "1" {
+		t.Fatalf("stale Get = %s %s, want STALE 1", body, state)
+	}
+	waitForLoads(t, c)
+	if body, _, state, _ := c.Get("k", load); state != CacheHit || string(body) != "2" {
+		t.Fatalf("Get after the refresh = %s %s, want HIT 2", body, state)
+	}
+
+	// Past MaxStale the caller waits for a new load
+	*now = now.Add(time.Minute)
+	if body, _, state, _ := c.Get("k", load); state != CacheMiss || string(body) != "3" {
+		t.Fatalf("expired Get = %s %s, want MISS 3", body, state)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("%d loads, want 3", n)
+	}
+}
+
+// Wait until no load is running
+func waitForLoads(t *testing.T, c *ResponseCache) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		n := len(c.inflight)
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("background load did not finish")
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newFakeClockCache(time.Minute, 0, 2)
+	var calls atomic.Int32
+	load := countingLoader(&calls)
+
+	c.Get("a", load)
+	c.Get("b", load)
+	c.Get("a", load) // b is now the least recently used
+	c.Get("c", load)
+
+	if len(c.entries) != 2 || c.lru.Len() != 2 {
+		t.Fatalf("%d entries, %d in the lru list, want 2", len(c.entries), c.lru.Len())
+	}
+	if _, _, state, _ := c.Get("a", load); state != CacheHit {
+		t.Fatalf("a = %s, want it kept", state)
+	}
+	if _, _, state, _ := c.Get("b", load); state != CacheMiss {
+		t.Fatalf("b = %s, want it evicted", state)
+	}
+}
+
+func TestResponseCachePurge(t *testing.T) {
+	c, _ := newFakeClockCache(time.Minute, 0, 10)
+	var calls atomic.Int32
+	load := countingLoader(&calls)
+	c.Get("users:a", load)
+	c.Get("stats:a", load)
+
+	c.Purge("users:")
+	if _, _, state, _ := c.Get("users:a", load); state != CacheMiss {
+		t.Fatalf("purged key = %s, want MISS", state)
+	}
+	if _, _, state, _ := c.Get("stats:a", load); state != CacheHit {
+		t.Fatalf("key outside the prefix = %s, want HIT", state)
+	}
+	if c.lru.Len() != len(c.entries) {
+		t.Fatalf("lru list has %d entries, map %d", c.lru.Len(), len(c.entries))
+	}
+}
+
+func TestResponseCacheDropsLoadsRacingAPurge(t *testing.T) {
+	c, _ := newFakeClockCache(time.Minute, 0, 10)
+	c.Get("users:a", func() ([]byte, error) {
+		c.Purge("users:")
+		return []byte("old"), nil
+	})
+	if _, _, state, _ := c.Get("users:a", func() ([]byte, error) { return []byte("new"), nil }); state != CacheMiss {
+		t.Fatalf("body loaded across a purge was cached, state %s", state)
+	}
+}
+
+func TestResponseCacheSharesConcurrentLoads(t *testing.T) {
+	c, _ := newFakeClockCache(time.Minute, 0, 10)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func() ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("v"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body, _, _, err := c.Get("k", load); err != nil || string(body) != "v" {
+				t.Errorf("Get = %s, %v", body, err)
+			}
+		}()
+	}
+	// Let every caller find the load before it finishes
+	for {
+		c.mu.Lock()
+		_, loading := c.inflight["k"]
+		c.mu.Unlock()
+		if loading {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d loads for one key, want 1", n)
+	}
+}
+
+// A loader that panics fails its callers and leaves nothing behind
+func TestCacheLoaderPanics(t *testing.T) {
+	c, now := newFakeClockCache(time.Second, time.Minute, 10)
+	release := make(chan struct{})
+	panicking := func() ([]byte, error) {
+		<-release
+		panic("loader is broken")
+	}
+
+	// Callers waiting on the load get its error instead of hanging
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, _, _, err := c.Get("k", panicking)
+			errs <- err
+		}()
+	}
+	for {
+		c.mu.Lock()
+		_, loading := c.inflight["k"]
+		c.mu.Unlock()
+		if loading {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Fatal("Get after a panicking load = nil error")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("caller still waiting on a load that panicked")
+		}
+	}
+	waitForLoads(t, c)
+	if body, _, state, err := c.Get("k", func() ([]byte, error) { return []byte("v"), nil }); err != nil || state != CacheMiss || string(body) != "v" {
+		t.Fatalf("Get after the panic = %s %s %v, want a new load", body, state, err)
+	}
+
+	// A background refresh that panics keeps the stale body
+	*now = now.Add(2 * time.Second)
+	if body, _, state, _ := c.Get("k", func() ([]byte, error) { panic("refresh is broken") }); state != CacheStale || string(body) != "v" {
+		t.Fatalf("stale Get = %s %s, want STALE v", body, state)
+	}
+	waitForLoads(t, c)
+	if body, _, state, _ := c.Get("k", countingLoader(new(atomic.Int32))); state != CacheStale || string(body) != "v" {
+		t.Fatalf("Get after the refresh panicked = %s %s, want STALE v", body, state)
+	}
+
+	users := &UserCache{TTL: time.Minute, MaxEntries: 10, Now: time.Now, keyedCache: newKeyedCache[cachedUser]()}
+	if _, err := users.Get(1, func() (models.User, error) { panic("store is broken") }); err == nil {
+		t.Fatal("user Get with a panicking load = nil error")
+	}
+	if user, err := users.Get(1, func() (models.User, error) { return models.User{Id: 1, Name: "Ada"}, nil }); err != nil || user.Name != "Ada" {
+		t.Fatalf("user Get after the panic = %+v %v, want a new load", user, err)
+	}
+}
+
+func TestUsersListCacheKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  string
+		second string
+		shared bool
+	}{
+		{"same query", "limit=10&sort=name", "limit=10&sort=name", true},
+		{"reordered parameters", "limit=10&sort=name", "sort=name&limit=10", true},
+		{"page and offset", "limit=10&page=3", "limit=10&offset=20", true},
+		{"defaults spelled out", "", "sort=id&order=asc&limit=" + fmt.Sprint(defaultPageLimit), true},
+		{"ignored parameters", "limit=10", "limit=10&utm_source=mail", true},
+		{"filter spellings", "filter[email]=ADA@example.com", "filter[email]=ada@example.com", true},
+		{"other page", "limit=10", "limit=20", false},
+		{"other order", "sort=name", "sort=name&order=desc", false},
+		{"other search", "q=ada", "q=grace", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.createUser(t, "Ada", "ada@example.com")
+
+			if w := s.do(t, "GET", "/users?"+tt.first, ""); w.Code != http.StatusOK || w.Header().Get("X-Cache") != CacheMiss {
+				t.Fatalf("first list = %d %s, X-Cache %s", w.Code, w.Body, w.Header().Get("X-Cache"))
+			}
+			w := s.do(t, "GET", "/users?"+tt.second, "")
+			want := CacheMiss
+			if tt.shared {
+				want = CacheHit
+			}
+			if got := w.Header().Get("X-Cache"); got != want {
+				t.Fatalf("?%s after ?%s: X-Cache %s, want %s", tt.second, tt.first, got, want)
+			}
+		})
+	}
+}
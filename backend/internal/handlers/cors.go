@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Request headers browsers may send and response headers they may read
+// Features register the headers they use while the router is wired up, and
+// the router how to find the methods of a route.
+type CORSHeaderRegistry struct {
+	mu      sync.RWMutex
+	allow   []string
+	expose  []string
+	methods func(r *http.Request) []string
+}
+
+// Headers used by the CORS middleware
+var CORSHeaders = &CORSHeaderRegistry{allow: []string{"Content-Type"}}
+
+// Allow request headers in preflight responses
+func (c *CORSHeaderRegistry) Allow(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.allow = appendHeaderNames(c.allow, names)
+}
+
+// Expose response headers to browser scripts
+func (c *CORSHeaderRegistry) Expose(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expose = appendHeaderNames(c.expose, names)
+}
+
+// Look up the methods of the route a preflight is for
+func (c *CORSHeaderRegistry) RouteMethods(lookup func(r *http.Request) []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.methods = lookup
+}
+
+// Methods of the route of a request, nil before the router is wired up
+func (c *CORSHeaderRegistry) routeMethods(r *http.Request) []string {
+	c.mu.RLock()
+	lookup := c.methods
+	c.mu.RUnlock()
+	if lookup == nil {
+		return nil
+	}
+	return lookup(r)
+}
+
+// Access-Control-Allow-Headers value
+func (c *CORSHeaderRegistry) AllowValue() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return strings.Join(c.allow, ", ")
+}
+
+// Access-Control-Expose-Headers value
+func (c *CORSHeaderRegistry) ExposeValue() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return strings.Join(c.expose, ", ")
+}
+
+// Add header names to a list, skipping ones already present
+func appendHeaderNames(list []string, names []string) []string {
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for _, existing := range list {
+			if existing == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// CORS settings read from the environment
+type CORSConfig struct {
+	// Origins allowed to call the API, nil allows any origin
+	AllowedOrigins map[string]bool
+	// Methods browsers may use cross-origin, on routes that have them
+	AllowedMethods []string
+	// Let browsers send cookies and auth headers cross-origin
+	AllowCredentials bool
+	// Answer Chrome's Private Network Access preflights
+	AllowPrivateNetwork bool
+	// How long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// Methods allowed cross-origin unless CORS_ALLOWED_METHODS says otherwise
+var defaultCORSMethods = []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete}
+
+// Load CORS settings from the environment
+// CORS_ALLOWED_ORIGINS is a comma separated list, unset or "*" allows any
+// origin. CORS_ALLOWED_METHODS narrows the methods from GET, PUT, PATCH,
+// POST and DELETE. CORS_ALLOWED_HEADERS adds request headers to the
+// registered ones.
+func LoadCORSConfig(p *env.Parser) CORSConfig {
+	config := CORSConfig{
+		AllowedMethods:      defaultCORSMethods,
+		AllowCredentials:    p.Bool("CORS_ALLOW_CREDENTIALS", false),
+		AllowPrivateNetwork: p.Bool("CORS_ALLOW_PRIVATE_NETWORK", false),
+		MaxAge:              p.Duration("CORS_MAX_AGE", 10*time.Minute),
+	}
+
+	origins := strings.TrimSpace(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if origins != "" && origins != "*" {
+		config.AllowedOrigins = map[string]bool{}
+		for _, origin := range strings.Split(origins, ",") {
+			origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+			if origin == "" {
+				continue
+			}
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+				p.Invalid("CORS_ALLOWED_ORIGINS entry %q is not an origin like https://example.com", origin)
+				continue
+			}
+			config.AllowedOrigins[origin] = true
+		}
+	}
+	if methods := strings.TrimSpace(os.Getenv("CORS_ALLOWED_METHODS")); methods != "" {
+		config.AllowedMethods = nil
+		for _, method := range strings.Split(methods, ",") {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if method == "" {
+				continue
+			}
+			if !slices.Contains(defaultCORSMethods, method) {
+				p.Invalid("CORS_ALLOWED_METHODS entry %q is not one of GET, PUT, PATCH, POST or DELETE", method)
+				continue
+			}
+			config.AllowedMethods = append(config.AllowedMethods, method)
+		}
+	}
+	// Credentials are never shared with every origin
+	if config.AllowedOrigins == nil && config.AllowCredentials {
+		log.Println("Warning: CORS_ALLOW_CREDENTIALS needs CORS_ALLOWED_ORIGINS, credentials stay disabled")
+		config.AllowCredentials = false
+	}
+
+	if headers := os.Getenv("CORS_ALLOWED_HEADERS"); headers != "" {
+		CORSHeaders.Allow(strings.Split(headers, ",")...)
+	}
+	return config
+}
+
+// Methods a preflight may be granted: the allowed ones the route has
+func (config CORSConfig) preflightMethods(r *http.Request) []string {
+	route := CORSHeaders.routeMethods(r)
+	if route == nil {
+		return config.AllowedMethods
+	}
+	methods := []string{}
+	for _, method := range config.AllowedMethods {
+		if slices.Contains(route, method) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// CORS middleware
+// Wraps the whole router so preflights to any route are answered here
+// without reaching a handler. Requests from origins that are not allowed get
+// no CORS headers, and their preflights a 403. Preflights list the methods
+// of their route only, and a 403 for a method it does not allow.
+func EnableCORS(config CORSConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if config.AllowedOrigins != nil {
+			w.Header().Add("Vary", "Origin")
+			if !config.AllowedOrigins[origin] {
+				if preflight {
+					writeJSONError(w, http.StatusForbidden, "origin not allowed")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		if config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if expose := CORSHeaders.ExposeValue(); expose != "" {
+				w.Header().Set("Access-Control-Expose-Headers", expose)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		methods := config.preflightMethods(r)
+		if !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+			writeJSONError(w, http.StatusForbidden, r.Header.Get("Access-Control-Request-Method")+" is not allowed cross-origin on "+r.URL.Path)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", CORSHeaders.AllowValue())
+		// Preflights may ask for private network access alongside custom
+		// headers, both are answered on the same response
+		if config.AllowPrivateNetwork && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+			w.Header().Set("Access-Control-Allow-Private-Network", "true")
+		}
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+func TestLoadCORSConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		vars        map[string]string
+		origins     map[string]bool
+		methods     []string
+		credentials bool
+		problem     string
+	}{
+		{"defaults", nil, nil, defaultCORSMethods, false, ""},
+		{"wildcard", map[string]string{"CORS_ALLOWED_ORIGINS": "*"}, nil, defaultCORSMethods, false, ""},
+		{"allow-list", map[string]string{"CORS_ALLOWED_ORIGINS": " https://app.example.com/, http://localhost:3000 ,"}, map[string]bool{"https://app.example.com": true, "http://localhost:3000": true}, defaultCORSMethods, false, ""},
+		{"not an origin", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example.com/path"}, map[string]bool{}, defaultCORSMethods, false, "CORS_ALLOWED_ORIGINS"},
+		{"narrowed methods", map[string]string{"CORS_ALLOWED_METHODS": "get, delete"}, nil, []string{"GET", "DELETE"}, false, ""},
+		{"unknown method", map[string]string{"CORS_ALLOWED_METHODS": "GET, TRACE"}, nil, []string{"GET"}, false, "CORS_ALLOWED_METHODS"},
+		{"credentials with an allow-list", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example.com", "CORS_ALLOW_CREDENTIALS": "true"}, map[string]bool{"https://app.example.com": true}, defaultCORSMethods, true, ""},
+		// Credentials are never shared with any origin
+		{"credentials with the wildcard", map[string]string{"CORS_ALLOWED_ORIGINS": "*", "CORS_ALLOW_CREDENTIALS": "true"}, nil, defaultCORSMethods, false, ""},
+		{"credentials without origins", map[string]string{"CORS_ALLOW_CREDENTIALS": "true"}, nil, defaultCORSMethods, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CORS_ALLOWED_ORIGINS", "CORS_ALLOWED_METHODS", "CORS_ALLOW_CREDENTIALS", "CORS_ALLOWED_HEADERS"} {
+				t.Setenv(name, tt.vars[name])
+			}
+			p := &env.Parser{}
+			config := LoadCORSConfig(p)
+			if !reflect.DeepEqual(config.AllowedOrigins, tt.origins) {
+				t.Fatalf("origins = %v, want %v", config.AllowedOrigins, tt.origins)
+			}
+			if !reflect.DeepEqual(config.AllowedMethods, tt.methods) {
+				t.Fatalf("methods = %v, want %v", config.AllowedMethods, tt.methods)
+			}
+			if config.AllowCredentials != tt.credentials {
+				t.Fatalf("credentials = %t, want %t", config.AllowCredentials, tt.credentials)
+			}
+			if err := p.Err(); (tt.problem == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.problem)) {
+				t.Fatalf("problems = %v, want one about %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+// CORS in front of routes with different methods
+func newCORSStack(t *testing.T, config CORSConfig) http.Handler {
+	t.Helper()
+	router := mux.NewRouter()
+	routes := NewRouteTable(router)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	routes.HandleFunc("GET", "/users", ok)
+	routes.HandleFunc("POST", "/users", ok)
+	for _, method := range []string{"GET", "PUT", "PATCH", "DELETE"} {
+		routes.HandleFunc(method, "/users/{id}", ok)
+	}
+	CORSHeaders.RouteMethods(routes.AllowedMethods)
+	t.Cleanup(func() { CORSHeaders.RouteMethods(nil) })
+	return EnableCORS(config, router)
+}
+
+func TestCORS(t *testing.T) {
+	const app, evil = "https://app.example.com", "https://evil.example.com"
+	listed := CORSConfig{AllowedOrigins: map[string]bool{app: true}, AllowedMethods: defaultCORSMethods, AllowCredentials: true, MaxAge: 10 * time.Minute}
+	anyOrigin := CORSConfig{AllowedMethods: defaultCORSMethods}
+	narrowed := CORSConfig{AllowedOrigins: map[string]bool{app: true}, AllowedMethods: []string{"GET", "PATCH", "DELETE"}}
+	private := CORSConfig{AllowedOrigins: map[string]bool{app: true}, AllowedMethods: defaultCORSMethods, AllowPrivateNetwork: true}
+
+	tests := []struct {
+		name    string
+		config  CORSConfig
+		method  string
+		path    string
+		origin  string
+		request string // Access-Control-Request-Method, making it a preflight
+		status  int
+		want    map[string]string // "" for a header that must be missing
+	}{
+		{"same origin", listed, "GET", "/users", "", "", 200,
+			map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""}},
+		{"listed origin", listed, "GET", "/users", app, "", 200,
+			map[string]string{"Access-Control-Allow-Origin": app, "Vary": "Origin", "Access-Control-Allow-Credentials": "true"}},
+		{"unlisted origin", listed, "GET", "/users", evil, "", 200,
+			map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": "", "Vary": "Origin"}},
+		{"unlisted origin preflight", listed, "OPTIONS", "/users", evil, "GET", 403,
+			map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": "", "Vary": "Origin"}},
+		{"any origin", anyOrigin, "GET", "/users", evil, "", 200,
+			map[string]string{"Access-Control-Allow-Origin": "*", "Vary": "", "Access-Control-Allow-Credentials": ""}},
+		{"preflight of a collection", listed, "OPTIONS", "/users", app, "POST", 204,
+			map[string]string{"Access-Control-Allow-Methods": "GET, POST", "Access-Control-Allow-Origin": app, "Access-Control-Max-Age": "600", "Vary": "Origin"}},
+		{"preflight of an item", listed, "OPTIONS", "/users/7", app, "DELETE", 204,
+			map[string]string{"Access-Control-Allow-Methods": "GET, PUT, PATCH, DELETE"}},
+		{"preflight for a method the route lacks", listed, "OPTIONS", "/users", app, "DELETE", 403,
+			map[string]string{"Access-Control-Allow-Methods": ""}},
+		{"preflight for a method narrowed away", narrowed, "OPTIONS", "/users/7", app, "PUT", 403,
+			map[string]string{"Access-Control-Allow-Methods": ""}},
+		{"preflight within the narrowed methods", narrowed, "OPTIONS", "/users/7", app, "PATCH", 204,
+			map[string]string{"Access-Control-Allow-Methods": "GET, PATCH, DELETE", "Access-Control-Max-Age": ""}},
+		{"preflight of an unknown path", listed, "OPTIONS", "/nowhere", app, "GET", 403,
+			map[string]string{"Access-Control-Allow-Methods": ""}},
+		{"private network preflight", private, "OPTIONS", "/users", app, "GET", 204,
+			map[string]string{"Access-Control-Allow-Private-Network": "true"}},
+		{"private network not allowed", listed, "OPTIONS", "/users", app, "GET", 204,
+			map[string]string{"Access-Control-Allow-Private-Network": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.request != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.request)
+				r.Header.Set("Access-Control-Request-Private-Network", "true")
+			}
+			w := httptest.NewRecorder()
+			newCORSStack(t, tt.config).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			for name, want := range tt.want {
+				if got := strings.Join(w.Header().Values(name), ", "); got != want {
+					t.Fatalf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Registered headers are offered to browsers
+func TestCORSRegisteredHeaders(t *testing.T) {
+	previous := CORSHeaders
+	CORSHeaders = &CORSHeaderRegistry{allow: []string{"Content-Type"}}
+	t.Cleanup(func() { CORSHeaders = previous })
+	CORSHeaders.Allow("idempotency-key", "Content-Type")
+	CORSHeaders.Expose("ETag", "x-request-id")
+	t.Setenv("CORS_ALLOWED_HEADERS", "X-Custom, ")
+	LoadCORSConfig(&env.Parser{})
+
+	h := newCORSStack(t, CORSConfig{AllowedMethods: defaultCORSMethods})
+	r := httptest.NewRequest("OPTIONS", "/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Idempotency-Key, X-Custom" {
+		t.Fatalf("Access-Control-Allow-Headers = %q", got)
+	}
+
+	r = httptest.NewRequest("GET", "/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "Etag, X-Request-Id" {
+		t.Fatalf("Access-Control-Expose-Headers = %q", got)
+	}
+}
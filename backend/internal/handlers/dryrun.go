@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Effects a write would have had, returned instead of running it for real
+type DryRunPlan struct {
+	DryRun       bool                   `json:"dry_run"`
+	Operation    string                 `json:"operation"`
+	RowsAffected map[string]int64       `json:"rows_affected"`
+	IdsTouched   map[string][]models.ID `json:"ids_touched"`
+}
+
+// Record rows touched in a table
+func (p *DryRunPlan) Touch(table string, ids ...models.ID) {
+	p.RowsAffected[table] += int64(len(ids))
+	p.IdsTouched[table] = append(p.IdsTouched[table], ids...)
+}
+
+// Whether a request asks for a dry run, via ?dry_run=true or X-Dry-Run
+func isDryRun(r *http.Request) bool {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		v = r.Header.Get("X-Dry-Run")
+	}
+	dry, _ := strconv.ParseBool(v)
+	return dry
+}
+
+// Rolls the transaction of a dry run back once fn is done
+var errDryRun = errors.New("dry run")
+
+// Run a write in a transaction, rolled back instead of committed on a dry run
+// The plan records what fn touched either way.
+func runWrite(ctx context.Context, r *http.Request, txs store.Transactor, operation string, fn func(tx store.Tx, plan *DryRunPlan) error) (*DryRunPlan, error) {
+	plan := &DryRunPlan{
+		DryRun:       isDryRun(r),
+		Operation:    operation,
+		RowsAffected: map[string]int64{},
+		IdsTouched:   map[string][]models.ID{},
+	}
+
+	err := txs.RunInTx(ctx, func(tx store.Tx) error {
+		if err := fn(tx, plan); err != nil {
+			return err
+		}
+		if plan.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	return plan, nil
+}
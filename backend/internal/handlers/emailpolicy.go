@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed disposable_domains.txt
+var disposableDomains string
+
+// Reasons an email address is refused
+const (
+	EmailInvalid           = "invalid_address"
+	EmailDisposableDomain  = "disposable_domain"
+	EmailDomainNotAllowed  = "domain_not_allowed"
+	EmailReservedLocalPart = "reserved_local_part"
+)
+
+// Rules for which email addresses may sign up
+// Lookups go through prebuilt maps. The blocklist is rebuilt when the
+// override file changes.
+type EmailPolicy struct {
+	OverrideFile string
+
+	mu       sync.RWMutex
+	blocked  map[string]bool
+	allowed  map[string]bool
+	reserved map[string]bool
+	modTime  time.Time
+}
+
+// Email policy configured from the environment
+// EMAIL_BLOCKLIST and EMAIL_BLOCKLIST_FILE add domains to the embedded list,
+// EMAIL_ALLOWLIST switches to only letting those domains register, and
+// EMAIL_RESERVED_LOCAL_PARTS replaces the default reserved mailbox names.
+func NewEmailPolicy() *EmailPolicy {
+	p := &EmailPolicy{
+		OverrideFile: os.Getenv("EMAIL_BLOCKLIST_FILE"),
+		allowed:      lowerSet(strings.Split(os.Getenv("EMAIL_ALLOWLIST"), ",")),
+		reserved:     lowerSet([]string{"admin", "administrator", "postmaster", "hostmaster", "webmaster", "abuse", "root"}),
+	}
+	if v := os.Getenv("EMAIL_RESERVED_LOCAL_PARTS"); v != "" {
+		p.reserved = lowerSet(strings.Split(v, ","))
+	}
+	p.reload()
+	return p
+}
+
+// Build a lowercased set, skipping blanks and comments
+func lowerSet(entries []string) map[string]bool {
+	set := map[string]bool{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		set[entry] = true
+	}
+	return set
+}
+
+// Rebuild the blocklist from the embedded list, env and override file
+func (p *EmailPolicy) reload() {
+	entries := strings.Split(disposableDomains, "\n")
+	entries = append(entries, strings.Split(os.Getenv("EMAIL_BLOCKLIST"), ",")...)
+
+	var modTime time.Time
+	if p.OverrideFile != "" {
+		file, err := os.Open(p.OverrideFile)
+		if err != nil {
+			log.Printf("Error reading email blocklist %s: %v", p.OverrideFile, err)
+		} else {
+			if info, err := file.Stat(); err == nil {
+				modTime = info.ModTime()
+			}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				entries = append(entries, scanner.Text())
+			}
+			file.Close()
+		}
+	}
+
+	blocked := lowerSet(entries)
+	p.mu.Lock()
+	p.blocked = blocked
+	p.modTime = modTime
+	p.mu.Unlock()
+}
+
+// Reload the override file whenever it changes, until ctx ends
+func (p *EmailPolicy) Watch(ctx context.Context, interval time.Duration) {
+	if p.OverrideFile == "" {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				info, err := os.Stat(p.OverrideFile)
+				if err != nil {
+					continue
+				}
+				p.mu.RLock()
+				changed := !info.ModTime().Equal(p.modTime)
+				p.mu.RUnlock()
+				if changed {
+					p.reload()
+					log.Printf("Reloaded email blocklist %s", p.OverrideFile)
+				}
+			}
+		}
+	}()
+}
+
+// Why an address may not register, empty when it may
+func (p *EmailPolicy) Check(email string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !ok || local == "" || domain == "" || strings.Contains(domain, "@") {
+		return EmailInvalid
+	}
+	// Plus addressing does not make a reserved mailbox any less reserved
+	local, _, _ = strings.Cut(local, "+")
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.reserved[local] {
+		return EmailReservedLocalPart
+	}
+	if len(p.allowed) > 0 {
+		if !p.allowed[domain] {
+			return EmailDomainNotAllowed
+		}
+		return ""
+	}
+	// Check the domain and each parent, so mx.mailinator.com is caught too
+	for d := domain; d != ""; {
+		if p.blocked[d] {
+			return EmailDisposableDomain
+		}
+		_, parent, found := strings.Cut(d, ".")
+		if !found {
+			break
+		}
+		d = parent
+	}
+	return ""
+}
+
+// Whether a request is an admin create asking to skip the email policy
+func emailPolicyBypassed(r *http.Request) bool {
+	bypass, _ := strconv.ParseBool(r.URL.Query().Get("bypass_email_policy"))
+	return bypass && isAdminRequest(r)
+}
+
+// Reject an address refused by the email policy
+func writeEmailNotAllowed(w http.ResponseWriter, reason string) {
+	writeErrorDetails(w, http.StatusUnprocessableEntity, "email_not_allowed", "this email address cannot be used", map[string]string{"reason": reason})
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Email policy configured from vars, the ones not given unset
+func newEmailPolicy(t *testing.T, vars map[string]string) *EmailPolicy {
+	t.Helper()
+	for _, name := range []string{"EMAIL_BLOCKLIST", "EMAIL_BLOCKLIST_FILE", "EMAIL_ALLOWLIST", "EMAIL_RESERVED_LOCAL_PARTS"} {
+		t.Setenv(name, vars[name])
+	}
+	return NewEmailPolicy()
+}
+
+func TestEmailPolicyCheck(t *testing.T) {
+	blocklist := map[string]string{"EMAIL_BLOCKLIST": " Spam.Example.org, ,#comment"}
+	allowlist := map[string]string{"EMAIL_ALLOWLIST": "Company.com, partner.io", "EMAIL_BLOCKLIST": "company.com"}
+	reserved := map[string]string{"EMAIL_RESERVED_LOCAL_PARTS": "Support, billing"}
+
+	tests := []struct {
+		name  string
+		vars  map[string]string
+		email string
+		want  string
+	}{
+		{"ordinary address", nil, "ada@example.com", ""},
+		{"no at sign", nil, "ada.example.com", EmailInvalid},
+		{"no local part", nil, "@example.com", EmailInvalid},
+		{"no domain", nil, "ada@", EmailInvalid},
+		{"two at signs", nil, "ada@home@example.com", EmailInvalid},
+		{"blank", nil, "  ", EmailInvalid},
+
+		// Addresses are compared lowercased and trimmed
+		{"embedded disposable domain", nil, "ada@mailinator.com", EmailDisposableDomain},
+		{"disposable domain in capitals", nil, "  Ada@MAILINATOR.Com ", EmailDisposableDomain},
+		{"subdomain of a disposable domain", nil, "ada@mx.eu.mailinator.com", EmailDisposableDomain},
+		{"domain merely ending like one", nil, "ada@notmailinator.com", ""},
+		{"parent of a disposable domain", nil, "ada@com", ""},
+		{"domain from EMAIL_BLOCKLIST", blocklist, "ada@spam.example.org", EmailDisposableDomain},
+		{"parent of an EMAIL_BLOCKLIST domain", blocklist, "ada@example.org", ""},
+		{"comment in EMAIL_BLOCKLIST", blocklist, "ada@#comment", ""},
+
+		{"reserved local part", nil, "admin@example.com", EmailReservedLocalPart},
+		{"reserved local part in capitals", nil, "PostMaster@example.com", EmailReservedLocalPart},
+		{"reserved local part with a plus", nil, "root+alerts@example.com", EmailReservedLocalPart},
+		{"local part starting like a reserved one", nil, "administrators@example.com", ""},
+		{"replaced reserved local parts", reserved, "support+1@example.com", EmailReservedLocalPart},
+		{"default no longer reserved", reserved, "admin@example.com", ""},
+
+		// An allowlist admits only its domains, above the blocklist
+		{"allowed domain", allowlist, "ada@COMPANY.com", ""},
+		{"second allowed domain", allowlist, "ada@partner.io", ""},
+		{"domain not allowed", allowlist, "ada@example.com", EmailDomainNotAllowed},
+		{"subdomain of an allowed domain", allowlist, "ada@mail.company.com", EmailDomainNotAllowed},
+		{"disposable domain not allowed", allowlist, "ada@mailinator.com", EmailDomainNotAllowed},
+		{"reserved local part of an allowed domain", allowlist, "abuse@company.com", EmailReservedLocalPart},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newEmailPolicy(t, tt.vars).Check(tt.email); got != tt.want {
+				t.Fatalf("Check(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmailPolicyReloadsOverrideFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(file, []byte("# extra domains\nfirst.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := newEmailPolicy(t, map[string]string{"EMAIL_BLOCKLIST_FILE": file})
+	if got := p.Check("ada@first.example"); got != EmailDisposableDomain {
+		t.Fatalf("domain from the override file = %q, want it blocked", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Watch(ctx, 5*time.Millisecond)
+	if err := os.WriteFile(file, []byte("second.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A modification time of its own, however coarse the file system's clock
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for p.Check("ada@second.example") == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := p.Check("ada@second.example"); got != EmailDisposableDomain {
+		t.Fatalf("domain added to the override file = %q, want it blocked", got)
+	}
+	if got := p.Check("ada@first.example"); got != "" {
+		t.Fatalf("domain removed from the override file = %q, want it allowed", got)
+	}
+	// The embedded list stays in force
+	if got := p.Check("ada@mailinator.com"); got != EmailDisposableDomain {
+		t.Fatalf("embedded domain after a reload = %q", got)
+	}
+}
+
+func TestCreateUserEmailPolicy(t *testing.T) {
+	for _, name := range []string{"EMAIL_BLOCKLIST", "EMAIL_BLOCKLIST_FILE", "EMAIL_ALLOWLIST", "EMAIL_RESERVED_LOCAL_PARTS"} {
+		t.Setenv(name, "")
+	}
+	s := newTestServer(t)
+
+	w := s.do(t, "POST", "/users", `{"name":"Ada","email":"ada@mailinator.com"}`, "X-API-Key", "")
+	if w.Code != http.StatusUnprocessableEntity || errorCode(t, w) != "email_not_allowed" {
+		t.Fatalf("create with a disposable address = %d %s, want 422 email_not_allowed", w.Code, w.Body)
+	}
+	details := decode[ErrorResponse](t, w).Error.Details
+	if reason := details.(map[string]any)["reason"]; reason != EmailDisposableDomain {
+		t.Fatalf("details = %v, want the reason %s", details, EmailDisposableDomain)
+	}
+	// Only the admin can skip the policy
+	if w := s.do(t, "POST", "/users?bypass_email_policy=true", `{"name":"Ada","email":"ada@mailinator.com"}`, "X-API-Key", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bypass without the admin key = %d, want 422", w.Code)
+	}
+	if w := s.do(t, "POST", "/users?bypass_email_policy=true", `{"name":"Ada","email":"ada@mailinator.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("bypass by the admin = %d %s, want 200", w.Code, w.Body)
+	}
+
+	w = s.do(t, "POST", "/users/bulk", `[{"name":"Grace","email":"grace@example.com"},{"name":"Root","email":"root@example.com"}]`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "email_not_allowed: "+EmailReservedLocalPart) {
+		t.Fatalf("bulk create with a reserved address = %d %s, want its row refused", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Receives 5xx errors and panics
+type ErrorReporter interface {
+	Report(r *http.Request, status int, err error)
+	Flush(timeout time.Duration)
+}
+
+// Reporter of every 5xx error response and panic
+var errorReporter ErrorReporter = noopReporter{}
+
+// How long a response waits for the reporter before going out anyway
+var errorReportTimeout = 100 * time.Millisecond
+
+// Reporter that drops everything, used when SENTRY_DSN is not set
+type noopReporter struct{}
+
+func (noopReporter) Report(r *http.Request, status int, err error) {}
+func (noopReporter) Flush(timeout time.Duration)                   {}
+
+// Pass an error to the reporter without letting it affect the response
+// A reporter that panics is logged, one that blocks is left to finish on
+// its own after errorReportTimeout.
+func reportError(r *http.Request, status int, err error) {
+	reporter := errorReporter
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Error reporter panicked: %v", p)
+			}
+		}()
+		reporter.Report(r, status, err)
+	}()
+
+	timer := time.NewTimer(errorReportTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("Error reporter did not return within %s", errorReportTimeout)
+	}
+}
+
+// Report the error behind a response, so writing it does not report again
+func reportOnce(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if g := findGuard(w); g != nil {
+		if g.reported {
+			return
+		}
+		g.reported = true
+	}
+	reportError(r, status, err)
+}
+
+// Wait for queued error reports to be sent, before exiting
+func FlushErrorReports(timeout time.Duration) {
+	errorReporter.Flush(timeout)
+}
+
+// Log and report a failed request, then answer 500
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	logRequest(r, slog.LevelError, "Error handling %s %s: %v", r.Method, routeName(r), err)
+	reportOnce(w, r, http.StatusInternalServerError, err)
+	writeJSONError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// Recover middleware
+// Turns a panicking handler into a 500 instead of a dropped connection, as
+// long as nothing was written yet. It runs inside the response guard so it
+// can tell.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// Deliberate aborts keep their meaning
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			logRequest(r, slog.LevelError, "Panic handling %s %s: %v\n%s", r.Method, routeName(r), p, debug.Stack())
+			// Always reported, even after an error the handler reported
+			reportError(r, 0, fmt.Errorf("panic: %v", p))
+			g := findGuard(w)
+			if g != nil {
+				g.reported = true
+			}
+			// A response already under way cannot turn into an error any
+			// more, the connection is dropped so the client sees it failed
+			if g != nil && (g.wroteHeader || g.committed) {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Sentry reporter, sampling 5xx errors and panics separately
+type sentryReporter struct {
+	sample5xx   float64
+	samplePanic float64
+}
+
+// Set up Sentry when SENTRY_DSN is configured
+func InitErrorReporting() {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: os.Getenv("SENTRY_ENVIRONMENT"),
+	})
+	if err != nil {
+		log.Printf("Sentry disabled: %v", err)
+		return
+	}
+
+	errorReporter = &sentryReporter{
+		sample5xx:   env.Float("SENTRY_SAMPLE_RATE_5XX", 1),
+		samplePanic: env.Float("SENTRY_SAMPLE_RATE_PANIC", 1),
+	}
+}
+
+// Status 0 marks a panic
+func (s *sentryReporter) Report(r *http.Request, status int, err error) {
+	rate := s.sample5xx
+	if status == 0 {
+		rate = s.samplePanic
+	}
+	if rand.Float64() >= rate {
+		return
+	}
+
+	hub := sentry.CurrentHub().Clone()
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("route", routeName(r))
+		scope.SetTag("method", r.Method)
+		if status != 0 {
+			scope.SetTag("status", fmt.Sprint(status))
+		}
+		if requestId := requestID(r.Context()); requestId != "" {
+			scope.SetTag("request_id", requestId)
+		}
+		// The id of the signed in user, never their email or name
+		if id, ok := authUserID(r.Context()); ok {
+			scope.SetUser(sentry.User{ID: id.String()})
+		}
+		// Only non-sensitive request metadata, never bodies or query values
+		scope.SetContext("request", map[string]interface{}{
+			"method":  r.Method,
+			"path":    r.URL.Path,
+			"headers": Redactions.Headers(r.Header),
+		})
+		hub.CaptureException(err)
+	})
+}
+
+func (s *sentryReporter) Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Sentry transport keeping the events instead of sending them
+type recordingTransport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *recordingTransport) Configure(sentry.ClientOptions) {}
+
+func (t *recordingTransport) Flush(time.Duration) bool { return true }
+
+func (t *recordingTransport) SendEvent(event *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+// Send the events of the current hub to a recording transport
+func recordSentryEvents(t *testing.T) *recordingTransport {
+	t.Helper()
+	transport := &recordingTransport{}
+	client, err := sentry.NewClient(sentry.ClientOptions{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := sentry.CurrentHub()
+	previous := hub.Client()
+	hub.BindClient(client)
+	t.Cleanup(func() { hub.BindClient(previous) })
+	return transport
+}
+
+// Request for the /users/{id} route that reports from its handler
+func reportFromRoute(reporter ErrorReporter, r *http.Request, status int, err error) {
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		reporter.Report(r, status, err)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestSentryReportsTheUserId(t *testing.T) {
+	transport := recordSentryEvents(t)
+	reporter := &sentryReporter{sample5xx: 1, samplePanic: 1}
+
+	r := httptest.NewRequest("PUT", "/users/7?email=ada@example.com&name=Ada", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Cookie", "session=secret-cookie")
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.RemoteAddr = "203.0.113.9:4000"
+	ctx := context.WithValue(r.Context(), requestIDKey{}, "req-123")
+	reportFromRoute(reporter, r.WithContext(context.WithValue(ctx, authUserKey{}, models.ID(7))), 500, errors.New("signed in"))
+	reportFromRoute(reporter, r, 0, errors.New("anonymous"))
+
+	if len(transport.events) != 2 {
+		t.Fatalf("%d events sent, want 2", len(transport.events))
+	}
+	event := transport.events[0]
+	user := event.User
+	if user.ID != "7" || user.Email != "" || user.Username != "" || user.Name != "" || user.IPAddress != "" || len(user.Data) != 0 {
+		t.Fatalf("user of a signed in request = %+v, want only the id 7", user)
+	}
+	if user := transport.events[1].User; !user.IsEmpty() {
+		t.Fatalf("user of an anonymous request = %+v, want none", user)
+	}
+
+	want := map[string]string{"route": "/users/{id}", "method": "PUT", "status": "500", "request_id": "req-123"}
+	for tag, value := range want {
+		if event.Tags[tag] != value {
+			t.Fatalf("tag %s = %q, want %q, tags %v", tag, event.Tags[tag], value, event.Tags)
+		}
+	}
+	if _, ok := transport.events[1].Tags["status"]; ok {
+		t.Fatalf("panic tagged with a status: %v", transport.events[1].Tags)
+	}
+
+	headers, _ := event.Contexts["request"]["headers"].(map[string]string)
+	if headers["Authorization"] != redactedValue || headers["Cookie"] != redactedValue || headers["User-Agent"] != "curl/8.0" {
+		t.Fatalf("headers in the event = %v, want Authorization and Cookie redacted", headers)
+	}
+	if path := event.Contexts["request"]["path"]; path != "/users/7" {
+		t.Fatalf("path in the event = %v, want it without the query", path)
+	}
+	for _, event := range transport.events {
+		// Stack frames quote the source of this test, which has them all
+		withoutStack := *event
+		withoutStack.Exception = nil
+		encoded, err := json.Marshal(withoutStack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"secret-token", "secret-cookie", "ada@example.com", "name=Ada", "203.0.113.9"} {
+			if strings.Contains(string(encoded), secret) {
+				t.Fatalf("event carries %q: %s", secret, encoded)
+			}
+		}
+	}
+}
+
+func TestSentrySampleRates(t *testing.T) {
+	tests := []struct {
+		name               string
+		sample5xx, panics  float64
+		want5xx, wantPanic int
+	}{
+		{"everything", 1, 1, 100, 100},
+		{"nothing", 0, 0, 0, 0},
+		{"only 5xx", 1, 0, 100, 0},
+		{"only panics", 0, 1, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := recordSentryEvents(t)
+			reporter := &sentryReporter{sample5xx: tt.sample5xx, samplePanic: tt.panics}
+			r := httptest.NewRequest("GET", "/users/1", nil)
+			for i := 0; i < 100; i++ {
+				reporter.Report(r, 503, errors.New("5xx"))
+				reporter.Report(r, 0, errors.New("panic"))
+			}
+
+			var got5xx, gotPanic int
+			for _, event := range transport.events {
+				if _, ok := event.Tags["status"]; ok {
+					got5xx++
+				} else {
+					gotPanic++
+				}
+			}
+			if got5xx != tt.want5xx || gotPanic != tt.wantPanic {
+				t.Fatalf("%d 5xx and %d panic events, want %d and %d", got5xx, gotPanic, tt.want5xx, tt.wantPanic)
+			}
+		})
+	}
+}
+
+// Reporter keeping what it was given
+type recordingReporter struct {
+	mu       sync.Mutex
+	statuses []int
+	report   func()
+}
+
+func (rr *recordingReporter) Report(r *http.Request, status int, err error) {
+	rr.mu.Lock()
+	rr.statuses = append(rr.statuses, status)
+	rr.mu.Unlock()
+	if rr.report != nil {
+		rr.report()
+	}
+}
+
+func (rr *recordingReporter) Flush(timeout time.Duration) {}
+
+func (rr *recordingReporter) reported() []int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return append([]int(nil), rr.statuses...)
+}
+
+// Use a reporter for the rest of the test
+func useReporter(t *testing.T, reporter ErrorReporter) {
+	previous := errorReporter
+	errorReporter = reporter
+	t.Cleanup(func() { errorReporter = previous })
+}
+
+// Every 5xx is reported once, wherever it was written
+func TestEvery5xxIsReported(t *testing.T) {
+	startup := &Startup{}
+	faults := NewFaultInjector()
+	if _, err := faults.Add(FaultRule{Route: "/faulty", Type: FaultError503, Percent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	guard := func(h http.HandlerFunc) http.Handler { return ResponseGuard(Recover(h)) }
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    []int
+	}{
+		{"server error", guard(func(w http.ResponseWriter, r *http.Request) { serverError(w, r, errors.New("db down")) }), []int{500}},
+		{"panic", guard(func(w http.ResponseWriter, r *http.Request) { panic("boom") }), []int{0}},
+		{"panic after a server error", guard(func(w http.ResponseWriter, r *http.Request) {
+			serverError(w, r, errors.New("db down"))
+			panic("boom")
+		}), []int{500, 0}},
+		{"busy", guard(func(w http.ResponseWriter, r *http.Request) {
+			(&AdmissionClass{Name: "heavy"}).reject(w, r, "queue full")
+		}), []int{503}},
+		{"out of storage", guard(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusInsufficientStorage, "capacity_limit", "users has reached its row limit")
+		}), []int{507}},
+		{"starting", startup.Handler(), []int{503}},
+		{"injected fault", faults.Middleware(http.NotFoundHandler()), []int{503}},
+		{"client error", guard(func(w http.ResponseWriter, r *http.Request) { writeJSONError(w, http.StatusNotFound, "user not found") }), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &recordingReporter{}
+			useReporter(t, reporter)
+			func() {
+				// A panic after the response went out drops the connection
+				defer func() {
+					if p := recover(); p != nil && p != http.ErrAbortHandler {
+						panic(p)
+					}
+				}()
+				tt.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/faulty", nil))
+			}()
+			if got := reporter.reported(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("reported %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The response is the same however the reporter behaves
+func TestReporterCannotChangeTheResponse(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	reporters := map[string]func(){
+		"panics": func() { panic("reporter is broken") },
+		"blocks": func() { <-release },
+	}
+	for name, report := range reporters {
+		t.Run(name, func(t *testing.T) {
+			useReporter(t, &recordingReporter{report: report})
+			h := ResponseGuard(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				serverError(w, r, errors.New("db down"))
+			})))
+
+			w := httptest.NewRecorder()
+			start := time.Now()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil))
+			if elapsed := time.Since(start); elapsed > errorReportTimeout+time.Second {
+				t.Fatalf("response took %s", elapsed)
+			}
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d %s, want 500", w.Code, w.Body)
+			}
+			if body := decode[ErrorResponse](t, w); body.Error.Code != "internal_server_error" {
+				t.Fatalf("error = %+v, want the generic 500", body.Error)
+			}
+		})
+	}
+}
+
+// User routes on a database that was closed, so every query fails
+func closedDatabaseServer(t *testing.T) *testServer {
+	t.Helper()
+	db, err := sql.Open("postgres", "postgres://127.0.0.1:1/none?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	users := store.NewPostgres(db, nil)
+	return &testServer{handler: userRoutes(t, users, store.NewMemory().Receipts(), store.NewPostgresTransactor(db, nil))}
+}
+
+func TestStoreFailuresAnswerJSONErrors(t *testing.T) {
+	s := closedDatabaseServer(t)
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+	}{
+		{"list", "GET", "/users", ""},
+		{"get", "GET", "/users/1", ""},
+		{"create", "POST", "/users", `{"name":"Ada","email":"ada@example.com"}`},
+		{"update", "PUT", "/users/1", `{"name":"Ada","email":"ada@example.com"}`},
+		{"patch", "PATCH", "/users/1", `{"name":"Ada"}`},
+		{"delete", "DELETE", "/users/1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.path, tt.body)
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d %s, want 500", w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Fatalf("Content-Type = %q, want JSON", ct)
+			}
+			body := decode[ErrorResponse](t, w)
+			if body.Error.Code != "internal_server_error" || body.Error.Message != "internal server error" {
+				t.Fatalf("error = %+v, want the generic 500", body.Error)
+			}
+			if strings.Contains(w.Body.String(), "sql") {
+				t.Fatalf("the database error leaked into the response: %s", w.Body)
+			}
+		})
+	}
+
+	// Still serving after the failures, the process was not stopped
+	if w := s.do(t, "GET", "/users/abc", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("request after the failures = %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusNotFound, "not_found"},
+		{http.StatusBadRequest, "bad_request"},
+		{http.StatusInternalServerError, "internal_server_error"},
+		{http.StatusRequestEntityTooLarge, "request_entity_too_large"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeJSONError(w, tt.status, "user not found")
+		if w.Code != tt.status {
+			t.Fatalf("status = %d, want %d", w.Code, tt.status)
+		}
+		if body := decode[ErrorResponse](t, w); body.Error.Code != tt.code || body.Error.Message != "user not found" {
+			t.Fatalf("writeJSONError(%d) = %s, want code %s", tt.status, w.Body, tt.code)
+		}
+	}
+}
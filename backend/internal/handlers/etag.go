@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Strong ETag of a user, its quoted version
+func userETag(user models.User) string {
+	return `"` + strconv.FormatInt(user.Version, 10) + `"`
+}
+
+// Write a user with its ETag
+func writeUser(w http.ResponseWriter, status int, user models.User) {
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, status, user)
+}
+
+// Version an update was based on, from If-Match or else the version field
+// of the body. 0 when neither was sent, or If-Match is *.
+func requestVersion(r *http.Request, body models.User) (int64, bool, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return body.Version, body.Version != 0, nil
+	}
+	if header == "*" {
+		return 0, true, nil
+	}
+
+	tag, weak := strings.CutPrefix(header, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false, errors.New("If-Match must be a single ETag from an earlier response")
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if weak || err != nil || version < 1 {
+		// If-Match compares strongly, these never match a stored version
+		return -1, true, nil
+	}
+	return version, true, nil
+}
+
+// Answer 412 with the user as it is now, so the client can merge
+func writeVersionMismatch(w http.ResponseWriter, current models.User) {
+	writeUser(w, http.StatusPreconditionFailed, current)
+}
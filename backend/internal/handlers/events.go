@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Types of user events
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// Sent instead of a replay when the events after Last-Event-ID are gone, so
+// the client reloads the list
+const eventResync = "resync"
+
+// Events buffered for each client before it is dropped as too slow
+const eventClientBuffer = 64
+
+// Comment sent on idle streams so proxies keep them open
+const eventHeartbeat = 25 * time.Second
+
+// A change to a user, as sent to the stream
+type UserEvent struct {
+	Id   uint64
+	Type string
+	User models.User
+}
+
+// Broadcasts user changes to every connected stream
+// The last few events are kept so a client reconnecting with Last-Event-ID
+// gets what it missed. Events are only seen by clients of this replica.
+type EventHub struct {
+	mu      sync.Mutex
+	clients map[chan UserEvent]struct{}
+	recent  []UserEvent // ring of the last events, oldest at next % len
+	first   uint64      // id of the first event
+	next    uint64      // id of the next event
+	closed  bool
+}
+
+// Hub keeping the last EVENTS_REPLAY events, 256 by default
+// Ids start at the time the hub was created, so ids a client kept from
+// before a restart are older than any event kept and cannot be mixed up.
+func NewEventHub() *EventHub {
+	first := uint64(time.Now().UnixMicro())
+	return &EventHub{
+		clients: map[chan UserEvent]struct{}{},
+		recent:  make([]UserEvent, max(env.Int("EVENTS_REPLAY", 256), 0)),
+		first:   first,
+		next:    first,
+	}
+}
+
+// Send an event for each user to every client
+// Never blocks: clients whose buffer is full are disconnected and can catch
+// up with Last-Event-ID.
+func (h *EventHub) Publish(eventType string, users ...models.User) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, user := range users {
+		event := UserEvent{Id: h.next, Type: eventType, User: user}
+		if len(h.recent) > 0 {
+			h.recent[h.next%uint64(len(h.recent))] = event
+		}
+		h.next++
+
+		for ch := range h.clients {
+			select {
+			case ch <- event:
+			default:
+				delete(h.clients, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// Register a client, returning the events after lastId it missed
+// ok is false when those are no longer kept. The channel is closed when the client is dropped or the hub is
+// closed.
+func (h *EventHub) Subscribe(lastId uint64) (ch chan UserEvent, missed []UserEvent, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch = make(chan UserEvent, eventClientBuffer)
+	if h.closed {
+		close(ch)
+		return ch, nil, true
+	}
+	h.clients[ch] = struct{}{}
+
+	oldest := max(h.first, h.next-uint64(len(h.recent)))
+	switch {
+	case lastId == 0:
+	case lastId >= h.next || lastId+1 < oldest:
+		return ch, nil, false
+	default:
+		for id := lastId + 1; id < h.next; id++ {
+			missed = append(missed, h.recent[id%uint64(len(h.recent))])
+		}
+	}
+	return ch, missed, true
+}
+
+// Remove a client that went away
+func (h *EventHub) Unsubscribe(ch chan UserEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// Disconnect every client, so streams do not hold up shutdown
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.clients {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// Whether the writer under any wrappers can stream
+// The wrappers all have a Flush, which does nothing when this is false.
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+			w = u.Unwrap()
+			continue
+		}
+		_, ok := w.(http.Flusher)
+		return ok
+	}
+}
+
+// Write one event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, id uint64, eventType string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, eventType, body)
+	return err
+}
+
+// Stream user changes as Server-Sent Events
+// Each event carries the user as JSON data. Reconnects with Last-Event-ID
+// get the events they missed, or a resync event when those are gone.
+func UserEvents(hub *EventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canFlush(w) {
+			writeJSONError(w, http.StatusNotImplemented, "streaming is not supported")
+			return
+		}
+		var lastId uint64
+		if v := strings.TrimSpace(r.Header.Get("Last-Event-ID")); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Last-Event-ID must be an event id")
+				return
+			}
+			lastId = id
+		}
+
+		ch, missed, ok := hub.Subscribe(lastId)
+		defer hub.Unsubscribe(ch)
+
+		// Streams stay open far past the server's write timeout
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		// Ask clients to wait a few seconds before reconnecting
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if !ok {
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventResync)
+		}
+		for _, event := range missed {
+			if writeEvent(w, event.Id, event.Type, event.User) != nil {
+				return
+			}
+		}
+		rc.Flush()
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, open := <-ch:
+				if !open {
+					return
+				}
+				if writeEvent(w, event.Id, event.Type, event.User) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+			rc.Flush()
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Named request/response pair showing how a route is used
+type Example struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Headers the request needs besides a bearer token
+	Header   map[string]string `json:"header,omitempty"`
+	Request  json.RawMessage   `json:"request,omitempty"`
+	Status   int               `json:"status"`
+	Response json.RawMessage   `json:"response,omitempty"`
+}
+
+// Placeholder for the admin API key in example headers
+const ExampleAdminKey = "<ADMIN_API_KEY>"
+
+// Headers of examples that need the admin API key
+var adminKeyHeader = map[string]string{"X-API-Key": ExampleAdminKey}
+
+// Examples registered per route
+type ExampleRegistry struct {
+	mu       sync.RWMutex
+	examples map[string][]Example
+}
+
+// Examples served to the docs UI
+var Examples = &ExampleRegistry{examples: map[string][]Example{}}
+
+// Register examples for a route, keyed like "GET /api/go/users"
+func (e *ExampleRegistry) Register(route string, examples ...Example) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.examples[route] = append(e.examples[route], examples...)
+}
+
+// All examples, grouped by route
+func (e *ExampleRegistry) All() map[string][]Example {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	all := make(map[string][]Example, len(e.examples))
+	for route, examples := range e.examples {
+		all[route] = append([]Example(nil), examples...)
+	}
+	return all
+}
+
+// Serve every registered example, with paths as the client reaches them
+func ExamplesHandler(w http.ResponseWriter, r *http.Request) {
+	all := Examples.All()
+	for _, examples := range all {
+		for i := range examples {
+			examples[i].Path = publicURL(r, examples[i].Path)
+		}
+	}
+	writeJSON(w, http.StatusOK, all)
+}
+
+// Examples for the users API
+func RegisterUserExamples() {
+	Examples.Register("GET /api/go/users", Example{
+		Name:     "list users",
+		Method:   "GET",
+		Path:     "/api/go/users",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1},{"id":2,"name":"Alan Turing","email":"alan@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}],"total":2,"limit":25,"offset":0,"page":1,"per_page":25}`),
+	}, Example{
+		Name:     "check whether an email is taken",
+		Method:   "GET",
+		Path:     "/api/go/users?email=ada@example.com",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}],"total":1,"limit":25,"offset":0,"page":1,"per_page":25}`),
+	}, Example{
+		Name:     "search names and emails",
+		Method:   "GET",
+		Path:     "/api/go/users?q=lov",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}],"total":1,"limit":25,"offset":0,"page":1,"per_page":25}`),
+	}, Example{
+		Name:     "newest users first",
+		Method:   "GET",
+		Path:     "/api/go/users?sort=created_at&order=desc&limit=1",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":2,"name":"Alan Turing","email":"alan@example.com","created_at":"2024-05-02T11:00:00Z","updated_at":"2024-05-02T11:00:00Z","version":1}],"total":2,"limit":1,"offset":0,"page":1,"per_page":1}`),
+	}, Example{
+		Name:     "page through users by name",
+		Method:   "GET",
+		Path:     "/api/go/users?sort=name&order=desc&limit=1&page=2",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}],"total":2,"limit":1,"offset":1,"page":2,"per_page":1}`),
+	}, Example{
+		Name:     "list deleted users too, with the admin API key",
+		Method:   "GET",
+		Path:     "/api/go/users?include_deleted=true&email=alan@example.com",
+		Header:   adminKeyHeader,
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":2,"name":"Alan Turing","email":"alan@example.com","created_at":"2024-05-02T11:00:00Z","updated_at":"2024-06-20T08:00:00Z","version":2,"deleted_at":"2024-06-20T08:00:00Z"}],"total":1,"limit":25,"offset":0,"page":1,"per_page":25}`),
+	})
+	Examples.Register("POST /api/go/users", Example{
+		Name:     "create a user",
+		Method:   "POST",
+		Path:     "/api/go/users",
+		Request:  json.RawMessage(`{"name":"Ada Lovelace","email":"ada@example.com"}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}`),
+	}, Example{
+		Name:     "create with an email that is taken",
+		Method:   "POST",
+		Path:     "/api/go/users",
+		Request:  json.RawMessage(`{"name":"Ada King","email":"ada@example.com"}`),
+		Status:   http.StatusConflict,
+		Response: json.RawMessage(`{"error":{"code":"email_taken","message":"email already exists"}}`),
+	}, Example{
+		Name:     "create with a misspelled field",
+		Method:   "POST",
+		Path:     "/api/go/users",
+		Request:  json.RawMessage(`{"name":"Ada Lovelace","emial":"ada@example.com"}`),
+		Status:   http.StatusBadRequest,
+		Response: json.RawMessage(`{"error":{"code":"unknown_field","message":"unknown field \"emial\""}}`),
+	}, Example{
+		Name:     "create with invalid fields",
+		Method:   "POST",
+		Path:     "/api/go/users",
+		Request:  json.RawMessage(`{"name":"","email":"ada.example.com"}`),
+		Status:   http.StatusBadRequest,
+		Response: json.RawMessage(`{"error":{"code":"invalid_fields","message":"some fields are invalid","details":{"email":"invalid format","name":"required"}}}`),
+	}, Example{
+		Name:     "create with a disposable address",
+		Method:   "POST",
+		Path:     "/api/go/users",
+		Request:  json.RawMessage(`{"name":"Ada Lovelace","email":"ada@mailinator.com"}`),
+		Status:   http.StatusUnprocessableEntity,
+		Response: json.RawMessage(`{"error":{"code":"email_not_allowed","message":"this email address cannot be used","details":{"reason":"disposable_domain"}}}`),
+	})
+	Examples.Register("POST /api/go/users/bulk", Example{
+		Name:     "create users in one transaction",
+		Method:   "POST",
+		Path:     "/api/go/users/bulk",
+		Request:  json.RawMessage(`[{"name":"Ada Lovelace","email":"ada@example.com"},{"name":"Alan Turing","email":"alan@example.com"}]`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`[{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1},{"id":2,"name":"Alan Turing","email":"alan@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}]`),
+	}, Example{
+		Name:     "rows that stop the batch",
+		Method:   "POST",
+		Path:     "/api/go/users/bulk",
+		Request:  json.RawMessage(`[{"name":"Ada Lovelace","email":"ada@example.com"},{"name":"","email":"alan@example.com"},{"name":"Ada King","email":"ada@example.com"}]`),
+		Status:   http.StatusUnprocessableEntity,
+		Response: json.RawMessage(`{"error":{"code":"invalid_rows","message":"some rows cannot be created","details":[{"index":1,"reason":"invalid fields","fields":{"name":"required"}},{"index":2,"reason":"email already used by row 0"}]}}`),
+	})
+	Examples.Register("POST /api/go/auth/register", Example{
+		Name:     "register with a password",
+		Method:   "POST",
+		Path:     "/api/go/auth/register",
+		Request:  json.RawMessage(`{"name":"Ada Lovelace","email":"ada@example.com","password":"analytical engine"}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}`),
+	})
+	Examples.Register("POST /api/go/auth/login", Example{
+		Name:     "log in for a bearer token",
+		Method:   "POST",
+		Path:     "/api/go/auth/login",
+		Request:  json.RawMessage(`{"email":"ada@example.com","password":"analytical engine"}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"token":"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiIxIiwiaWF0IjoxNzE0NTU1ODAwLCJleHAiOjE3MTQ1NTk0MDB9.signature","token_type":"Bearer","expires_at":"2024-05-01T10:30:00Z","refresh_token":"p7Vq3y9Xc1sKJm0rT4hZbE8wNfL2aGdU6oYiRjHxC5k","refresh_expires_at":"2024-05-31T09:30:00Z","user_id":1}`),
+	}, Example{
+		Name:     "log in with a wrong password",
+		Method:   "POST",
+		Path:     "/api/go/auth/login",
+		Request:  json.RawMessage(`{"email":"ada@example.com","password":"difference engine"}`),
+		Status:   http.StatusUnauthorized,
+		Response: json.RawMessage(`{"error":{"code":"unauthorized","message":"invalid email or password"}}`),
+	})
+	Examples.Register("POST /api/go/auth/refresh", Example{
+		Name:     "refresh an expired bearer token",
+		Method:   "POST",
+		Path:     "/api/go/auth/refresh",
+		Request:  json.RawMessage(`{"refresh_token":"p7Vq3y9Xc1sKJm0rT4hZbE8wNfL2aGdU6oYiRjHxC5k"}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"token":"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiIxIiwiaWF0IjoxNzE0NTYwMDAwLCJleHAiOjE3MTQ1NjM2MDB9.signature","token_type":"Bearer","expires_at":"2024-05-01T11:40:00Z","refresh_token":"Zr2mQ8tWk5vB1nXh7cLs0dPyA4eJg9uFoK3iTqN6wEb","refresh_expires_at":"2024-05-31T10:40:00Z","user_id":1}`),
+	}, Example{
+		Name:     "refresh with a token already used",
+		Method:   "POST",
+		Path:     "/api/go/auth/refresh",
+		Request:  json.RawMessage(`{"refresh_token":"p7Vq3y9Xc1sKJm0rT4hZbE8wNfL2aGdU6oYiRjHxC5k"}`),
+		Status:   http.StatusUnauthorized,
+		Response: json.RawMessage(`{"error":{"code":"unauthorized","message":"refresh token already used"}}`),
+	})
+	Examples.Register("GET /api/go/users/{id}", Example{
+		Name:     "get a user",
+		Method:   "GET",
+		Path:     "/api/go/users/1",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1}`),
+	}, Example{
+		Name:     "get a user that does not exist",
+		Method:   "GET",
+		Path:     "/api/go/users/999999",
+		Status:   http.StatusNotFound,
+		Response: json.RawMessage(`{"error":{"code":"not_found","message":"user not found"}}`),
+	}, Example{
+		Name:     "get with an id that is not a number",
+		Method:   "GET",
+		Path:     "/api/go/users/abc",
+		Status:   http.StatusBadRequest,
+		Response: json.RawMessage(`{"error":{"code":"bad_request","message":"invalid user id"}}`),
+	})
+	Examples.Register("PATCH /api/go/users/{id}", Example{
+		Name:     "change only the name",
+		Method:   "PATCH",
+		Path:     "/api/go/users/1",
+		Request:  json.RawMessage(`{"name":"Ada King"}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada King","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-12T14:05:00Z","version":2}`),
+	}, Example{
+		Name:     "try to remove the email",
+		Method:   "PATCH",
+		Path:     "/api/go/users/1",
+		Request:  json.RawMessage(`{"email":null}`),
+		Status:   http.StatusBadRequest,
+		Response: json.RawMessage(`{"error":{"code":"invalid_fields","message":"some fields are invalid","details":{"email":"required"}}}`),
+	})
+	Examples.Register("PUT /api/go/users/{id}", Example{
+		Name:     "update a user",
+		Method:   "PUT",
+		Path:     "/api/go/users/1",
+		Request:  json.RawMessage(`{"name":"Ada King","email":"ada@example.com","version":1}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada King","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-12T14:05:00Z","version":2}`),
+	}, Example{
+		Name:     "update a user someone else changed first",
+		Method:   "PUT",
+		Path:     "/api/go/users/1",
+		Request:  json.RawMessage(`{"name":"Ada Byron","email":"ada@example.com","version":1}`),
+		Status:   http.StatusPreconditionFailed,
+		Response: json.RawMessage(`{"id":1,"name":"Ada King","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-12T14:05:00Z","version":2}`),
+	}, Example{
+		Name:     "preview an update",
+		Method:   "PUT",
+		Path:     "/api/go/users/1?dry_run=true",
+		Request:  json.RawMessage(`{"name":"Ada King","email":"ada@example.com"}`),
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"dry_run":true,"operation":"update_user","rows_affected":{"users":1},"ids_touched":{"users":[1]}}`),
+	})
+	Examples.Register("DELETE /api/go/users/{id}", Example{
+		Name:   "delete a user",
+		Method: "DELETE",
+		Path:   "/api/go/users/1",
+		Status: http.StatusNoContent,
+	}, Example{
+		Name:     "delete another user",
+		Method:   "DELETE",
+		Path:     "/api/go/users/2",
+		Status:   http.StatusForbidden,
+		Response: json.RawMessage(`{"error":{"code":"forbidden","message":"users can only change themselves"}}`),
+	}, Example{
+		Name:     "delete a user that does not exist",
+		Method:   "DELETE",
+		Path:     "/api/go/users/999999",
+		Header:   adminKeyHeader,
+		Status:   http.StatusNotFound,
+		Response: json.RawMessage(`{"error":{"code":"not_found","message":"user not found"}}`),
+	}, Example{
+		Name:     "preview a delete",
+		Method:   "DELETE",
+		Path:     "/api/go/users/1?dry_run=true",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"dry_run":true,"operation":"delete_user","rows_affected":{"users":1},"ids_touched":{"users":[1]}}`),
+	}, Example{
+		Name:   "erase a user for good",
+		Method: "DELETE",
+		Path:   "/api/go/users/1?hard=true",
+		Header: adminKeyHeader,
+		Status: http.StatusNoContent,
+	}, Example{
+		Name:     "erase a user without the admin API key",
+		Method:   "DELETE",
+		Path:     "/api/go/users/1?hard=true",
+		Status:   http.StatusForbidden,
+		Response: json.RawMessage(`{"error":{"code":"forbidden","message":"hard deletes need the admin API key"}}`),
+	})
+	Examples.Register("POST /api/go/users/{id}/restore", Example{
+		Name:     "restore a deleted user",
+		Method:   "POST",
+		Path:     "/api/go/users/1/restore",
+		Header:   adminKeyHeader,
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-20T08:15:00Z","version":3}`),
+	}, Example{
+		Name:     "restore a user that is not deleted",
+		Method:   "POST",
+		Path:     "/api/go/users/2/restore",
+		Header:   adminKeyHeader,
+		Status:   http.StatusNotFound,
+		Response: json.RawMessage(`{"error":{"code":"not_found","message":"no deleted user with this id"}}`),
+	})
+	Examples.Register("GET /api/go/users/{id}/audit", Example{
+		Name:     "history of a user",
+		Method:   "GET",
+		Path:     "/api/go/users/1/audit?limit=2",
+		Header:   adminKeyHeader,
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"data":[{"id":12,"entity":"users","entity_id":1,"action":"restore","old_value":{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-20T08:10:00Z","version":2,"deleted_at":"2024-06-20T08:10:00Z"},"new_value":{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-20T08:15:00Z","version":3},"actor":"admin","request_id":"4f1c2b7e9a0d3e65","created_at":"2024-06-20T08:15:00Z"},{"id":9,"entity":"users","entity_id":1,"action":"delete","old_value":{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","version":1},"new_value":{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-20T08:10:00Z","version":2,"deleted_at":"2024-06-20T08:10:00Z"},"actor":"user:1","request_id":"b83e0f5d21c47a90","created_at":"2024-06-20T08:10:00Z"}],"total":3,"limit":2,"offset":0,"page":1,"per_page":2}`),
+	})
+	Examples.Register("POST /api/go/users/{id}/avatar", Example{
+		Name:     "upload a PNG",
+		Method:   "POST",
+		Path:     "/api/go/users/1/avatar",
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"id":1,"name":"Ada Lovelace","email":"ada@example.com","created_at":"2024-05-01T09:30:00Z","updated_at":"2024-06-21T10:00:00Z","version":4,"avatar_url":"/api/v1/users/1/avatar?v=9b2f0c41d7e85a36f1c04e2d8a7b5c19"}`),
+	}, Example{
+		Name:     "upload a text file named avatar.png",
+		Method:   "POST",
+		Path:     "/api/go/users/1/avatar",
+		Status:   http.StatusUnsupportedMediaType,
+		Response: json.RawMessage(`{"error":{"code":"unsupported_image","message":"avatar must be a JPEG, PNG or WebP image"}}`),
+	})
+	Examples.Register("GET /api/go/stats/users", Example{
+		Name:     "three days of signups",
+		Method:   "GET",
+		Path:     "/api/go/stats/users?days=3&newest=1",
+		Header:   adminKeyHeader,
+		Status:   http.StatusOK,
+		Response: json.RawMessage(`{"total":42,"days":3,"signups":[{"date":"2024-06-19","count":4},{"date":"2024-06-20","count":0},{"date":"2024-06-21","count":2}],"newest":[{"id":42,"name":"Grace Hopper","email":"grace@example.com","created_at":"2024-06-21T16:05:00Z","updated_at":"2024-06-21T16:05:00Z","version":1}]}`),
+	})
+	Examples.Register("GET /api/go/audit", Example{
+		Name:     "filter by an unknown action",
+		Method:   "GET",
+		Path:     "/api/go/audit?action=rename",
+		Header:   adminKeyHeader,
+		Status:   http.StatusBadRequest,
+		Response: json.RawMessage(`{"error":{"code":"bad_request","message":"action must be one of create, update, delete, hard_delete, restore or anonymize"}}`),
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Export formats and their content types
+var exportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// Columns of a CSV export
+var exportColumns = []string{"id", "name", "email", "created_at", "updated_at", "version", "deleted_at", "avatar_url"}
+
+// Pick the export format from ?format, then Accept, CSV by default
+func exportFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, ok := exportFormats[format]
+		return format, ok
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/x-ndjson") && !strings.Contains(accept, "text/csv") {
+		return "ndjson", true
+	}
+	return "csv", true
+}
+
+// Text cell of a CSV record
+// Cells starting like a spreadsheet formula get a leading quote, so they
+// open as text instead of running.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// One user as a CSV record
+func csvRecord(user models.User) []string {
+	deletedAt := ""
+	if user.DeletedAt != nil {
+		deletedAt = user.DeletedAt.Format(time.RFC3339)
+	}
+	return []string{
+		user.Id.String(),
+		csvText(user.Name),
+		csvText(user.Email),
+		user.CreatedAt.Format(time.RFC3339),
+		user.UpdatedAt.Format(time.RFC3339),
+		strconv.FormatInt(user.Version, 10),
+		deletedAt,
+		user.AvatarURL,
+	}
+}
+
+// Stream the users matching the list filters as CSV or NDJSON
+// Rows go out as they are read, in id order, flushed every few hundred.
+func ExportUsers(users store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, ok := exportFormat(r)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "format must be csv or ndjson")
+			return
+		}
+		opts, err := parseListParams(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if opts.IncludeDeleted && !isAdminRequest(r) {
+			writeJSONError(w, http.StatusForbidden, "include_deleted needs the admin API key")
+			return
+		}
+
+		// A large export can outlast the server's write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		filename := "users-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+		w.Header().Set("Content-Type", exportFormats[format])
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		flusher, _ := w.(http.Flusher)
+
+		var write func(models.User) error
+		var flush func()
+		switch format {
+		case "csv":
+			cw := csv.NewWriter(w)
+			cw.Write(exportColumns)
+			write = func(user models.User) error { return cw.Write(csvRecord(user)) }
+			flush = cw.Flush
+		case "ndjson":
+			enc := json.NewEncoder(w)
+			write = func(user models.User) error { return enc.Encode(user) }
+			flush = func() {}
+		}
+
+		count := 0
+		err = users.Export(r.Context(), opts, func(user models.User) error {
+			if err := write(user); err != nil {
+				return err
+			}
+			count++
+			if count%snapshotFlushEvery == 0 {
+				flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return nil
+		})
+		// Nothing has gone out when the query itself failed
+		if err != nil && count == 0 && r.Context().Err() == nil {
+			w.Header().Del("Content-Disposition")
+			serverError(w, r, err)
+			return
+		}
+		flush()
+		// Otherwise the status is already sent and the file is cut short
+		if err != nil && r.Context().Err() == nil {
+			logRequest(r, slog.LevelError, "Error exporting users after %d rows: %v", count, err)
+		}
+	}
+}
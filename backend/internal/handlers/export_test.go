@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Names a spreadsheet or a naive parser could get wrong
+var awkwardNames = []string{
+	"Lovelace, Ada",
+	`Grace "Amazing" Hopper`,
+	"Alan\nTuring",
+	"Line\r\nBreak",
+	`"`,
+	"=HYPERLINK(\"http://evil.example\",\"x\")",
+	"+1+1",
+	"-2+3",
+	"@SUM(A1:A2)",
+	"\tTabbed",
+	"Inner =formula",
+	"Ünïcödé 名前",
+}
+
+// Memory store holding n users, the awkward names first
+func exportStore(t *testing.T, n int) (*store.Memory, []models.User) {
+	t.Helper()
+	batch := make([]models.User, n)
+	for i := range batch {
+		batch[i] = models.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)}
+		if i < len(awkwardNames) {
+			batch[i].Name = awkwardNames[i]
+		}
+	}
+	mem := store.NewMemory()
+	created, err := mem.CreateMany(context.Background(), batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mem, created
+}
+
+func export(t *testing.T, mem *store.Memory, query string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/users/export"+query, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	ExportUsers(mem).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export%s = %d %s", query, w.Code, w.Body)
+	}
+	return w
+}
+
+func TestExportCSVParsesBack(t *testing.T) {
+	mem, created := exportStore(t, 3000)
+	w := export(t, mem, "?format=csv")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="users-`) || !strings.HasSuffix(cd, `.csv"`) {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if !reflect.DeepEqual(records[0], exportColumns) {
+		t.Fatalf("header = %v, want %v", records[0], exportColumns)
+	}
+	if len(records)-1 != len(created) {
+		t.Fatalf("%d rows, want %d", len(records)-1, len(created))
+	}
+	for i, user := range created {
+		record := records[i+1]
+		if len(record) != len(exportColumns) || record[0] != user.Id.String() || record[2] != user.Email {
+			t.Fatalf("row %d = %q, want user %s", i, record, user.Id)
+		}
+		want := user.Name
+		if strings.ContainsAny(want[:1], "=+-@\t") {
+			want = "'" + want
+		}
+		// Quoted fields come back with their line endings as \n
+		want = strings.ReplaceAll(want, "\r\n", "\n")
+		if record[1] != want {
+			t.Fatalf("name of row %d = %q, want %q", i, record[1], want)
+		}
+	}
+}
+
+// No cell of a spreadsheet opening the export starts a formula
+func TestExportCSVNeutralizesFormulas(t *testing.T) {
+	for _, value := range []string{"=1+1", "+1", "-1", "@A1", "\tx", "\rx"} {
+		if got := csvText(value); got != "'"+value {
+			t.Fatalf("csvText(%q) = %q, want it quoted", value, got)
+		}
+	}
+	for _, value := range []string{"", "Ada", "1=1", "'already"} {
+		if got := csvText(value); got != value {
+			t.Fatalf("csvText(%q) = %q, want it unchanged", value, got)
+		}
+	}
+
+	mem := store.NewMemory()
+	if _, err := mem.Create(context.Background(), models.User{Name: "Ada", Email: "=cmd|'/c calc'!A1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(export(t, mem, "").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email := records[1][2]; email != "'=cmd|'/c calc'!A1@example.com" {
+		t.Fatalf("email cell = %q, want it quoted", email)
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	mem, created := exportStore(t, 1200)
+	w := export(t, mem, "", "Accept", "application/x-ndjson")
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	reader := bufio.NewReader(w.Body)
+	for i, want := range created {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		var user models.User
+		if err := json.Unmarshal(line, &user); err != nil {
+			t.Fatalf("line %d %q is not JSON: %v", i, line, err)
+		}
+		// JSON needs no neutralizing, the names come back as they are
+		if user.Id != want.Id || user.Name != want.Name || user.Email != want.Email {
+			t.Fatalf("line %d = %+v, want %+v", i, user, want)
+		}
+	}
+	if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+		t.Fatalf("%d bytes after the last user", len(rest))
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	mem, _ := exportStore(t, 100)
+	records, err := csv.NewReader(export(t, mem, "?email=user42@example.com").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][2] != "user42@example.com" {
+		t.Fatalf("export filtered by email = %q", records)
+	}
+
+	r := httptest.NewRequest("GET", "/users/export?format=xml", nil)
+	w := httptest.NewRecorder()
+	ExportUsers(mem).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("export as xml = %d, want 400", w.Code)
+	}
+}
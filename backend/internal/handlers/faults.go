@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Kinds of faults that can be injected
+const (
+	FaultLatency  = "latency"
+	FaultError500 = "error_500"
+	FaultError503 = "error_503"
+	FaultReset    = "reset"
+	FaultTruncate = "truncate"
+)
+
+// Limits on how long a fault rule can stay active
+const (
+	defaultFaultTTL = 10 * time.Minute
+	maxFaultTTL     = time.Hour
+)
+
+// Fault injected into a share of the requests matching a route pattern
+type FaultRule struct {
+	Id         string    `json:"id"`
+	Route      string    `json:"route"`
+	Type       string    `json:"type"`
+	Percent    float64   `json:"percent"`
+	LatencyMs  int       `json:"latency_ms,omitempty"`
+	TTLSeconds int       `json:"ttl_seconds,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Active fault rules, kept in memory only
+type FaultInjector struct {
+	Now  func() time.Time
+	Rand func() float64
+
+	mu    sync.Mutex
+	rules []FaultRule
+}
+
+// Create a fault injector with no rules
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{Now: time.Now, Rand: mathrand.Float64}
+}
+
+// Whether fault injection may be turned on in this environment
+func FaultsEnabled() bool {
+	return env.Bool("FAULTS_ENABLED", false) && os.Getenv("APP_ENV") != "production"
+}
+
+// Validate and store a rule, filling in its id and expiry
+func (f *FaultInjector) Add(rule FaultRule) (FaultRule, error) {
+	switch rule.Type {
+	case FaultLatency, FaultError500, FaultError503, FaultReset, FaultTruncate:
+	default:
+		return rule, fmt.Errorf("unknown fault type %q", rule.Type)
+	}
+	if rule.Percent <= 0 || rule.Percent > 100 {
+		return rule, fmt.Errorf("percent must be between 0 and 100")
+	}
+	if _, err := path.Match(rule.Route, ""); err != nil {
+		return rule, fmt.Errorf("invalid route pattern %q", rule.Route)
+	}
+	if rule.Type == FaultLatency && rule.LatencyMs <= 0 {
+		return rule, fmt.Errorf("latency faults need latency_ms")
+	}
+
+	ttl := time.Duration(rule.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultFaultTTL
+	}
+	if ttl > maxFaultTTL {
+		ttl = maxFaultTTL
+	}
+
+	id := make([]byte, 6)
+	rand.Read(id)
+	rule.Id = hex.EncodeToString(id)
+	rule.TTLSeconds = int(ttl.Seconds())
+	rule.ExpiresAt = f.Now().Add(ttl)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule)
+	return rule, nil
+}
+
+// Remove a rule, false if it did not exist
+func (f *FaultInjector) Remove(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, rule := range f.rules {
+		if rule.Id == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Rules that have not expired, dropping the ones that have
+func (f *FaultInjector) Rules() []FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.Now()
+	active := f.rules[:0]
+	for _, rule := range f.rules {
+		if now.Before(rule.ExpiresAt) {
+			active = append(active, rule)
+		}
+	}
+	f.rules = active
+	return append([]FaultRule(nil), active...)
+}
+
+// Rule to inject for a route, if any fires
+func (f *FaultInjector) pick(route string) (FaultRule, bool) {
+	for _, rule := range f.Rules() {
+		if matched, _ := path.Match(rule.Route, route); !matched {
+			continue
+		}
+		if f.Rand()*100 < rule.Percent {
+			return rule, true
+		}
+	}
+	return FaultRule{}, false
+}
+
+// Fault injection middleware
+// Admin routes are never faulted so rules can always be removed.
+func (f *FaultInjector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeName(r)
+		if strings.HasPrefix(route, "/api/go/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rule, ok := f.pick(route)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logRequest(r, slog.LevelWarn, "Injected fault %s (rule %s) into %s %s", rule.Type, rule.Id, r.Method, route)
+		w.Header().Set("X-Fault-Injected", rule.Type)
+
+		switch rule.Type {
+		case FaultLatency:
+			select {
+			case <-time.After(time.Duration(rule.LatencyMs) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			next.ServeHTTP(w, r)
+		case FaultError500:
+			writeJSONError(guardedFor(w, r), http.StatusInternalServerError, "injected fault")
+		case FaultError503:
+			writeJSONError(guardedFor(w, r), http.StatusServiceUnavailable, "injected fault")
+		case FaultReset:
+			panic(http.ErrAbortHandler)
+		case FaultTruncate:
+			tw := &truncatingWriter{ResponseWriter: w}
+			next.ServeHTTP(tw, r)
+			tw.flushHalf()
+		}
+	})
+}
+
+// Writer that only ever sends the first half of the body
+type truncatingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (t *truncatingWriter) Write(b []byte) (int, error) {
+	return t.body.Write(b)
+}
+
+func (t *truncatingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *truncatingWriter) flushHalf() {
+	b := t.body.Bytes()
+	t.ResponseWriter.Write(b[:len(b)/2])
+}
+
+// List active fault rules
+func ListFaults(faults *FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, faults.Rules())
+	}
+}
+
+// Add a fault rule
+func CreateFault(faults *FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rule FaultRule
+		err := decodeJSONBody(r, &rule)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		rule, err = faults.Add(rule)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Fault rule %s added: %s on %s at %g%% until %s", rule.Id, rule.Type, rule.Route, rule.Percent, rule.ExpiresAt.Format(time.RFC3339))
+		writeJSON(w, http.StatusCreated, rule)
+	}
+}
+
+// Remove a fault rule
+func DeleteFault(faults *FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if !faults.Remove(id) {
+			writeJSONError(w, http.StatusNotFound, "fault rule not found")
+			return
+		}
+
+		log.Printf("Fault rule %s removed", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
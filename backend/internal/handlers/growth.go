@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Soft and hard row-count limits for a table
+// The count is cached and refreshed periodically, so checks stay cheap.
+type GrowthGuard struct {
+	Table   string
+	Soft    int64
+	Hard    int64
+	Enforce bool
+
+	mu            sync.Mutex
+	count         int64
+	softAlerted   bool
+	overrideUntil time.Time
+}
+
+// Row limits of the users table and how often its count is refreshed
+type GrowthConfig struct {
+	Soft    int64 // 0 for no limit
+	Hard    int64
+	Enforce bool // false only warns past the hard limit
+	Refresh time.Duration
+}
+
+// Read the growth limits
+// USERS_SOFT_LIMIT, USERS_HARD_LIMIT, GROWTH_ENFORCE and GROWTH_REFRESH.
+func LoadGrowthConfig(p *env.Parser) GrowthConfig {
+	config := GrowthConfig{
+		Soft:    int64(p.Int("USERS_SOFT_LIMIT", 0, 0)),
+		Hard:    int64(p.Int("USERS_HARD_LIMIT", 0, 0)),
+		Enforce: p.Bool("GROWTH_ENFORCE", true),
+		Refresh: p.Duration("GROWTH_REFRESH", time.Minute),
+	}
+	if config.Soft > 0 && config.Hard > 0 && config.Soft > config.Hard {
+		p.Invalid("USERS_SOFT_LIMIT (%d) is more than USERS_HARD_LIMIT (%d)", config.Soft, config.Hard)
+	}
+	if config.Refresh == 0 {
+		p.Invalid("GROWTH_REFRESH must be more than 0")
+	}
+	return config
+}
+
+// Growth guard for the users table
+// A limit of zero disables it.
+func NewUsersGrowthGuard(config GrowthConfig) *GrowthGuard {
+	return &GrowthGuard{
+		Table:   "users",
+		Soft:    config.Soft,
+		Hard:    config.Hard,
+		Enforce: config.Enforce,
+	}
+}
+
+// Refresh the cached count now and then every interval until ctx ends
+func (g *GrowthGuard) Start(ctx context.Context, db *sql.DB, interval time.Duration) {
+	if g.Soft == 0 && g.Hard == 0 {
+		return
+	}
+
+	g.refresh(ctx, db)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				g.refresh(ctx, db)
+			}
+		}
+	}()
+}
+
+func (g *GrowthGuard) refresh(ctx context.Context, db *sql.DB) {
+	var count int64
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM "+pq.QuoteIdentifier(g.Table)).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting %s rows: %v", g.Table, err)
+		return
+	}
+	g.set(count)
+}
+
+// Record rows added (or removed, when negative) since the last refresh
+func (g *GrowthGuard) Added(n int64) {
+	g.mu.Lock()
+	count := g.count + n
+	g.mu.Unlock()
+	g.set(count)
+}
+
+// Update the cached count, alerting once when it crosses the soft limit
+func (g *GrowthGuard) set(count int64) {
+	g.mu.Lock()
+	g.count = count
+	alert := false
+	if g.Soft > 0 && count >= g.Soft && !g.softAlerted {
+		g.softAlerted = true
+		alert = true
+	}
+	// Rearm the alert once the table shrinks back under the limit
+	if g.Soft > 0 && count < g.Soft {
+		g.softAlerted = false
+	}
+	g.mu.Unlock()
+
+	if alert {
+		log.Printf("Warning: %s has %d rows, past the soft limit of %d", g.Table, count, g.Soft)
+		go sendGrowthAlert(g.Table, count, g.Soft)
+	}
+}
+
+// Whether another row may be created
+func (g *GrowthGuard) Allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.Hard == 0 || g.count < g.Hard {
+		return true
+	}
+	if time.Now().Before(g.overrideUntil) {
+		return true
+	}
+	if !g.Enforce {
+		log.Printf("Warning: %s has %d rows, past the hard limit of %d", g.Table, g.count, g.Hard)
+		return true
+	}
+	return false
+}
+
+// Let writes past the hard limit through for a while
+func (g *GrowthGuard) Override(d time.Duration) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.overrideUntil = time.Now().Add(d)
+	return g.overrideUntil
+}
+
+// Post a growth alert to ALERT_WEBHOOK_URL when configured
+func sendGrowthAlert(table string, count, limit int64) {
+	url := os.Getenv("ALERT_WEBHOOK_URL")
+	if url == "" {
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"alert": "table_growth",
+		"table": table,
+		"count": count,
+		"limit": limit,
+	})
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Error sending growth alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// Reject a create because the table is full
+func writeCapacityLimit(w http.ResponseWriter, table string) {
+	writeError(w, http.StatusInsufficientStorage, "capacity_limit", table+" has reached its row limit")
+}
+
+// Temporarily lift a hard limit, logged for the audit trail
+func OverrideGrowth(guard *GrowthGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Minutes int `json:"minutes"`
+		}
+		err := decodeJSONBody(r, &body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if body.Minutes <= 0 || body.Minutes > 24*60 {
+			writeJSONError(w, http.StatusBadRequest, "minutes must be between 1 and 1440")
+			return
+		}
+
+		until := guard.Override(time.Duration(body.Minutes) * time.Minute)
+		logRequest(r, slog.LevelInfo, "AUDIT: growth limit on %s overridden until %s by admin from %s", guard.Table, until.Format(time.RFC3339), r.RemoteAddr)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"table": guard.Table, "override_until": until})
+	}
+}
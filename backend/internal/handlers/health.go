@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Build information, set by main from its -ldflags variables
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+)
+
+// When the process started, for uptime
+var startedAt = time.Now()
+
+// Longest a health check waits for the database, so a hung Postgres does not
+// hang the probe
+const healthPingTimeout = 2 * time.Second
+
+// Ping the database within the health check timeout
+func pingDatabase(ctx context.Context, db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// Health report with a database check, 503 when the database is down
+// The database is reported as reconnecting while queries are still failing
+// on dropped connections although a fresh one answers the ping.
+func HealthHandler(db *sql.DB, startup *Startup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, database, code := "ok", "up", http.StatusOK
+		if err := pingDatabase(r.Context(), db); err != nil {
+			status, database, code = "degraded", "down", http.StatusServiceUnavailable
+		} else if store.Reconnecting() {
+			status, database = "degraded", "reconnecting"
+		}
+
+		writeJSON(w, code, map[string]interface{}{
+			"status":     status,
+			"database":   database,
+			"uptime":     time.Since(startedAt).Round(time.Second).String(),
+			"version":    Version,
+			"build_time": BuildTime,
+			"startup":    startup.Phases(),
+		})
+	}
+}
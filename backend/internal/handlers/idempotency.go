@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Longest Idempotency-Key accepted
+const maxIdempotencyKey = 255
+
+// Response headers replayed along with the stored body
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Replays responses to writes retried with the same Idempotency-Key
+type Idempotency struct {
+	// How long a key is remembered
+	TTL time.Duration
+	// How long a claimed key waits for its request before it is given to a retry
+	LockTimeout time.Duration
+
+	store store.IdempotencyStore
+}
+
+// Idempotency keys kept for IDEMPOTENCY_TTL, 24h by default
+// They expire in the key/value store they are kept in.
+func NewIdempotency(keys store.IdempotencyStore) *Idempotency {
+	return &Idempotency{
+		TTL:         env.Duration("IDEMPOTENCY_TTL", 24*time.Hour),
+		LockTimeout: env.Duration("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
+		store:       keys,
+	}
+}
+
+// Writer keeping a copy of the response so it can be stored
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Whether a key is printable ASCII of a sensible length
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKey {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Idempotency middleware, for writes that must not run twice
+// The first request with an Idempotency-Key runs, and its response is
+// stored. Retries with the same key and body get that response back with
+// Idempotent-Replayed: true. Reusing the key for another request is a 422,
+// and a retry while the first request is still running a 409. 5xx responses
+// are not stored, so the retry runs again.
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || isDryRun(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeError(w, http.StatusBadRequest, "invalid_idempotency_key", fmt.Sprintf("Idempotency-Key must be printable ASCII of at most %d characters", maxIdempotencyKey))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("body must be at most %d bytes", tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The same body sent to another route is another request, in any
+		// version of the API
+		sum := sha256.New()
+		fmt.Fprintf(sum, "%s %s\n", r.Method, unversionedPath(routeName(r)))
+		sum.Write(body)
+		hash := hex.EncodeToString(sum.Sum(nil))
+		scope := requestActor(r)
+
+		stored, claimed, err := i.store.Claim(r.Context(), scope, key, hash, i.TTL, i.LockTimeout)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !claimed {
+			switch {
+			case stored.RequestHash != hash:
+				writeError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+			case stored.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusConflict, "idempotency_key_in_use", "a request with this Idempotency-Key is still running")
+			default:
+				for name, value := range stored.Header {
+					w.Header().Set(name, value)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		// Released after a 5xx or a panic, so a retry runs the request again
+		ctx := context.WithoutCancel(r.Context())
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := i.store.Release(ctx, scope, key, hash); err != nil {
+				log.Printf("Error releasing idempotency key: %v", err)
+			}
+		}()
+
+		rw := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.status == 0 || rw.status >= 500 {
+			return
+		}
+
+		response := store.IdempotentResponse{RequestHash: hash, Status: rw.status, Header: map[string]string{}, Body: rw.body.Bytes()}
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				response.Header[name] = value
+			}
+		}
+		// A write that happened keeps its claim even if storing the response
+		// fails, retries wait for the lock timeout rather than run it again
+		completed = true
+		if err := i.store.Complete(ctx, scope, key, response); err != nil {
+			logRequest(r, slog.LevelError, "Error storing the response for an idempotency key: %v", err)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Channel replicas share cache invalidations on
+const invalidationChannel = "cache_invalidation"
+
+// Cache invalidation sent between replicas
+type InvalidationMessage struct {
+	Prefix string    `json:"prefix"`
+	Origin string    `json:"origin"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Transport for cache invalidations between replicas
+// Subscribe calls onConnect every time the subscription is (re)established.
+type InvalidationBus interface {
+	Publish(ctx context.Context, msg InvalidationMessage) error
+	Subscribe(ctx context.Context, onConnect func(), onMessage func(InvalidationMessage)) error
+}
+
+// Id of this replica, used to skip our own invalidations
+var replicaId = newReplicaId()
+
+func newReplicaId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Pick the invalidation bus: Redis when REDIS_URL is set, Postgres NOTIFY otherwise
+func NewInvalidationBus(db *sql.DB, databaseURL string) InvalidationBus {
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatal("Invalid REDIS_URL:", err)
+		}
+		return &redisBus{client: redis.NewClient(opts)}
+	}
+	return &postgresBus{db: db, databaseURL: databaseURL}
+}
+
+// Cache kept in step with the other replicas
+type invalidatedCache interface {
+	Purge(prefix string)
+	setBus(bus InvalidationBus)
+}
+
+func (c *ResponseCache) setBus(bus InvalidationBus) { c.bus = bus }
+
+func (c *UserCache) setBus(bus InvalidationBus) {
+	if c != nil {
+		c.bus = bus
+	}
+}
+
+// Apply invalidations from other replicas to caches
+// Everything is purged on every (re)connect, since messages sent while
+// disconnected are lost. Each cache only holds keys under its own prefixes.
+func SubscribeCacheInvalidation(ctx context.Context, bus InvalidationBus, caches ...invalidatedCache) {
+	for _, cache := range caches {
+		cache.setBus(bus)
+	}
+
+	onConnect := func() {
+		log.Println("Cache invalidation subscribed, purging the cache")
+		for _, cache := range caches {
+			cache.Purge("")
+		}
+	}
+	onMessage := func(msg InvalidationMessage) {
+		if msg.Origin == replicaId {
+			return
+		}
+		for _, cache := range caches {
+			cache.Purge(msg.Prefix)
+		}
+		invalidationLag.Observe(time.Since(msg.SentAt).Seconds())
+		log.Printf("Cache invalidation for %q applied %s after it was sent", msg.Prefix, time.Since(msg.SentAt))
+	}
+
+	go func() {
+		err := bus.Subscribe(ctx, onConnect, onMessage)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Cache invalidation subscription stopped: %v", err)
+		}
+	}()
+}
+
+// Invalidation bus over Postgres LISTEN/NOTIFY
+type postgresBus struct {
+	db          *sql.DB
+	databaseURL string
+}
+
+func (b *postgresBus) Publish(ctx context.Context, msg InvalidationMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", invalidationChannel, string(payload))
+	return err
+}
+
+func (b *postgresBus) Subscribe(ctx context.Context, onConnect func(), onMessage func(InvalidationMessage)) error {
+	connected := make(chan struct{}, 1)
+	listener := pq.NewListener(b.databaseURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected, pq.ListenerEventReconnected:
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		case pq.ListenerEventConnectionAttemptFailed, pq.ListenerEventDisconnected:
+			log.Printf("Cache invalidation listener disconnected: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(invalidationChannel)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-connected:
+			onConnect()
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established
+			if n == nil {
+				continue
+			}
+			var msg InvalidationMessage
+			if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+				log.Printf("Invalid cache invalidation message: %v", err)
+				continue
+			}
+			onMessage(msg)
+		}
+	}
+}
+
+// Invalidation bus over Redis pub/sub
+type redisBus struct {
+	client *redis.Client
+}
+
+func (b *redisBus) Publish(ctx context.Context, msg InvalidationMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, invalidationChannel, payload).Err()
+}
+
+func (b *redisBus) Subscribe(ctx context.Context, onConnect func(), onMessage func(InvalidationMessage)) error {
+	pubsub := b.client.Subscribe(ctx, invalidationChannel)
+	defer pubsub.Close()
+
+	// Subscription confirmations arrive again after every reconnect
+	ch := pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			switch m := m.(type) {
+			case *redis.Subscription:
+				if m.Kind == "subscribe" {
+					onConnect()
+				}
+			case *redis.Message:
+				var msg InvalidationMessage
+				if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+					log.Printf("Invalid cache invalidation message: %v", err)
+					continue
+				}
+				onMessage(msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// A request body that was refused, with a machine readable code
+// Status is 400 unless set.
+type RequestBodyError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *RequestBodyError) Error() string {
+	return e.Message
+}
+
+// Check a JSON body is a single value without duplicate object keys
+// Last-one-wins duplicates let two parsers disagree about the same body, so
+// they are refused at any depth.
+func checkJSONBody(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	if err := walkJSONValue(dec, "$"); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &RequestBodyError{Code: "trailing_content", Message: "unexpected content after the JSON body"}
+	}
+	return nil
+}
+
+// Walk one value, recursing into objects and arrays
+func walkJSONValue(dec *json.Decoder, path string) error {
+	token, err := dec.Token()
+	if err != nil {
+		return &RequestBodyError{Code: "invalid_json", Message: "invalid JSON body"}
+	}
+
+	switch token {
+	case json.Delim('{'):
+		seen := map[string]bool{}
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return &RequestBodyError{Code: "invalid_json", Message: "invalid JSON body"}
+			}
+			key := token.(string)
+			if seen[key] {
+				return &RequestBodyError{Code: "duplicate_json_key", Message: fmt.Sprintf("duplicate key %s.%s", path, key)}
+			}
+			seen[key] = true
+			if err := walkJSONValue(dec, path+"."+key); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := walkJSONValue(dec, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	if err != nil {
+		return &RequestBodyError{Code: "invalid_json", Message: "invalid JSON body"}
+	}
+	return nil
+}
+
+// Body limit middleware
+// Bodies over MAX_BODY_BYTES, 1MB by default, fail to read and are answered
+// with 413. Uploads are multipart and capped by the handler taking them.
+func LimitBody(next http.Handler) http.Handler {
+	limit := int64(env.Int("MAX_BODY_BYTES", 1<<20))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Read a JSON request body and check it is a single value
+// The Content-Type has to be application/json, or one of mediaTypes when
+// they are given.
+func readJSONBody(r *http.Request, mediaTypes ...string) ([]byte, error) {
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"application/json"}
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !slices.Contains(mediaTypes, mediaType) {
+		return nil, &RequestBodyError{Status: http.StatusUnsupportedMediaType, Code: "unsupported_media_type", Message: "Content-Type must be " + strings.Join(mediaTypes, " or ")}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, &RequestBodyError{Status: http.StatusRequestEntityTooLarge, Code: "body_too_large", Message: fmt.Sprintf("body must be at most %d bytes", tooLarge.Limit)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJSONBody(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Decode a checked body into v, refusing fields v does not have
+func unmarshalStrict(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return &RequestBodyError{Code: "unknown_field", Message: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	if err != nil {
+		return &RequestBodyError{Code: "invalid_json", Message: "invalid JSON body"}
+	}
+	return nil
+}
+
+// Read and check a JSON request body, then decode it into v
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	body, err := readJSONBody(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalStrict(body, v)
+}
+
+// Reject a body that could not be decoded
+func writeBodyError(w http.ResponseWriter, err error) {
+	var bodyErr *RequestBodyError
+	if errors.As(err, &bodyErr) {
+		status := bodyErr.Status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, bodyErr.Code, bodyErr.Message)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+}
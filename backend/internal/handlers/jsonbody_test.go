@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+func TestCheckJSONBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		code    string
+		message string
+	}{
+		{"object", `{"name":"Ada","email":"ada@example.com"}`, "", ""},
+		{"trailing whitespace", "{\"name\":\"Ada\"}\r\n\t ", "", ""},
+		{"same key in sibling objects", `{"a":{"id":1},"b":{"id":2}}`, "", ""},
+		{"same key in array elements", `[{"id":1},{"id":2}]`, "", ""},
+		{"scalar", `42`, "", ""},
+		{"duplicate at the top", `{"name":"Ada","name":"Grace"}`, "duplicate_json_key", "duplicate key $.name"},
+		{"duplicate with another value type", `{"email":null,"email":"ada@example.com"}`, "duplicate_json_key", "duplicate key $.email"},
+		{"duplicate in a nested object", `{"user":{"profile":{"name":"Ada","name":"Grace"}}}`, "duplicate_json_key", "duplicate key $.user.profile.name"},
+		{"duplicate in an array of objects", `{"users":[{"id":1},{"id":2,"id":3}]}`, "duplicate_json_key", "duplicate key $.users[1].id"},
+		{"duplicate in a nested array", `[[{}],[{"a":1,"a":1}]]`, "duplicate_json_key", "duplicate key $[1][0].a"},
+		{"second value", `{"name":"Ada"} {"name":"Grace"}`, "trailing_content", ""},
+		{"trailing garbage", `{"name":"Ada"}x`, "trailing_content", ""},
+		{"trailing bracket", `{"name":"Ada"}}`, "trailing_content", ""},
+		{"trailing content after a scalar", `1 2`, "trailing_content", ""},
+		{"empty", ``, "invalid_json", ""},
+		{"truncated", `{"name":`, "invalid_json", ""},
+		{"unclosed array", `[1,2`, "invalid_json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkJSONBody([]byte(tt.body))
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("checkJSONBody = %v, want nil", err)
+				}
+				return
+			}
+			var bodyErr *RequestBodyError
+			if !errors.As(err, &bodyErr) || bodyErr.Code != tt.code {
+				t.Fatalf("checkJSONBody = %v, want %s", err, tt.code)
+			}
+			if tt.message != "" && bodyErr.Message != tt.message {
+				t.Fatalf("message = %q, want %q", bodyErr.Message, tt.message)
+			}
+		})
+	}
+}
+
+// Every JSON-accepting user route refuses the same bodies
+func TestUserRoutesCheckJSONBodies(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	path := "/users/" + user.Id.String()
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		header       []string
+		code         string
+	}{
+		{"create duplicate", "POST", "/users", `{"name":"Ada","email":"a@example.com","email":"b@example.com"}`, nil, "duplicate_json_key"},
+		{"create trailing", "POST", "/users", `{"name":"Ada","email":"a@example.com"}{}`, nil, "trailing_content"},
+		{"bulk nested duplicate", "POST", "/users/bulk", `{"users":[{"name":"Ada","name":"Grace","email":"a@example.com"}]}`, nil, "duplicate_json_key"},
+		{"update duplicate", "PUT", path, `{"name":"Ada","email":"ada@example.com","name":"Grace"}`, nil, "duplicate_json_key"},
+		{"update trailing", "PUT", path, `{"name":"Ada","email":"ada@example.com"} x`, nil, "trailing_content"},
+		{"merge patch duplicate", "PATCH", path, `{"name":"Ada","name":"Grace"}`, []string{"Content-Type", "application/merge-patch+json"}, "duplicate_json_key"},
+		{"merge patch trailing", "PATCH", path, `{"name":"Grace"}]`, []string{"Content-Type", "application/merge-patch+json"}, "trailing_content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.path, tt.body, tt.header...)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d %s, want 400", w.Code, w.Body)
+			}
+			if code := errorCode(t, w); code != tt.code {
+				t.Fatalf("error code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+
+	// Nothing was written by the refused bodies
+	if got := decode[models.User](t, s.do(t, "GET", path, "")); got.Name != "Ada" || got.Version != user.Version {
+		t.Fatalf("user after the refused bodies = %+v", got)
+	}
+}
+
+// A body the size of a typical create or update
+var userBody = []byte(`{"name":"Ada Lovelace","email":"ada.lovelace@example.com"}`)
+
+func BenchmarkCheckJSONBody(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := checkJSONBody(userBody); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The decode every body goes through anyway, to compare the check with
+func BenchmarkUnmarshalStrict(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var user models.User
+		if err := unmarshalStrict(userBody, &user); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
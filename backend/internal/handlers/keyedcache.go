@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entries by key in least recently used order, with one load per missing key
+// The response and user caches build on it, deciding themselves when an
+// entry is fresh. Callers hold mu around everything but run.
+type keyedCache[V any] struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List // front is the most recently used
+	inflight   map[string]*cacheLoad[V]
+	generation uint64
+}
+
+type cacheEntry[V any] struct {
+	key      string
+	value    V
+	storedAt time.Time
+}
+
+// A load shared by every caller waiting on the same key
+type cacheLoad[V any] struct {
+	done       chan struct{}
+	generation uint64
+	value      V
+	err        error
+}
+
+func newKeyedCache[V any]() keyedCache[V] {
+	return keyedCache[V]{
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		inflight: map[string]*cacheLoad[V]{},
+	}
+}
+
+// Entry under a key, with its element in the lru list
+func (k *keyedCache[V]) lookup(key string) (*list.Element, *cacheEntry[V], bool) {
+	el, ok := k.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	return el, el.Value.(*cacheEntry[V]), true
+}
+
+// Add an entry, evicting the least recently used ones past maxEntries
+func (k *keyedCache[V]) store(key string, value V, storedAt time.Time, maxEntries int) {
+	if el, ok := k.entries[key]; ok {
+		k.remove(el)
+	}
+	k.entries[key] = k.lru.PushFront(&cacheEntry[V]{key: key, value: value, storedAt: storedAt})
+	for k.lru.Len() > maxEntries {
+		k.remove(k.lru.Back())
+	}
+}
+
+// Drop an entry
+func (k *keyedCache[V]) remove(el *list.Element) {
+	k.lru.Remove(el)
+	delete(k.entries, el.Value.(*cacheEntry[V]).key)
+}
+
+// Load under way for a key, or a new one the caller has to run
+func (k *keyedCache[V]) join(key string) (call *cacheLoad[V], started bool) {
+	if call, ok := k.inflight[key]; ok {
+		return call, false
+	}
+	call = &cacheLoad[V]{done: make(chan struct{}), generation: k.generation}
+	k.inflight[key] = call
+	return call, true
+}
+
+// Run a load started by join, without holding mu
+// save is called with the lock held unless the key was purged meanwhile, as
+// the load may have read what the purge was for. A load that panics fails
+// every caller waiting on it instead of leaving them waiting.
+func (k *keyedCache[V]) run(key string, call *cacheLoad[V], load func() (V, error), save func(V, error)) {
+	defer func() {
+		panicked := false
+		if p := recover(); p != nil {
+			log.Printf("Cache load of %s panicked: %v\n%s", key, p, debug.Stack())
+			call.err = fmt.Errorf("cache load of %s panicked: %v", key, p)
+			panicked = true
+		}
+
+		k.mu.Lock()
+		if !panicked && call.generation == k.generation {
+			save(call.value, call.err)
+		}
+		if k.inflight[key] == call {
+			delete(k.inflight, key)
+		}
+		k.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = load()
+}
+
+// Drop every entry whose key starts with prefix
+func (k *keyedCache[V]) purge(prefix string) {
+	k.generation++
+	for key, el := range k.entries {
+		if strings.HasPrefix(key, prefix) {
+			k.remove(el)
+		}
+	}
+	// Loads started before the purge may return old data, let new callers start fresh ones
+	for key := range k.inflight {
+		if strings.HasPrefix(key, prefix) {
+			delete(k.inflight, key)
+		}
+	}
+}
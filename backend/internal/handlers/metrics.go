@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Registry served on /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route template, status and source, probe for health checks.",
+	}, []string{"method", "route", "status", "source"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route template, status and source.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status", "source"})
+
+	userOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_operations_total",
+		Help: "Users created, updated and deleted.",
+	}, []string{"operation"})
+
+	admissionWaits = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "admission_queue_wait_seconds",
+		Help:    "Time requests spent queued for an admission slot.",
+		Buckets: []float64{.01, .05, .1, .5, 1, 2.5, 5, 10},
+	}, []string{"class"})
+
+	admissionRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "admission_rejections_total",
+		Help: "Requests turned away by admission control, by reason.",
+	}, []string{"class", "reason"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limited_requests_total",
+		Help: "Requests answered 429 by the read or write rate limit.",
+	}, []string{"limit"})
+
+	userCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_cache_lookups_total",
+		Help: "User cache lookups by result: hit, negative_hit for a cached 404, or miss.",
+	}, []string{"result"})
+
+	budgetOverruns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "request_budget_overruns_total",
+		Help: "Handler phases that ran out of their share of the request budget, by route and phase.",
+	}, []string{"route", "phase"})
+
+	invalidationLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cache_invalidation_lag_seconds",
+		Help:    "Delay between another replica sending an invalidation and it being applied here.",
+		Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5},
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, userOperations,
+		admissionWaits, admissionRejections, rateLimited, userCacheLookups, budgetOverruns, invalidationLag,
+	)
+}
+
+// Whether /metrics is served, on unless METRICS_ENABLED=false
+func MetricsEnabled() bool {
+	return env.Bool("METRICS_ENABLED", true)
+}
+
+// Export the connection pool stats of a database
+// Open, in use and idle connections, and how often and long callers waited.
+func RegisterDBMetrics(db *sql.DB) {
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// Count a user operation, n users at a time for bulk writes
+func countUserOperation(operation string, n int) {
+	userOperations.WithLabelValues(operation).Add(float64(n))
+}
+
+// Metrics middleware
+// Records every routed request under its route template, so ids do not
+// blow up the label count.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := metricsRoute(r)
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		defer func() {
+			status := lw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			observeRequest(r, route, status, start)
+		}()
+		next.ServeHTTP(lw, r)
+	})
+}
+
+// Route template of a request as a metrics label, unmatched outside a route
+func metricsRoute(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// Count a request and how long it took
+func observeRequest(r *http.Request, route string, status int, start time.Time) {
+	labels := []string{r.Method, route, strconv.Itoa(status), requestSource(r.Context())}
+	httpRequests.WithLabelValues(labels...).Inc()
+	httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+// Serve /metrics ahead of the rest of the stack
+// Scrapes skip CORS, request ids and request logging, and work while the
+// service is still starting.
+func ServeMetrics(path string, next http.Handler) http.Handler {
+	metrics := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
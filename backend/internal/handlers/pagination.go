@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Page sizes for list endpoints
+const (
+	defaultPageLimit = 25
+	maxPageLimit     = 500
+)
+
+// Longest ?q search term
+const maxSearchLength = 100
+
+// Fields users can be filtered on with ?filter[field]
+var filterFields = map[string]bool{"name": true, "email": true}
+
+// A page of a list response
+// Page and PerPage say the same as Offset and Limit, for clients that count
+// in pages.
+type Page struct {
+	Data        json.RawMessage `json:"data"`
+	Total       int64           `json:"total"`
+	Limit       int             `json:"limit"`
+	Offset      int             `json:"offset"`
+	Page        int             `json:"page"`
+	PerPage     int             `json:"per_page"`
+	NextAfterId *models.ID      `json:"next_after_id,omitempty"`
+}
+
+// Page of data at offset, numbered from 1
+func newPage(data json.RawMessage, total int64, limit, offset int) Page {
+	return Page{Data: data, Total: total, Limit: limit, Offset: offset, Page: offset/limit + 1, PerPage: limit}
+}
+
+// ?filter[field], or the bare ?field
+func filterParam(query url.Values, field string) string {
+	if v, ok := query["filter["+field+"]"]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return query.Get(field)
+}
+
+// Read ?limit, ?offset or ?page, ?after_id, ?sort, ?order, ?collation, ?q,
+// ?name, ?email and ?include_deleted
+// Name and email can also be given as ?filter[name] and ?filter[email].
+// Sorting by name is implied when only a collation is given.
+func parseListParams(query url.Values) (store.ListOptions, error) {
+	for key := range query {
+		field, ok := strings.CutPrefix(key, "filter[")
+		if !ok {
+			continue
+		}
+		if field, ok = strings.CutSuffix(field, "]"); !ok || !filterFields[field] {
+			return store.ListOptions{}, fmt.Errorf("unknown filter %s, users can be filtered by name or email", key)
+		}
+		if query.Has(field) {
+			return store.ListOptions{}, fmt.Errorf("%s and %s cannot be used together", key, field)
+		}
+	}
+
+	p := store.ListOptions{
+		Limit:     defaultPageLimit,
+		Sort:      query.Get("sort"),
+		Collation: query.Get("collation"),
+		Query:     strings.TrimSpace(query.Get("q")),
+		Name:      strings.TrimSpace(filterParam(query, "name")),
+		Email:     strings.ToLower(strings.TrimSpace(filterParam(query, "email"))),
+	}
+	if v := query.Get("include_deleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, errors.New("include_deleted must be true or false")
+		}
+		p.IncludeDeleted = include
+	}
+	if utf8.RuneCountInString(p.Query) > maxSearchLength {
+		return p, fmt.Errorf("q must be at most %d characters", maxSearchLength)
+	}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		p.Limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, errors.New("offset must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+	if v := query.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, errors.New("page must be a positive integer")
+		}
+		if query.Has("offset") {
+			return p, errors.New("page and offset cannot be used together")
+		}
+		if n-1 > math.MaxInt32/p.Limit {
+			return p, errors.New("page is too large")
+		}
+		p.Offset = (n - 1) * p.Limit
+	}
+
+	if p.Sort == "" {
+		p.Sort = "id"
+		if p.Collation != "" {
+			p.Sort = "name"
+		}
+	}
+	if _, ok := store.SortColumns[p.Sort]; !ok {
+		return p, fmt.Errorf("sort must be one of id, name, email or created_at")
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		p.Desc = true
+	default:
+		return p, errors.New("order must be asc or desc")
+	}
+
+	if v := query.Get("after_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return p, errors.New("after_id must be a non-negative integer")
+		}
+		if p.Sort != "id" || p.Offset != 0 {
+			return p, errors.New("after_id only works when sorting by id without an offset")
+		}
+		p.AfterId = models.ID(n)
+		p.HasAfter = true
+	}
+	return p, nil
+}
+
+// Cache key of a users list, built from the options its query parsed to
+// Queries asking for the same page share an entry, whatever order their
+// parameters came in or whether they used page or offset, and parameters the
+// list ignores cannot fill the cache with copies of it.
+func listCacheKey(opts store.ListOptions) string {
+	key := url.Values{}
+	key.Set("limit", strconv.Itoa(opts.Limit))
+	key.Set("offset", strconv.Itoa(opts.Offset))
+	key.Set("sort", opts.Sort)
+	key.Set("desc", strconv.FormatBool(opts.Desc))
+	key.Set("collation", opts.Collation)
+	key.Set("q", opts.Query)
+	key.Set("name", opts.Name)
+	key.Set("email", opts.Email)
+	key.Set("include_deleted", strconv.FormatBool(opts.IncludeDeleted))
+	if opts.HasAfter {
+		key.Set("after_id", opts.AfterId.String())
+	}
+	return "users:" + key.Encode()
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Value of a counter so far
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// Requests counted under the given labels so far
+func requestCount(t *testing.T, method, route, status, source string) float64 {
+	t.Helper()
+	return counterValue(t, httpRequests.WithLabelValues(method, route, status, source))
+}
+
+// The stack main serves probes through, with router middleware that fails
+// the test when a probe answered up front reaches it
+func newProbeStack(t *testing.T, cors CORSConfig) http.Handler {
+	t.Helper()
+	router := mux.NewRouter()
+	routes := NewRouteTable(router)
+	routes.Use(Mw("metrics", Metrics))
+	routes.Use(Mw("guarded", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions && probePaths[r.URL.Path] && r.Header.Get("Access-Control-Request-Method") == "" {
+				t.Errorf("OPTIONS probe of %s reached the router middleware", r.URL.Path)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	routes.HandleFunc("GET", "/healthz", HealthzHandler)
+	routes.HandleFunc("", "/", HomeHandler)
+	routes.Handle("GET", "/api/go/users", http.NotFoundHandler(), Mw("admin", RequireAdmin))
+
+	CORSHeaders.RouteMethods(routes.AllowedMethods)
+	t.Cleanup(func() { CORSHeaders.RouteMethods(nil) })
+	probes := ProbeConfig{UserAgents: append(slices.Clone(defaultProbeAgents), "Custom-LB/")}
+	return ProbeHandler(probes, RequestLogger(EnableCORS(cors, router)))
+}
+
+func TestOptionsProbes(t *testing.T) {
+	locked := CORSConfig{AllowedOrigins: map[string]bool{"https://app.example.com": true}, AllowedMethods: defaultCORSMethods}
+	tests := []struct {
+		name   string
+		path   string
+		agent  string
+		origin string
+		cors   CORSConfig
+		allow  string
+		source string
+	}{
+		{"load balancer on the health check", "/healthz", "ELB-HealthChecker/2.0", "", locked, "GET, OPTIONS", "probe"},
+		{"load balancer on the home page", "/", "GoogleHC/1.0", "", locked, "GET, POST, PUT, PATCH, DELETE, OPTIONS", "probe"},
+		{"configured user agent", "/healthz", "Custom-LB/7", "", locked, "GET, OPTIONS", "probe"},
+		{"unknown user agent", "/healthz", "curl/8.5.0", "", locked, "GET, OPTIONS", "client"},
+		{"origin CORS refuses", "/healthz", "kube-probe/1.30", "https://evil.example.com", locked, "GET, OPTIONS", "probe"},
+		{"any origin allowed", "/healthz", "kube-probe/1.30", "https://app.example.com", CORSConfig{AllowedMethods: defaultCORSMethods}, "GET, OPTIONS", "probe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newProbeStack(t, tt.cors)
+			before := requestCount(t, "OPTIONS", tt.path, "200", tt.source)
+
+			r := httptest.NewRequest("OPTIONS", tt.path, nil)
+			r.Header.Set("User-Agent", tt.agent)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK || w.Body.Len() != 0 {
+				t.Fatalf("OPTIONS %s = %d %q, want 200 and no body", tt.path, w.Code, w.Body)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Fatalf("Allow = %q, want %q", got, tt.allow)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+				t.Fatalf("probe answered with CORS headers, Access-Control-Allow-Origin %q", got)
+			}
+			if w.Header().Get("X-Request-ID") != "" {
+				t.Fatal("probe went through the request logger")
+			}
+			if got := requestCount(t, "OPTIONS", tt.path, "200", tt.source); got != before+1 {
+				t.Fatalf("%v probes counted with source=%s, want %v", got, tt.source, before+1)
+			}
+		})
+	}
+}
+
+func TestOptionsProbeBeforeRoutesAreWired(t *testing.T) {
+	handler := ProbeHandler(LoadProbeConfig(&env.Parser{}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("probe was passed on")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Allow") != "OPTIONS" {
+		t.Fatalf("OPTIONS /healthz while starting = %d, Allow %q, want 200 and OPTIONS", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestRequestsPassedOnByTheProbeHandler(t *testing.T) {
+	handler := newProbeStack(t, CORSConfig{AllowedOrigins: map[string]bool{"https://app.example.com": true}, AllowedMethods: defaultCORSMethods})
+
+	t.Run("GET probes are counted as probes", func(t *testing.T) {
+		before := requestCount(t, "GET", "/healthz", "200", "probe")
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Header.Set("User-Agent", "kube-probe/1.30")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /healthz = %d", w.Code)
+		}
+		if got := requestCount(t, "GET", "/healthz", "200", "probe"); got != before+1 {
+			t.Fatalf("%v GET probes counted, want %v", got, before+1)
+		}
+	})
+
+	t.Run("preflights go to CORS", func(t *testing.T) {
+		r := httptest.NewRequest("OPTIONS", "/healthz", nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Fatalf("preflight = %d %v, want CORS to answer it", w.Code, w.Header())
+		}
+	})
+
+	t.Run("other paths are not probes", func(t *testing.T) {
+		r := httptest.NewRequest("OPTIONS", "/api/go/users", nil)
+		r.Header.Set("User-Agent", "ELB-HealthChecker/2.0")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Header().Get("X-Request-ID") == "" {
+			t.Fatalf("OPTIONS /api/go/users = %d, answered as a probe", w.Code)
+		}
+	})
+}
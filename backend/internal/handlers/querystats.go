@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Writer that reports the query count in a header before the response starts
+type queryCountWriter struct {
+	http.ResponseWriter
+	collector   *store.QueryCollector
+	wroteHeader bool
+}
+
+func (q *queryCountWriter) WriteHeader(status int) {
+	if !q.wroteHeader {
+		q.wroteHeader = true
+		q.Header().Set("X-DB-Queries", strconv.Itoa(len(q.collector.Queries())))
+	}
+	q.ResponseWriter.WriteHeader(status)
+}
+
+func (q *queryCountWriter) Write(b []byte) (int, error) {
+	if !q.wroteHeader {
+		q.WriteHeader(http.StatusOK)
+	}
+	return q.ResponseWriter.Write(b)
+}
+
+func (q *queryCountWriter) Unwrap() http.ResponseWriter {
+	return q.ResponseWriter
+}
+
+func (q *queryCountWriter) Flush() {
+	if f, ok := q.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Query stats middleware
+// Counts the database operations of each request, warning when a request
+// runs more than DB_QUERY_BUDGET of them. DEV_MODE adds the X-DB-Queries
+// header and a summary log line per request.
+func QueryStats(next http.Handler) http.Handler {
+	budget := env.Int("DB_QUERY_BUDGET", 10)
+	devMode := env.Bool("DEV_MODE", false)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, collector := store.WithQueryCollector(r.Context())
+		r = r.WithContext(ctx)
+
+		if devMode {
+			w = &queryCountWriter{ResponseWriter: w, collector: collector}
+		}
+		next.ServeHTTP(w, r)
+
+		queries := collector.Queries()
+		if budget > 0 && len(queries) > budget {
+			logRequest(r, slog.LevelWarn, "%s %s ran %d queries, over the budget of %d", r.Method, routeName(r), len(queries), budget)
+		}
+		if devMode && len(queries) > 0 {
+			var total time.Duration
+			var rows int64
+			for _, q := range queries {
+				total += q.Duration
+				rows += q.Rows
+			}
+			logRequest(r, slog.LevelDebug, "%s %s ran %d queries in %s returning %d rows", r.Method, routeName(r), len(queries), total, rows)
+		}
+	})
+}
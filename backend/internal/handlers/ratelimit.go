@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Whether X-Forwarded-For can be trusted, only behind a proxy that sets it
+var TrustProxy bool
+
+// IP a request came from
+// Behind a trusted proxy it is the last X-Forwarded-For entry, the address
+// the proxy saw, since clients can put anything before it.
+func clientIP(r *http.Request) string {
+	if TrustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Rate limit per client IP, shared by replicas through a key/value store
+// Clients get Burst requests per window of Burst/Rate seconds. The count of
+// the previous window is weighed in for the part of it that still overlaps
+// the last window length, so the limit slides instead of resetting at once.
+type RateLimiter struct {
+	Name  string
+	Rate  float64 // requests per second on average, 0 for no limit
+	Burst int
+	Now   func() time.Time
+
+	kv store.KeyValueTTL
+}
+
+func NewRateLimiter(kv store.KeyValueTTL, name string, rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{Name: name, Rate: rate, Burst: burst, Now: time.Now, kv: kv}
+}
+
+// Length of a counting window
+func (l *RateLimiter) window() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// Count a request of a client
+// Returns the requests left, and how long until one is allowed again when
+// there were none. Refused requests are not counted.
+func (l *RateLimiter) take(ctx context.Context, key string) (bool, int, time.Duration, error) {
+	now := l.Now()
+	window := l.window()
+	index := now.UnixNano() / int64(window)
+	elapsed := now.Sub(time.Unix(0, index*int64(window)))
+	prefix := "ratelimit:" + l.Name + ":" + key + ":"
+	current := prefix + strconv.FormatInt(index, 10)
+
+	count, err := l.kv.IncrWithExpiry(ctx, current, 1, 2*window)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	var previous int64
+	raw, ok, err := l.kv.Get(ctx, prefix+strconv.FormatInt(index-1, 10))
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if ok {
+		previous, _ = strconv.ParseInt(string(raw), 10, 64)
+	}
+
+	overlap := 1 - float64(elapsed)/float64(window)
+	used := float64(previous)*overlap + float64(count)
+	if used <= float64(l.Burst) {
+		return true, int(float64(l.Burst) - used), 0, nil
+	}
+
+	if _, err := l.kv.IncrWithExpiry(ctx, current, -1, 2*window); err != nil {
+		return false, 0, 0, err
+	}
+	// Until the previous window has faded enough to leave room, or when this
+	// one alone is full, until it has faded enough in the next window
+	var wait time.Duration
+	if room := float64(int64(l.Burst) - count); room >= 0 {
+		wait = time.Duration((1-room/float64(previous))*float64(window)) - elapsed
+	} else {
+		room := float64(l.Burst - 1)
+		wait = window - elapsed + time.Duration((1-room/float64(count-1))*float64(window))
+	}
+	return false, 0, max(wait, 0), nil
+}
+
+// Seconds until a client has its whole burst again
+func (l *RateLimiter) resetAfter(remaining int) int {
+	return int(math.Ceil(float64(l.Burst-remaining) / l.Rate))
+}
+
+// Rates and bursts of the read and write limits
+type RateLimitConfig struct {
+	ReadRate   float64 // requests per second, 0 for no limit
+	ReadBurst  int
+	WriteRate  float64
+	WriteBurst int
+	// Send the X-RateLimit-* headers older clients read too
+	LegacyHeaders bool
+}
+
+// Read the rate limits
+// RATE_LIMIT_RPS and RATE_LIMIT_BURST for reads, RATE_LIMIT_WRITE_RPS and
+// RATE_LIMIT_WRITE_BURST for writes, and RATE_LIMIT_LEGACY_HEADERS.
+func LoadRateLimitConfig(p *env.Parser) RateLimitConfig {
+	return RateLimitConfig{
+		ReadRate:      p.Float("RATE_LIMIT_RPS", 20, 0),
+		ReadBurst:     p.Int("RATE_LIMIT_BURST", 40, 1),
+		WriteRate:     p.Float("RATE_LIMIT_WRITE_RPS", 5, 0),
+		WriteBurst:    p.Int("RATE_LIMIT_WRITE_BURST", 10, 1),
+		LegacyHeaders: p.Bool("RATE_LIMIT_LEGACY_HEADERS", false),
+	}
+}
+
+// Separate limits for reads and writes to the API
+type RateLimits struct {
+	Reads         *RateLimiter
+	Writes        *RateLimiter
+	LegacyHeaders bool
+}
+
+// Limits for reads and writes, a rate of 0 turns one off
+func NewRateLimits(kv store.KeyValueTTL, config RateLimitConfig) *RateLimits {
+	return &RateLimits{
+		Reads:         NewRateLimiter(kv, "reads", config.ReadRate, config.ReadBurst),
+		Writes:        NewRateLimiter(kv, "writes", config.WriteRate, config.WriteBurst),
+		LegacyHeaders: config.LegacyHeaders,
+	}
+}
+
+// Rate limit middleware for every version of the API
+// Answers 429 with Retry-After once a client has used up its burst.
+func (l *RateLimits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := l.Writes
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			limiter = l.Reads
+		}
+		if limiter.Rate <= 0 || !isAPIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, remaining, wait, err := limiter.take(r.Context(), clientIP(r))
+		if err != nil {
+			// A limit that cannot be checked lets requests through rather
+			// than take the API down with it
+			logRequest(r, slog.LevelError, "Error checking the %s rate limit: %v", limiter.Name, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeRateLimitHeaders(w, limiter, remaining, l.LegacyHeaders)
+		if !ok {
+			rateLimited.WithLabelValues(limiter.Name).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit-* headers of the rate limit headers draft
+// The X-RateLimit-* ones older clients read are added with legacy.
+func writeRateLimitHeaders(w http.ResponseWriter, limiter *RateLimiter, remaining int, legacy bool) {
+	h := w.Header()
+	limit := strconv.Itoa(limiter.Burst)
+	left := strconv.Itoa(remaining)
+	reset := strconv.Itoa(limiter.resetAfter(remaining))
+	h.Set("RateLimit-Limit", limit)
+	h.Set("RateLimit-Remaining", left)
+	h.Set("RateLimit-Reset", reset)
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limiter.Burst, int(math.Ceil(float64(limiter.Burst)/limiter.Rate))))
+	if legacy {
+		h.Set("X-RateLimit-Limit", limit)
+		h.Set("X-RateLimit-Remaining", left)
+		h.Set("X-RateLimit-Reset", reset)
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Rate limits on a memory store, with a clock the test moves
+// The clock starts at the beginning of a window of every limit used here.
+func newFakeClockLimits(config RateLimitConfig) (*RateLimits, *store.MemoryKV, *time.Time) {
+	now := time.Unix(1_000_000, 0)
+	clock := func() time.Time { return now }
+	kv := store.NewMemoryKV()
+	kv.Now = clock
+	limits := NewRateLimits(kv, config)
+	limits.Reads.Now = clock
+	limits.Writes.Now = clock
+	return limits, kv, &now
+}
+
+// Rate limit middleware in front of a handler answering 200
+func rateLimited200(limits *RateLimits) http.Handler {
+	return limits.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// Send a request from an address
+func sendFrom(h http.Handler, method, addr string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/go/users", nil)
+	r.RemoteAddr = addr
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimitCapsConcurrentRequests(t *testing.T) {
+	limits, _, _ := newFakeClockLimits(RateLimitConfig{ReadRate: 1, ReadBurst: 25})
+	h := rateLimited200(limits)
+	refusedBefore := counterValue(t, rateLimited.WithLabelValues("reads"))
+
+	var allowed, refused atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch w := sendFrom(h, "GET", "203.0.113.1:4000"); w.Code {
+			case http.StatusOK:
+				allowed.Add(1)
+			case http.StatusTooManyRequests:
+				refused.Add(1)
+			default:
+				t.Errorf("status %d", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 25 || refused.Load() != 175 {
+		t.Fatalf("%d allowed and %d refused, want the burst of 25 allowed", allowed.Load(), refused.Load())
+	}
+	if got := counterValue(t, rateLimited.WithLabelValues("reads")) - refusedBefore; got != 175 {
+		t.Fatalf("rate_limited_requests_total went up by %v, want 175", got)
+	}
+}
+
+// Sustained traffic gets through at about the rate, never more
+// The estimate of the previous window is a little high for traffic that
+// keeps hitting the limit, which costs up to one request a window.
+func TestRateLimitCapsThroughput(t *testing.T) {
+	limits, _, now := newFakeClockLimits(RateLimitConfig{WriteRate: 10, WriteBurst: 20})
+	h := rateLimited200(limits)
+
+	allowed := 0
+	for i := 0; i < 6000; i++ {
+		if sendFrom(h, "POST", "203.0.113.1:4000").Code == http.StatusOK {
+			allowed++
+		}
+		*now = now.Add(10 * time.Millisecond)
+	}
+	// 60 seconds of 100 requests a second, in 30 windows of 20
+	if allowed > 600+20 || allowed < 600-30-20 {
+		t.Fatalf("%d requests allowed in 60s, want about 600", allowed)
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	limits, _, now := newFakeClockLimits(RateLimitConfig{ReadRate: 1, ReadBurst: 2, LegacyHeaders: true})
+	h := rateLimited200(limits)
+
+	if w := sendFrom(h, "GET", "203.0.113.1:4000"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("first request = %d, remaining %q", w.Code, w.Header().Get("RateLimit-Remaining"))
+	}
+	sendFrom(h, "GET", "203.0.113.1:4000")
+	w := sendFrom(h, "GET", "203.0.113.1:4000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst = %d, want 429", w.Code)
+	}
+	if code := errorCode(t, w); code != "rate_limited" {
+		t.Fatalf("error code = %q, want rate_limited", code)
+	}
+	// The full window weighs in at the start of the next one, so it takes
+	// half of that one too before there is room
+	h429 := w.Header()
+	if h429.Get("Retry-After") != "3" || h429.Get("RateLimit-Limit") != "2" || h429.Get("RateLimit-Remaining") != "0" || h429.Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("headers of the 429 = %v", h429)
+	}
+
+	*now = now.Add(2 * time.Second)
+	if w := sendFrom(h, "GET", "203.0.113.1:4000"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request before Retry-After = %d, want 429", w.Code)
+	}
+	// Allowed again once Retry-After has passed
+	*now = now.Add(time.Second)
+	if w := sendFrom(h, "GET", "203.0.113.1:4000"); w.Code != http.StatusOK {
+		t.Fatalf("request after Retry-After = %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitsReadsAndWritesApart(t *testing.T) {
+	limits, _, _ := newFakeClockLimits(RateLimitConfig{ReadRate: 1, ReadBurst: 3, WriteRate: 1, WriteBurst: 1})
+	h := rateLimited200(limits)
+
+	if w := sendFrom(h, "POST", "203.0.113.1:4000"); w.Code != http.StatusOK {
+		t.Fatalf("first write = %d", w.Code)
+	}
+	if w := sendFrom(h, "DELETE", "203.0.113.1:4000"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second write = %d, want 429", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if w := sendFrom(h, "GET", "203.0.113.1:4000"); w.Code != http.StatusOK {
+			t.Fatalf("read %d after the writes ran out = %d, want 200", i+1, w.Code)
+		}
+	}
+
+	// A rate of 0 turns a limit off
+	limits, _, _ = newFakeClockLimits(RateLimitConfig{ReadRate: 1, ReadBurst: 1, WriteRate: 0, WriteBurst: 1})
+	h = rateLimited200(limits)
+	for i := 0; i < 10; i++ {
+		if w := sendFrom(h, "POST", "203.0.113.1:4000"); w.Code != http.StatusOK {
+			t.Fatalf("write %d without a write limit = %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestRateLimitPerClientIP(t *testing.T) {
+	limits, _, _ := newFakeClockLimits(RateLimitConfig{ReadRate: 1, ReadBurst: 1})
+	h := rateLimited200(limits)
+	t.Cleanup(func() { TrustProxy = false })
+
+	sendFrom(h, "GET", "203.0.113.1:4000")
+	if w := sendFrom(h, "GET", "203.0.113.1:5000"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("same IP from another port = %d, want 429", w.Code)
+	}
+	if w := sendFrom(h, "GET", "203.0.113.2:4000"); w.Code != http.StatusOK {
+		t.Fatalf("another IP = %d, want 200", w.Code)
+	}
+
+	// X-Forwarded-For is only read behind a trusted proxy
+	TrustProxy = false
+	if w := sendFrom(h, "GET", "198.51.100.1:4000", "X-Forwarded-For", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("first request through the proxy = %d", w.Code)
+	}
+	if w := sendFrom(h, "GET", "198.51.100.1:4000", "X-Forwarded-For", "192.0.2.2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("forwarded for another client without TRUST_PROXY = %d, want 429", w.Code)
+	}
+	TrustProxy = true
+	if w := sendFrom(h, "GET", "198.51.100.1:4000", "X-Forwarded-For", "192.0.2.3"); w.Code != http.StatusOK {
+		t.Fatalf("forwarded for another client with TRUST_PROXY = %d, want 200", w.Code)
+	}
+	// Clients cannot get a bucket of their own by prepending addresses
+	if w := sendFrom(h, "GET", "198.51.100.1:4000", "X-Forwarded-For", "10.0.0.1, 192.0.2.3"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed X-Forwarded-For = %d, want 429", w.Code)
+	}
+}
+
+// Counters of clients expire, so the store does not grow with every IP seen
+func TestRateLimitCountersExpire(t *testing.T) {
+	limits, kv, now := newFakeClockLimits(RateLimitConfig{ReadRate: 1, ReadBurst: 5})
+	h := rateLimited200(limits)
+	for _, addr := range []string{"203.0.113.1:4000", "203.0.113.2:4000", "203.0.113.3:4000"} {
+		sendFrom(h, "GET", addr)
+	}
+
+	*now = now.Add(5 * time.Second)
+	if n, _ := kv.Purge(context.Background()); n != 0 {
+		t.Fatalf("%d counters purged while still in use", n)
+	}
+	*now = now.Add(5 * time.Second)
+	if n, _ := kv.Purge(context.Background()); n != 3 {
+		t.Fatalf("%d counters purged two windows later, want 3", n)
+	}
+}
+
+// Key/value store that is down
+type failingKV struct{ store.KeyValueTTL }
+
+func (failingKV) IncrWithExpiry(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return 0, errors.New("kv is down")
+}
+
+// A limit that cannot be checked lets requests through
+func TestRateLimitFailsOpen(t *testing.T) {
+	h := rateLimited200(NewRateLimits(failingKV{}, RateLimitConfig{ReadRate: 1, ReadBurst: 1}))
+	for i := 0; i < 3; i++ {
+		if w := sendFrom(h, "GET", "203.0.113.1:4000"); w.Code != http.StatusOK {
+			t.Fatalf("request %d with the store down = %d, want 200", i+1, w.Code)
+		}
+	}
+}
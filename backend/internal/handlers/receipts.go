@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Record that a user's data was removed
+type DeletionReceipt struct {
+	Id          string           `json:"id"`
+	UserId      models.ID        `json:"user_id"`
+	Operation   string           `json:"operation"`
+	CompletedAt time.Time        `json:"completed_at"`
+	Actor       string           `json:"actor"`
+	Tables      map[string]int64 `json:"tables"`
+}
+
+// Receipt as issued, with the exact bytes that were signed
+type SignedReceipt struct {
+	Receipt   json.RawMessage `json:"receipt"`
+	KeyId     string          `json:"key_id"`
+	Signature string          `json:"signature"`
+}
+
+// Signs receipts with the active key and knows the public half of retired ones
+type ReceiptSigner struct {
+	keyId   string
+	private ed25519.PrivateKey
+	public  map[string]ed25519.PublicKey
+}
+
+// Load receipt keys from the environment
+// RECEIPT_SIGNING_KEY is "kid:base64 seed" for the active key. RECEIPT_VERIFY_KEYS
+// lists retired keys as comma separated "kid:base64 public key" pairs so receipts
+// signed before a rotation still verify. Returns nil when receipts are off.
+func NewReceiptSigner() (*ReceiptSigner, error) {
+	active := os.Getenv("RECEIPT_SIGNING_KEY")
+	if active == "" {
+		return nil, nil
+	}
+
+	kid, seed, err := parseReceiptKey(active)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("receipt signing key %s must be a %d byte seed", kid, ed25519.SeedSize)
+	}
+
+	private := ed25519.NewKeyFromSeed(seed)
+	signer := &ReceiptSigner{
+		keyId:   kid,
+		private: private,
+		public:  map[string]ed25519.PublicKey{kid: private.Public().(ed25519.PublicKey)},
+	}
+
+	for _, entry := range strings.Split(os.Getenv("RECEIPT_VERIFY_KEYS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		kid, public, err := parseReceiptKey(entry)
+		if err != nil {
+			return nil, err
+		}
+		if len(public) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("receipt verify key %s must be a %d byte public key", kid, ed25519.PublicKeySize)
+		}
+		if _, exists := signer.public[kid]; exists {
+			return nil, fmt.Errorf("duplicate receipt key id %s", kid)
+		}
+		signer.public[kid] = public
+	}
+	return signer, nil
+}
+
+func parseReceiptKey(entry string) (string, []byte, error) {
+	kid, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+	if !ok || kid == "" {
+		return "", nil, fmt.Errorf("receipt key must look like kid:base64")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("receipt key %s is not valid base64", kid)
+	}
+	return kid, key, nil
+}
+
+// Sign a receipt and store it in the same transaction as the deletion
+func (s *ReceiptSigner) Issue(ctx context.Context, receipts store.ReceiptStore, receipt DeletionReceipt) (*SignedReceipt, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	receipt.Id = hex.EncodeToString(id)
+	receipt.CompletedAt = time.Now().UTC()
+
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	signed := &SignedReceipt{
+		Receipt:   body,
+		KeyId:     s.keyId,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.private, body)),
+	}
+
+	err = receipts.Save(ctx, store.Receipt{
+		Id:        receipt.Id,
+		UserId:    receipt.UserId,
+		Operation: receipt.Operation,
+		Body:      body,
+		KeyId:     signed.KeyId,
+		Signature: signed.Signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// Public keys receipts may be signed with, by key id
+func (s *ReceiptSigner) PublicKeys() map[string]ed25519.PublicKey {
+	return s.public
+}
+
+// Check a receipt's signature against a set of public keys
+func VerifyReceipt(receipt *SignedReceipt, keys map[string]ed25519.PublicKey) error {
+	public, ok := keys[receipt.KeyId]
+	if !ok {
+		return fmt.Errorf("unknown receipt key %s", receipt.KeyId)
+	}
+	signature, err := base64.StdEncoding.DecodeString(receipt.Signature)
+	if err != nil {
+		return errors.New("receipt signature is not valid base64")
+	}
+	if !ed25519.Verify(public, receipt.Receipt, signature) {
+		return errors.New("receipt signature does not match")
+	}
+	return nil
+}
+
+// Latest receipt issued for an operation on a user, so repeats get the same one
+func findReceipt(ctx context.Context, receipts store.ReceiptStore, userId models.ID, operation string) (*SignedReceipt, error) {
+	stored, err := receipts.Latest(ctx, userId, operation)
+	if err != nil {
+		return nil, err
+	}
+	return signedReceipt(stored), nil
+}
+
+// Receipt as it is served, from how it is stored
+func signedReceipt(stored store.Receipt) *SignedReceipt {
+	return &SignedReceipt{Receipt: json.RawMessage(stored.Body), KeyId: stored.KeyId, Signature: stored.Signature}
+}
+
+// Who is acting on a request, for receipts and audit lines
+func requestActor(r *http.Request) string {
+	if isAdminRequest(r) {
+		return "admin"
+	}
+	if id, ok := authUserID(r.Context()); ok {
+		return "user:" + id.String()
+	}
+	return "anonymous"
+}
+
+// Serve the public receipt keys
+func ReceiptKeysHandler(signer *ReceiptSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type key struct {
+			KeyId     string `json:"kid"`
+			Algorithm string `json:"alg"`
+			PublicKey string `json:"public_key"`
+		}
+		keys := []key{}
+		for kid, public := range signer.PublicKeys() {
+			keys = append(keys, key{KeyId: kid, Algorithm: "Ed25519", PublicKey: base64.StdEncoding.EncodeToString(public)})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"active": signer.keyId, "keys": keys})
+	}
+}
+
+// Re-serve a stored receipt
+func GetReceipt(receipts store.ReceiptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stored, err := receipts.Get(r.Context(), mux.Vars(r)["id"])
+		if errors.Is(err, store.ErrReceiptNotFound) {
+			writeJSONError(w, http.StatusNotFound, "receipt not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, signedReceipt(stored))
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Receipt signer with the key kid, its seed made of one repeated byte, and
+// the public halves of retired keys
+func newTestSigner(t *testing.T, kid string, seed byte, retired ...string) *ReceiptSigner {
+	t.Helper()
+	t.Setenv("RECEIPT_SIGNING_KEY", kid+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{seed}, ed25519.SeedSize)))
+	t.Setenv("RECEIPT_VERIFY_KEYS", strings.Join(retired, ","))
+	signer, err := NewReceiptSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// Public half of a signer's active key, as RECEIPT_VERIFY_KEYS lists it
+func verifyKey(signer *ReceiptSigner) string {
+	return signer.keyId + ":" + base64.StdEncoding.EncodeToString(signer.PublicKeys()[signer.keyId])
+}
+
+func TestReceiptSignAndVerify(t *testing.T) {
+	signer := newTestSigner(t, "2026-01", 1)
+	receipts := store.NewMemory().Receipts()
+	signed, err := signer.Issue(context.Background(), receipts, DeletionReceipt{UserId: 7, Operation: "hard_delete", Actor: "admin", Tables: map[string]int64{"users": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyReceipt(signed, signer.PublicKeys()); err != nil {
+		t.Fatalf("VerifyReceipt = %v, want the issued receipt to verify", err)
+	}
+
+	var receipt DeletionReceipt
+	if err := json.Unmarshal(signed.Receipt, &receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Id == "" || receipt.CompletedAt.IsZero() || receipt.UserId != 7 || signed.KeyId != "2026-01" {
+		t.Fatalf("receipt = %+v with key %s", receipt, signed.KeyId)
+	}
+	// Stored exactly as signed, so it verifies when it is served again
+	stored, err := receipts.Get(context.Background(), receipt.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyReceipt(signedReceipt(stored), signer.PublicKeys()); err != nil {
+		t.Fatalf("stored receipt does not verify: %v", err)
+	}
+}
+
+func TestReceiptChangesFailVerification(t *testing.T) {
+	signer := newTestSigner(t, "2026-01", 1)
+	signed, err := signer.Issue(context.Background(), store.NewMemory().Receipts(), DeletionReceipt{UserId: 7, Operation: "delete", Actor: "user:7", Tables: map[string]int64{"users": 1, "audit_log": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(signed.Receipt, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := map[string]string{
+		"id":           `"00000000000000000000000000000000"`,
+		"user_id":      `"8"`,
+		"operation":    `"hard_delete"`,
+		"completed_at": `"2020-01-01T00:00:00Z"`,
+		"actor":        `"admin"`,
+		"tables":       `{"users":0,"audit_log":2}`,
+	}
+	if len(changed) != len(fields) {
+		t.Fatalf("receipt has the fields %v, the test changes %d", fields, len(changed))
+	}
+	for field, value := range changed {
+		t.Run(field, func(t *testing.T) {
+			copied := map[string]json.RawMessage{}
+			for k, v := range fields {
+				copied[k] = v
+			}
+			copied[field] = json.RawMessage(value)
+			body, err := json.Marshal(copied)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forged := &SignedReceipt{Receipt: body, KeyId: signed.KeyId, Signature: signed.Signature}
+			if err := VerifyReceipt(forged, signer.PublicKeys()); err == nil {
+				t.Fatalf("receipt with %s changed to %s verified", field, value)
+			}
+		})
+	}
+
+	// Spacing counts too, the signature covers the exact bytes
+	spaced := &SignedReceipt{Receipt: append([]byte(" "), signed.Receipt...), KeyId: signed.KeyId, Signature: signed.Signature}
+	if err := VerifyReceipt(spaced, signer.PublicKeys()); err == nil {
+		t.Fatal("receipt with a leading space verified")
+	}
+	signature, _ := base64.StdEncoding.DecodeString(signed.Signature)
+	signature[0] ^= 1
+	flipped := &SignedReceipt{Receipt: signed.Receipt, KeyId: signed.KeyId, Signature: base64.StdEncoding.EncodeToString(signature)}
+	if err := VerifyReceipt(flipped, signer.PublicKeys()); err == nil {
+		t.Fatal("receipt with a changed signature verified")
+	}
+	if err := VerifyReceipt(&SignedReceipt{Receipt: signed.Receipt, KeyId: "other", Signature: signed.Signature}, signer.PublicKeys()); err == nil {
+		t.Fatal("receipt of an unknown key verified")
+	}
+}
+
+func TestReceiptsVerifyAfterKeyRotation(t *testing.T) {
+	old := newTestSigner(t, "2025-01", 1)
+	receipts := store.NewMemory().Receipts()
+	signed, err := old.Issue(context.Background(), receipts, DeletionReceipt{UserId: 7, Operation: "delete", Actor: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newTestSigner(t, "2026-01", 2, verifyKey(old))
+	if err := VerifyReceipt(signed, rotated.PublicKeys()); err != nil {
+		t.Fatalf("receipt of the retired key = %v, want it to verify", err)
+	}
+	fresh, err := rotated.Issue(context.Background(), receipts, DeletionReceipt{UserId: 8, Operation: "delete", Actor: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.KeyId != "2026-01" {
+		t.Fatalf("new receipt signed with %s, want the active key", fresh.KeyId)
+	}
+	if err := VerifyReceipt(fresh, rotated.PublicKeys()); err != nil {
+		t.Fatal(err)
+	}
+	// A key dropped from RECEIPT_VERIFY_KEYS no longer vouches for its receipts
+	if err := VerifyReceipt(signed, newTestSigner(t, "2026-01", 2).PublicKeys()); err == nil {
+		t.Fatal("receipt of a key that is no longer listed verified")
+	}
+
+	// Keys of the wrong size or listed twice are refused at startup
+	t.Setenv("RECEIPT_VERIFY_KEYS", "2025-01:"+base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := NewReceiptSigner(); err == nil {
+		t.Fatal("short verify key accepted")
+	}
+	t.Setenv("RECEIPT_VERIFY_KEYS", verifyKey(rotated))
+	if _, err := NewReceiptSigner(); err == nil {
+		t.Fatal("verify key with the id of the active key accepted")
+	}
+}
+
+func TestRepeatedDeleteReturnsTheFirstReceipt(t *testing.T) {
+	signer := newTestSigner(t, "2026-01", 1)
+	mem := store.NewMemory()
+	s := newTestServerWith(t, mem, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}", DeleteUser(mem, mem.Receipts(), NewResponseCache(time.Minute, 0, 100), nil, NewEventHub(), &GrowthGuard{Table: "users"}, signer, nil)).Methods("DELETE")
+	router.NotFoundHandler = s.handler
+	s.handler = router
+	user := s.createUser(t, "Ada", "ada@example.com")
+
+	first := s.do(t, "DELETE", "/users/"+user.Id.String(), "")
+	if first.Code != http.StatusOK {
+		t.Fatalf("delete = %d %s, want 200 with the receipt", first.Code, first.Body)
+	}
+	receipt := decode[SignedReceipt](t, first)
+	if err := VerifyReceipt(&receipt, signer.PublicKeys()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		again := s.do(t, "DELETE", "/users/"+user.Id.String(), "")
+		if again.Code != http.StatusOK || again.Body.String() != first.Body.String() {
+			t.Fatalf("repeated delete = %d %s, want the first receipt %s", again.Code, again.Body, first.Body)
+		}
+	}
+	if w := s.do(t, "DELETE", "/users/999", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("delete of a user never deleted = %d, want 404", w.Code)
+	}
+}
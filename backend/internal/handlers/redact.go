@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Placeholder written in place of redacted values
+const redactedValue = "[REDACTED]"
+
+// Column holding personal data
+type PIIColumn struct {
+	Table  string
+	Column string
+	Kind   string
+}
+
+// Headers and columns that must not leave the service in clear text
+type RedactionRegistry struct {
+	mu      sync.RWMutex
+	headers map[string]bool
+	columns []PIIColumn
+}
+
+// Redactions applied to logs, error reports and exports
+var Redactions = &RedactionRegistry{
+	headers: map[string]bool{
+		"Authorization": true,
+		"Cookie":        true,
+		"Set-Cookie":    true,
+		"X-Api-Key":     true,
+	},
+	columns: []PIIColumn{
+		{Table: "users", Column: "name", Kind: "name"},
+		{Table: "users", Column: "email", Kind: "email"},
+	},
+}
+
+// Mark headers as sensitive
+func (reg *RedactionRegistry) AddHeaders(names ...string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, name := range names {
+		reg.headers[http.CanonicalHeaderKey(name)] = true
+	}
+}
+
+// Mark a column as holding personal data
+func (reg *RedactionRegistry) AddColumn(col PIIColumn) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.columns = append(reg.columns, col)
+}
+
+// Columns holding personal data
+func (reg *RedactionRegistry) Columns() []PIIColumn {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return append([]PIIColumn(nil), reg.columns...)
+}
+
+// Copy headers with sensitive values replaced
+func (reg *RedactionRegistry) Headers(h http.Header) map[string]string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	out := make(map[string]string, len(h))
+	for name := range h {
+		if reg.headers[http.CanonicalHeaderKey(name)] {
+			out[name] = redactedValue
+		} else {
+			out[name] = h.Get(name)
+		}
+	}
+	return out
+}
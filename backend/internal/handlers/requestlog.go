@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type requestIDKey struct{}
+
+// Request id of the request a context belongs to, empty outside requests
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Slog handler adding the request id of the context to every record
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// Log JSON to out at LOG_LEVEL (debug, info, warn or error)
+// The standard log package goes through the same handler at info level.
+func SetupLogger(out io.Writer) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	handler := slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+// Log a line about a request, tagged with its request id
+func logRequest(r *http.Request, level slog.Level, format string, args ...interface{}) {
+	slog.Log(r.Context(), level, fmt.Sprintf(format, args...))
+}
+
+// Whether a client supplied request id is safe to reuse
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Writer recording the status and size of a response
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (l *loggingResponseWriter) WriteHeader(status int) {
+	if l.status == 0 {
+		l.status = status
+	}
+	l.ResponseWriter.WriteHeader(status)
+}
+
+func (l *loggingResponseWriter) Write(b []byte) (int, error) {
+	if l.status == 0 {
+		l.status = http.StatusOK
+	}
+	n, err := l.ResponseWriter.Write(b)
+	l.bytes += int64(n)
+	return n, err
+}
+
+func (l *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+func (l *loggingResponseWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Request logging middleware
+// Gives every request an X-Request-ID, reusing the client's when it sends a
+// sane one, and logs one line per request. Paths in LOG_EXCLUDE_PATHS (the
+// health checks by default) are not logged.
+func RequestLogger(next http.Handler) http.Handler {
+	excluded := map[string]bool{}
+	exclude := os.Getenv("LOG_EXCLUDE_PATHS")
+	if exclude == "" {
+		exclude = "/healthz,/livez,/readyz"
+	}
+	for _, p := range strings.Split(exclude, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			excluded[p] = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		defer func() {
+			if excluded[r.URL.Path] {
+				return
+			}
+			status := lw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case status >= 400:
+				level = slog.LevelWarn
+			}
+			slog.LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int64("bytes", lw.bytes),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote_ip", clientIP(r)),
+			)
+		}()
+		next.ServeHTTP(lw, r)
+	})
+}
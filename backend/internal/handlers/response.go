@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Response wrapper that makes sure a response carries at most one body
+type guardedResponseWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	status      int
+	wroteHeader bool
+	committed   bool
+	// The error behind the response went to the error reporter already
+	reported bool
+}
+
+// Route template a request matched, or its path when it matched none
+func routeName(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// Log a write that was dropped to keep the response intact
+func (g *guardedResponseWriter) reject(what string) {
+	logRequest(g.r, slog.LevelError, "Dropped %s on %s %s after the response was committed\n%s", what, g.r.Method, routeName(g.r), debug.Stack())
+}
+
+func (g *guardedResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		g.reject("second WriteHeader(" + strconv.Itoa(status) + ")")
+		return
+	}
+	g.wroteHeader = true
+	g.status = status
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *guardedResponseWriter) Write(b []byte) (int, error) {
+	if g.committed {
+		g.reject("second response body")
+		return len(b), nil
+	}
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(g.status) {
+		g.reject("body on a " + strconv.Itoa(g.status) + " response")
+		return len(b), nil
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+func (g *guardedResponseWriter) Flush() {
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *guardedResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Whether a status code may carry a body
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified && status >= 200
+}
+
+// Find the guard under any writers wrapping it
+func findGuard(w http.ResponseWriter) *guardedResponseWriter {
+	for {
+		switch v := w.(type) {
+		case *guardedResponseWriter:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// Writer that knows its request, for errors written outside the router
+// Startup and fault injection answer before the response guard runs.
+func guardedFor(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if findGuard(w) != nil {
+		return w
+	}
+	return &guardedResponseWriter{ResponseWriter: w, r: r}
+}
+
+// Response guard middleware
+func ResponseGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&guardedResponseWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// Write a complete JSON response with a matching Content-Length
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSONBody(w, status, append(body, '\n'))
+}
+
+// Body of every error response, under "error"
+// Code is stable for clients to branch on, the message is for people.
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Every error response, wrapping its APIError
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// Error response in the RFC 7807 format, served as application/problem+json
+// Code and Details carry the same as in an APIError.
+type ProblemDetails struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      string      `json:"code"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestId string      `json:"request_id,omitempty"`
+}
+
+// Serve every error as problem details, set from ERROR_FORMAT=problem
+// Otherwise only clients that accept application/problem+json get them.
+var ProblemErrors bool
+
+// Whether an error response should be problem details
+func wantsProblem(r *http.Request) bool {
+	if ProblemErrors {
+		return true
+	}
+	return r != nil && strings.Contains(r.Header.Get("Accept"), "application/problem+json")
+}
+
+// Write an error as {"error": {"code": code, "message": msg}}
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeErrorDetails(w, status, code, msg, nil)
+}
+
+// Write an error with details, like the fields or rows that were rejected
+// Problem details and error reports need the request, which only the
+// response guard knows, so errors written outside of one only follow
+// ERROR_FORMAT and are not reported.
+func writeErrorDetails(w http.ResponseWriter, status int, code, msg string, details interface{}) {
+	var r *http.Request
+	if g := findGuard(w); g != nil {
+		r = g.r
+		if status >= 500 && !g.reported {
+			g.reported = true
+			reportError(r, status, fmt.Errorf("%s: %s", code, msg))
+		}
+	}
+	if r != nil && !ProblemErrors {
+		w.Header().Add("Vary", "Accept")
+	}
+	if !wantsProblem(r) {
+		writeJSON(w, status, ErrorResponse{Error: APIError{Code: code, Message: msg, Details: details}})
+		return
+	}
+
+	problem := ProblemDetails{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: msg, Code: code, Details: details}
+	if r != nil {
+		problem.Instance = r.URL.Path
+		problem.RequestId = requestID(r.Context())
+	}
+	body, err := json.Marshal(problem)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, status, "application/problem+json", append(body, '\n'))
+}
+
+// Write an error with the code of its status, like not_found for a 404
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeError(w, status, statusCode(status), msg)
+}
+
+// Error code of a status, its status text in snake case
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// Write an already encoded JSON body
+func writeJSONBody(w http.ResponseWriter, status int, body []byte) {
+	writeBody(w, status, "application/json", body)
+}
+
+// Write an already encoded body of a JSON media type
+func writeBody(w http.ResponseWriter, status int, contentType string, body []byte) {
+	g := findGuard(w)
+	if g != nil && (g.committed || g.wroteHeader) {
+		g.reject("second JSON document")
+		return
+	}
+
+	if !bodyAllowed(status) {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+
+	if g != nil {
+		g.committed = true
+	}
+}
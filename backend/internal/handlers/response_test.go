@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Number of JSON documents in a body, failing on anything that is not JSON
+func countDocuments(t *testing.T, body []byte) int {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(body))
+	n := 0
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return n
+			}
+			t.Fatalf("body %q is not a sequence of JSON documents: %v", body, err)
+		}
+		n++
+	}
+}
+
+func TestResponseGuardKeepsOneDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"second writeJSON", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]int{"id": 1})
+			writeJSON(w, http.StatusOK, map[string]int{"id": 2})
+		}, http.StatusOK},
+		{"error after a body", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusCreated, map[string]int{"id": 1})
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}, http.StatusCreated},
+		{"error after the header", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}, http.StatusAccepted},
+		{"panic before writing", func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}, http.StatusInternalServerError},
+		{"panic after writeJSON", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]int{"id": 1})
+			panic("boom")
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ResponseGuard(Recover(tt.handler))
+			rec := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if p := recover(); p != nil && p != http.ErrAbortHandler {
+						t.Fatalf("unexpected panic: %v", p)
+					}
+				}()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			}()
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			body := rec.Body.Bytes()
+			if len(body) == 0 {
+				return
+			}
+			if n := countDocuments(t, body); n != 1 {
+				t.Errorf("body %q has %d JSON documents, want 1", body, n)
+			}
+			if length := rec.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length = %s for a %d byte body", length, len(body))
+			}
+		})
+	}
+}
+
+func TestRecoverAbortsPartialResponses(t *testing.T) {
+	handler := ResponseGuard(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1`))
+		panic("boom")
+	})))
+
+	rec := httptest.NewRecorder()
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler so the connection is dropped", p)
+		}
+		if got := rec.Body.String(); got != `{"id":1` {
+			t.Errorf("body = %q, want only what the handler wrote", got)
+		}
+	}()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+}
+
+func TestNoBodyStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ResponseGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, status, map[string]int{"id": 1})
+			})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			if rec.Code != status {
+				t.Errorf("status = %d, want %d", rec.Code, status)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("body = %q, want none", rec.Body.String())
+			}
+			if length := rec.Header().Get("Content-Length"); length != "" && length != "0" {
+				t.Errorf("Content-Length = %s on a %d", length, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// What the retention sweep does to stale users
+const (
+	RetentionAnonymize = "anonymize"
+	RetentionDelete    = "delete"
+)
+
+// Actor of the changes the sweep records in the audit log
+const retentionActor = "retention"
+
+// One retention sweep
+type RetentionRun struct {
+	Trigger      string    `json:"trigger"` // schedule or admin
+	Mode         string    `json:"mode"`
+	Cutoff       time.Time `json:"cutoff"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	RowsAffected int64     `json:"rows_affected"`
+	Batches      int       `json:"batches"`
+	Skipped      bool      `json:"skipped,omitempty"` // another replica was sweeping
+	Error        string    `json:"error,omitempty"`
+}
+
+// Settings of the sweep and how the last one went
+type RetentionStatus struct {
+	Enabled   bool          `json:"enabled"`
+	Mode      string        `json:"mode"`
+	Days      int           `json:"days"`
+	BatchSize int           `json:"batch_size"`
+	Interval  string        `json:"interval"`
+	Running   bool          `json:"running"`
+	LastRun   *RetentionRun `json:"last_run,omitempty"`
+}
+
+// Anonymizes or deletes users nobody has changed for Days
+// Sweeps run every Interval and on demand, one at a time in this process,
+// and across replicas thanks to an advisory lock. Each batch of BatchSize
+// users is changed and audited in one transaction.
+type Retention struct {
+	Days      int // 0 turns the sweep off
+	Mode      string
+	BatchSize int
+	Interval  time.Duration
+
+	db        *sql.DB
+	txs       store.Transactor
+	uploads   store.Storage
+	cache     *ResponseCache
+	userCache *UserCache
+	events    *EventHub
+	growth    *GrowthGuard
+
+	ctx     context.Context // of the worker, on demand sweeps stop with it too
+	running sync.Mutex
+	mu      sync.Mutex
+	busy    bool
+	last    *RetentionRun
+}
+
+// Sweep from RETENTION_DAYS, RETENTION_MODE, RETENTION_BATCH_SIZE and
+// RETENTION_INTERVAL
+// It is off until RETENTION_DAYS is set.
+func NewRetention(db *sql.DB, txs store.Transactor, uploads store.Storage, cache *ResponseCache, userCache *UserCache, events *EventHub, growth *GrowthGuard) (*Retention, error) {
+	rt := &Retention{
+		Days:      max(env.Int("RETENTION_DAYS", 0), 0),
+		Mode:      RetentionAnonymize,
+		BatchSize: max(env.Int("RETENTION_BATCH_SIZE", 500), 1),
+		Interval:  env.Duration("RETENTION_INTERVAL", 24*time.Hour),
+		db:        db,
+		txs:       txs,
+		uploads:   uploads,
+		cache:     cache,
+		userCache: userCache,
+		events:    events,
+		growth:    growth,
+		ctx:       context.Background(),
+	}
+	if mode := os.Getenv("RETENTION_MODE"); mode != "" {
+		rt.Mode = mode
+	}
+	if rt.Mode != RetentionAnonymize && rt.Mode != RetentionDelete {
+		return nil, fmt.Errorf("RETENTION_MODE must be %s or %s, not %q", RetentionAnonymize, RetentionDelete, rt.Mode)
+	}
+	if rt.Interval <= 0 {
+		return nil, fmt.Errorf("RETENTION_INTERVAL must be positive")
+	}
+	return rt, nil
+}
+
+// Whether RETENTION_DAYS is set
+func (rt *Retention) Enabled() bool {
+	return rt.Days > 0
+}
+
+// Sweep every Interval until ctx is done
+// A sweep in progress when ctx is cancelled rolls back its current batch.
+func (rt *Retention) Start(ctx context.Context) {
+	rt.ctx = ctx
+	if !rt.Enabled() {
+		log.Println("Retention sweep is off, set RETENTION_DAYS to turn it on")
+		return
+	}
+	log.Printf("Retention sweep will %s users unchanged for %d days, every %s", rt.Mode, rt.Days, rt.Interval)
+	go func() {
+		ticker := time.NewTicker(rt.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !rt.Trigger("schedule") {
+					log.Println("Skipping the scheduled retention sweep, one is still running")
+				}
+			}
+		}
+	}()
+}
+
+// Start a sweep in the background, false when one is already running
+func (rt *Retention) Trigger(trigger string) bool {
+	if !rt.running.TryLock() {
+		return false
+	}
+	rt.mu.Lock()
+	rt.busy = true
+	rt.mu.Unlock()
+
+	go func() {
+		defer rt.running.Unlock()
+		run := rt.sweep(rt.ctx, trigger)
+
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		rt.busy = false
+		rt.last = &run
+	}()
+	return true
+}
+
+// Settings and the last sweep
+func (rt *Retention) Status() RetentionStatus {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return RetentionStatus{
+		Enabled:   rt.Enabled(),
+		Mode:      rt.Mode,
+		Days:      rt.Days,
+		BatchSize: rt.BatchSize,
+		Interval:  rt.Interval.String(),
+		Running:   rt.busy,
+		LastRun:   rt.last,
+	}
+}
+
+// Process batches until there are no stale users left
+func (rt *Retention) sweep(ctx context.Context, trigger string) RetentionRun {
+	run := RetentionRun{
+		Trigger:   trigger,
+		Mode:      rt.Mode,
+		Cutoff:    time.Now().UTC().AddDate(0, 0, -rt.Days),
+		StartedAt: time.Now().UTC(),
+	}
+	sweepAll := func() error {
+		for ctx.Err() == nil {
+			n, err := rt.sweepBatch(ctx, run.Cutoff)
+			if n > 0 {
+				run.RowsAffected += int64(n)
+				run.Batches++
+			}
+			if err != nil || n < rt.BatchSize {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+	// Without a database there are no replicas to take turns with
+	locked, err := true, error(nil)
+	if rt.db != nil {
+		locked, err = store.WithRetentionLock(ctx, rt.db, sweepAll)
+	} else {
+		err = sweepAll()
+	}
+	run.Skipped = !locked && err == nil
+	run.FinishedAt = time.Now().UTC()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	took := run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond)
+	switch {
+	case err != nil:
+		log.Printf("Retention sweep (%s) failed after %s and %d users: %v", trigger, took, run.RowsAffected, err)
+	case run.Skipped:
+		log.Printf("Retention sweep (%s) skipped, another replica holds the lock", trigger)
+	default:
+		log.Printf("Retention sweep (%s): %s %d users unchanged since %s in %d batches, %s", trigger, rt.Mode, run.RowsAffected, run.Cutoff.Format(time.DateOnly), run.Batches, took)
+	}
+	return run
+}
+
+// Anonymize or delete one batch of stale users, returning how many
+func (rt *Retention) sweepBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	var stale, changed []models.User
+	var ids []models.ID
+	action := "anonymize"
+	err := rt.txs.RunInTx(ctx, func(tx store.Tx) error {
+		var err error
+		stale, err = tx.Users.LockStale(ctx, cutoff, rt.BatchSize)
+		if err != nil || len(stale) == 0 {
+			return err
+		}
+		ids = make([]models.ID, len(stale))
+		for i, user := range stale {
+			ids[i] = user.Id
+		}
+
+		// Entries keep no values, a copy of the old name and email in the
+		// audit log is exactly what the sweep is removing
+		entries := make([]models.AuditEntry, len(stale))
+		if rt.Mode == RetentionAnonymize {
+			if changed, err = tx.Users.Anonymize(ctx, ids); err != nil {
+				return err
+			}
+		} else {
+			action = "hard_delete"
+			for _, id := range ids {
+				if _, err := tx.Users.HardDelete(ctx, id); err != nil {
+					return err
+				}
+			}
+		}
+		for i, id := range ids {
+			entries[i] = models.AuditEntry{Entity: "users", EntityId: id, Action: action, Actor: retentionActor}
+		}
+		if err := tx.Audit.ForgetValues(ctx, "users", ids); err != nil {
+			return err
+		}
+		return tx.Audit.Record(ctx, entries...)
+	})
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+
+	// Side effects run even if the sweep is being stopped
+	sideCtx := context.WithoutCancel(ctx)
+	countUserOperation(action, len(stale))
+	for _, user := range stale {
+		removeAvatar(sideCtx, rt.uploads, user.Id, user.Avatar)
+	}
+	rt.cache.Invalidate(sideCtx, "users:")
+	rt.userCache.Invalidate(sideCtx, ids...)
+	if rt.Mode == RetentionAnonymize {
+		rt.events.Publish(EventUserUpdated, changed...)
+	} else {
+		rt.growth.Added(-int64(len(stale)))
+		rt.events.Publish(EventUserDeleted, stale...)
+	}
+	return len(stale), nil
+}
+
+// Start a retention sweep now
+// Answers 202 with the status, the sweep runs in the background.
+func RunRetention(rt *Retention) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rt.Enabled() {
+			writeError(w, http.StatusConflict, "retention_disabled", "retention is off, set RETENTION_DAYS to turn it on")
+			return
+		}
+		if !rt.Trigger("admin") {
+			writeError(w, http.StatusConflict, "retention_running", "a retention sweep is already running")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, rt.Status())
+	}
+}
+
+// Report the retention settings and the last sweep
+func GetRetentionStatus(rt *Retention) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, rt.Status())
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Users last changed before and within a 30 day retention window
+type retentionSeed struct {
+	old, recent []models.User
+}
+
+// Memory store with old and recent users, some of each soft-deleted
+// Rows are read back with Lock, which sees soft-deleted users too.
+func seedRetention(t *testing.T) (*store.Memory, retentionSeed) {
+	t.Helper()
+	ctx := context.Background()
+	mem := store.NewMemory()
+	var seed retentionSeed
+	create := func(name string, deleted bool) models.User {
+		user, err := mem.Create(ctx, models.User{Name: name, Email: name + "@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted {
+			if _, err := mem.Delete(ctx, user.Id); err != nil {
+				t.Fatal(err)
+			}
+			user, _ = mem.Lock(ctx, user.Id)
+		}
+		return user
+	}
+
+	mem.Now = func() time.Time { return time.Now().AddDate(0, 0, -90) }
+	for i := 0; i < 5; i++ {
+		seed.old = append(seed.old, create(fmt.Sprintf("old%d", i), i%2 == 0))
+	}
+	mem.Now = time.Now
+	for i := 0; i < 3; i++ {
+		seed.recent = append(seed.recent, create(fmt.Sprintf("recent%d", i), i == 0))
+	}
+	// Created long ago but changed since, so it is not stale
+	touched := create("touched", false)
+	mem.Now = func() time.Time { return time.Now().AddDate(0, 0, -29) }
+	if touched, _ = mem.Update(ctx, touched.Id, models.User{Name: "touched again", Email: touched.Email}, 0); touched.Id == 0 {
+		t.Fatal("updating the touched user failed")
+	}
+	mem.Now = time.Now
+	seed.recent = append(seed.recent, touched)
+	return mem, seed
+}
+
+// Retention sweep of 30 days over txs, in batches of two
+func newTestRetention(t *testing.T, txs store.Transactor, mode string) *Retention {
+	t.Helper()
+	t.Setenv("RETENTION_DAYS", "30")
+	t.Setenv("RETENTION_MODE", mode)
+	t.Setenv("RETENTION_BATCH_SIZE", "2")
+	uploads, err := store.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := NewEventHub()
+	t.Cleanup(events.Close)
+	rt, err := NewRetention(nil, txs, uploads, NewResponseCache(time.Minute, 0, 100), NewUserCache(), events, &GrowthGuard{Table: "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+// Trigger a sweep and wait for it to finish
+func runRetention(t *testing.T, rt *Retention) RetentionRun {
+	t.Helper()
+	previous := rt.Status().LastRun
+	if !rt.Trigger("admin") {
+		t.Fatal("Trigger = false, want a sweep started")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := rt.Status(); !status.Running && status.LastRun != previous {
+			return *status.LastRun
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("retention sweep did not finish")
+	return RetentionRun{}
+}
+
+func TestRetentionTouchesOnlyStaleUsers(t *testing.T) {
+	for _, mode := range []string{RetentionAnonymize, RetentionDelete} {
+		t.Run(mode, func(t *testing.T) {
+			ctx := context.Background()
+			mem, seed := seedRetention(t)
+			rt := newTestRetention(t, mem, mode)
+
+			run := runRetention(t, rt)
+			if run.Error != "" || run.RowsAffected != int64(len(seed.old)) || run.Batches != 3 || run.Mode != mode {
+				t.Fatalf("run = %+v, want %d users in 3 batches", run, len(seed.old))
+			}
+
+			for _, before := range seed.old {
+				after, err := mem.Lock(ctx, before.Id)
+				switch mode {
+				case RetentionDelete:
+					if !errors.Is(err, store.ErrNotFound) {
+						t.Fatalf("stale user %s after the sweep = %+v %v, want it gone", before.Name, after, err)
+					}
+				case RetentionAnonymize:
+					if err != nil || after.Name != "Anonymized user" || after.Email != "anonymized-"+before.Id.String()+"@anonymized.invalid" {
+						t.Fatalf("stale user %s after the sweep = %+v %v, want it anonymized", before.Name, after, err)
+					}
+					// Soft-deleted users stay deleted
+					if (before.DeletedAt == nil) != (after.DeletedAt == nil) {
+						t.Fatalf("stale user %s deleted at %v, was %v", before.Name, after.DeletedAt, before.DeletedAt)
+					}
+				}
+			}
+			for _, before := range seed.recent {
+				after, err := mem.Lock(ctx, before.Id)
+				if err != nil || after.Name != before.Name || after.Email != before.Email || after.Version != before.Version || (after.DeletedAt == nil) != (before.DeletedAt == nil) {
+					t.Fatalf("recent user %s after the sweep = %+v %v, want it untouched", before.Name, after, err)
+				}
+			}
+
+			entries, _, err := mem.Audit().List(ctx, store.AuditListOptions{Actor: retentionActor, Limit: 100})
+			if err != nil || len(entries) != len(seed.old) {
+				t.Fatalf("%d audit entries by the sweep (%v), want %d", len(entries), err, len(seed.old))
+			}
+
+			// Anonymized users are not stale anymore
+			if again := runRetention(t, rt); again.RowsAffected != 0 || again.Error != "" {
+				t.Fatalf("second run = %+v, want nothing left to do", again)
+			}
+		})
+	}
+}
+
+// Transactor holding every transaction until released
+type heldTx struct {
+	*store.Memory
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *heldTx) RunInTx(ctx context.Context, fn func(tx store.Tx) error) error {
+	select {
+	case h.started <- struct{}{}:
+	default:
+	}
+	<-h.release
+	return h.Memory.RunInTx(ctx, fn)
+}
+
+func TestRetentionRunsOneSweepAtATime(t *testing.T) {
+	mem, seed := seedRetention(t)
+	held := &heldTx{Memory: mem, started: make(chan struct{}, 1), release: make(chan struct{})}
+	rt := newTestRetention(t, held, RetentionAnonymize)
+
+	if !rt.Trigger("admin") {
+		t.Fatal("first Trigger = false")
+	}
+	<-held.started
+	if !rt.Status().Running {
+		t.Fatal("status does not report the running sweep")
+	}
+	if rt.Trigger("schedule") {
+		t.Fatal("second Trigger = true while a sweep is running")
+	}
+	close(held.release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for rt.Status().Running && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if last := rt.Status().LastRun; last == nil || last.RowsAffected != int64(len(seed.old)) || last.Trigger != "admin" {
+		t.Fatalf("last run = %+v, want the first sweep", last)
+	}
+}
+
+func TestRetentionStopsWithItsContext(t *testing.T) {
+	mem, seed := seedRetention(t)
+	rt := newTestRetention(t, mem, RetentionDelete)
+	ctx, cancel := context.WithCancel(context.Background())
+	rt.Start(ctx)
+	cancel()
+
+	if run := runRetention(t, rt); run.Error == "" || run.RowsAffected != 0 {
+		t.Fatalf("run after shutdown = %+v, want it stopped", run)
+	}
+	if _, err := mem.Lock(context.Background(), seed.old[0].Id); err != nil {
+		t.Fatalf("stale user after a stopped sweep: %v, want it kept", err)
+	}
+}
+
+func TestRetentionSettings(t *testing.T) {
+	t.Setenv("RETENTION_DAYS", "")
+	t.Setenv("RETENTION_MODE", "")
+	rt, err := NewRetention(nil, store.NewMemory(), nil, nil, nil, nil, nil)
+	if err != nil || rt.Enabled() {
+		t.Fatalf("retention without RETENTION_DAYS = %+v %v, want it off", rt, err)
+	}
+	t.Setenv("RETENTION_MODE", "shred")
+	if _, err := NewRetention(nil, store.NewMemory(), nil, nil, nil, nil, nil); err == nil {
+		t.Fatal("unknown RETENTION_MODE accepted")
+	}
+}
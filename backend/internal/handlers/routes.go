@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Middleware with the name it is listed under
+type NamedMiddleware struct {
+	Name string
+	Wrap func(http.Handler) http.Handler
+}
+
+// Name a middleware for the routes listing
+func Mw(name string, wrap func(http.Handler) http.Handler) NamedMiddleware {
+	return NamedMiddleware{Name: name, Wrap: wrap}
+}
+
+// A registered route and the middleware it runs through, outermost first
+type RouteInfo struct {
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Middleware []string `json:"middleware"`
+}
+
+// Registers routes on a router while recording which middleware wraps each one
+type RouteTable struct {
+	router *mux.Router
+	// Set on the table of a path prefix, which lists its routes in root
+	root   *RouteTable
+	prefix string
+	group  []NamedMiddleware
+
+	mu     sync.Mutex
+	outer  []string
+	global []string
+	routes []RouteInfo
+}
+
+// Route table for a router
+func NewRouteTable(router *mux.Router) *RouteTable {
+	return &RouteTable{router: router}
+}
+
+// Record middleware that wraps the whole router from outside, outermost first
+func (t *RouteTable) Outer(names ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.outer = append(t.outer, names...)
+}
+
+// Add router middleware, run for every matched route in the order added
+func (t *RouteTable) Use(mws ...NamedMiddleware) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, mw := range mws {
+		t.router.Use(mux.MiddlewareFunc(mw.Wrap))
+		t.global = append(t.global, mw.Name)
+	}
+}
+
+// Route table for the routes under a path prefix, on a subrouter
+// Its routes run behind mws, before their own middleware, and are listed
+// with their full path.
+func (t *RouteTable) Prefix(prefix string, mws ...NamedMiddleware) *RouteTable {
+	root := t
+	if t.root != nil {
+		root = t.root
+	}
+	return &RouteTable{
+		router: t.router.PathPrefix(prefix).Subrouter(),
+		root:   root,
+		prefix: t.prefix + prefix,
+		group:  append(append([]NamedMiddleware{}, t.group...), mws...),
+	}
+}
+
+// Register a handler wrapped in route middleware, the first one outermost
+// An empty method matches every method.
+func (t *RouteTable) Handle(method, path string, handler http.Handler, mws ...NamedMiddleware) *mux.Route {
+	mws = append(append([]NamedMiddleware{}, t.group...), mws...)
+	names := []string{}
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i].Wrap(handler)
+	}
+	for _, mw := range mws {
+		names = append(names, mw.Name)
+	}
+
+	route := t.router.Handle(path, handler)
+	if method != "" {
+		route.Methods(method)
+	} else {
+		method = "*"
+	}
+
+	table := t
+	if t.root != nil {
+		table = t.root
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.routes = append(table.routes, RouteInfo{Method: method, Path: t.prefix + path, Middleware: names})
+	return route
+}
+
+// Register a handler function, see Handle
+func (t *RouteTable) HandleFunc(method, path string, handler http.HandlerFunc, mws ...NamedMiddleware) *mux.Route {
+	return t.Handle(method, path, handler, mws...)
+}
+
+// Every route with its full middleware stack
+func (t *RouteTable) Routes() []RouteInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	routes := make([]RouteInfo, 0, len(t.routes))
+	for _, route := range t.routes {
+		stack := append(append(append([]string{}, t.outer...), t.global...), route.Middleware...)
+		routes = append(routes, RouteInfo{Method: route.Method, Path: route.Path, Middleware: stack})
+	}
+	return routes
+}
+
+// Log every route and its middleware stack
+func (t *RouteTable) LogRoutes() {
+	for _, route := range t.Routes() {
+		log.Printf("Route %s %s: %s", route.Method, route.Path, strings.Join(route.Middleware, " > "))
+	}
+}
+
+// List routes with their middleware
+func RoutesHandler(table *RouteTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, table.Routes())
+	}
+}
+
+// Answer paths without a route with a JSON 404
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "no route for "+r.URL.Path)
+}
+
+// Methods the route matching a request's path has, out of GET, POST, PUT,
+// PATCH and DELETE
+func (t *RouteTable) AllowedMethods(r *http.Request) []string {
+	allowed := []string{}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if t.router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// Answer a route called with the wrong method with a JSON 405, listing the
+// methods it does have in Allow
+func (t *RouteTable) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(t.AllowedMethods(r), ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
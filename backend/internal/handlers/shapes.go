@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// One accepted request body shape and its conversion to a User
+// A shape is sniffed when the body has any of its Fields; the shape without
+// Fields is the canonical one used by default.
+type RequestShape struct {
+	Name    string
+	Fields  []string
+	Convert func(body []byte, user *models.User) error
+}
+
+// Request shapes accepted by a group of routes, with usage counts
+type ShapeRegistry struct {
+	prefix string
+	shapes []RequestShape
+
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+// Create a registry, shapes are sniffed in the order given
+func NewShapeRegistry(prefix string, shapes ...RequestShape) *ShapeRegistry {
+	return &ShapeRegistry{prefix: prefix, shapes: shapes, usage: map[string]int64{}}
+}
+
+// Whether a shape was retired through DISABLED_API_SHAPES
+func (s *ShapeRegistry) disabled(shape RequestShape) bool {
+	for _, name := range strings.Split(os.Getenv("DISABLED_API_SHAPES"), ",") {
+		if strings.TrimSpace(name) == s.prefix+"."+shape.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Pick the shape named by X-Api-Shape, or sniff it from the body fields
+func (s *ShapeRegistry) pick(r *http.Request, fields map[string]json.RawMessage) (RequestShape, error) {
+	if name := r.Header.Get("X-Api-Shape"); name != "" {
+		for _, shape := range s.shapes {
+			if shape.Name == name && !s.disabled(shape) {
+				return shape, nil
+			}
+		}
+		return RequestShape{}, fmt.Errorf("unsupported request shape %q", name)
+	}
+
+	var canonical *RequestShape
+	for i, shape := range s.shapes {
+		if s.disabled(shape) {
+			continue
+		}
+		if len(shape.Fields) == 0 {
+			canonical = &s.shapes[i]
+			continue
+		}
+		for _, field := range shape.Fields {
+			if _, ok := fields[field]; ok {
+				return shape, nil
+			}
+		}
+	}
+	if canonical == nil {
+		return RequestShape{}, fmt.Errorf("no request shape matches the body")
+	}
+	return *canonical, nil
+}
+
+// Decode a request body into a User, whichever accepted shape it uses
+// The shape used is echoed in the X-Api-Shape response header.
+func (s *ShapeRegistry) Decode(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	body, err := readJSONBody(r)
+	if err != nil {
+		return err
+	}
+	shape, err := s.convert(r, body, user)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("X-Api-Shape", shape)
+	return nil
+}
+
+// Convert one checked JSON object into a User, returning the shape it used
+func (s *ShapeRegistry) convert(r *http.Request, body []byte, user *models.User) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", &RequestBodyError{Code: "invalid_json", Message: "invalid JSON body"}
+	}
+
+	shape, err := s.pick(r, fields)
+	if err != nil {
+		return "", err
+	}
+	if err := shape.Convert(body, user); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.usage[shape.Name]++
+	s.mu.Unlock()
+	return shape.Name, nil
+}
+
+// Requests decoded per shape, keyed by prefix.shape
+func (s *ShapeRegistry) Usage() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make(map[string]int64, len(s.shapes))
+	for _, shape := range s.shapes {
+		usage[s.prefix+"."+shape.Name] = s.usage[shape.Name]
+	}
+	return usage
+}
+
+// Shapes accepted when creating or updating a user
+var userShapes = NewShapeRegistry("users",
+	RequestShape{
+		Name:   "split_name",
+		Fields: []string{"first_name", "last_name"},
+		Convert: func(body []byte, user *models.User) error {
+			var legacy struct {
+				FirstName string `json:"first_name"`
+				LastName  string `json:"last_name"`
+				Email     string `json:"email"`
+			}
+			if err := unmarshalStrict(body, &legacy); err != nil {
+				return err
+			}
+			user.Name = strings.TrimSpace(legacy.FirstName + " " + legacy.LastName)
+			user.Email = legacy.Email
+			return nil
+		},
+	},
+	RequestShape{
+		Name: "v1",
+		Convert: func(body []byte, user *models.User) error {
+			return unmarshalStrict(body, user)
+		},
+	},
+)
+
+// Report how often each request shape is used
+func ShapeUsageHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, userShapes.Usage())
+}
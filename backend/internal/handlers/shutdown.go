@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Whether the server should receive new traffic
+type Readiness struct {
+	Startup  *Startup
+	DB       *sql.DB
+	draining atomic.Bool
+}
+
+// Start failing readiness so load balancers deregister us
+func (rd *Readiness) StartDrain() {
+	rd.draining.Store(true)
+}
+
+// Readiness probe, failing while draining or when the database is down
+// A database that answers the ping while queries still fail on dropped
+// connections is reported as degraded, the instance stays in rotation.
+func (rd *Readiness) Handler(w http.ResponseWriter, r *http.Request) {
+	if rd.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	start := time.Now()
+	if err := pingDatabase(r.Context(), rd.DB); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "database": "down"})
+		return
+	}
+	status, database := "ready", "up"
+	if store.Reconnecting() {
+		status, database = "degraded", "reconnecting"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":           status,
+		"database":         database,
+		"database_ping_ms": float64(time.Since(start).Microseconds()) / 1000,
+		"startup":          rd.Startup.Phases(),
+	})
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Flush the stream after this many rows
+const snapshotFlushEvery = 500
+
+// Snapshot metadata, sent as the first line of the dump
+// A resumed dump is read in a snapshot of its own, so together with the
+// part before it the copy is not consistent as of one point in time.
+type SnapshotMeta struct {
+	Cursor     string    `json:"cursor"`
+	AfterId    models.ID `json:"after_id"`
+	SnapshotAt time.Time `json:"snapshot_at"`
+	Resumed    bool      `json:"resumed,omitempty"`
+}
+
+// API keys with a snapshot in progress
+var activeSnapshots = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// Mark a snapshot as running for a key, false if one already is
+func startSnapshot(key string) bool {
+	activeSnapshots.Lock()
+	defer activeSnapshots.Unlock()
+
+	if activeSnapshots.keys[key] {
+		return false
+	}
+	activeSnapshots.keys[key] = true
+	return true
+}
+
+// Mark the snapshot for a key as finished
+func finishSnapshot(key string) {
+	activeSnapshots.Lock()
+	defer activeSnapshots.Unlock()
+
+	delete(activeSnapshots.keys, key)
+}
+
+// Stream every user in primary key order as NDJSON
+// One request reads a single repeatable read snapshot. Resuming with after_id
+// reads the remaining rows as they are now, not as of the first snapshot,
+// since that one is gone with the dropped connection: consumers keep the
+// cursor of the first part and replay the change feed from it, skipping
+// changes with a version not above the one of the row they have.
+func SnapshotUsers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var afterId models.ID
+		if v := r.URL.Query().Get("after_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id < 0 {
+				writeJSONError(w, http.StatusBadRequest, "after_id must be a non-negative integer")
+				return
+			}
+			afterId = models.ID(id)
+		}
+
+		key := requestAPIKey(r)
+		if !startSnapshot(key) {
+			w.Header().Set("Retry-After", "30")
+			writeJSONError(w, http.StatusTooManyRequests, "a snapshot is already running for this API key")
+			return
+		}
+		defer finishSnapshot(key)
+
+		// Repeatable read keeps every row of the dump in the same snapshot
+		tx, err := db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			serverError(w, r, fmt.Errorf("starting snapshot transaction: %w", err))
+			return
+		}
+		defer tx.Rollback()
+
+		meta := SnapshotMeta{AfterId: afterId, Resumed: afterId > 0}
+		err = tx.QueryRowContext(r.Context(), "SELECT txid_current_snapshot()::text, now()").Scan(&meta.Cursor, &meta.SnapshotAt)
+		if err != nil {
+			serverError(w, r, fmt.Errorf("reading snapshot cursor: %w", err))
+			return
+		}
+
+		done := store.TrackQuery(r.Context(), "users.snapshot")
+		rows, err := tx.QueryContext(r.Context(), "SELECT "+store.UserColumns+" FROM users WHERE id > $1 ORDER BY id", afterId)
+		if err != nil {
+			serverError(w, r, fmt.Errorf("querying snapshot rows: %w", err))
+			return
+		}
+		defer rows.Close()
+
+		// A full dump can outlast the server's write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		enc.Encode(meta)
+
+		count := 0
+		defer func() { done(int64(count)) }()
+		for rows.Next() {
+			var user models.User
+			err := store.ScanUser(rows, &user)
+			if err != nil {
+				logRequest(r, slog.LevelError, "Error scanning snapshot row: %v", err)
+				return
+			}
+			if err := enc.Encode(user); err != nil {
+				// The client went away, it can resume with after_id
+				return
+			}
+
+			count++
+			if flusher != nil && count%snapshotFlushEvery == 0 {
+				flusher.Flush()
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			logRequest(r, slog.LevelError, "Error streaming snapshot: %v", err)
+		}
+	}
+}
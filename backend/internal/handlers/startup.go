@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// How long one startup phase took
+type StartupPhase struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Startup phases, and the API handler once they are done
+// With FAST_START the listener is bound before the phases run. Until Serve
+// is called only /healthz and /livez answer normally, /readyz reports the phases so far
+// and everything else gets 503.
+type Startup struct {
+	mu      sync.Mutex
+	phases  []StartupPhase
+	handler atomic.Pointer[http.Handler]
+}
+
+// Whether to bind the listener before initialization
+func FastStart() bool {
+	return env.Bool("FAST_START", false)
+}
+
+// Run a startup phase, logging how long it took
+func (s *Startup) Run(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	phase := StartupPhase{Name: name, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		phase.Error = err.Error()
+		log.Printf("Startup phase %s failed after %dms: %v", name, phase.DurationMs, err)
+	} else {
+		log.Printf("Startup phase %s took %dms", name, phase.DurationMs)
+	}
+
+	s.mu.Lock()
+	s.phases = append(s.phases, phase)
+	s.mu.Unlock()
+	return err
+}
+
+// Run independent phases concurrently, returning the first error
+func (s *Startup) Parallel(phases map[string]func() error) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(phases))
+	for name, fn := range phases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Run(name, fn); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// Phases run so far
+func (s *Startup) Phases() []StartupPhase {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StartupPhase{}, s.phases...)
+}
+
+// Start serving the API
+func (s *Startup) Serve(handler http.Handler) {
+	s.handler.Store(&handler)
+	log.Println("Startup complete, serving requests")
+}
+
+// Handler that answers probes while starting and the API afterwards
+func (s *Startup) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler := s.handler.Load(); handler != nil {
+			(*handler).ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/healthz", "/livez":
+			HealthzHandler(w, r)
+		case "/readyz":
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "starting", "startup": s.Phases()})
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(guardedFor(w, r), http.StatusServiceUnavailable, "starting")
+		}
+	})
+}
+
+// Liveness probe, answering as soon as the listener is bound
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func serveStartup(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+// Startup progress as /readyz reports it
+type startupReport struct {
+	Status  string         `json:"status"`
+	Startup []StartupPhase `json:"startup"`
+}
+
+func TestStartupAnswersProbesUntilServing(t *testing.T) {
+	startup := &Startup{}
+	h := startup.Handler()
+
+	for _, path := range []string{"/healthz", "/livez"} {
+		if w := serveStartup(h, path); w.Code != http.StatusOK {
+			t.Fatalf("%s while starting = %d, want 200", path, w.Code)
+		}
+	}
+	for _, path := range []string{"/", "/api/go/users", "/api/v1/users/1"} {
+		w := serveStartup(h, path)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+			t.Fatalf("%s while starting = %d, Retry-After %q, want 503 with a retry", path, w.Code, w.Header().Get("Retry-After"))
+		}
+		if body := decode[ErrorResponse](t, w); body.Error.Code != "service_unavailable" || body.Error.Message != "starting" {
+			t.Fatalf("%s while starting = %s", path, w.Body)
+		}
+	}
+
+	if err := startup.Run("database", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	w := serveStartup(h, "/readyz")
+	report := decode[startupReport](t, w)
+	if w.Code != http.StatusServiceUnavailable || report.Status != "starting" || len(report.Startup) != 1 || report.Startup[0].Name != "database" {
+		t.Fatalf("/readyz while starting = %d %s", w.Code, w.Body)
+	}
+
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("app " + r.URL.Path)) })
+	startup.Serve(app)
+	for _, path := range []string{"/api/go/users", "/readyz", "/healthz"} {
+		if w := serveStartup(h, path); w.Code != http.StatusOK || w.Body.String() != "app "+path {
+			t.Fatalf("%s once serving = %d %s, want the app", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStartupReportsFailedPhases(t *testing.T) {
+	startup := &Startup{}
+	err := startup.Parallel(map[string]func() error{
+		"schema":     func() error { return errors.New("migration 0014 failed") },
+		"collations": func() error { time.Sleep(time.Millisecond); return nil },
+	})
+	if err == nil || err.Error() != "migration 0014 failed" {
+		t.Fatalf("Parallel = %v, want the failed phase's error", err)
+	}
+
+	report := decode[startupReport](t, serveStartup(startup.Handler(), "/readyz"))
+	phases := map[string]StartupPhase{}
+	for _, phase := range report.Startup {
+		phases[phase.Name] = phase
+	}
+	if len(phases) != 2 || phases["schema"].Error != "migration 0014 failed" || phases["collations"].Error != "" {
+		t.Fatalf("phases = %+v, want schema failed and collations done", report.Startup)
+	}
+	if phases["collations"].DurationMs < 1 {
+		t.Fatalf("collations took %dms, want its duration", phases["collations"].DurationMs)
+	}
+}
+
+func TestFastStart(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "false": false, "true": true, "1": true} {
+		t.Setenv("FAST_START", value)
+		if got := FastStart(); got != want {
+			t.Fatalf("FastStart with FAST_START=%q = %t, want %t", value, got, want)
+		}
+	}
+}
+
+// Requests racing Serve get the 503 or the app, nothing in between
+func TestStartupServeWhileRequestsArrive(t *testing.T) {
+	startup := &Startup{}
+	h := startup.Handler()
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if w := serveStartup(h, "/api/go/users"); w.Code != http.StatusServiceUnavailable && w.Code != http.StatusNoContent {
+					t.Errorf("request during startup = %d", w.Code)
+					return
+				}
+			}
+		}()
+	}
+	startup.Serve(app)
+	wg.Wait()
+	if w := serveStartup(h, "/api/go/users"); w.Code != http.StatusNoContent {
+		t.Fatalf("request after Serve = %d, want the app", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Longest signup window ?days can ask for
+const maxStatsDays = 365
+
+// Most newest users ?newest can ask for
+const maxStatsNewest = 50
+
+// Numbers for the dashboard
+type UserStats struct {
+	Total   int64               `json:"total"`
+	Days    int                 `json:"days"`
+	Signups []models.DailyCount `json:"signups"` // oldest day first, one per day of the window
+	Newest  []models.User       `json:"newest"`
+}
+
+// Read ?days, 30 by default, and ?newest, 5 by default
+func parseStatsParams(query url.Values) (days, newest int, err error) {
+	days, newest = 30, 5
+	if v := query.Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > maxStatsDays {
+			return 0, 0, fmt.Errorf("days must be between 1 and %d", maxStatsDays)
+		}
+	}
+	if v := query.Get("newest"); v != "" {
+		newest, err = strconv.Atoi(v)
+		if err != nil || newest < 0 || newest > maxStatsNewest {
+			return 0, 0, fmt.Errorf("newest must be between 0 and %d", maxStatsNewest)
+		}
+	}
+	return days, newest, nil
+}
+
+// Compute the stats, running the queries concurrently
+// Days without signups are filled in with a count of 0.
+func loadUserStats(ctx context.Context, users store.UserStore, days, newest int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), env.Duration("LIST_QUERY_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+	stats := UserStats{Days: days}
+	var signups []models.DailyCount
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		// The total comes with the page, newest=0 only counts
+		opts := store.ListOptions{Limit: newest, Sort: "created_at", Desc: true}
+		stats.Newest, stats.Total, err = users.List(gctx, opts)
+		return err
+	})
+	g.Go(func() (err error) {
+		signups, err = users.SignupsPerDay(gctx, since)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, day := range signups {
+		counts[day.Date] = day.Count
+	}
+	stats.Signups = make([]models.DailyCount, 0, days)
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		stats.Signups = append(stats.Signups, models.DailyCount{Date: date, Count: counts[date]})
+	}
+	if stats.Newest == nil {
+		stats.Newest = []models.User{}
+	}
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// Total users, signups per day and the newest users, for the dashboard
+// Results are cached for STATS_CACHE_TTL, 5s by default.
+func GetUserStats(users store.UserStore, cache *ResponseCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days, newest, err := parseStatsParams(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		key := fmt.Sprintf("stats:users:days=%d:newest=%d", days, newest)
+		body, age, state, err := cache.Get(key, func() ([]byte, error) {
+			return loadUserStats(r.Context(), users, days, newest)
+		})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("X-Cache", state)
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+		writeJSONBody(w, http.StatusOK, body)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Most ids invalidated one by one, larger batches purge the whole cache
+const maxUserInvalidations = 16
+
+// Longest a load may take, it is shared so not cancelled with one request
+const userLoadTimeout = 10 * time.Second
+
+// Bounded read-through cache of users by id
+// Users are kept for TTL and ids without a user for NegativeTTL, evicting the
+// least recently used past MaxEntries. Writes invalidate the ids they touch
+// here and, over the invalidation bus, on every other replica.
+type UserCache struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	MaxEntries  int
+	Now         func() time.Time
+
+	bus InvalidationBus
+	keyedCache[cachedUser]
+}
+
+// A user, or that there is none with the id
+type cachedUser struct {
+	user  models.User
+	found bool
+}
+
+// Set up the user cache from CACHE_TTL, CACHE_NEGATIVE_TTL and CACHE_MAX_ENTRIES
+// Returns nil with CACHE_ENABLED=false, and a nil cache reads through.
+func NewUserCache() *UserCache {
+	if !env.Bool("CACHE_ENABLED", true) {
+		log.Println("User cache is disabled")
+		return nil
+	}
+	return &UserCache{
+		TTL:         env.Duration("CACHE_TTL", 30*time.Second),
+		NegativeTTL: env.Duration("CACHE_NEGATIVE_TTL", 5*time.Second),
+		MaxEntries:  env.Int("CACHE_MAX_ENTRIES", 10000),
+		Now:         time.Now,
+		keyedCache:  newKeyedCache[cachedUser](),
+	}
+}
+
+// Cache key of a user, terminated so invalidating 1 leaves 12 alone
+func userCacheKey(id models.ID) string {
+	return "user:" + id.String() + ":"
+}
+
+// Get a user, loading it on a miss
+// store.ErrNotFound is cached too, briefly.
+func (c *UserCache) Get(id models.ID, load func() (models.User, error)) (models.User, error) {
+	if c == nil {
+		return load()
+	}
+	key := userCacheKey(id)
+
+	c.mu.Lock()
+	if el, entry, ok := c.lookup(key); ok {
+		ttl := c.TTL
+		if !entry.value.found {
+			ttl = c.NegativeTTL
+		}
+		if c.Now().Sub(entry.storedAt) < ttl {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			if !entry.value.found {
+				userCacheLookups.WithLabelValues("negative_hit").Inc()
+				return models.User{}, store.ErrNotFound
+			}
+			userCacheLookups.WithLabelValues("hit").Inc()
+			return entry.value.user, nil
+		}
+		c.remove(el)
+	}
+	userCacheLookups.WithLabelValues("miss").Inc()
+
+	call, started := c.join(key)
+	c.mu.Unlock()
+	if !started {
+		<-call.done
+		return call.value.user, call.err
+	}
+
+	c.run(key, call, func() (cachedUser, error) {
+		user, err := load()
+		return cachedUser{user: user, found: err == nil}, err
+	}, func(value cachedUser, err error) {
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			c.store(key, value, c.Now(), c.MaxEntries)
+		}
+	})
+	return call.value.user, call.err
+}
+
+// Drop users here and on every other replica after a write
+func (c *UserCache) Invalidate(ctx context.Context, ids ...models.ID) {
+	if c == nil || len(ids) == 0 {
+		return
+	}
+	prefixes := []string{"user:"}
+	if len(ids) <= maxUserInvalidations {
+		prefixes = prefixes[:0]
+		for _, id := range ids {
+			prefixes = append(prefixes, userCacheKey(id))
+		}
+	}
+
+	for _, prefix := range prefixes {
+		c.Purge(prefix)
+		if c.bus == nil {
+			continue
+		}
+		msg := InvalidationMessage{Prefix: prefix, Origin: replicaId, SentAt: time.Now()}
+		if err := c.bus.Publish(ctx, msg); err != nil {
+			log.Printf("Error publishing cache invalidation for %q: %v", prefix, err)
+		}
+	}
+}
+
+// Drop every entry whose key starts with prefix
+func (c *UserCache) Purge(prefix string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge(prefix)
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Memory store counting the users it is asked for
+type countingUsers struct {
+	*store.Memory
+	gets atomic.Int64
+}
+
+func (c *countingUsers) Get(ctx context.Context, id models.ID) (models.User, error) {
+	c.gets.Add(1)
+	return c.Memory.Get(ctx, id)
+}
+
+// User cache whose clock only moves when the test moves it
+func newFakeClockUserCache(maxEntries int) (*UserCache, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	c := &UserCache{TTL: time.Minute, NegativeTTL: 5 * time.Second, MaxEntries: maxEntries, Now: func() time.Time { return now }, keyedCache: newKeyedCache[cachedUser]()}
+	return c, &now
+}
+
+func TestUserCacheReadsThrough(t *testing.T) {
+	users := &countingUsers{Memory: store.NewMemory()}
+	ada, _ := users.Create(context.Background(), models.User{Name: "Ada", Email: "ada@example.com"})
+	c, now := newFakeClockUserCache(10)
+	load := func(id models.ID) func() (models.User, error) {
+		return func() (models.User, error) { return users.Get(context.Background(), id) }
+	}
+
+	for i := 0; i < 3; i++ {
+		if user, err := c.Get(ada.Id, load(ada.Id)); err != nil || user.Name != "Ada" {
+			t.Fatalf("Get = %+v %v", user, err)
+		}
+	}
+	if n := users.gets.Load(); n != 1 {
+		t.Fatalf("%d store reads for three Gets, want 1", n)
+	}
+	*now = now.Add(time.Minute)
+	c.Get(ada.Id, load(ada.Id))
+	if n := users.gets.Load(); n != 2 {
+		t.Fatalf("%d store reads after the TTL, want 2", n)
+	}
+
+	// Missing users are remembered, for the shorter TTL
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(999, load(999)); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("Get of a missing user = %v", err)
+		}
+	}
+	if n := users.gets.Load(); n != 3 {
+		t.Fatalf("%d store reads after three misses, want 3", n)
+	}
+	*now = now.Add(5 * time.Second)
+	c.Get(999, load(999))
+	if n := users.gets.Load(); n != 4 {
+		t.Fatalf("%d store reads after the negative TTL, want 4", n)
+	}
+
+	// Store errors are not cached
+	broken := func() (models.User, error) { return models.User{}, errors.New("database is down") }
+	c.Get(1000, broken)
+	if _, err := c.Get(1000, load(1000)); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get after an error = %v, want a new load", err)
+	}
+}
+
+func TestUserCacheInvalidate(t *testing.T) {
+	c, _ := newFakeClockUserCache(10)
+	for _, id := range []models.ID{1, 12} {
+		c.Get(id, func() (models.User, error) { return models.User{Id: id, Name: "old"}, nil })
+	}
+	c.Invalidate(context.Background(), 1)
+	fresh := func(id models.ID) func() (models.User, error) {
+		return func() (models.User, error) { return models.User{Id: id, Name: "new"}, nil }
+	}
+	if user, _ := c.Get(1, fresh(1)); user.Name != "new" {
+		t.Fatalf("user 1 after its invalidation = %q, want it reloaded", user.Name)
+	}
+	if user, _ := c.Get(12, fresh(12)); user.Name != "old" {
+		t.Fatalf("user 12 after invalidating 1 = %q, want it still cached", user.Name)
+	}
+
+	// Too many ids at once purge everything
+	ids := make([]models.ID, maxUserInvalidations+1)
+	for i := range ids {
+		ids[i] = models.ID(100 + i)
+	}
+	c.Invalidate(context.Background(), ids...)
+	if user, _ := c.Get(12, fresh(12)); user.Name != "new" {
+		t.Fatalf("user 12 after a large invalidation = %q, want it reloaded", user.Name)
+	}
+}
+
+func TestUserCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newFakeClockUserCache(2)
+	var loads atomic.Int32
+	load := func(id models.ID) func() (models.User, error) {
+		return func() (models.User, error) { loads.Add(1); return models.User{Id: id}, nil }
+	}
+	c.Get(1, load(1))
+	c.Get(2, load(2))
+	c.Get(1, load(1))
+	c.Get(3, load(3))
+	if n := len(c.entries); n != 2 {
+		t.Fatalf("%d entries, want at most 2", n)
+	}
+	c.Get(1, load(1))
+	if n := loads.Load(); n != 3 {
+		t.Fatalf("%d loads, want user 1 to have stayed cached", n)
+	}
+	c.Get(2, load(2))
+	if n := loads.Load(); n != 4 {
+		t.Fatalf("%d loads, want user 2 to have been evicted", n)
+	}
+}
+
+func TestUserCacheDisabled(t *testing.T) {
+	t.Setenv("CACHE_ENABLED", "false")
+	c := NewUserCache()
+	if c != nil {
+		t.Fatal("NewUserCache with CACHE_ENABLED=false returned a cache")
+	}
+	var loads int
+	for i := 0; i < 2; i++ {
+		c.Get(1, func() (models.User, error) { loads++; return models.User{Id: 1}, nil })
+	}
+	c.Invalidate(context.Background(), 1)
+	if loads != 2 {
+		t.Fatalf("%d loads through a disabled cache, want every Get to read", loads)
+	}
+}
+
+// Readers racing writers never keep a user the writers replaced
+// Run with -race, the cache is shared by every request.
+func TestUserCacheConcurrentReadsAndWrites(t *testing.T) {
+	users := &countingUsers{Memory: store.NewMemory()}
+	ctx := context.Background()
+	var ids []models.ID
+	for i := 0; i < 4; i++ {
+		user, err := users.Create(ctx, models.User{Name: "v0", Email: fmt.Sprintf("user%d@example.com", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.Id)
+	}
+	c := &UserCache{TTL: time.Hour, NegativeTTL: time.Hour, MaxEntries: 3, Now: time.Now, keyedCache: newKeyedCache[cachedUser]()}
+	load := func(id models.ID) func() (models.User, error) {
+		return func() (models.User, error) {
+			// Slow enough for writes to land in the middle of loads
+			time.Sleep(time.Duration(id%3) * 100 * time.Microsecond)
+			return users.Get(ctx, id)
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 16; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := ids[(r+i)%len(ids)]
+				if user, err := c.Get(id, load(id)); err != nil || user.Id != id {
+					t.Errorf("Get(%d) = %+v %v", id, user, err)
+					return
+				}
+			}
+		}(r)
+	}
+	var writers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for v := 1; v <= 50; v++ {
+				id := ids[(w+v)%len(ids)]
+				current, _ := users.Memory.Get(ctx, id)
+				if _, err := users.Update(ctx, id, models.User{Name: fmt.Sprintf("v%d-%d", w, v), Email: current.Email}, 0); err != nil {
+					t.Error(err)
+					return
+				}
+				c.Invalidate(ctx, id)
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(stop)
+	wg.Wait()
+
+	for _, id := range ids {
+		want, _ := users.Memory.Get(ctx, id)
+		got, err := c.Get(id, load(id))
+		if err != nil || got.Name != want.Name || got.Version != want.Version {
+			t.Fatalf("user %d after the writes = %+v %v, want %+v", id, got, err, want)
+		}
+	}
+}
+
+// GET /users/{id} with the cache, reporting the store reads per request
+// Compare with BenchmarkGetUserUncached for the reads the cache saves.
+func BenchmarkGetUserCached(b *testing.B) {
+	benchmarkGetUser(b, &UserCache{TTL: time.Minute, NegativeTTL: time.Second, MaxEntries: 1000, Now: time.Now, keyedCache: newKeyedCache[cachedUser]()})
+}
+
+func BenchmarkGetUserUncached(b *testing.B) {
+	benchmarkGetUser(b, nil)
+}
+
+func benchmarkGetUser(b *testing.B, cache *UserCache) {
+	users := &countingUsers{Memory: store.NewMemory()}
+	for i := 0; i < 100; i++ {
+		users.Create(context.Background(), models.User{Name: "User", Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}", GetUsersId(users, cache)).Methods("GET")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 1; pb.Next(); i++ {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/users/%d", i%100+1), nil))
+			if w.Code != http.StatusOK {
+				b.Errorf("GET = %d %s", w.Code, w.Body)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(users.gets.Load())/float64(b.N), "store-reads/op")
+}
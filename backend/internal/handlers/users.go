@@ -0,0 +1,580 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Id from the route, answering 400 when it is not a positive integer
+func userIdVar(w http.ResponseWriter, r *http.Request) (models.ID, bool) {
+	id, err := models.ParseID(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid user id")
+		return 0, false
+	}
+	return id, true
+}
+
+// Id from the route of a user being changed, which has to be the signed in
+// user unless the request carries the admin API key
+// Answers 403 for anyone else's id, whether that user exists or not.
+func ownUserIdVar(w http.ResponseWriter, r *http.Request) (models.ID, bool) {
+	id, ok := userIdVar(w, r)
+	if !ok {
+		return 0, false
+	}
+	if caller, signedIn := authUserID(r.Context()); !isAdminRequest(r) && (!signedIn || caller != id) {
+		writeJSONError(w, http.StatusForbidden, "users can only change themselves")
+		return 0, false
+	}
+	return id, true
+}
+
+// Home handler example
+func HomeHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Welcome to the Backend Service in Go!"})
+}
+
+// Get all users
+func GetUsers(users store.UserStore, cache *ResponseCache, collations *store.Collations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseListParams(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if opts.IncludeDeleted && !isAdminRequest(r) {
+			writeJSONError(w, http.StatusForbidden, "include_deleted needs the admin API key")
+			return
+		}
+		fallback, err := collations.Check(opts.Collation)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if fallback {
+			w.Header().Set("Warning", fmt.Sprintf(`299 - "collation %s is not available, sorted with the default collation"`, opts.Collation))
+		}
+
+		body, age, state, err := cache.Get(listCacheKey(opts), func() ([]byte, error) {
+			return listUsers(r.Context(), users, opts)
+		})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("X-Cache", state)
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+		writeJSONBody(w, http.StatusOK, body)
+	}
+}
+
+// Load a page of users as an encoded Page
+// Queries are not cancelled with the request because one load is shared by
+// every request waiting on the same cache key, and stale entries are refreshed
+// after the request that noticed them has finished.
+func listUsers(ctx context.Context, users store.UserStore, opts store.ListOptions) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), env.Duration("LIST_QUERY_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	list, total, err := users.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	page := newPage(data, total, opts.Limit, opts.Offset)
+	if opts.Sort == "id" && len(list) == opts.Limit {
+		next := list[len(list)-1].Id
+		page.NextAfterId = &next
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// Get a user by Id, through the user cache
+func GetUsersId(users store.UserStore, userCache *UserCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userIdVar(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := userCache.Get(id, func() (models.User, error) {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), userLoadTimeout)
+			defer cancel()
+			return users.Get(ctx, id)
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		writeUser(w, http.StatusOK, user)
+	}
+}
+
+// Create a new user
+func CreateUsers(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub, growth *GrowthGuard, emails *EmailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !growth.Allow() {
+			writeCapacityLimit(w, growth.Table)
+			return
+		}
+
+		var user models.User
+		err := userShapes.Decode(w, r, &user)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if errs := validateUser(&user); errs != nil {
+			writeFieldErrors(w, errs)
+			return
+		}
+		if reason := emails.Check(user.Email); reason != "" {
+			if !emailPolicyBypassed(r) {
+				writeEmailNotAllowed(w, reason)
+				return
+			}
+			logRequest(r, slog.LevelInfo, "AUDIT: email policy (%s) bypassed by admin for %s from %s", reason, user.Email, r.RemoteAddr)
+		}
+
+		budget := NewBudget(r)
+		dbCtx, cancelDB := budget.Phase(r.Context(), dbBudgetShare, 0)
+		defer cancelDB()
+		plan, err := runWrite(dbCtx, r, txs, "create_user", func(tx store.Tx, plan *DryRunPlan) error {
+			var err error
+			user, err = tx.Users.Create(dbCtx, user)
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", user.Id)
+			return recordUserChange(dbCtx, r, tx.Audit, "create", nil, &user)
+		})
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "email_taken", "email already exists")
+			return
+		}
+		if budget.Check("db", err) != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		growth.Added(1)
+		countUserOperation("create", 1)
+		sideCtx, cancelSide := budget.Phase(context.WithoutCancel(r.Context()), sideEffectBudgetShare, minSideEffectBudget)
+		defer cancelSide()
+		budget.Check("side effects", cache.Invalidate(sideCtx, "users:"))
+		userCache.Invalidate(sideCtx, user.Id)
+		events.Publish(EventUserCreated, user)
+
+		writeUser(w, http.StatusOK, user)
+	}
+}
+
+// Update a user by Id
+// The body replaces the user, so name and email are both required.
+// The version it was based on comes from If-Match or the version field, with
+// 412 and the current user when someone else changed it in between. With
+// STRICT_CONCURRENCY updates without a version are refused with 428.
+func UpdateUser(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub) http.HandlerFunc {
+	strict := env.Bool("STRICT_CONCURRENCY", false)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := ownUserIdVar(w, r)
+		if !ok {
+			return
+		}
+
+		var user models.User
+		err := userShapes.Decode(w, r, &user)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if errs := validateUser(&user); errs != nil {
+			writeFieldErrors(w, errs)
+			return
+		}
+		version, conditional, err := requestVersion(r, user)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strict && !conditional {
+			writeJSONError(w, http.StatusPreconditionRequired, "send If-Match with the ETag of the user")
+			return
+		}
+
+		var updatedUser models.User
+		found := true
+		budget := NewBudget(r)
+		dbCtx, cancelDB := budget.Phase(r.Context(), dbBudgetShare, 0)
+		defer cancelDB()
+
+		plan, err := runWrite(dbCtx, r, txs, "update_user", func(tx store.Tx, plan *DryRunPlan) error {
+			before, err := tx.Users.Lock(dbCtx, id)
+			if errors.Is(err, store.ErrNotFound) || before.DeletedAt != nil {
+				found = false
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			updatedUser, err = tx.Users.Update(dbCtx, id, user, version)
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", updatedUser.Id)
+			return recordUserChange(dbCtx, r, tx.Audit, "update", &before, &updatedUser)
+		})
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "email_taken", "email already exists")
+			return
+		}
+		if errors.Is(err, store.ErrVersionMismatch) {
+			writeVersionMismatch(w, updatedUser)
+			return
+		}
+		if budget.Check("db", err) != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		countUserOperation("update", 1)
+		sideCtx, cancelSide := budget.Phase(context.WithoutCancel(r.Context()), sideEffectBudgetShare, minSideEffectBudget)
+		defer cancelSide()
+		budget.Check("side effects", cache.Invalidate(sideCtx, "users:"))
+		userCache.Invalidate(sideCtx, id)
+		events.Publish(EventUserUpdated, updatedUser)
+
+		writeUser(w, http.StatusOK, updatedUser)
+	}
+}
+
+// Body of a partial update, JSON Merge Patch of a user
+// Fields left out keep their value. Name and email cannot be removed, so
+// null is refused for them.
+type UserPatch struct {
+	Name    json.RawMessage `json:"name,omitempty"`
+	Email   json.RawMessage `json:"email,omitempty"`
+	Version int64           `json:"version,omitempty"`
+}
+
+// Apply a patch to a user, returning field errors for values that are not
+// strings or are null
+func (p UserPatch) apply(user *models.User) FieldErrors {
+	errs := FieldErrors{}
+	for field, value := range map[string]struct {
+		raw json.RawMessage
+		to  *string
+	}{"name": {p.Name, &user.Name}, "email": {p.Email, &user.Email}} {
+		switch {
+		case value.raw == nil:
+		case string(value.raw) == "null":
+			errs[field] = "required"
+		case json.Unmarshal(value.raw, value.to) != nil:
+			errs[field] = "must be a string"
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Update some fields of a user by Id
+// The body is a JSON Merge Patch, sent as application/merge-patch+json or
+// application/json, and the result is validated like a full update.
+// Versions are checked as in UpdateUser.
+func PatchUser(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub) http.HandlerFunc {
+	strict := env.Bool("STRICT_CONCURRENCY", false)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := ownUserIdVar(w, r)
+		if !ok {
+			return
+		}
+
+		body, err := readJSONBody(r, "application/merge-patch+json", "application/json")
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		var patch UserPatch
+		if err := unmarshalStrict(body, &patch); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if patch.Name == nil && patch.Email == nil {
+			writeError(w, http.StatusBadRequest, "empty_patch", "the patch changes neither name nor email")
+			return
+		}
+		if errs := patch.apply(&models.User{}); errs != nil {
+			writeFieldErrors(w, errs)
+			return
+		}
+		version, conditional, err := requestVersion(r, models.User{Version: patch.Version})
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strict && !conditional {
+			writeJSONError(w, http.StatusPreconditionRequired, "send If-Match with the ETag of the user")
+			return
+		}
+
+		var updatedUser models.User
+		var invalid FieldErrors
+		found := true
+		budget := NewBudget(r)
+		dbCtx, cancelDB := budget.Phase(r.Context(), dbBudgetShare, 0)
+		defer cancelDB()
+
+		plan, err := runWrite(dbCtx, r, txs, "patch_user", func(tx store.Tx, plan *DryRunPlan) error {
+			before, err := tx.Users.Lock(dbCtx, id)
+			if errors.Is(err, store.ErrNotFound) || before.DeletedAt != nil {
+				found = false
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			user := before
+			patch.apply(&user)
+			if invalid = validateUser(&user); invalid != nil {
+				return nil
+			}
+			updatedUser, err = tx.Users.Update(dbCtx, id, user, version)
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", updatedUser.Id)
+			return recordUserChange(dbCtx, r, tx.Audit, "update", &before, &updatedUser)
+		})
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "email_taken", "email already exists")
+			return
+		}
+		if errors.Is(err, store.ErrVersionMismatch) {
+			writeVersionMismatch(w, updatedUser)
+			return
+		}
+		if budget.Check("db", err) != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if invalid != nil {
+			writeFieldErrors(w, invalid)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		countUserOperation("update", 1)
+		sideCtx, cancelSide := budget.Phase(context.WithoutCancel(r.Context()), sideEffectBudgetShare, minSideEffectBudget)
+		defer cancelSide()
+		budget.Check("side effects", cache.Invalidate(sideCtx, "users:"))
+		userCache.Invalidate(sideCtx, id)
+		events.Publish(EventUserUpdated, updatedUser)
+
+		writeUser(w, http.StatusOK, updatedUser)
+	}
+}
+
+// Delete a user
+// Users are soft-deleted and can be restored, ?hard=true erases the row for
+// good and needs the admin API key.
+// Answers 204, or 200 with the signed receipt when receipts are configured.
+// Deleting a user that is already gone is a 404, unless a receipt from the
+// first delete can be handed back.
+func DeleteUser(txs store.Transactor, stored store.ReceiptStore, cache *ResponseCache, userCache *UserCache, events *EventHub, growth *GrowthGuard, receipts *ReceiptSigner, uploads store.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := ownUserIdVar(w, r)
+		if !ok {
+			return
+		}
+		hard, _ := strconv.ParseBool(r.URL.Query().Get("hard"))
+		if hard && !isAdminRequest(r) {
+			writeJSONError(w, http.StatusForbidden, "hard deletes need the admin API key")
+			return
+		}
+		operation := "delete"
+		if hard {
+			operation = "hard_delete"
+		}
+
+		budget := NewBudget(r)
+		dbCtx, cancelDB := budget.Phase(r.Context(), dbBudgetShare, 0)
+		defer cancelDB()
+
+		var receipt *SignedReceipt
+		var deleted models.User
+		plan, err := runWrite(dbCtx, r, txs, operation+"_user", func(tx store.Tx, plan *DryRunPlan) error {
+			txUsers := tx.Users
+			before, err := txUsers.Lock(dbCtx, id)
+			if errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			remove := txUsers.Delete
+			if hard {
+				remove = txUsers.HardDelete
+			}
+			ids, err := remove(dbCtx, id)
+			plan.Touch("users", ids...)
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+
+			var after *models.User
+			deleted = before
+			if !hard {
+				deleted, err = txUsers.Lock(dbCtx, id)
+				if err != nil {
+					return err
+				}
+				after = &deleted
+			}
+			if err := recordUserChange(dbCtx, r, tx.Audit, operation, &before, after); err != nil {
+				return err
+			}
+			if receipts == nil || plan.DryRun {
+				return nil
+			}
+			receipt, err = receipts.Issue(dbCtx, tx.Receipts, DeletionReceipt{
+				UserId:    ids[0],
+				Operation: operation,
+				Actor:     requestActor(r),
+				Tables:    plan.RowsAffected,
+			})
+			return err
+		})
+		if budget.Check("db", err) != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		// Soft-deleted rows still count towards the growth limits
+		if hard {
+			growth.Added(-plan.RowsAffected["users"])
+		}
+		countUserOperation(operation, int(plan.RowsAffected["users"]))
+		sideCtx, cancelSide := budget.Phase(context.WithoutCancel(r.Context()), sideEffectBudgetShare, minSideEffectBudget)
+		defer cancelSide()
+		budget.Check("side effects", cache.Invalidate(sideCtx, "users:"))
+		userCache.Invalidate(sideCtx, plan.IdsTouched["users"]...)
+		if plan.RowsAffected["users"] > 0 {
+			events.Publish(EventUserDeleted, deleted)
+			// Soft-deleted users keep their avatar for a restore
+			if hard {
+				removeAvatar(sideCtx, uploads, id, deleted.Avatar)
+			}
+		}
+
+		// Deleting again hands back the receipt from the first time
+		if receipts != nil && receipt == nil {
+			receipt, err = findReceipt(r.Context(), stored, id, operation)
+			if err != nil && !errors.Is(err, store.ErrReceiptNotFound) {
+				serverError(w, r, err)
+				return
+			}
+		}
+		if receipt != nil {
+			writeJSON(w, http.StatusOK, receipt)
+			return
+		}
+		if plan.RowsAffected["users"] == 0 {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Restore a soft-deleted user
+func RestoreUser(txs store.Transactor, cache *ResponseCache, userCache *UserCache, events *EventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userIdVar(w, r)
+		if !ok {
+			return
+		}
+
+		var user models.User
+		plan, err := runWrite(r.Context(), r, txs, "restore_user", func(tx store.Tx, plan *DryRunPlan) error {
+			before, err := tx.Users.Lock(r.Context(), id)
+			if err != nil {
+				return err
+			}
+			user, err = tx.Users.Restore(r.Context(), id)
+			if err != nil {
+				return err
+			}
+			plan.Touch("users", user.Id)
+			return recordUserChange(r.Context(), r, tx.Audit, "restore", &before, &user)
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "no deleted user with this id")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if plan.DryRun {
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+		countUserOperation("restore", 1)
+		cache.Invalidate(context.WithoutCancel(r.Context()), "users:")
+		userCache.Invalidate(context.WithoutCancel(r.Context()), id)
+		// Restored users come back to lists as if they were new
+		events.Publish(EventUserCreated, user)
+
+		writeUser(w, http.StatusOK, user)
+	}
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Admin API key the test servers send unless a request sets its own
+const testAdminKey = "test-admin-key"
+
+// User routes served from a memory store
+type testServer struct {
+	store   *store.Memory
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWith(t, store.NewMemory(), nil)
+}
+
+// Test server whose writes run through txs, the memory store unless set
+func newTestServerWith(t *testing.T, mem *store.Memory, txs store.Transactor) *testServer {
+	t.Helper()
+	if txs == nil {
+		txs = mem
+	}
+	return &testServer{store: mem, handler: userRoutes(t, mem, mem.Receipts(), txs)}
+}
+
+// User routes reading from users and writing through txs
+func userRoutes(t *testing.T, users store.UserStore, receipts store.ReceiptStore, txs store.Transactor) http.Handler {
+	t.Helper()
+	t.Setenv("ADMIN_API_KEY", testAdminKey)
+	cache := NewResponseCache(time.Minute, 0, 100)
+	userCache := NewUserCache()
+	events := NewEventHub()
+	t.Cleanup(events.Close)
+	growth := &GrowthGuard{Table: "users"}
+	emails := NewEmailPolicy()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users", GetUsers(users, cache, nil)).Methods("GET")
+	router.HandleFunc("/users", CreateUsers(txs, cache, userCache, events, growth, emails)).Methods("POST")
+	router.HandleFunc("/users/bulk", CreateUsersBulk(txs, cache, userCache, events, growth, emails)).Methods("POST")
+	router.HandleFunc("/users/{id}", GetUsersId(users, userCache)).Methods("GET")
+	router.HandleFunc("/users/{id}", UpdateUser(txs, cache, userCache, events)).Methods("PUT")
+	router.HandleFunc("/users/{id}", PatchUser(txs, cache, userCache, events)).Methods("PATCH")
+	router.HandleFunc("/users/{id}", DeleteUser(txs, receipts, cache, userCache, events, growth, nil, nil)).Methods("DELETE")
+	router.HandleFunc("/users/{id}/restore", RestoreUser(txs, cache, userCache, events)).Methods("POST")
+	return router
+}
+
+// Serve a request as the admin, with headers given as name/value pairs
+func (s *testServer) do(t *testing.T, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("X-API-Key", testAdminKey)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// Decode a response body, failing the test when it is not JSON
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("response %q is not JSON: %v", w.Body, err)
+	}
+	return v
+}
+
+// Create a user through the API
+func (s *testServer) createUser(t *testing.T, name, email string) models.User {
+	t.Helper()
+	w := s.do(t, "POST", "/users", `{"name":"`+name+`","email":"`+email+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating %s: %d %s", email, w.Code, w.Body)
+	}
+	return decode[models.User](t, w)
+}
+
+// Error code of an error response
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	return decode[struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}](t, w).Error.Code
+}
+
+func TestUserLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	path := "/users/" + user.Id.String()
+
+	if w := s.do(t, "GET", path, ""); w.Code != http.StatusOK || decode[models.User](t, w).Email != "ada@example.com" {
+		t.Fatalf("GET after create = %d %s", w.Code, w.Body)
+	}
+
+	w := s.do(t, "PUT", path, `{"name":"Ada Lovelace","email":"ada@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	if updated := decode[models.User](t, w); updated.Name != "Ada Lovelace" || updated.Version != user.Version+1 {
+		t.Fatalf("PUT returned %+v", updated)
+	}
+
+	if w := s.do(t, "DELETE", path, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d %s", w.Code, w.Body)
+	}
+	if w := s.do(t, "GET", path, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET after delete = %d, want 404", w.Code)
+	}
+	if w := s.do(t, "DELETE", path, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("second DELETE = %d, want 404", w.Code)
+	}
+
+	if w := s.do(t, "POST", path+"/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s", w.Code, w.Body)
+	}
+	if w := s.do(t, "POST", path+"/restore", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("restoring a user that is not deleted = %d, want 404", w.Code)
+	}
+	if w := s.do(t, "GET", path, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET after restore = %d", w.Code)
+	}
+
+	entries, _, err := s.store.Audit().List(context.Background(), store.AuditListOptions{Limit: 10, EntityId: user.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		actions = append([]string{entry.Action}, actions...)
+	}
+	if got := strings.Join(actions, ","); got != "create,update,delete,restore" {
+		t.Fatalf("audit log = %s, want create,update,delete,restore", got)
+	}
+}
+
+func TestUserErrors(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	s.createUser(t, "Grace", "grace@example.com")
+	path := "/users/" + user.Id.String()
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		header       []string
+		status       int
+		code         string
+	}{
+		{"malformed body", "POST", "/users", `{"name":`, nil, http.StatusBadRequest, ""},
+		{"missing fields", "POST", "/users", `{"name":"Ada"}`, nil, http.StatusBadRequest, "invalid_fields"},
+		{"taken email", "POST", "/users", `{"name":"Ada","email":"ADA@example.com"}`, nil, http.StatusConflict, "email_taken"},
+		{"invalid id", "GET", "/users/abc", "", nil, http.StatusBadRequest, ""},
+		{"missing user", "GET", "/users/999", "", nil, http.StatusNotFound, ""},
+		{"update missing user", "PUT", "/users/999", `{"name":"X","email":"x@example.com"}`, nil, http.StatusNotFound, ""},
+		{"update to a taken email", "PUT", path, `{"name":"Ada","email":"grace@example.com"}`, nil, http.StatusConflict, "email_taken"},
+		{"delete invalid id", "DELETE", "/users/0", "", nil, http.StatusBadRequest, ""},
+		{"delete missing user", "DELETE", "/users/999", "", nil, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.path, tt.body, tt.header...)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.code != "" {
+				if code := errorCode(t, w); code != tt.code {
+					t.Fatalf("error code = %q, want %q", code, tt.code)
+				}
+			}
+		})
+	}
+}
+
+// GET, PUT and DELETE by id answer 200 or 204, 400 for ids that are not
+// positive integers, 404 for missing users and 500 when the store fails
+func TestUserByIdStatuses(t *testing.T) {
+	s := newTestServer(t)
+	failing := closedDatabaseServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	path := "/users/" + user.Id.String()
+	body := `{"name":"Ada","email":"ada@example.com"}`
+
+	tests := []struct {
+		name         string
+		server       *testServer
+		method, path string
+		body         string
+		status       int
+		message      string
+	}{
+		{"get", s, "GET", path, "", http.StatusOK, ""},
+		{"update", s, "PUT", path, body, http.StatusOK, ""},
+		{"get text id", s, "GET", "/users/abc", "", http.StatusBadRequest, "invalid user id"},
+		{"get zero id", s, "GET", "/users/0", "", http.StatusBadRequest, "invalid user id"},
+		{"get negative id", s, "GET", "/users/-1", "", http.StatusBadRequest, "invalid user id"},
+		{"update text id", s, "PUT", "/users/abc", body, http.StatusBadRequest, "invalid user id"},
+		{"update text id with an invalid body", s, "PUT", "/users/abc", `{"name":""}`, http.StatusBadRequest, "invalid user id"},
+		{"patch text id with an invalid body", s, "PATCH", "/users/abc", `{}`, http.StatusBadRequest, "invalid user id"},
+		{"delete text id", s, "DELETE", "/users/abc", "", http.StatusBadRequest, "invalid user id"},
+		{"delete id past int64", s, "DELETE", "/users/99999999999999999999", "", http.StatusBadRequest, "invalid user id"},
+		{"get missing", s, "GET", "/users/999999", "", http.StatusNotFound, "user not found"},
+		{"update missing", s, "PUT", "/users/999999", body, http.StatusNotFound, "user not found"},
+		{"delete missing", s, "DELETE", "/users/999999", "", http.StatusNotFound, "user not found"},
+		{"get failing store", failing, "GET", "/users/1", "", http.StatusInternalServerError, "internal server error"},
+		{"update failing store", failing, "PUT", "/users/1", body, http.StatusInternalServerError, "internal server error"},
+		{"delete failing store", failing, "DELETE", "/users/1", "", http.StatusInternalServerError, "internal server error"},
+		{"delete", s, "DELETE", path, "", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.server.do(t, tt.method, tt.path, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.status == http.StatusNoContent && w.Body.Len() != 0 {
+				t.Fatalf("204 with a body: %s", w.Body)
+			}
+			if tt.message != "" {
+				if got := decode[ErrorResponse](t, w).Error.Message; got != tt.message {
+					t.Fatalf("error message = %q, want %q", got, tt.message)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateUserVersionMismatch(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	path := "/users/" + user.Id.String()
+	etag := userETag(user)
+
+	if w := s.do(t, "PUT", path, `{"name":"First","email":"ada@example.com"}`, "If-Match", etag); w.Code != http.StatusOK {
+		t.Fatalf("first update = %d %s", w.Code, w.Body)
+	}
+	w := s.do(t, "PUT", path, `{"name":"Second","email":"ada@example.com"}`, "If-Match", etag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("update with a stale ETag = %d, want 412", w.Code)
+	}
+	if current := decode[models.User](t, w); current.Name != "First" {
+		t.Fatalf("412 returned %+v, want the current user", current)
+	}
+}
+
+func TestDryRunChangesNothing(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	path := "/users/" + user.Id.String()
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{"POST", "/users?dry_run=true", `{"name":"Grace","email":"grace@example.com"}`},
+		{"PUT", path + "?dry_run=true", `{"name":"Changed","email":"ada@example.com"}`},
+		{"DELETE", path + "?dry_run=true", ""},
+	}
+	for _, tt := range tests {
+		w := s.do(t, tt.method, tt.path, tt.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s = %d %s", tt.method, tt.path, w.Code, w.Body)
+		}
+		if plan := decode[DryRunPlan](t, w); !plan.DryRun || plan.RowsAffected["users"] != 1 {
+			t.Fatalf("%s %s planned %+v", tt.method, tt.path, plan)
+		}
+	}
+
+	users, total, err := s.store.List(context.Background(), store.ListOptions{Limit: 10, Sort: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || users[0] != user {
+		t.Fatalf("store holds %+v after dry runs, want only %+v", users, user)
+	}
+}
+
+// Transactor whose audit log fails, so writes have to roll back
+type failingAudit struct {
+	store.Transactor
+}
+
+func (f failingAudit) RunInTx(ctx context.Context, fn func(tx store.Tx) error) error {
+	return f.Transactor.RunInTx(ctx, func(tx store.Tx) error {
+		tx.Audit = brokenAudit{tx.Audit}
+		return fn(tx)
+	})
+}
+
+type brokenAudit struct {
+	store.AuditStore
+}
+
+func (brokenAudit) Record(ctx context.Context, entries ...models.AuditEntry) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestWriteRolledBackWithItsAuditEntry(t *testing.T) {
+	mem := store.NewMemory()
+	s := newTestServerWith(t, mem, failingAudit{mem})
+
+	if w := s.do(t, "POST", "/users", `{"name":"Ada","email":"ada@example.com"}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("create with a failing audit log = %d %s, want 500", w.Code, w.Body)
+	}
+	if _, total, _ := mem.List(context.Background(), store.ListOptions{Limit: 10, Sort: "id"}); total != 0 {
+		t.Fatalf("%d users stored, the create should have rolled back", total)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		status int
+		code   string
+		want   models.User // name and email after the patch
+	}{
+		{name: "name only", body: `{"name":"Ada Lovelace"}`, status: http.StatusOK,
+			want: models.User{Name: "Ada Lovelace", Email: "ada@example.com"}},
+		{name: "email only", body: `{"email":"lovelace@example.com"}`, status: http.StatusOK,
+			want: models.User{Name: "Ada", Email: "lovelace@example.com"}},
+		{name: "both", body: `{"name":"A","email":"a@example.com"}`, header: []string{"Content-Type", "application/merge-patch+json"}, status: http.StatusOK,
+			want: models.User{Name: "A", Email: "a@example.com"}},
+		{name: "null name", body: `{"name":null}`, status: http.StatusBadRequest, code: "invalid_fields"},
+		{name: "null email", body: `{"name":"Ada","email":null}`, status: http.StatusBadRequest, code: "invalid_fields"},
+		{name: "not a string", body: `{"name":42}`, status: http.StatusBadRequest, code: "invalid_fields"},
+		{name: "unknown field", body: `{"name":"Ada","role":"admin"}`, status: http.StatusBadRequest},
+		{name: "empty patch", body: `{}`, status: http.StatusBadRequest, code: "empty_patch"},
+		{name: "only a version", body: `{"version":1}`, status: http.StatusBadRequest, code: "empty_patch"},
+		{name: "invalid result", body: `{"email":"not an email"}`, status: http.StatusBadRequest, code: "invalid_fields"},
+		{name: "taken email", body: `{"email":"grace@example.com"}`, status: http.StatusConflict, code: "email_taken"},
+		{name: "stale version", body: `{"name":"X","version":7}`, status: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			user := s.createUser(t, "Ada", "ada@example.com")
+			s.createUser(t, "Grace", "grace@example.com")
+			path := "/users/" + user.Id.String()
+
+			w := s.do(t, "PATCH", path, tt.body, tt.header...)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.code != "" {
+				if code := errorCode(t, w); code != tt.code {
+					t.Fatalf("error code = %q, want %q", code, tt.code)
+				}
+			}
+
+			stored, err := s.store.Get(context.Background(), user.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != http.StatusOK {
+				if stored != user {
+					t.Fatalf("refused patch changed the user to %+v", stored)
+				}
+				return
+			}
+			patched := decode[models.User](t, w)
+			if patched.Name != tt.want.Name || patched.Email != tt.want.Email || patched.Version != user.Version+1 {
+				t.Fatalf("patched user = %+v, want %s <%s> at version %d", patched, tt.want.Name, tt.want.Email, user.Version+1)
+			}
+			if stored != patched {
+				t.Fatalf("stored %+v, returned %+v", stored, patched)
+			}
+		})
+	}
+}
+
+func TestPatchUserNotFound(t *testing.T) {
+	s := newTestServer(t)
+	if w := s.do(t, "PATCH", "/users/999", `{"name":"X"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("patching a missing user = %d, want 404", w.Code)
+	}
+	user := s.createUser(t, "Ada", "ada@example.com")
+	s.do(t, "DELETE", "/users/"+user.Id.String(), "")
+	if w := s.do(t, "PATCH", "/users/"+user.Id.String(), `{"name":"X"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("patching a deleted user = %d, want 404", w.Code)
+	}
+}
+
+func TestPatchUserBudget(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+
+	// A request already out of time gets no database phase at all
+	t.Setenv("REQUEST_BUDGET", "1ns")
+	overruns := counterValue(t, budgetOverruns.WithLabelValues("/users/{id}", "db"))
+	w := s.do(t, "PATCH", "/users/"+user.Id.String(), `{"name":"X"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("patch without budget = %d %s, want 500", w.Code, w.Body)
+	}
+	if stored, _ := s.store.Get(context.Background(), user.Id); stored.Name != "Ada" {
+		t.Fatalf("patch without budget stored %+v", stored)
+	}
+	if got := counterValue(t, budgetOverruns.WithLabelValues("/users/{id}", "db")); got != overruns+1 {
+		t.Fatalf("%v db overruns counted for /users/{id}, want %v", got, overruns+1)
+	}
+}
+
+func TestBudgetCheckCountsOverruns(t *testing.T) {
+	b := NewBudget(httptest.NewRequest("GET", "/users", nil))
+	overruns := budgetOverruns.WithLabelValues("unmatched", "side effects")
+	before := counterValue(t, overruns)
+
+	b.Check("side effects", nil)
+	b.Check("side effects", errors.New("connection refused"))
+	if got := counterValue(t, overruns); got != before {
+		t.Fatalf("errors other than deadlines counted as overruns: %v, want %v", got, before)
+	}
+	b.Check("side effects", fmt.Errorf("publishing: %w", context.DeadlineExceeded))
+	if got := counterValue(t, overruns); got != before+1 {
+		t.Fatalf("%v overruns, want %v", got, before+1)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Field length caps, in characters
+const (
+	maxNameLength  = 100
+	maxEmailLength = 254
+)
+
+// Field errors keyed by field name
+type FieldErrors map[string]string
+
+// Check and normalize a user body
+// Bodies are full replacements, so create and update both need every field.
+// Names are trimmed and emails trimmed and lowercased.
+func validateUser(user *models.User) FieldErrors {
+	errs := FieldErrors{}
+
+	user.Name = strings.TrimSpace(user.Name)
+	switch {
+	case user.Name == "":
+		errs["name"] = "required"
+	case utf8.RuneCountInString(user.Name) > maxNameLength:
+		errs["name"] = "too long"
+	}
+
+	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+	switch {
+	case user.Email == "":
+		errs["email"] = "required"
+	case utf8.RuneCountInString(user.Email) > maxEmailLength:
+		errs["email"] = "too long"
+	case !validEmail(user.Email):
+		errs["email"] = "invalid format"
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Whether a string is a bare address, without a display name or brackets
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.Contains(domain, ".")
+}
+
+// Reject a body that failed validation
+func writeFieldErrors(w http.ResponseWriter, errs FieldErrors) {
+	writeErrorDetails(w, http.StatusBadRequest, "invalid_fields", "some fields are invalid", errs)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+func TestValidateUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      models.User
+		errs      FieldErrors
+		wantName  string
+		wantEmail string
+	}{
+		{"valid", models.User{Name: "Ada", Email: "ada@example.com"}, nil, "Ada", "ada@example.com"},
+		{"normalized", models.User{Name: "  Ada ", Email: " ADA@Example.COM "}, nil, "Ada", "ada@example.com"},
+		{"empty body", models.User{}, FieldErrors{"name": "required", "email": "required"}, "", ""},
+		{"blank name", models.User{Name: "   ", Email: "ada@example.com"}, FieldErrors{"name": "required"}, "", "ada@example.com"},
+		{"name too long", models.User{Name: strings.Repeat("a", maxNameLength+1), Email: "ada@example.com"}, FieldErrors{"name": "too long"}, strings.Repeat("a", maxNameLength+1), "ada@example.com"},
+		{"name at the cap in characters", models.User{Name: strings.Repeat("é", maxNameLength), Email: "ada@example.com"}, nil, strings.Repeat("é", maxNameLength), "ada@example.com"},
+		{"email too long", models.User{Name: "Ada", Email: strings.Repeat("a", maxEmailLength) + "@example.com"}, FieldErrors{"email": "too long"}, "Ada", strings.Repeat("a", maxEmailLength) + "@example.com"},
+		{"email without an at", models.User{Name: "Ada", Email: "ada.example.com"}, FieldErrors{"email": "invalid format"}, "Ada", "ada.example.com"},
+		{"email without a dot in the domain", models.User{Name: "Ada", Email: "ada@localhost"}, FieldErrors{"email": "invalid format"}, "Ada", "ada@localhost"},
+		{"email with a display name", models.User{Name: "Ada", Email: "Ada <ada@example.com>"}, FieldErrors{"email": "invalid format"}, "Ada", "ada <ada@example.com>"},
+		{"email in brackets", models.User{Name: "Ada", Email: "<ada@example.com>"}, FieldErrors{"email": "invalid format"}, "Ada", "<ada@example.com>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.user
+			errs := validateUser(&user)
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Fatalf("errors = %v, want %v", errs, tt.errs)
+			}
+			if user.Name != tt.wantName || user.Email != tt.wantEmail {
+				t.Fatalf("normalized to %q %q, want %q %q", user.Name, user.Email, tt.wantName, tt.wantEmail)
+			}
+		})
+	}
+}
+
+// Create and update reject the same bodies, listing the failed fields
+func TestUserBodiesValidated(t *testing.T) {
+	s := newTestServer(t)
+	user := s.createUser(t, "Ada", "ada@example.com")
+	path := "/users/" + user.Id.String()
+
+	tests := []struct {
+		name string
+		body string
+		errs FieldErrors
+	}{
+		{"empty object", `{}`, FieldErrors{"name": "required", "email": "required"}},
+		{"partial body", `{"name":"Ada"}`, FieldErrors{"email": "required"}},
+		{"invalid email", `{"name":"Ada","email":"not an email"}`, FieldErrors{"email": "invalid format"}},
+		{"name too long", `{"name":"` + strings.Repeat("a", maxNameLength+1) + `","email":"ada@example.com"}`, FieldErrors{"name": "too long"}},
+	}
+	for _, method := range []string{"POST", "PUT"} {
+		target := "/users"
+		if method == "PUT" {
+			target = path
+		}
+		for _, tt := range tests {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				w := s.do(t, method, target, tt.body)
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d %s, want 400", w.Code, w.Body)
+				}
+				body := decode[struct {
+					Error struct {
+						Code    string      `json:"code"`
+						Details FieldErrors `json:"details"`
+					} `json:"error"`
+				}](t, w)
+				if body.Error.Code != "invalid_fields" || !reflect.DeepEqual(body.Error.Details, tt.errs) {
+					t.Fatalf("error = %+v, want invalid_fields with %v", body.Error, tt.errs)
+				}
+			})
+		}
+	}
+
+	for _, body := range []string{`{"name":`, `[]`, `not json`} {
+		if w := s.do(t, "POST", "/users", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("POST %s = %d, want 400", body, w.Code)
+		}
+	}
+
+	// Normalized before it is stored
+	w := s.do(t, "PUT", path, `{"name":" Ada Lovelace ","email":"ADA@Example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	if updated := decode[models.User](t, w); updated.Name != "Ada Lovelace" || updated.Email != "ada@example.com" {
+		t.Fatalf("stored %q %q, want them normalized", updated.Name, updated.Email)
+	}
+}
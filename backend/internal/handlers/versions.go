@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version statuses
+const (
+	VersionCurrent    = "current"
+	VersionDeprecated = "deprecated"
+)
+
+// A version of the API and where it is served
+type APIVersion struct {
+	Version      string     `json:"version"`
+	Prefix       string     `json:"prefix"`
+	Status       string     `json:"status"`
+	DeprecatedAt *time.Time `json:"deprecated_at,omitempty"`
+	Sunset       *time.Time `json:"sunset,omitempty"`
+	Successor    string     `json:"successor,omitempty"` // prefix of the version replacing it
+}
+
+// Path prefixes of every version, for middleware that only applies to the API
+var apiPrefixes = []string{"/api/go/", "/api/v1/"}
+
+// Whether a path belongs to the API rather than probes and the home page
+func isAPIPath(path string) bool {
+	if path == "/api/versions" {
+		return true
+	}
+	for _, prefix := range apiPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Path without its version prefix, the same in every version
+func unversionedPath(path string) string {
+	for _, prefix := range apiPrefixes {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			return "/" + rest
+		}
+	}
+	return path
+}
+
+// Deprecation middleware for the routes of a version that is going away
+// Responses get Deprecation and Sunset headers, and a Link to the same path
+// in the successor version.
+func (v APIVersion) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v.DeprecatedAt != nil {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(v.DeprecatedAt.Unix(), 10))
+		}
+		if v.Sunset != nil {
+			w.Header().Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		links := []string{`<` + publicURL(r, "/api/versions") + `>; rel="deprecation"`}
+		if rest, ok := strings.CutPrefix(r.URL.Path, v.Prefix); ok && v.Successor != "" {
+			successor := v.Successor + rest
+			if r.URL.RawQuery != "" {
+				successor += "?" + r.URL.RawQuery
+			}
+			links = append(links, `<`+publicURL(r, successor)+`>; rel="successor-version"`)
+		}
+		w.Header().Add("Link", strings.Join(links, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List the versions of the API and their status
+func VersionsHandler(versions []APIVersion) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"versions": versions})
+	}
+}
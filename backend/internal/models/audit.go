@@ -0,0 +1,21 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry struct
+// One change to a row, with the row before and after it. OldValue is null
+// for creates and NewValue for hard deletes.
+type AuditEntry struct {
+	Id        int64           `json:"id"`
+	Entity    string          `json:"entity"`
+	EntityId  ID              `json:"entity_id"`
+	Action    string          `json:"action"`
+	OldValue  json.RawMessage `json:"old_value"`
+	NewValue  json.RawMessage `json:"new_value"`
+	Actor     string          `json:"actor"`
+	RequestId string          `json:"request_id"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -0,0 +1,58 @@
+// Types shared by the store and the HTTP handlers
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Encode ids as JSON strings instead of numbers, set from ID_ENCODING=string
+// JavaScript loses precision on integers past 2^53.
+var IdsAsStrings = false
+
+// Database id that can marshal as a JSON number or string
+// Decoding accepts both forms regardless of the setting.
+type ID int64
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	s := strconv.FormatInt(int64(id), 10)
+	if IdsAsStrings {
+		return []byte(`"` + s + `"`), nil
+	}
+	return []byte(s), nil
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %s", data)
+	}
+	*id = ID(n)
+	return nil
+}
+
+// Parse an id from a path or query parameter
+func ParseID(s string) (ID, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return ID(n), nil
+}
+
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
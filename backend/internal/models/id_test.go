@@ -0,0 +1,108 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// Encode ids as strings for the rest of the test
+func useIdsAsStrings(t *testing.T, asStrings bool) {
+	t.Helper()
+	previous := IdsAsStrings
+	IdsAsStrings = asStrings
+	t.Cleanup(func() { IdsAsStrings = previous })
+}
+
+// Ids past 2^53, where a float64 can no longer hold every integer
+var largeIds = []ID{1<<53 + 1, 9007199254740993, 1234567890123456789, math.MaxInt64}
+
+func TestLargeIdsRoundTripAsStrings(t *testing.T) {
+	useIdsAsStrings(t, true)
+	for _, id := range largeIds {
+		data, err := json.Marshal(User{Id: id, Name: "Ada"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// What a JavaScript client sees, every number a float64
+		var generic map[string]any
+		if err := json.Unmarshal(data, &generic); err != nil {
+			t.Fatal(err)
+		}
+		if s, ok := generic["id"].(string); !ok || s != id.String() {
+			t.Fatalf("id %d encoded as %s, want the string %q", id, data, id.String())
+		}
+
+		var user User
+		if err := json.Unmarshal(data, &user); err != nil {
+			t.Fatal(err)
+		}
+		if user.Id != id {
+			t.Fatalf("id %d came back as %d", id, user.Id)
+		}
+	}
+}
+
+func TestIdsEncodeAsNumbersByDefault(t *testing.T) {
+	useIdsAsStrings(t, false)
+	for _, id := range largeIds {
+		data, err := json.Marshal(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != id.String() {
+			t.Fatalf("id %d encoded as %s, want a number", id, data)
+		}
+		var back ID
+		if err := json.Unmarshal(data, &back); err != nil || back != id {
+			t.Fatalf("id %d came back as %d (%v)", id, back, err)
+		}
+		// The loss the string encoding exists to avoid
+		var float float64
+		json.Unmarshal(data, &float)
+		if id != math.MaxInt64 && ID(float) == id {
+			t.Fatalf("id %d survived a float64, the test ids are not large enough", id)
+		}
+	}
+}
+
+// Requests may send either form whatever the setting
+func TestIdsDecodeFromEitherForm(t *testing.T) {
+	for _, asStrings := range []bool{false, true} {
+		useIdsAsStrings(t, asStrings)
+		tests := []struct {
+			json string
+			want ID
+		}{
+			{`9007199254740993`, 9007199254740993},
+			{`"9007199254740993"`, 9007199254740993},
+			{`"-7"`, -7},
+			{`null`, 0},
+		}
+		for _, tt := range tests {
+			var id ID
+			if err := json.Unmarshal([]byte(tt.json), &id); err != nil || id != tt.want {
+				t.Fatalf("decoding %s with strings %t = %d (%v), want %d", tt.json, asStrings, id, err, tt.want)
+			}
+		}
+		for _, bad := range []string{`""`, `"12a"`, `1.5`, `1e3`, `"9223372036854775808"`, `true`, `{}`} {
+			var id ID
+			if err := json.Unmarshal([]byte(bad), &id); err == nil {
+				t.Fatalf("decoding %s gave %d, want an error", bad, id)
+			}
+		}
+	}
+
+	// Both forms in one request body
+	var ids struct {
+		From ID   `json:"from"`
+		To   []ID `json:"to"`
+	}
+	if err := json.Unmarshal([]byte(`{"from":"9007199254740993","to":[9007199254740995,"1"]}`), &ids); err != nil {
+		t.Fatal(err)
+	}
+	if ids.From != 9007199254740993 || len(ids.To) != 2 || ids.To[0] != 9007199254740995 || ids.To[1] != 1 {
+		t.Fatalf("mixed ids decoded as %+v", ids)
+	}
+}
@@ -0,0 +1,7 @@
+package models
+
+// Number of rows on a day, as YYYY-MM-DD in UTC
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
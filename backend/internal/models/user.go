@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Prefix of avatar links, the current API version under BASE_PATH
+var AvatarPrefix = "/api/v1"
+
+// An uploaded avatar of a user
+type Avatar struct {
+	ETag        string // hash of the image, also naming the stored file
+	ContentType string
+}
+
+// User struct
+// DeletedAt is only set on soft-deleted users, which admins can list.
+// Version goes up with every change and is sent as the ETag.
+// AvatarURL links to the avatar when there is one, and changes with it.
+type User struct {
+	Id        ID         `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Version   int64      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	AvatarURL string     `json:"avatar_url,omitempty"`
+	Avatar    *Avatar    `json:"-"`
+}
+
+// Link to the avatar of a user, versioned so it can be cached for good
+func AvatarURL(id ID, avatar Avatar) string {
+	return AvatarPrefix + "/users/" + id.String() + "/avatar?v=" + avatar.ETag
+}
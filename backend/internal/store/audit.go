@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Columns selected for an audit entry, in the order scanAuditEntry reads them
+const auditColumns = "id, entity, entity_id, action, old_value, new_value, actor, request_id, created_at"
+
+// Paging and filtering of the audit log
+type AuditListOptions struct {
+	Limit    int
+	Offset   int
+	Entity   string    // users, or empty for every entity
+	EntityId models.ID // 0 for every row of the entity
+	Action   string    // exact match
+	Actor    string    // exact match
+	Since    time.Time // zero for no lower bound
+	Until    time.Time // zero for no upper bound, exclusive
+}
+
+// Audit log storage
+type AuditStore interface {
+	// Add entries, in the transaction of the change they record so one is
+	// never kept without the other
+	Record(ctx context.Context, entries ...models.AuditEntry) error
+	// A page of entries, newest first, and the number matching the filters
+	List(ctx context.Context, opts AuditListOptions) ([]models.AuditEntry, int64, error)
+	// Clear the old and new values of every entry about some rows, for
+	// users whose personal data has to go
+	ForgetValues(ctx context.Context, entity string, ids []models.ID) error
+}
+
+// Audit log stored in Postgres
+type PostgresAudit struct {
+	db querier
+}
+
+func NewPostgresAudit(db *sql.DB) *PostgresAudit {
+	return &PostgresAudit{db: db}
+}
+
+func (s *PostgresAudit) Record(ctx context.Context, entries ...models.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	entities := make([]string, len(entries))
+	ids := make([]int64, len(entries))
+	actions := make([]string, len(entries))
+	olds := make([]sql.NullString, len(entries))
+	news := make([]sql.NullString, len(entries))
+	actors := make([]string, len(entries))
+	requests := make([]string, len(entries))
+	for i, entry := range entries {
+		entities[i] = entry.Entity
+		ids[i] = int64(entry.EntityId)
+		actions[i] = entry.Action
+		olds[i] = jsonValue(entry.OldValue)
+		news[i] = jsonValue(entry.NewValue)
+		actors[i] = entry.Actor
+		requests[i] = entry.RequestId
+	}
+
+	// One statement for a whole batch, in the order given
+	done := TrackQuery(ctx, "audit.record")
+	rows, err := s.db.QueryContext(ctx, `INSERT INTO audit_log (entity, entity_id, action, old_value, new_value, actor, request_id)
+		SELECT entity, entity_id, action, old_value::jsonb, new_value::jsonb, actor, request_id
+		FROM unnest($1::text[], $2::bigint[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[])
+			WITH ORDINALITY AS t(entity, entity_id, action, old_value, new_value, actor, request_id, n)
+		ORDER BY n`,
+		pq.Array(entities), pq.Array(ids), pq.Array(actions), pq.Array(olds), pq.Array(news), pq.Array(actors), pq.Array(requests))
+	if err != nil {
+		done(0)
+		return err
+	}
+	rows.Close()
+	done(int64(len(entries)))
+	return rows.Err()
+}
+
+func (s *PostgresAudit) ForgetValues(ctx context.Context, entity string, ids []models.ID) error {
+	values := make([]int64, len(ids))
+	for i, id := range ids {
+		values[i] = int64(id)
+	}
+	done := TrackQuery(ctx, "audit.forget_values")
+	result, err := s.db.ExecContext(ctx, "UPDATE audit_log SET old_value = NULL, new_value = NULL WHERE entity = $1 AND entity_id = ANY($2) AND (old_value IS NOT NULL OR new_value IS NOT NULL)", entity, pq.Array(values))
+	if err != nil {
+		done(0)
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	done(rows)
+	return nil
+}
+
+func (s *PostgresAudit) List(ctx context.Context, opts AuditListOptions) ([]models.AuditEntry, int64, error) {
+	where := []string{}
+	args := []interface{}{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+	if opts.Entity != "" {
+		add("entity = $%d", opts.Entity)
+	}
+	if opts.EntityId != 0 {
+		add("entity_id = $%d", opts.EntityId)
+	}
+	if opts.Action != "" {
+		add("action = $%d", opts.Action)
+	}
+	if opts.Actor != "" {
+		add("actor = $%d", opts.Actor)
+	}
+	if !opts.Since.IsZero() {
+		add("created_at >= $%d", opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		add("created_at < $%d", opts.Until)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM audit_log%s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d",
+		auditColumns, whereClause(where), len(args)+1, len(args)+2)
+
+	var total int64
+	var entries []models.AuditEntry
+	err := retryRead(ctx, s.db, "audit.list", func() error {
+		done := TrackQuery(ctx, "audit.count")
+		err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM audit_log"+whereClause(where), args...).Scan(&total)
+		done(1)
+		if err != nil {
+			return err
+		}
+
+		done = TrackQuery(ctx, "audit.list")
+		rows, err := s.db.QueryContext(ctx, query, append(args, opts.Limit, opts.Offset)...)
+		if err != nil {
+			done(0)
+			return err
+		}
+		defer rows.Close()
+
+		entries = []models.AuditEntry{}
+		for rows.Next() {
+			var entry models.AuditEntry
+			if err := scanAuditEntry(rows, &entry); err != nil {
+				done(int64(len(entries)))
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		done(int64(len(entries)))
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// Scan a row selected with auditColumns
+func scanAuditEntry(row RowScanner, entry *models.AuditEntry) error {
+	var oldValue, newValue sql.NullString
+	err := row.Scan(&entry.Id, &entry.Entity, &entry.EntityId, &entry.Action, &oldValue, &newValue, &entry.Actor, &entry.RequestId, &entry.CreatedAt)
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	entry.OldValue, entry.NewValue = nil, nil
+	if oldValue.Valid {
+		entry.OldValue = []byte(oldValue.String)
+	}
+	if newValue.Valid {
+		entry.NewValue = []byte(newValue.String)
+	}
+	return err
+}
+
+// JSON as a nullable text parameter, NULL when there is none
+func jsonValue(raw []byte) sql.NullString {
+	if len(raw) == 0 || string(raw) == "null" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(raw), Valid: true}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// ICU collations users may sort names by
+var nameCollations = []string{
+	"und-x-icu",
+	"de-x-icu",
+	"en-x-icu",
+	"es-x-icu",
+	"fr-x-icu",
+	"sv-x-icu",
+}
+
+// Collations the connected database actually provides
+type Collations struct {
+	available map[string]bool
+}
+
+// Look up which whitelisted collations the database supports
+func DetectCollations(db *sql.DB) *Collations {
+	c := &Collations{available: map[string]bool{}}
+
+	rows, err := db.Query("SELECT collname FROM pg_collation WHERE collprovider = 'i'")
+	if err != nil {
+		log.Printf("Could not detect ICU collations, sorting by name will use the default collation: %v", err)
+		return c
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			c.available[name] = true
+		}
+	}
+
+	for _, name := range nameCollations {
+		if !c.available[name] {
+			log.Printf("Warning: collation %s is not available, sorting with it will fall back to the default", name)
+		}
+	}
+	return c
+}
+
+// Check a requested collation is whitelisted
+// Reports whether sorting with it falls back to the default collation.
+func (c *Collations) Check(collation string) (bool, error) {
+	if collation == "" {
+		return false, nil
+	}
+
+	allowed := false
+	for _, name := range nameCollations {
+		if name == collation {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false, fmt.Errorf("unknown collation %q", collation)
+	}
+	return c == nil || !c.available[collation], nil
+}
+
+// Expression sorting names with a checked collation
+func (c *Collations) nameColumn(collation string) string {
+	if collation == "" || c == nil || !c.available[collation] {
+		return "name"
+	}
+	// Safe to quote directly, the name comes from the whitelist
+	return fmt.Sprintf(`name COLLATE "%s"`, collation)
+}
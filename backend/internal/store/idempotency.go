@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// What is stored for an idempotency key
+// Status is 0 while the request that claimed the key is still running.
+type IdempotentResponse struct {
+	RequestHash string
+	Status      int
+	Header      map[string]string
+	Body        []byte
+}
+
+// Responses kept for idempotency keys, scoped to the caller that sent them
+type IdempotencyStore interface {
+	// Claim a key for a request, keeping it for ttl
+	// claimed is false when the key is taken, with what is stored for it.
+	// Claims still running after stale are taken over, their request is
+	// assumed to have died.
+	Claim(ctx context.Context, scope, key, requestHash string, ttl, stale time.Duration) (stored IdempotentResponse, claimed bool, err error)
+	// Store the response of a claimed key
+	Complete(ctx context.Context, scope, key string, response IdempotentResponse) error
+	// Give up a claim without a response, so the key can be retried
+	Release(ctx context.Context, scope, key, requestHash string) error
+}
+
+// Idempotency keys in a key/value store, expiring with their ttl
+// SetNX makes sure only one request claims a key, and every later change is
+// a compare-and-swap on the record it read, so a claim taken over meanwhile
+// is never overwritten.
+type KVIdempotency struct {
+	Now func() time.Time
+
+	kv KeyValueTTL
+}
+
+func NewKVIdempotency(kv KeyValueTTL) *KVIdempotency {
+	return &KVIdempotency{Now: time.Now, kv: kv}
+}
+
+// Value stored under an idempotency key
+type idempotencyRecord struct {
+	RequestHash string            `json:"request_hash"`
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+	ClaimedAt   time.Time         `json:"claimed_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+func (r idempotencyRecord) response() IdempotentResponse {
+	return IdempotentResponse{RequestHash: r.RequestHash, Status: r.Status, Header: r.Header, Body: r.Body}
+}
+
+func idempotencyKey(scope, key string) string {
+	return "idempotency:" + scope + ":" + key
+}
+
+// Record stored under a key, false when there is none
+func (s *KVIdempotency) load(ctx context.Context, k string) (idempotencyRecord, []byte, bool, error) {
+	var record idempotencyRecord
+	raw, ok, err := s.kv.Get(ctx, k)
+	if err != nil || !ok {
+		return record, nil, false, err
+	}
+	return record, raw, true, json.Unmarshal(raw, &record)
+}
+
+func (s *KVIdempotency) Claim(ctx context.Context, scope, key, requestHash string, ttl, stale time.Duration) (IdempotentResponse, bool, error) {
+	k := idempotencyKey(scope, key)
+	now := s.Now()
+	claim, err := json.Marshal(idempotencyRecord{RequestHash: requestHash, ClaimedAt: now, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+
+	for {
+		claimed, err := s.kv.SetNX(ctx, k, claim, ttl)
+		if err != nil || claimed {
+			return IdempotentResponse{}, claimed, err
+		}
+		stored, raw, ok, err := s.load(ctx, k)
+		if err != nil {
+			return IdempotentResponse{}, false, err
+		}
+		if !ok {
+			// Expired in between, claim it anew
+			continue
+		}
+		if stored.Status != 0 || now.Sub(stored.ClaimedAt) < stale {
+			return stored.response(), false, nil
+		}
+
+		// An abandoned claim is taken over, unless another retry got to it
+		// first, in which case the next round finds that one's claim
+		swapped, err := s.kv.CompareAndSwap(ctx, k, raw, claim, ttl)
+		if err != nil || swapped {
+			return IdempotentResponse{}, swapped, err
+		}
+	}
+}
+
+func (s *KVIdempotency) Complete(ctx context.Context, scope, key string, response IdempotentResponse) error {
+	k := idempotencyKey(scope, key)
+	stored, raw, ok, err := s.load(ctx, k)
+	if err != nil || !ok || stored.RequestHash != response.RequestHash || stored.Status != 0 {
+		return err
+	}
+	ttl := stored.ExpiresAt.Sub(s.Now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	stored.Status, stored.Header, stored.Body = response.Status, response.Header, response.Body
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	// Left alone when the claim was taken over since it was loaded
+	_, err = s.kv.CompareAndSwap(ctx, k, raw, value, ttl)
+	return err
+}
+
+func (s *KVIdempotency) Release(ctx context.Context, scope, key, requestHash string) error {
+	k := idempotencyKey(scope, key)
+	stored, raw, ok, err := s.load(ctx, k)
+	if err != nil || !ok || stored.RequestHash != requestHash || stored.Status != 0 {
+		return err
+	}
+	_, err = s.kv.CompareAndDelete(ctx, k, raw)
+	return err
+}
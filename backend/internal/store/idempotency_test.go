@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKVIdempotency(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	kv := NewMemoryKV()
+	kv.Now = func() time.Time { return now }
+	keys := NewKVIdempotency(kv)
+	keys.Now = kv.Now
+	ttl, stale := time.Hour, time.Minute
+
+	if _, claimed, err := keys.Claim(ctx, "user:1", "k", "hash", ttl, stale); err != nil || !claimed {
+		t.Fatalf("first Claim = %v, %v, want claimed", claimed, err)
+	}
+	stored, claimed, err := keys.Claim(ctx, "user:1", "k", "hash", ttl, stale)
+	if err != nil || claimed || stored.Status != 0 || stored.RequestHash != "hash" {
+		t.Fatalf("Claim while running = %+v, %v, %v, want the running claim", stored, claimed, err)
+	}
+	if _, claimed, _ := keys.Claim(ctx, "user:2", "k", "hash", ttl, stale); !claimed {
+		t.Fatal("the same key of another scope was not claimed")
+	}
+
+	response := IdempotentResponse{RequestHash: "hash", Status: 201, Header: map[string]string{"Content-Type": "application/json"}, Body: []byte(`{"id":1}`)}
+	if err := keys.Complete(ctx, "user:1", "k", response); err != nil {
+		t.Fatal(err)
+	}
+	stored, claimed, _ = keys.Claim(ctx, "user:1", "k", "hash", ttl, stale)
+	if claimed || stored.Status != 201 || string(stored.Body) != `{"id":1}` || stored.Header["Content-Type"] != "application/json" {
+		t.Fatalf("Claim after Complete = %+v, %v, want the stored response", stored, claimed)
+	}
+	// A completed key is never taken over, however old
+	now = now.Add(30 * time.Minute)
+	if _, claimed, _ := keys.Claim(ctx, "user:1", "k", "hash", ttl, stale); claimed {
+		t.Fatal("completed key was claimed again before it expired")
+	}
+	now = now.Add(31 * time.Minute)
+	if _, claimed, _ := keys.Claim(ctx, "user:1", "k", "other", ttl, stale); !claimed {
+		t.Fatal("expired key was not claimed again")
+	}
+}
+
+func TestKVIdempotencyRelease(t *testing.T) {
+	ctx := context.Background()
+	keys := NewKVIdempotency(NewMemoryKV())
+	keys.Claim(ctx, "s", "k", "hash", time.Hour, time.Minute)
+
+	if err := keys.Release(ctx, "s", "k", "someone else"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, _ := keys.Claim(ctx, "s", "k", "hash", time.Hour, time.Minute); claimed {
+		t.Fatal("Release with another request hash gave up the claim")
+	}
+	if err := keys.Release(ctx, "s", "k", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, _ := keys.Claim(ctx, "s", "k", "hash", time.Hour, time.Minute); !claimed {
+		t.Fatal("released key was not claimed again")
+	}
+}
+
+func TestKVIdempotencyStaleClaim(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	kv := NewMemoryKV()
+	kv.Now = func() time.Time { return now }
+	keys := NewKVIdempotency(kv)
+	keys.Now = kv.Now
+
+	keys.Claim(ctx, "s", "k", "hash", time.Hour, time.Minute)
+	now = now.Add(2 * time.Minute)
+	if _, claimed, _ := keys.Claim(ctx, "s", "k", "hash", time.Hour, time.Minute); !claimed {
+		t.Fatal("abandoned claim was not taken over")
+	}
+	// The first request finishing late does not overwrite the new claim's
+	// response once that one has completed
+	keys.Complete(ctx, "s", "k", IdempotentResponse{RequestHash: "hash", Status: 200, Body: []byte("second")})
+	keys.Complete(ctx, "s", "k", IdempotentResponse{RequestHash: "hash", Status: 200, Body: []byte("first")})
+	stored, _, _ := keys.Claim(ctx, "s", "k", "hash", time.Hour, time.Minute)
+	if string(stored.Body) != "second" {
+		t.Fatalf("stored body = %q, want the first completed response", stored.Body)
+	}
+}
+
+// Key/value store running a function once, right after the next Get
+type racingKV struct {
+	KeyValueTTL
+	afterGet func()
+}
+
+func (r *racingKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, ok, err := r.KeyValueTTL.Get(ctx, key)
+	if race := r.afterGet; race != nil {
+		r.afterGet = nil
+		race()
+	}
+	return value, ok, err
+}
+
+// A request whose stale claim is taken over while it finishes leaves the
+// new claim alone
+func TestKVIdempotencyTakenOverClaim(t *testing.T) {
+	ctx := context.Background()
+	ttl, stale := time.Hour, time.Minute
+	finishes := map[string]func(keys *KVIdempotency) error{
+		"complete": func(keys *KVIdempotency) error {
+			return keys.Complete(ctx, "s", "k", IdempotentResponse{RequestHash: "hash", Status: 201, Body: []byte("late")})
+		},
+		"release": func(keys *KVIdempotency) error {
+			return keys.Release(ctx, "s", "k", "hash")
+		},
+	}
+	for name, finish := range finishes {
+		t.Run(name, func(t *testing.T) {
+			now := time.Unix(1_700_000_000, 0)
+			mem := NewMemoryKV()
+			mem.Now = func() time.Time { return now }
+			kv := &racingKV{KeyValueTTL: mem}
+			keys := NewKVIdempotency(kv)
+			keys.Now = mem.Now
+
+			if _, claimed, _ := keys.Claim(ctx, "s", "k", "hash", ttl, stale); !claimed {
+				t.Fatal("first Claim was refused")
+			}
+			now = now.Add(2 * time.Minute)
+			// A retry takes the stale claim over between the load and the write
+			kv.afterGet = func() {
+				if _, claimed, err := keys.Claim(ctx, "s", "k", "hash", ttl, stale); err != nil || !claimed {
+					t.Fatalf("takeover = %v, %v, want claimed", claimed, err)
+				}
+			}
+			if err := finish(keys); err != nil {
+				t.Fatal(err)
+			}
+			if kv.afterGet != nil {
+				t.Fatal("the takeover did not run")
+			}
+
+			stored, claimed, err := keys.Claim(ctx, "s", "k", "hash", ttl, stale)
+			if err != nil || claimed || stored.Status != 0 {
+				t.Fatalf("Claim after the race = %+v, %v, %v, want the running takeover", stored, claimed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys that expire, holding the state of rate limits and idempotency keys
+// Replicas share it with the Postgres and Redis backends, the memory one is
+// for a single instance. Every key is written with a positive ttl.
+type KeyValueTTL interface {
+	// Value of a key, false when it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set a key and return the value it had, false when it had none
+	GetSet(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, bool, error)
+	// Add delta to a counter, created with ttl when it is missing
+	// The expiry of an existing counter is left alone.
+	IncrWithExpiry(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Set a key unless it exists, false when it did
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Set a key only while it still holds old, false when it does not
+	CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+	// Delete a key only while it still holds old, false when it does not
+	CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Backends a KeyValueTTL can be kept in
+const (
+	KVMemory   = "memory"
+	KVPostgres = "postgres"
+	KVRedis    = "redis"
+)
+
+// Open the key/value store of a backend
+// Redis needs redisURL, Postgres the kv_entries table of the migrations.
+func OpenKeyValue(backend string, db *sql.DB, redisURL string) (KeyValueTTL, error) {
+	switch backend {
+	case KVMemory:
+		return NewMemoryKV(), nil
+	case KVPostgres:
+		return NewPostgresKV(db), nil
+	case KVRedis:
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisKV(redis.NewClient(opts)), nil
+	}
+	return nil, errors.New("unknown key/value backend " + strconv.Quote(backend))
+}
+
+// Remove expired keys every interval until ctx is done, for the backends
+// that do not expire them on their own
+func StartKVPurge(ctx context.Context, kv KeyValueTTL, interval time.Duration) {
+	purger, ok := kv.(interface {
+		Purge(ctx context.Context) (int64, error)
+	})
+	if !ok {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purgeKV(ctx, purger.Purge)
+			}
+		}
+	}()
+}
+
+func purgeKV(ctx context.Context, purge func(ctx context.Context) (int64, error)) {
+	purged, err := purge(ctx)
+	if err != nil {
+		log.Printf("Error purging expired keys: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired keys", purged)
+	}
+}
+
+// Keys kept in memory, lost on restart and not shared between replicas
+type MemoryKV struct {
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{Now: time.Now, entries: map[string]memoryEntry{}}
+}
+
+// Entry of a key that has not expired, call with the lock held
+func (m *MemoryKV) live(key string) (memoryEntry, bool) {
+	entry, ok := m.entries[key]
+	if ok && !m.Now().Before(entry.expires) {
+		delete(m.entries, key)
+		return entry, false
+	}
+	return entry, ok
+}
+
+func (m *MemoryKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.live(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte(nil), entry.value...), true, nil
+}
+
+func (m *MemoryKV) GetSet(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.live(key)
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: m.Now().Add(ttl)}
+	if !ok {
+		return nil, false, nil
+	}
+	return old.value, true, nil
+}
+
+func (m *MemoryKV) IncrWithExpiry(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.live(key)
+	var n int64
+	if ok {
+		var err error
+		if n, err = strconv.ParseInt(string(entry.value), 10, 64); err != nil {
+			return 0, errors.New("value of " + key + " is not a counter")
+		}
+	} else {
+		entry.expires = m.Now().Add(ttl)
+	}
+	n += delta
+	entry.value = strconv.AppendInt(nil, n, 10)
+	m.entries[key] = entry
+	return n, nil
+}
+
+func (m *MemoryKV) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.live(key); ok {
+		return false, nil
+	}
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: m.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryKV) CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.live(key); !ok || !bytes.Equal(entry.value, old) {
+		return false, nil
+	}
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: m.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryKV) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.live(key); !ok || !bytes.Equal(entry.value, old) {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+func (m *MemoryKV) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *MemoryKV) Purge(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.Now()
+	var purged int64
+	for key, entry := range m.entries {
+		if !now.Before(entry.expires) {
+			delete(m.entries, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Keys in the UNLOGGED kv_entries table
+// Writes skip the WAL, so the table is emptied after a crash, which only
+// resets rate limits and forgets idempotency keys. Expired rows are ignored
+// by every query and removed by Purge.
+type PostgresKV struct {
+	db *sql.DB
+}
+
+func NewPostgresKV(db *sql.DB) *PostgresKV {
+	return &PostgresKV{db: db}
+}
+
+func (s *PostgresKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	done := TrackQuery(ctx, "kv.get")
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM kv_entries WHERE key = $1 AND expires_at > now()", key).Scan(&value)
+	done(1)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *PostgresKV) GetSet(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, bool, error) {
+	var old []byte
+	done := TrackQuery(ctx, "kv.get_set")
+	err := s.db.QueryRowContext(ctx, `WITH old AS (SELECT value FROM kv_entries WHERE key = $1 AND expires_at > now() FOR UPDATE)
+		INSERT INTO kv_entries (key, value, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+		RETURNING (SELECT value FROM old)`, key, value, ttl.Seconds()).Scan(&old)
+	done(1)
+	return old, old != nil, err
+}
+
+func (s *PostgresKV) IncrWithExpiry(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	var n int64
+	done := TrackQuery(ctx, "kv.incr")
+	// Counters are stored as decimal text, like Redis does
+	err := s.db.QueryRowContext(ctx, `INSERT INTO kv_entries (key, value, expires_at) VALUES ($1, convert_to($2::bigint::text, 'UTF8'), now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET
+			value = CASE WHEN kv_entries.expires_at > now() THEN convert_to((convert_from(kv_entries.value, 'UTF8')::bigint + $2::bigint)::text, 'UTF8') ELSE EXCLUDED.value END,
+			expires_at = CASE WHEN kv_entries.expires_at > now() THEN kv_entries.expires_at ELSE EXCLUDED.expires_at END
+		RETURNING convert_from(value, 'UTF8')::bigint`, key, delta, ttl.Seconds()).Scan(&n)
+	done(1)
+	return n, err
+}
+
+func (s *PostgresKV) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	done := TrackQuery(ctx, "kv.set_nx")
+	// An expired row counts as missing and is taken over in place
+	result, err := s.db.ExecContext(ctx, `INSERT INTO kv_entries (key, value, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at WHERE kv_entries.expires_at <= now()`,
+		key, value, ttl.Seconds())
+	done(1)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (s *PostgresKV) CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	done := TrackQuery(ctx, "kv.compare_and_swap")
+	result, err := s.db.ExecContext(ctx, `UPDATE kv_entries SET value = $3, expires_at = now() + make_interval(secs => $4)
+		WHERE key = $1 AND value = $2 AND expires_at > now()`, key, old, value, ttl.Seconds())
+	done(1)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (s *PostgresKV) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	done := TrackQuery(ctx, "kv.compare_and_delete")
+	result, err := s.db.ExecContext(ctx, "DELETE FROM kv_entries WHERE key = $1 AND value = $2 AND expires_at > now()", key, old)
+	done(1)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (s *PostgresKV) Delete(ctx context.Context, key string) error {
+	done := TrackQuery(ctx, "kv.delete")
+	defer done(1)
+	_, err := s.db.ExecContext(ctx, "DELETE FROM kv_entries WHERE key = $1", key)
+	return err
+}
+
+func (s *PostgresKV) Purge(ctx context.Context) (int64, error) {
+	done := TrackQuery(ctx, "kv.purge")
+	result, err := s.db.ExecContext(ctx, "DELETE FROM kv_entries WHERE expires_at <= now()")
+	if err != nil {
+		done(0)
+		return 0, err
+	}
+	rows, _ := result.RowsAffected()
+	done(rows)
+	return rows, nil
+}
+
+// Keys in Redis, which expires them itself
+type RedisKV struct {
+	client redis.UniversalClient
+}
+
+func NewRedisKV(client redis.UniversalClient) *RedisKV {
+	return &RedisKV{client: client}
+}
+
+// INCRBY that only sets the expiry of a new counter, in one round trip
+var redisIncrWithExpiry = redis.NewScript(`
+local n = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return n`)
+
+// SET and DEL that only go ahead while the key holds ARGV[1]
+var (
+	redisCompareAndSwap = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1`)
+	redisCompareAndDelete = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])`)
+)
+
+func (s *RedisKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *RedisKV) GetSet(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, bool, error) {
+	old, err := s.client.SetArgs(ctx, key, value, redis.SetArgs{TTL: ttl, Get: true}).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	return old, err == nil, err
+}
+
+func (s *RedisKV) IncrWithExpiry(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return redisIncrWithExpiry.Run(ctx, s.client, []string{key}, delta, ttl.Milliseconds()).Int64()
+}
+
+func (s *RedisKV) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (s *RedisKV) CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	n, err := redisCompareAndSwap.Run(ctx, s.client, []string{key}, old, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s *RedisKV) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	n, err := redisCompareAndDelete.Run(ctx, s.client, []string{key}, old).Int()
+	return n == 1, err
+}
+
+func (s *RedisKV) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
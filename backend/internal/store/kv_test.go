@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// A backend under test and a way to move its clock forward
+type kvBackend struct {
+	kv      KeyValueTTL
+	advance func(d time.Duration)
+}
+
+// Every backend that can run here: memory and Redis on fake clocks, and
+// Postgres against TEST_DATABASE_URL when it is set
+func kvBackends(t *testing.T) map[string]func(t *testing.T) kvBackend {
+	return map[string]func(t *testing.T) kvBackend{
+		KVMemory: func(t *testing.T) kvBackend {
+			now := time.Unix(1_700_000_000, 0)
+			kv := NewMemoryKV()
+			kv.Now = func() time.Time { return now }
+			return kvBackend{kv: kv, advance: func(d time.Duration) { now = now.Add(d) }}
+		},
+		KVRedis: func(t *testing.T) kvBackend {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { client.Close() })
+			return kvBackend{kv: NewRedisKV(client), advance: server.FastForward}
+		},
+		KVPostgres: func(t *testing.T) kvBackend {
+			db := testDatabase(t)
+			if _, err := db.Exec("TRUNCATE kv_entries"); err != nil {
+				t.Fatal(err)
+			}
+			// Postgres expires on its own clock, so time really has to pass
+			return kvBackend{kv: NewPostgresKV(db), advance: time.Sleep}
+		},
+	}
+}
+
+// Migrated database of TEST_DATABASE_URL, skipping the test without one
+func testDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestKeyValueTTL(t *testing.T) {
+	ctx := context.Background()
+	for name, open := range kvBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Run("get missing", func(t *testing.T) {
+				b := open(t)
+				if _, ok, err := b.kv.Get(ctx, "missing"); err != nil || ok {
+					t.Fatalf("Get = %v, %v, want not found", ok, err)
+				}
+			})
+
+			t.Run("set nx", func(t *testing.T) {
+				b := open(t)
+				if ok, err := b.kv.SetNX(ctx, "k", []byte("first"), time.Second); err != nil || !ok {
+					t.Fatalf("first SetNX = %v, %v, want set", ok, err)
+				}
+				if ok, err := b.kv.SetNX(ctx, "k", []byte("second"), time.Second); err != nil || ok {
+					t.Fatalf("second SetNX = %v, %v, want refused", ok, err)
+				}
+				if v, ok, err := b.kv.Get(ctx, "k"); err != nil || !ok || string(v) != "first" {
+					t.Fatalf("Get = %q, %v, %v, want first", v, ok, err)
+				}
+			})
+
+			t.Run("get set", func(t *testing.T) {
+				b := open(t)
+				if old, ok, err := b.kv.GetSet(ctx, "k", []byte("a"), time.Second); err != nil || ok || old != nil {
+					t.Fatalf("GetSet on a missing key = %q, %v, %v", old, ok, err)
+				}
+				if old, ok, err := b.kv.GetSet(ctx, "k", []byte("b"), time.Second); err != nil || !ok || string(old) != "a" {
+					t.Fatalf("GetSet = %q, %v, %v, want a", old, ok, err)
+				}
+				if v, _, _ := b.kv.Get(ctx, "k"); string(v) != "b" {
+					t.Fatalf("Get = %q, want b", v)
+				}
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				b := open(t)
+				b.kv.SetNX(ctx, "k", []byte("v"), time.Second)
+				if err := b.kv.Delete(ctx, "k"); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok, _ := b.kv.Get(ctx, "k"); ok {
+					t.Fatal("key still there after Delete")
+				}
+				if err := b.kv.Delete(ctx, "k"); err != nil {
+					t.Fatalf("deleting a missing key: %v", err)
+				}
+			})
+
+			t.Run("compare and swap", func(t *testing.T) {
+				b := open(t)
+				if ok, err := b.kv.CompareAndSwap(ctx, "k", []byte("a"), []byte("b"), time.Second); err != nil || ok {
+					t.Fatalf("CompareAndSwap on a missing key = %v, %v, want refused", ok, err)
+				}
+				b.kv.SetNX(ctx, "k", []byte("a"), time.Second)
+				if ok, err := b.kv.CompareAndSwap(ctx, "k", []byte("other"), []byte("b"), time.Second); err != nil || ok {
+					t.Fatalf("CompareAndSwap from another value = %v, %v, want refused", ok, err)
+				}
+				if ok, err := b.kv.CompareAndSwap(ctx, "k", []byte("a"), []byte("b"), 2*time.Second); err != nil || !ok {
+					t.Fatalf("CompareAndSwap = %v, %v, want swapped", ok, err)
+				}
+				// The swap sets the ttl it was given
+				b.advance(1100 * time.Millisecond)
+				if v, _, _ := b.kv.Get(ctx, "k"); string(v) != "b" {
+					t.Fatalf("Get = %q, want b", v)
+				}
+				b.advance(time.Second)
+				if ok, _ := b.kv.CompareAndSwap(ctx, "k", []byte("b"), []byte("c"), time.Second); ok {
+					t.Fatal("CompareAndSwap swapped an expired key")
+				}
+			})
+
+			t.Run("compare and delete", func(t *testing.T) {
+				b := open(t)
+				b.kv.SetNX(ctx, "k", []byte("a"), time.Second)
+				if ok, err := b.kv.CompareAndDelete(ctx, "k", []byte("other")); err != nil || ok {
+					t.Fatalf("CompareAndDelete of another value = %v, %v, want refused", ok, err)
+				}
+				if ok, err := b.kv.CompareAndDelete(ctx, "k", []byte("a")); err != nil || !ok {
+					t.Fatalf("CompareAndDelete = %v, %v, want deleted", ok, err)
+				}
+				if _, ok, _ := b.kv.Get(ctx, "k"); ok {
+					t.Fatal("key still there after CompareAndDelete")
+				}
+				if ok, err := b.kv.CompareAndDelete(ctx, "k", []byte("a")); err != nil || ok {
+					t.Fatalf("CompareAndDelete of a missing key = %v, %v", ok, err)
+				}
+			})
+
+			t.Run("concurrent compare and swap", func(t *testing.T) {
+				b := open(t)
+				b.kv.SetNX(ctx, "k", []byte("start"), time.Minute)
+				var wg sync.WaitGroup
+				var mu sync.Mutex
+				var winners []string
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						value := "v" + strconv.Itoa(i)
+						ok, err := b.kv.CompareAndSwap(ctx, "k", []byte("start"), []byte(value), time.Minute)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if ok {
+							mu.Lock()
+							winners = append(winners, value)
+							mu.Unlock()
+						}
+					}()
+				}
+				wg.Wait()
+				if len(winners) != 1 {
+					t.Fatalf("%d swaps from the same value went ahead, want 1", len(winners))
+				}
+				if v, _, _ := b.kv.Get(ctx, "k"); string(v) != winners[0] {
+					t.Fatalf("Get = %q, want %s", v, winners[0])
+				}
+			})
+
+			t.Run("incr keeps the first expiry", func(t *testing.T) {
+				b := open(t)
+				for want := int64(1); want <= 3; want++ {
+					n, err := b.kv.IncrWithExpiry(ctx, "c", 1, 2*time.Second)
+					if err != nil || n != want {
+						t.Fatalf("IncrWithExpiry = %d, %v, want %d", n, err, want)
+					}
+					b.advance(500 * time.Millisecond)
+				}
+				if n, _ := b.kv.IncrWithExpiry(ctx, "c", -2, 2*time.Second); n != 1 {
+					t.Fatalf("IncrWithExpiry by -2 = %d, want 1", n)
+				}
+				// 1.5s passed, the counter started 2s ago once this has
+				b.advance(600 * time.Millisecond)
+				if n, _ := b.kv.IncrWithExpiry(ctx, "c", 1, 2*time.Second); n != 1 {
+					t.Fatalf("IncrWithExpiry after the expiry = %d, want a new counter at 1", n)
+				}
+			})
+
+			t.Run("expiry", func(t *testing.T) {
+				b := open(t)
+				b.kv.SetNX(ctx, "k", []byte("v"), time.Second)
+				b.advance(1100 * time.Millisecond)
+				if _, ok, _ := b.kv.Get(ctx, "k"); ok {
+					t.Fatal("key still there after its ttl")
+				}
+				if ok, err := b.kv.SetNX(ctx, "k", []byte("again"), time.Second); err != nil || !ok {
+					t.Fatalf("SetNX on an expired key = %v, %v, want set", ok, err)
+				}
+				if old, ok, _ := b.kv.GetSet(ctx, "k", []byte("x"), time.Second); !ok || string(old) != "again" {
+					t.Fatalf("GetSet = %q, %v, want again", old, ok)
+				}
+				b.advance(1100 * time.Millisecond)
+				if old, ok, _ := b.kv.GetSet(ctx, "k", []byte("y"), time.Second); ok {
+					t.Fatalf("GetSet on an expired key returned %q", old)
+				}
+			})
+
+			t.Run("concurrent incr", func(t *testing.T) {
+				b := open(t)
+				var wg sync.WaitGroup
+				seen := make([]bool, 51)
+				var mu sync.Mutex
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						n, err := b.kv.IncrWithExpiry(ctx, "c", 1, time.Minute)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						mu.Lock()
+						defer mu.Unlock()
+						if n < 1 || n > 50 || seen[n] {
+							t.Errorf("counter value %d handed out twice or out of range", n)
+							return
+						}
+						seen[n] = true
+					}()
+				}
+				wg.Wait()
+				if v, _, _ := b.kv.Get(ctx, "c"); string(v) != strconv.Itoa(50) {
+					t.Fatalf("counter = %s, want 50", v)
+				}
+			})
+
+			t.Run("concurrent set nx", func(t *testing.T) {
+				b := open(t)
+				var wg sync.WaitGroup
+				var mu sync.Mutex
+				winners := 0
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						ok, err := b.kv.SetNX(ctx, "k", []byte("v"), time.Minute)
+						if err != nil {
+							t.Error(err)
+						}
+						if ok {
+							mu.Lock()
+							winners++
+							mu.Unlock()
+						}
+					}()
+				}
+				wg.Wait()
+				if winners != 1 {
+					t.Fatalf("%d SetNX calls won, want exactly 1", winners)
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryKVPurge(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	kv := NewMemoryKV()
+	kv.Now = func() time.Time { return now }
+	ctx := context.Background()
+	kv.SetNX(ctx, "short", []byte("v"), time.Second)
+	kv.SetNX(ctx, "long", []byte("v"), time.Hour)
+
+	now = now.Add(time.Minute)
+	if purged, _ := kv.Purge(ctx); purged != 1 {
+		t.Fatalf("purged %d keys, want 1", purged)
+	}
+	if len(kv.entries) != 1 {
+		t.Fatalf("%d keys left, want only the long-lived one", len(kv.entries))
+	}
+}
@@ -0,0 +1,603 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Users, audit log and receipts kept in memory, for tests
+// Behaves like the Postgres stores, transactions included: RunInTx works
+// on a copy that replaces the stored rows only once fn returns nil.
+// Transactions run one at a time, and calls on the store itself block
+// while one is running.
+type Memory struct {
+	Now func() time.Time
+
+	mu    *sync.Mutex
+	state *memoryState
+	// A store handed to a transaction, already holding mu
+	inTx bool
+}
+
+// Rows of a memory store
+type memoryState struct {
+	users       map[models.ID]memoryUser
+	audit       []models.AuditEntry
+	receipts    []Receipt
+	tokens      map[string]memoryToken
+	lastUser    models.ID
+	lastAuditId int64
+}
+
+// A refresh token row, keyed by its hash
+type memoryToken struct {
+	userId  models.ID
+	family  string
+	expires time.Time
+	used    bool
+	revoked bool
+}
+
+// A user row with the columns users never see
+type memoryUser struct {
+	user         models.User
+	passwordHash string
+	anonymized   bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{Now: time.Now, mu: &sync.Mutex{}, state: &memoryState{users: map[models.ID]memoryUser{}, tokens: map[string]memoryToken{}}}
+}
+
+func (s *memoryState) clone() *memoryState {
+	c := *s
+	c.users = make(map[models.ID]memoryUser, len(s.users))
+	for id, u := range s.users {
+		c.users[id] = u
+	}
+	c.audit = append([]models.AuditEntry(nil), s.audit...)
+	c.receipts = append([]Receipt(nil), s.receipts...)
+	c.tokens = make(map[string]memoryToken, len(s.tokens))
+	for hash, token := range s.tokens {
+		c.tokens[hash] = token
+	}
+	return &c
+}
+
+// Lock the rows, unless a transaction already holds them
+func (m *Memory) lock() func() {
+	if m.inTx {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+func (m *Memory) now() time.Time {
+	return m.Now().UTC()
+}
+
+func (m *Memory) RunInTx(ctx context.Context, fn func(tx Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	unlock := m.lock()
+	defer unlock()
+
+	tx := &Memory{Now: m.Now, mu: m.mu, state: m.state.clone(), inTx: true}
+	if err := fn(Tx{Users: tx, Credentials: tx, Audit: tx.Audit(), Receipts: tx.Receipts()}); err != nil {
+		return err
+	}
+	*m.state = *tx.state
+	return nil
+}
+
+// Whether a user matches the filters of a list
+func (opts ListOptions) matches(u models.User) bool {
+	switch {
+	case u.DeletedAt != nil && !opts.IncludeDeleted:
+		return false
+	case opts.Email != "" && strings.ToLower(u.Email) != opts.Email:
+		return false
+	case opts.Name != "" && u.Name != opts.Name:
+		return false
+	case opts.Query != "":
+		q := strings.ToLower(opts.Query)
+		return strings.Contains(strings.ToLower(u.Name), q) || strings.Contains(strings.ToLower(u.Email), q)
+	}
+	return true
+}
+
+// Users matching the filters, in id order
+func (m *Memory) filter(opts ListOptions) []models.User {
+	users := []models.User{}
+	for _, u := range m.state.users {
+		if opts.matches(u.user) {
+			users = append(users, u.user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return users
+}
+
+func (m *Memory) List(ctx context.Context, opts ListOptions) ([]models.User, int64, error) {
+	if _, ok := SortColumns[opts.Sort]; !ok {
+		return nil, 0, fmt.Errorf("unknown sort column %q", opts.Sort)
+	}
+	if opts.Sort == "name" {
+		var none *Collations
+		if _, err := none.Check(opts.Collation); err != nil {
+			return nil, 0, err
+		}
+	}
+	unlock := m.lock()
+	defer unlock()
+
+	users := m.filter(opts)
+	total := int64(len(users))
+	less := func(a, b models.User) bool {
+		switch opts.Sort {
+		case "name":
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		case "email":
+			if a.Email != b.Email {
+				return a.Email < b.Email
+			}
+		case "created_at":
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		}
+		return a.Id < b.Id
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if opts.Desc {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+
+	page := []models.User{}
+	skipped := 0
+	for _, u := range users {
+		if opts.HasAfter && (!opts.Desc && u.Id <= opts.AfterId || opts.Desc && u.Id >= opts.AfterId) {
+			continue
+		}
+		if skipped < opts.Offset {
+			skipped++
+			continue
+		}
+		if len(page) == opts.Limit {
+			break
+		}
+		page = append(page, u)
+	}
+	return page, total, nil
+}
+
+func (m *Memory) Export(ctx context.Context, opts ListOptions, fn func(models.User) error) error {
+	unlock := m.lock()
+	users := m.filter(opts)
+	unlock()
+
+	for _, u := range users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id models.ID) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	u, ok := m.state.users[id]
+	if !ok || u.user.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	return u.user, nil
+}
+
+func (m *Memory) Lock(ctx context.Context, id models.ID) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	u, ok := m.state.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return u.user, nil
+}
+
+// Whether a user other than id has an email, compared case-insensitively
+func (m *Memory) emailTaken(email string, id models.ID) bool {
+	for _, u := range m.state.users {
+		if u.user.Id != id && strings.EqualFold(u.user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a user row, ErrEmailTaken when the email is used
+func (m *Memory) insert(user models.User, passwordHash string) (models.User, error) {
+	if m.emailTaken(user.Email, 0) {
+		return models.User{}, ErrEmailTaken
+	}
+	m.state.lastUser++
+	now := m.now()
+	created := models.User{Id: m.state.lastUser, Name: user.Name, Email: user.Email, CreatedAt: now, UpdatedAt: now, Version: 1}
+	m.state.users[created.Id] = memoryUser{user: created, passwordHash: passwordHash}
+	return created, nil
+}
+
+func (m *Memory) Create(ctx context.Context, user models.User) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	return m.insert(user, "")
+}
+
+func (m *Memory) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	rowErrs := RowErrors{}
+	seen := map[string]bool{}
+	for i, user := range users {
+		email := strings.ToLower(user.Email)
+		if seen[email] || m.emailTaken(email, 0) {
+			rowErrs[i] = ErrEmailTaken
+		}
+		seen[email] = true
+	}
+	if len(rowErrs) > 0 {
+		return nil, rowErrs
+	}
+
+	created := make([]models.User, 0, len(users))
+	for _, user := range users {
+		user.Email = strings.ToLower(user.Email)
+		u, err := m.insert(user, "")
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, u)
+	}
+	return created, nil
+}
+
+func (m *Memory) CreateWithPassword(ctx context.Context, user models.User, passwordHash string) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	return m.insert(user, passwordHash)
+}
+
+func (m *Memory) PasswordHash(ctx context.Context, email string) (models.ID, string, error) {
+	unlock := m.lock()
+	defer unlock()
+	for _, u := range m.state.users {
+		if strings.EqualFold(u.user.Email, email) && u.passwordHash != "" && u.user.DeletedAt == nil {
+			return u.user.Id, u.passwordHash, nil
+		}
+	}
+	return 0, "", ErrNotFound
+}
+
+// Change a user that is not soft-deleted, bumping its version
+func (m *Memory) change(id models.ID, fn func(u *memoryUser)) (models.User, error) {
+	u, ok := m.state.users[id]
+	if !ok || u.user.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	fn(&u)
+	u.user.UpdatedAt = m.now()
+	u.user.Version++
+	m.state.users[id] = u
+	return u.user, nil
+}
+
+func (m *Memory) Update(ctx context.Context, id models.ID, user models.User, version int64) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	current, ok := m.state.users[id]
+	if !ok || current.user.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	if version != 0 && current.user.Version != version {
+		return current.user, ErrVersionMismatch
+	}
+	if m.emailTaken(user.Email, id) {
+		return models.User{}, ErrEmailTaken
+	}
+	return m.change(id, func(u *memoryUser) {
+		u.user.Name, u.user.Email = user.Name, user.Email
+	})
+}
+
+func (m *Memory) SetAvatar(ctx context.Context, id models.ID, avatar *models.Avatar) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	return m.change(id, func(u *memoryUser) {
+		u.user.Avatar, u.user.AvatarURL = nil, ""
+		if avatar != nil {
+			a := *avatar
+			u.user.Avatar, u.user.AvatarURL = &a, models.AvatarURL(id, a)
+		}
+	})
+}
+
+func (m *Memory) Delete(ctx context.Context, id models.ID) ([]models.ID, error) {
+	unlock := m.lock()
+	defer unlock()
+	_, err := m.change(id, func(u *memoryUser) {
+		now := m.now()
+		u.user.DeletedAt = &now
+	})
+	if err == ErrNotFound {
+		return []models.ID{}, nil
+	}
+	return []models.ID{id}, err
+}
+
+func (m *Memory) HardDelete(ctx context.Context, id models.ID) ([]models.ID, error) {
+	unlock := m.lock()
+	defer unlock()
+	if _, ok := m.state.users[id]; !ok {
+		return []models.ID{}, nil
+	}
+	delete(m.state.users, id)
+	return []models.ID{id}, nil
+}
+
+func (m *Memory) Restore(ctx context.Context, id models.ID) (models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	u, ok := m.state.users[id]
+	if !ok || u.user.DeletedAt == nil {
+		return models.User{}, ErrNotFound
+	}
+	u.user.DeletedAt = nil
+	u.user.UpdatedAt = m.now()
+	u.user.Version++
+	m.state.users[id] = u
+	return u.user, nil
+}
+
+func (m *Memory) LockStale(ctx context.Context, cutoff time.Time, limit int) ([]models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	stale := []models.User{}
+	for _, u := range m.filter(ListOptions{IncludeDeleted: true}) {
+		if len(stale) == limit {
+			break
+		}
+		if u.UpdatedAt.Before(cutoff) && !m.state.users[u.Id].anonymized {
+			stale = append(stale, u)
+		}
+	}
+	return stale, nil
+}
+
+func (m *Memory) Anonymize(ctx context.Context, ids []models.ID) ([]models.User, error) {
+	unlock := m.lock()
+	defer unlock()
+	users := []models.User{}
+	for _, id := range ids {
+		u, ok := m.state.users[id]
+		if !ok {
+			continue
+		}
+		u.user.Name = "Anonymized user"
+		u.user.Email = "anonymized-" + id.String() + "@anonymized.invalid"
+		u.user.Avatar, u.user.AvatarURL = nil, ""
+		u.user.UpdatedAt = m.now()
+		u.user.Version++
+		u.passwordHash, u.anonymized = "", true
+		m.state.users[id] = u
+		users = append(users, u.user)
+	}
+	return users, nil
+}
+
+func (m *Memory) SignupsPerDay(ctx context.Context, since time.Time) ([]models.DailyCount, error) {
+	unlock := m.lock()
+	defer unlock()
+	counts := map[string]int64{}
+	for _, u := range m.state.users {
+		if !u.user.CreatedAt.Before(since) {
+			counts[u.user.CreatedAt.UTC().Format("2006-01-02")]++
+		}
+	}
+	days := []models.DailyCount{}
+	for day, count := range counts {
+		days = append(days, models.DailyCount{Date: day, Count: count})
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days, nil
+}
+
+// Audit log of a memory store
+type MemoryAudit struct {
+	m *Memory
+}
+
+func (m *Memory) Audit() *MemoryAudit {
+	return &MemoryAudit{m: m}
+}
+
+func (a *MemoryAudit) Record(ctx context.Context, entries ...models.AuditEntry) error {
+	unlock := a.m.lock()
+	defer unlock()
+	for _, entry := range entries {
+		a.m.state.lastAuditId++
+		entry.Id = a.m.state.lastAuditId
+		entry.CreatedAt = a.m.now()
+		if string(entry.OldValue) == "null" {
+			entry.OldValue = nil
+		}
+		if string(entry.NewValue) == "null" {
+			entry.NewValue = nil
+		}
+		a.m.state.audit = append(a.m.state.audit, entry)
+	}
+	return nil
+}
+
+func (a *MemoryAudit) List(ctx context.Context, opts AuditListOptions) ([]models.AuditEntry, int64, error) {
+	unlock := a.m.lock()
+	defer unlock()
+
+	// Newest first, entries are kept in the order they were recorded
+	matching := []models.AuditEntry{}
+	for i := len(a.m.state.audit) - 1; i >= 0; i-- {
+		e := a.m.state.audit[i]
+		switch {
+		case opts.Entity != "" && e.Entity != opts.Entity,
+			opts.EntityId != 0 && e.EntityId != opts.EntityId,
+			opts.Action != "" && e.Action != opts.Action,
+			opts.Actor != "" && e.Actor != opts.Actor,
+			!opts.Since.IsZero() && e.CreatedAt.Before(opts.Since),
+			!opts.Until.IsZero() && !e.CreatedAt.Before(opts.Until):
+			continue
+		}
+		matching = append(matching, e)
+	}
+	total := int64(len(matching))
+	if opts.Offset >= len(matching) {
+		return []models.AuditEntry{}, total, nil
+	}
+	matching = matching[opts.Offset:]
+	if len(matching) > opts.Limit {
+		matching = matching[:opts.Limit]
+	}
+	return matching, total, nil
+}
+
+func (a *MemoryAudit) ForgetValues(ctx context.Context, entity string, ids []models.ID) error {
+	unlock := a.m.lock()
+	defer unlock()
+	forget := map[models.ID]bool{}
+	for _, id := range ids {
+		forget[id] = true
+	}
+	for i, entry := range a.m.state.audit {
+		if entry.Entity == entity && forget[entry.EntityId] {
+			a.m.state.audit[i].OldValue, a.m.state.audit[i].NewValue = nil, nil
+		}
+	}
+	return nil
+}
+
+// Deletion receipts of a memory store
+type MemoryReceipts struct {
+	m *Memory
+}
+
+func (m *Memory) Receipts() *MemoryReceipts {
+	return &MemoryReceipts{m: m}
+}
+
+func (r *MemoryReceipts) Save(ctx context.Context, receipt Receipt) error {
+	unlock := r.m.lock()
+	defer unlock()
+	for _, stored := range r.m.state.receipts {
+		if stored.Id == receipt.Id {
+			return fmt.Errorf("receipt %s already exists", receipt.Id)
+		}
+	}
+	r.m.state.receipts = append(r.m.state.receipts, receipt)
+	return nil
+}
+
+func (r *MemoryReceipts) Get(ctx context.Context, id string) (Receipt, error) {
+	return r.find(func(stored Receipt) bool { return stored.Id == id })
+}
+
+func (r *MemoryReceipts) Latest(ctx context.Context, userId models.ID, operation string) (Receipt, error) {
+	return r.find(func(stored Receipt) bool { return stored.UserId == userId && stored.Operation == operation })
+}
+
+// Most recently saved receipt that matches
+func (r *MemoryReceipts) find(match func(Receipt) bool) (Receipt, error) {
+	unlock := r.m.lock()
+	defer unlock()
+	for i := len(r.m.state.receipts) - 1; i >= 0; i-- {
+		if match(r.m.state.receipts[i]) {
+			return r.m.state.receipts[i], nil
+		}
+	}
+	return Receipt{}, ErrReceiptNotFound
+}
+
+// Refresh tokens of a memory store, for its users
+type MemoryRefreshTokens struct {
+	m *Memory
+}
+
+func (m *Memory) RefreshTokens() *MemoryRefreshTokens {
+	return &MemoryRefreshTokens{m: m}
+}
+
+func (r *MemoryRefreshTokens) Create(ctx context.Context, userId models.ID, family, tokenHash string, expires time.Time) error {
+	unlock := r.m.lock()
+	defer unlock()
+	r.m.state.tokens[tokenHash] = memoryToken{userId: userId, family: family, expires: expires}
+	return nil
+}
+
+func (r *MemoryRefreshTokens) Rotate(ctx context.Context, tokenHash, newHash string, expires time.Time) (models.ID, error) {
+	unlock := r.m.lock()
+	defer unlock()
+	token, ok := r.m.state.tokens[tokenHash]
+	if !ok {
+		return 0, ErrRefreshTokenInvalid
+	}
+	if token.used {
+		for hash, t := range r.m.state.tokens {
+			if t.family == token.family {
+				t.revoked = true
+				r.m.state.tokens[hash] = t
+			}
+		}
+		return token.userId, ErrRefreshTokenReused
+	}
+	u, ok := r.m.state.users[token.userId]
+	if token.revoked || !token.expires.After(r.m.now()) || !ok || u.user.DeletedAt != nil || u.passwordHash == "" {
+		return 0, ErrRefreshTokenInvalid
+	}
+	token.used = true
+	r.m.state.tokens[tokenHash] = token
+	r.m.state.tokens[newHash] = memoryToken{userId: token.userId, family: token.family, expires: expires}
+	return token.userId, nil
+}
+
+func (r *MemoryRefreshTokens) Purge(ctx context.Context) (int64, error) {
+	unlock := r.m.lock()
+	defer unlock()
+	var purged int64
+	for hash, token := range r.m.state.tokens {
+		if token.expires.Before(r.m.now()) {
+			delete(r.m.state.tokens, hash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+var (
+	_ UserStore       = (*Memory)(nil)
+	_ CredentialStore = (*Memory)(nil)
+	_ Transactor      = (*Memory)(nil)
+	_ AuditStore      = (*MemoryAudit)(nil)
+	_ ReceiptStore    = (*MemoryReceipts)(nil)
+
+	_ RefreshTokenStore = (*MemoryRefreshTokens)(nil)
+)
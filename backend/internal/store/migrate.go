@@ -0,0 +1,329 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes, applied in the order of their numeric prefix
+// A migration NNNN_name.sql can be undone by NNNN_name.down.sql next to it.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Advisory lock held while migrating, so instances starting together take
+// turns
+const migrationLockId = 7_246_118_001
+
+// Suffix of the files undoing a migration
+const downSuffix = ".down.sql"
+
+// One embedded migration file
+type Migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Down     string // empty when the migration cannot be undone
+	Checksum string
+}
+
+// Embedded migrations, sorted by version
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	seen := map[int]string{}
+	downs := []string{}
+	for _, path := range names {
+		name := strings.TrimPrefix(path, "migrations/")
+		if strings.HasSuffix(name, downSuffix) {
+			downs = append(downs, name)
+			continue
+		}
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s does not start with a version number", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		body, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(body)
+		m := Migration{
+			Version:  version,
+			Name:     name,
+			SQL:      string(body),
+			Checksum: hex.EncodeToString(sum[:]),
+		}
+		downName := strings.TrimSuffix(name, ".sql") + downSuffix
+		if down, err := migrationFiles.ReadFile("migrations/" + downName); err == nil {
+			m.Down = string(down)
+		}
+		migrations = append(migrations, m)
+	}
+	for _, name := range downs {
+		if _, err := fs.Stat(migrationFiles, "migrations/"+strings.TrimSuffix(name, downSuffix)+".sql"); err != nil {
+			return nil, fmt.Errorf("%s does not undo any migration", name)
+		}
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// A migration as the database has it
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrations recorded in schema_migrations, by version
+// Empty when the table does not exist yet.
+func appliedMigrations(ctx context.Context, q querier) (map[int]AppliedMigration, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]AppliedMigration{}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Version, &m.Name, &m.Checksum, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[m.Version] = m
+	}
+	return applied, rows.Err()
+}
+
+// Take the migration lock on a connection of its own
+// The lock is session level, so release has to run on the same connection.
+func lockMigrations(ctx context.Context, db *sql.DB) (*sql.Conn, func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockId); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("locking migrations: %w", err)
+	}
+	return conn, func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockId)
+		conn.Close()
+	}, nil
+}
+
+// Apply the migrations a database is missing, each in its own transaction
+// Fails when a migration that was already applied has been edited since.
+func MigrateUp(db *sql.DB) error {
+	ctx := context.Background()
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	conn, unlock, err := lockMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INT PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())")
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	known := map[int]bool{}
+	for _, m := range migrations {
+		known[m.Version] = true
+		if a, ok := applied[m.Version]; ok {
+			if a.Checksum != m.Checksum {
+				return fmt.Errorf("migration %s was changed after it was applied (checksum %s, applied %s), add a new migration instead", m.Name, m.Checksum, a.Checksum)
+			}
+			continue
+		}
+
+		if err := applyMigration(ctx, conn, m.SQL, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)", m.Version, m.Name, m.Checksum); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		log.Printf("Applied migration %s", m.Name)
+	}
+	for version := range applied {
+		if !known[version] {
+			log.Printf("Warning: the database has migration %d applied, which this build does not know about", version)
+		}
+	}
+	return nil
+}
+
+// Undo the last steps migrations applied, newest first
+// Stops at a migration without a down file, or one this build does not know.
+func MigrateDown(db *sql.DB, steps int) error {
+	ctx := context.Background()
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	byVersion := map[int]Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	conn, unlock, err := lockMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	for _, version := range versions {
+		m, ok := byVersion[version]
+		switch {
+		case !ok:
+			return fmt.Errorf("migration %d is not known to this build, undo it with the build that applied it", version)
+		case m.Down == "":
+			return fmt.Errorf("migration %s cannot be undone, it has no %s file", m.Name, downSuffix)
+		case applied[version].Checksum != m.Checksum:
+			return fmt.Errorf("migration %s was changed after it was applied, refusing to undo it", m.Name)
+		}
+		if err := applyMigration(ctx, conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+			return fmt.Errorf("undoing migration %s: %w", m.Name, err)
+		}
+		log.Printf("Reverted migration %s", m.Name)
+	}
+	return nil
+}
+
+// Where each migration stands in a database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Changed   bool // edited after it was applied
+	Unknown   bool // applied but not in this build
+}
+
+// Status of the embedded migrations and any the database has on top
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = &a.AppliedAt
+			s.Changed = a.Checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		status = append(status, s)
+	}
+	for _, a := range applied {
+		status = append(status, MigrationStatus{Version: a.Version, Name: a.Name, AppliedAt: &a.AppliedAt, Unknown: true})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Check the database has every migration of this build, unedited
+// Migrations from a newer build only get a warning, so an older build can
+// keep serving during a rollout.
+func CheckSchema(db *sql.DB) error {
+	status, err := Status(db)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range status {
+		switch {
+		case s.Unknown:
+			log.Printf("Warning: the database has migration %d applied, which this build does not know about", s.Version)
+		case s.Changed:
+			return fmt.Errorf("migration %s was changed after it was applied", s.Name)
+		case s.AppliedAt == nil:
+			pending = append(pending, s.Name)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("the database is missing %d migrations (%s), run the migrate up command", len(pending), strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Run a migration or its down file and record it in the same transaction
+func applyMigration(ctx context.Context, conn *sql.Conn, migration, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Bring the schema up to date
+// The migrations are required; the search indexes depend on the pg_trgm
+// extension being allowed, so they are retried on every start instead.
+func Migrate(db *sql.DB) error {
+	if err := MigrateUp(db); err != nil {
+		return err
+	}
+	EnsureSearchIndexes(db)
+	return nil
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// Undo the migrations from version on
+func migrateDownTo(t *testing.T, db *sql.DB, version int) {
+	t.Helper()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for _, m := range migrations {
+		if m.Version >= version {
+			steps++
+		}
+	}
+	if err := MigrateDown(db, steps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationsEmbedded(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.SQL == "" || len(m.Checksum) != 64 {
+			t.Fatalf("migration %s is empty or has no checksum", m.Name)
+		}
+		// Only the table every other migration builds on cannot be undone
+		if (m.Down == "") != (m.Version == 1) {
+			t.Fatalf("migration %s has down file %t", m.Name, m.Down != "")
+		}
+	}
+}
+
+// Applied versions of a database, in order
+func appliedVersions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	status, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := testDatabase(t)
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := MigrateUp(db); err != nil {
+			t.Errorf("migrating back up: %v", err)
+		}
+	})
+
+	if err := MigrateDown(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations)-1 {
+		t.Fatalf("applied after one step down = %v", got)
+	}
+	if err := CheckSchema(db); err == nil || !strings.Contains(err.Error(), migrations[len(migrations)-1].Name) {
+		t.Fatalf("CheckSchema with the last migration undone = %v", err)
+	}
+
+	err = MigrateDown(db, len(migrations))
+	if err == nil || !strings.Contains(err.Error(), "0001_create_users.sql cannot be undone") {
+		t.Fatalf("MigrateDown past the first migration = %v", err)
+	}
+	if got := appliedVersions(t, db); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("applied after undoing everything = %v, want [1]", got)
+	}
+
+	if err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations) {
+		t.Fatalf("applied after migrating up = %v", got)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Fatalf("CheckSchema after migrating up: %v", err)
+	}
+	// Nothing left to apply the second time
+	if err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateRefusesEditedMigrations(t *testing.T) {
+	db := testDatabase(t)
+	var checksum string
+	if err := db.QueryRow("SELECT checksum FROM schema_migrations WHERE version = 2").Scan(&checksum); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE schema_migrations SET checksum = 'edited' WHERE version = 2"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("UPDATE schema_migrations SET checksum = $1 WHERE version = 2", checksum) })
+
+	if err := MigrateUp(db); err == nil || !strings.Contains(err.Error(), "0002_user_timestamps.sql was changed after it was applied") {
+		t.Fatalf("MigrateUp = %v, want a checksum mismatch", err)
+	}
+	if err := CheckSchema(db); err == nil || !strings.Contains(err.Error(), "was changed") {
+		t.Fatalf("CheckSchema = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestUniqueEmailsMigration(t *testing.T) {
+	db := testDatabase(t)
+	migrateDownTo(t, db, 13)
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM users WHERE email LIKE '%@unique-emails.test'")
+		if err := MigrateUp(db); err != nil {
+			t.Errorf("migrating back up: %v", err)
+		}
+	})
+
+	if _, err := db.Exec("INSERT INTO users (name, email) VALUES ('Ada', 'ada@unique-emails.test'), ('Ada', 'ADA@unique-emails.test')"); err != nil {
+		t.Fatal(err)
+	}
+	err := MigrateUp(db)
+	if err == nil || !strings.Contains(err.Error(), "0013_unique_emails.sql") || !strings.Contains(err.Error(), "array_agg(id)") {
+		t.Fatalf("MigrateUp with duplicate emails = %v, want 0013 to fail with the query finding them", err)
+	}
+	var missing bool
+	if err := db.QueryRow("SELECT to_regclass('users_email_key') IS NULL").Scan(&missing); err != nil || !missing {
+		t.Fatalf("users_email_key exists after the failed migration (%v)", err)
+	}
+
+	if _, err := db.Exec("DELETE FROM users WHERE email = 'ADA@unique-emails.test'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp once the duplicates are gone: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (name, email) VALUES ('Ada', 'Ada@unique-emails.test')")
+	if !isUniqueViolation(err) {
+		t.Fatalf("inserting a taken email in other case = %v, want a unique violation", err)
+	}
+
+	migrateDownTo(t, db, 13)
+	if err := db.QueryRow("SELECT to_regclass('users_email_key') IS NULL").Scan(&missing); err != nil || !missing {
+		t.Fatalf("users_email_key exists after 0013 was undone (%v)", err)
+	}
+}
@@ -0,0 +1,2 @@
+-- The original users table, a no-op on databases created before migrations
+CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name TEXT, email TEXT);
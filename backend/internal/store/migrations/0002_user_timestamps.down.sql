@@ -0,0 +1,3 @@
+-- created_at and updated_at, with their index
+DROP INDEX IF EXISTS users_created_at;
+ALTER TABLE users DROP COLUMN IF EXISTS created_at, DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,7 @@
+-- Rows that already exist get the time of the migration, their real creation
+-- time is not known
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS users_created_at ON users (created_at);
@@ -0,0 +1,2 @@
+-- Every password is lost
+ALTER TABLE users DROP COLUMN IF EXISTS password_hash;
@@ -0,0 +1,2 @@
+-- Only users that registered with a password can log in
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT;
@@ -0,0 +1,2 @@
+-- Every receipt is lost
+DROP TABLE IF EXISTS receipts;
@@ -0,0 +1,10 @@
+-- Signed deletion receipts
+CREATE TABLE IF NOT EXISTS receipts (
+    id TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    operation TEXT NOT NULL,
+    body TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
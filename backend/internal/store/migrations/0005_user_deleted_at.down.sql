@@ -0,0 +1,2 @@
+-- Soft-deleted users come back, remove them first to keep them gone
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,2 @@
+-- Deleted users keep their row until they are erased, and can be restored
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
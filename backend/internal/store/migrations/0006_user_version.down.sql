@@ -0,0 +1,2 @@
+-- Conditional requests with an old ETag are no longer refused
+ALTER TABLE users DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+-- Bumped on every change, for optimistic concurrency with ETag and If-Match
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
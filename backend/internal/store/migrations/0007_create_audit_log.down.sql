@@ -0,0 +1,2 @@
+-- The whole audit log is lost
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,3 @@
+-- Stored files are lost, the ones under UPLOAD_DIR stay
+DROP TABLE IF EXISTS uploads;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_etag, DROP COLUMN IF EXISTS avatar_type;
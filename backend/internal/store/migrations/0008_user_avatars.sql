@@ -0,0 +1,9 @@
+-- Avatar of a user, the file itself is kept in the upload storage
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_etag TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_type TEXT;
+-- Uploaded files when UPLOAD_DIR is not set
+CREATE TABLE IF NOT EXISTS uploads (
+    key TEXT PRIMARY KEY,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
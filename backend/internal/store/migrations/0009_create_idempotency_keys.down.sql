@@ -0,0 +1,2 @@
+-- Retries of writes sent before the rollback run again
+DROP TABLE IF EXISTS idempotency_keys;
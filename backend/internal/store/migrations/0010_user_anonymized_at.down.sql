@@ -0,0 +1,3 @@
+-- Anonymized users stay anonymized, but the sweep no longer skips them
+DROP INDEX IF EXISTS users_retention_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS anonymized_at;
@@ -0,0 +1,3 @@
+-- Set when the retention sweep anonymized a user, so it is not picked again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS users_retention_idx ON users (updated_at) WHERE anonymized_at IS NULL;
@@ -0,0 +1,2 @@
+-- Everyone has to log in again
+DROP TABLE IF EXISTS refresh_tokens;
@@ -0,0 +1,2 @@
+-- Rate limits start over and idempotency keys are forgotten
+DROP TABLE IF EXISTS kv_entries;
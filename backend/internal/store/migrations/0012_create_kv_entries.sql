@@ -0,0 +1,11 @@
+-- Keys with an expiry for rate limits and idempotency keys, shared by replicas
+-- UNLOGGED skips the WAL: the table is emptied after a crash and is not
+-- replicated, which only resets limits and forgets idempotency keys.
+-- It replaces idempotency_keys, which is left for older builds still running
+-- during a rollout.
+CREATE UNLOGGED TABLE IF NOT EXISTS kv_entries (
+    key TEXT PRIMARY KEY,
+    value BYTEA NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS kv_entries_expires_at_idx ON kv_entries (expires_at);
@@ -0,0 +1,2 @@
+-- Emails may be taken twice again
+DROP INDEX IF EXISTS users_email_key;
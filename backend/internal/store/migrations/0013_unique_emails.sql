@@ -0,0 +1,17 @@
+-- One user per email, compared case-insensitively, soft-deleted users
+-- included, so an email is only free again once its user is erased and
+-- restoring a user never collides
+-- Fails while existing rows collide; merge or remove them first.
+DO $$
+DECLARE
+    duplicates BIGINT;
+BEGIN
+    SELECT count(*) INTO duplicates FROM (
+        SELECT lower(email) FROM users WHERE email IS NOT NULL GROUP BY lower(email) HAVING count(*) > 1
+    ) d;
+    IF duplicates > 0 THEN
+        RAISE EXCEPTION '% emails are used by more than one user, merge or remove them before migrating. Find them with: SELECT lower(email), array_agg(id) FROM users GROUP BY lower(email) HAVING count(*) > 1', duplicates;
+    END IF;
+END
+$$;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email));
@@ -0,0 +1,3 @@
+-- idempotency_keys has been unused since kv_entries replaced it in 0012,
+-- every build still running reads and writes idempotency keys there
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,480 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Columns selected for a user, in the order ScanUser reads them
+const UserColumns = "id, name, email, created_at, updated_at, version, deleted_at, avatar_etag, avatar_type"
+
+// Row returned by QueryRow or Rows
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan a row selected with UserColumns
+// Timestamps are returned in UTC whatever the session time zone is.
+func ScanUser(row RowScanner, user *models.User) error {
+	var deletedAt sql.NullTime
+	var avatarETag, avatarType sql.NullString
+	err := row.Scan(&user.Id, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Version, &deletedAt, &avatarETag, &avatarType)
+	user.CreatedAt = user.CreatedAt.UTC()
+	user.UpdatedAt = user.UpdatedAt.UTC()
+	user.DeletedAt = nil
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		user.DeletedAt = &t
+	}
+	user.Avatar = nil
+	user.AvatarURL = ""
+	if avatarETag.Valid {
+		user.Avatar = &models.Avatar{ETag: avatarETag.String, ContentType: avatarType.String}
+		user.AvatarURL = models.AvatarURL(user.Id, *user.Avatar)
+	}
+	return err
+}
+
+// What queries run against, a database or a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Users stored in Postgres
+type Postgres struct {
+	db         querier
+	collations *Collations
+}
+
+// Store on a database, sorting names with the collations it provides
+func NewPostgres(db *sql.DB, collations *Collations) *Postgres {
+	return &Postgres{db: db, collations: collations}
+}
+
+func (s *Postgres) List(ctx context.Context, opts ListOptions) ([]models.User, int64, error) {
+	column, ok := SortColumns[opts.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort column %q", opts.Sort)
+	}
+	if opts.Sort == "name" {
+		if _, err := s.collations.Check(opts.Collation); err != nil {
+			return nil, 0, err
+		}
+		column = s.collations.nameColumn(opts.Collation)
+	}
+
+	where, args := listFilters(opts)
+
+	// Build the page query before running anything, so a retry runs the
+	// same statements
+	direction := "ASC"
+	comparison := ">"
+	if opts.Desc {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	// Only whitelisted columns and fixed keywords are put into the SQL
+	countWhere, countArgs := whereClause(where), append([]interface{}{}, args...)
+	if opts.HasAfter {
+		args = append(args, opts.AfterId)
+		where = append(where, fmt.Sprintf("id %s $%d", comparison, len(args)))
+	}
+	query := "SELECT " + UserColumns + " FROM users" + whereClause(where)
+	query += " ORDER BY " + column + " " + direction
+	if opts.Sort != "id" {
+		query += ", id " + direction
+	}
+	query += fmt.Sprintf(" LIMIT %d OFFSET %d", opts.Limit, opts.Offset)
+
+	var total int64
+	var users []models.User
+	err := retryRead(ctx, s.db, "users.list", func() error {
+		done := TrackQuery(ctx, "users.count")
+		err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM users"+countWhere, countArgs...).Scan(&total)
+		done(1)
+		if err != nil {
+			return err
+		}
+
+		done = TrackQuery(ctx, "users.list")
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			done(0)
+			return err
+		}
+		defer rows.Close()
+
+		users = []models.User{}
+		for rows.Next() {
+			var user models.User
+			err := ScanUser(rows, &user)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		done(int64(len(users)))
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Conditions for the filters of a list, with their values passed as arguments
+func listFilters(opts ListOptions) ([]string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+	if !opts.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if opts.Email != "" {
+		args = append(args, opts.Email)
+		where = append(where, fmt.Sprintf("lower(email) = $%d", len(args)))
+	}
+	if opts.Name != "" {
+		args = append(args, opts.Name)
+		where = append(where, fmt.Sprintf("name = $%d", len(args)))
+	}
+	if opts.Query != "" {
+		args = append(args, "%"+escapeLike(opts.Query)+"%")
+		where = append(where, fmt.Sprintf(`(name ILIKE $%d ESCAPE '\' OR email ILIKE $%d ESCAPE '\')`, len(args), len(args)))
+	}
+	return where, args
+}
+
+func (s *Postgres) Export(ctx context.Context, opts ListOptions, fn func(models.User) error) error {
+	where, args := listFilters(opts)
+	done := TrackQuery(ctx, "users.export")
+	// Only opening the query is retried, rows already handed to fn cannot be
+	var rows *sql.Rows
+	err := retryRead(ctx, s.db, "users.export", func() (err error) {
+		rows, err = s.db.QueryContext(ctx, "SELECT "+UserColumns+" FROM users"+whereClause(where)+" ORDER BY id", args...)
+		return err
+	})
+	if err != nil {
+		done(0)
+		return err
+	}
+	defer rows.Close()
+
+	count := 0
+	defer func() { done(int64(count)) }()
+	for rows.Next() {
+		var user models.User
+		if err := ScanUser(rows, &user); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		count++
+	}
+	return rows.Err()
+}
+
+func (s *Postgres) Get(ctx context.Context, id models.ID) (models.User, error) {
+	var user models.User
+	err := retryRead(ctx, s.db, "users.get", func() error {
+		done := TrackQuery(ctx, "users.get")
+		defer done(1)
+		return ScanUser(s.db.QueryRowContext(ctx, "SELECT "+UserColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", id), &user)
+	})
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *Postgres) Lock(ctx context.Context, id models.ID) (models.User, error) {
+	var user models.User
+	done := TrackQuery(ctx, "users.lock")
+	err := ScanUser(s.db.QueryRowContext(ctx, "SELECT "+UserColumns+" FROM users WHERE id = $1 FOR UPDATE", id), &user)
+	done(1)
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *Postgres) Create(ctx context.Context, user models.User) (models.User, error) {
+	var created models.User
+	done := TrackQuery(ctx, "users.create")
+	err := ScanUser(s.db.QueryRowContext(ctx, "INSERT INTO users (name, email) VALUES ($1, $2) RETURNING "+UserColumns, user.Name, user.Email), &created)
+	done(1)
+	if isUniqueViolation(err) {
+		return created, ErrEmailTaken
+	}
+	return created, err
+}
+
+func (s *Postgres) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	names := make([]string, len(users))
+	emails := make([]string, len(users))
+	for i, user := range users {
+		names[i] = user.Name
+		emails[i] = strings.ToLower(user.Email)
+	}
+
+	// Check the emails up front so each taken one is reported by row
+	done := TrackQuery(ctx, "users.emails_taken")
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT lower(email) FROM users WHERE lower(email) = ANY($1)", pq.Array(emails))
+	if err != nil {
+		done(0)
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		taken[email] = true
+	}
+	rows.Close()
+	done(int64(len(taken)))
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rowErrs := RowErrors{}
+	for i, email := range emails {
+		if taken[email] {
+			rowErrs[i] = ErrEmailTaken
+		}
+		taken[email] = true
+	}
+	if len(rowErrs) > 0 {
+		return nil, rowErrs
+	}
+
+	// Serial ids are handed out in insertion order, so sorting by id restores
+	// the order of the batch
+	done = TrackQuery(ctx, "users.create_many")
+	rows, err = s.db.QueryContext(ctx, "WITH created AS (INSERT INTO users (name, email) SELECT * FROM unnest($1::text[], $2::text[]) RETURNING "+UserColumns+") SELECT "+UserColumns+" FROM created ORDER BY id", pq.Array(names), pq.Array(emails))
+	if err != nil {
+		done(0)
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := make([]models.User, 0, len(users))
+	for rows.Next() {
+		var user models.User
+		if err := ScanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		created = append(created, user)
+	}
+	done(int64(len(created)))
+	if err := rows.Err(); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *Postgres) CreateWithPassword(ctx context.Context, user models.User, passwordHash string) (models.User, error) {
+	var created models.User
+	done := TrackQuery(ctx, "users.create")
+	err := ScanUser(s.db.QueryRowContext(ctx, "INSERT INTO users (name, email, password_hash) VALUES ($1, $2, $3) RETURNING "+UserColumns, user.Name, user.Email, passwordHash), &created)
+	done(1)
+	if isUniqueViolation(err) {
+		return created, ErrEmailTaken
+	}
+	return created, err
+}
+
+func (s *Postgres) PasswordHash(ctx context.Context, email string) (models.ID, string, error) {
+	var id models.ID
+	var hash string
+	err := retryRead(ctx, s.db, "users.password_hash", func() error {
+		done := TrackQuery(ctx, "users.password_hash")
+		defer done(1)
+		return s.db.QueryRowContext(ctx, "SELECT id, password_hash FROM users WHERE lower(email) = lower($1) AND password_hash IS NOT NULL AND deleted_at IS NULL", email).Scan(&id, &hash)
+	})
+	if err == sql.ErrNoRows {
+		return 0, "", ErrNotFound
+	}
+	return id, hash, err
+}
+
+func (s *Postgres) Update(ctx context.Context, id models.ID, user models.User, version int64) (models.User, error) {
+	// The version is checked and bumped in the same statement
+	query := "UPDATE users SET name=$1, email=$2, updated_at=now(), version=version+1 WHERE id=$3 AND deleted_at IS NULL"
+	args := []interface{}{user.Name, user.Email, id}
+	if version != 0 {
+		query += " AND version=$4"
+		args = append(args, version)
+	}
+
+	var updated models.User
+	done := TrackQuery(ctx, "users.update")
+	err := ScanUser(s.db.QueryRowContext(ctx, query+" RETURNING "+UserColumns, args...), &updated)
+	done(1)
+	switch {
+	case err == sql.ErrNoRows && version != 0:
+		current, err := s.Get(ctx, id)
+		if err != nil {
+			return current, err
+		}
+		return current, ErrVersionMismatch
+	case err == sql.ErrNoRows:
+		return updated, ErrNotFound
+	case isUniqueViolation(err):
+		return updated, ErrEmailTaken
+	}
+	return updated, err
+}
+
+func (s *Postgres) SetAvatar(ctx context.Context, id models.ID, avatar *models.Avatar) (models.User, error) {
+	var etag, contentType sql.NullString
+	if avatar != nil {
+		etag = sql.NullString{String: avatar.ETag, Valid: true}
+		contentType = sql.NullString{String: avatar.ContentType, Valid: true}
+	}
+
+	var updated models.User
+	done := TrackQuery(ctx, "users.set_avatar")
+	err := ScanUser(s.db.QueryRowContext(ctx, "UPDATE users SET avatar_etag=$1, avatar_type=$2, updated_at=now(), version=version+1 WHERE id=$3 AND deleted_at IS NULL RETURNING "+UserColumns, etag, contentType, id), &updated)
+	done(1)
+	if err == sql.ErrNoRows {
+		return updated, ErrNotFound
+	}
+	return updated, err
+}
+
+func (s *Postgres) Delete(ctx context.Context, id models.ID) ([]models.ID, error) {
+	done := TrackQuery(ctx, "users.delete")
+	rows, err := s.db.QueryContext(ctx, "UPDATE users SET deleted_at=now(), updated_at=now(), version=version+1 WHERE id=$1 AND deleted_at IS NULL RETURNING id", id)
+	if err != nil {
+		done(0)
+		return nil, err
+	}
+	ids, err := ScanIds(rows)
+	done(int64(len(ids)))
+	return ids, err
+}
+
+func (s *Postgres) HardDelete(ctx context.Context, id models.ID) ([]models.ID, error) {
+	done := TrackQuery(ctx, "users.hard_delete")
+	rows, err := s.db.QueryContext(ctx, "DELETE FROM users WHERE id=$1 RETURNING id", id)
+	if err != nil {
+		done(0)
+		return nil, err
+	}
+	ids, err := ScanIds(rows)
+	done(int64(len(ids)))
+	return ids, err
+}
+
+func (s *Postgres) Restore(ctx context.Context, id models.ID) (models.User, error) {
+	var restored models.User
+	done := TrackQuery(ctx, "users.restore")
+	err := ScanUser(s.db.QueryRowContext(ctx, "UPDATE users SET deleted_at=NULL, updated_at=now(), version=version+1 WHERE id=$1 AND deleted_at IS NOT NULL RETURNING "+UserColumns, id), &restored)
+	done(1)
+	if err == sql.ErrNoRows {
+		return restored, ErrNotFound
+	}
+	return restored, err
+}
+
+func (s *Postgres) SignupsPerDay(ctx context.Context, since time.Time) ([]models.DailyCount, error) {
+	days := []models.DailyCount{}
+	err := retryRead(ctx, s.db, "users.signups_per_day", func() error {
+		done := TrackQuery(ctx, "users.signups_per_day")
+		rows, err := s.db.QueryContext(ctx, "SELECT to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD') AS day, COUNT(*) FROM users WHERE created_at >= $1 GROUP BY day ORDER BY day", since)
+		if err != nil {
+			done(0)
+			return err
+		}
+		defer rows.Close()
+
+		days = days[:0]
+		for rows.Next() {
+			var day models.DailyCount
+			if err := rows.Scan(&day.Date, &day.Count); err != nil {
+				done(int64(len(days)))
+				return err
+			}
+			days = append(days, day)
+		}
+		done(int64(len(days)))
+		return rows.Err()
+	})
+	return days, err
+}
+
+// Collect the ids returned by a RETURNING id statement
+func ScanIds(rows *sql.Rows) ([]models.ID, error) {
+	defer rows.Close()
+
+	ids := []models.ID{}
+	for rows.Next() {
+		var id models.ID
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Escape the LIKE wildcards in a search term so they match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// WHERE clause joining conditions, empty without any
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// Whether an error is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// Trigram indexes so ?q substring searches do not scan the whole table
+// pg_trgm needs a role allowed to create extensions, searches still work
+// without it, just slower.
+func EnsureSearchIndexes(db *sql.DB) {
+	_, err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm")
+	if err != nil {
+		log.Printf("Warning: pg_trgm is not available, user searches will scan the table: %v", err)
+		return
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS users_name_trgm ON users USING gin (name gin_trgm_ops)")
+	if err != nil {
+		log.Printf("Error creating the name search index: %v", err)
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS users_email_trgm ON users USING gin (email gin_trgm_ops)")
+	if err != nil {
+		log.Printf("Error creating the email search index: %v", err)
+	}
+}
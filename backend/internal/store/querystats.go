@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// One database operation run while serving a request
+type QueryStat struct {
+	Op       string
+	Duration time.Duration
+	Rows     int64
+}
+
+// Database operations run by a single request
+type QueryCollector struct {
+	mu      sync.Mutex
+	queries []QueryStat
+}
+
+func (c *QueryCollector) add(stat QueryStat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries = append(c.queries, stat)
+}
+
+// Operations recorded so far
+func (c *QueryCollector) Queries() []QueryStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]QueryStat(nil), c.queries...)
+}
+
+type queryCollectorKey struct{}
+
+// Context collecting the database operations run with it
+func WithQueryCollector(ctx context.Context) (context.Context, *QueryCollector) {
+	c := &QueryCollector{}
+	return context.WithValue(ctx, queryCollectorKey{}, c), c
+}
+
+// Collector of the request a context belongs to, nil outside requests
+func queryCollector(ctx context.Context) *QueryCollector {
+	c, _ := ctx.Value(queryCollectorKey{}).(*QueryCollector)
+	return c
+}
+
+// Start timing a database operation, call the result with the rows it returned
+func TrackQuery(ctx context.Context, op string) func(rows int64) {
+	c := queryCollector(ctx)
+	if c == nil {
+		return func(int64) {}
+	}
+
+	start := time.Now()
+	return func(rows int64) {
+		c.add(QueryStat{Op: op, Duration: time.Since(start), Rows: rows})
+	}
+}
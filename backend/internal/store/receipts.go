@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// A signed deletion receipt as it is stored
+type Receipt struct {
+	Id        string
+	UserId    models.ID
+	Operation string
+	Body      []byte // the JSON that was signed
+	KeyId     string
+	Signature string
+}
+
+// Deletion receipts, kept so they can be served again
+type ReceiptStore interface {
+	// Keep a receipt, in the transaction of the deletion it is for
+	Save(ctx context.Context, receipt Receipt) error
+	// ErrReceiptNotFound when there is no receipt with the id
+	Get(ctx context.Context, id string) (Receipt, error)
+	// Latest receipt of an operation on a user, ErrReceiptNotFound without one
+	Latest(ctx context.Context, userId models.ID, operation string) (Receipt, error)
+}
+
+// Receipts stored in Postgres
+type PostgresReceipts struct {
+	db querier
+}
+
+func NewPostgresReceipts(db *sql.DB) *PostgresReceipts {
+	return &PostgresReceipts{db: db}
+}
+
+func (s *PostgresReceipts) Save(ctx context.Context, receipt Receipt) error {
+	done := TrackQuery(ctx, "receipts.save")
+	defer done(1)
+	_, err := s.db.ExecContext(ctx, "INSERT INTO receipts (id, user_id, operation, body, key_id, signature) VALUES ($1, $2, $3, $4, $5, $6)",
+		receipt.Id, receipt.UserId, receipt.Operation, string(receipt.Body), receipt.KeyId, receipt.Signature)
+	return err
+}
+
+func (s *PostgresReceipts) Get(ctx context.Context, id string) (Receipt, error) {
+	done := TrackQuery(ctx, "receipts.get")
+	defer done(1)
+	return scanReceipt(s.db.QueryRowContext(ctx, "SELECT id, user_id, operation, body, key_id, signature FROM receipts WHERE id = $1", id))
+}
+
+func (s *PostgresReceipts) Latest(ctx context.Context, userId models.ID, operation string) (Receipt, error) {
+	done := TrackQuery(ctx, "receipts.find")
+	defer done(1)
+	return scanReceipt(s.db.QueryRowContext(ctx, "SELECT id, user_id, operation, body, key_id, signature FROM receipts WHERE user_id = $1 AND operation = $2 ORDER BY created_at DESC LIMIT 1", userId, operation))
+}
+
+func scanReceipt(row RowScanner) (Receipt, error) {
+	var receipt Receipt
+	var body string
+	err := row.Scan(&receipt.Id, &receipt.UserId, &receipt.Operation, &body, &receipt.KeyId, &receipt.Signature)
+	if err == sql.ErrNoRows {
+		return receipt, ErrReceiptNotFound
+	}
+	receipt.Body = []byte(body)
+	return receipt, err
+}
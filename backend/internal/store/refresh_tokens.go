@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Errors refreshing a token
+var (
+	// Unknown, expired or revoked, or its user can no longer log in
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// Used before, its family is revoked
+	ErrRefreshTokenReused = errors.New("refresh token already used")
+)
+
+// Refresh tokens by the hash of the token, never the token itself
+type RefreshTokenStore interface {
+	// Store the first token of a new family
+	Create(ctx context.Context, userId models.ID, family, tokenHash string, expires time.Time) error
+	// Replace a token with a new one of the same family, returning its user
+	// ErrRefreshTokenReused when it was already replaced, which revokes the
+	// family, ErrRefreshTokenInvalid when it cannot be used.
+	Rotate(ctx context.Context, tokenHash, newHash string, expires time.Time) (models.ID, error)
+	// Remove expired tokens, returning how many there were
+	Purge(ctx context.Context) (int64, error)
+}
+
+// Refresh tokens stored in Postgres
+type PostgresRefreshTokens struct {
+	db *sql.DB
+}
+
+func NewPostgresRefreshTokens(db *sql.DB) *PostgresRefreshTokens {
+	return &PostgresRefreshTokens{db: db}
+}
+
+func (s *PostgresRefreshTokens) Create(ctx context.Context, userId models.ID, family, tokenHash string, expires time.Time) error {
+	done := TrackQuery(ctx, "refresh_tokens.create")
+	defer done(1)
+	_, err := s.db.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, user_id, family, expires_at) VALUES ($1, $2, $3, $4)", tokenHash, userId, family, expires)
+	return err
+}
+
+func (s *PostgresRefreshTokens) Rotate(ctx context.Context, tokenHash, newHash string, expires time.Time) (models.ID, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userId models.ID
+	var family string
+	var used, usable bool
+	done := TrackQuery(ctx, "refresh_tokens.rotate")
+	// Locked so two refreshes with the same token cannot both succeed
+	err = tx.QueryRowContext(ctx, `SELECT t.user_id, t.family, t.used_at IS NOT NULL,
+			t.revoked_at IS NULL AND t.expires_at > now() AND u.deleted_at IS NULL AND u.password_hash IS NOT NULL
+		FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 FOR UPDATE OF t`, tokenHash).Scan(&userId, &family, &used, &usable)
+	if err == sql.ErrNoRows {
+		done(0)
+		return 0, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		done(0)
+		return 0, err
+	}
+
+	switch {
+	case used:
+		result, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE family = $1 AND revoked_at IS NULL", family)
+		if err != nil {
+			done(1)
+			return 0, err
+		}
+		revoked, _ := result.RowsAffected()
+		done(1 + revoked)
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return userId, ErrRefreshTokenReused
+	case !usable:
+		done(1)
+		return 0, ErrRefreshTokenInvalid
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1", tokenHash); err != nil {
+		done(1)
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, user_id, family, expires_at) VALUES ($1, $2, $3, $4)", newHash, userId, family, expires)
+	done(2)
+	if err != nil {
+		return 0, err
+	}
+	return userId, tx.Commit()
+}
+
+func (s *PostgresRefreshTokens) Purge(ctx context.Context) (int64, error) {
+	done := TrackQuery(ctx, "refresh_tokens.purge")
+	result, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < now()")
+	if err != nil {
+		done(0)
+		return 0, err
+	}
+	rows, _ := result.RowsAffected()
+	done(rows)
+	return rows, nil
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Refresh tokens and the credentials of their users, in one backend
+type tokenBackend struct {
+	tokens RefreshTokenStore
+	users  CredentialStore
+}
+
+func tokenBackends() map[string]func(t *testing.T) tokenBackend {
+	return map[string]func(t *testing.T) tokenBackend{
+		"memory": func(t *testing.T) tokenBackend {
+			mem := NewMemory()
+			return tokenBackend{tokens: mem.RefreshTokens(), users: mem}
+		},
+		"postgres": func(t *testing.T) tokenBackend {
+			db := testDatabase(t)
+			t.Cleanup(func() { db.Exec("DELETE FROM users WHERE email LIKE '%@refresh-tokens.test'") })
+			return tokenBackend{tokens: NewPostgresRefreshTokens(db), users: NewPostgres(db, nil)}
+		},
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+	for name, open := range tokenBackends() {
+		t.Run(name, func(t *testing.T) {
+			b := open(t)
+			user, err := b.users.CreateWithPassword(ctx, models.User{Name: "Ada", Email: "ada@refresh-tokens.test"}, "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rotate := func(from, to string) error {
+				id, err := b.tokens.Rotate(ctx, from, to, expires)
+				if err == nil && id != user.Id {
+					t.Fatalf("rotated for user %d, want %d", id, user.Id)
+				}
+				return err
+			}
+
+			if err := b.tokens.Create(ctx, user.Id, "login-1", "login-1", expires); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.tokens.Create(ctx, user.Id, "login-2", "login-2", expires); err != nil {
+				t.Fatal(err)
+			}
+			if err := rotate("login-1", "second"); err != nil {
+				t.Fatalf("first rotation: %v", err)
+			}
+			if err := rotate("second", "third"); err != nil {
+				t.Fatalf("rotating the new token: %v", err)
+			}
+
+			// A token used again revokes everything descended from its login
+			if err := rotate("login-1", "stolen"); !errors.Is(err, ErrRefreshTokenReused) {
+				t.Fatalf("reusing a rotated token = %v, want ErrRefreshTokenReused", err)
+			}
+			if err := rotate("third", "fourth"); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Fatalf("latest token of a revoked family = %v, want ErrRefreshTokenInvalid", err)
+			}
+			if err := rotate("stolen", "fifth"); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Fatalf("token handed out by the reuse = %v, want ErrRefreshTokenInvalid", err)
+			}
+			// Other logins of the user keep working
+			if err := rotate("login-2", "other"); err != nil {
+				t.Fatalf("token of another login: %v", err)
+			}
+
+			if err := rotate("unknown", "sixth"); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Fatalf("unknown token = %v, want ErrRefreshTokenInvalid", err)
+			}
+			if err := b.tokens.Create(ctx, user.Id, "expired", "expired", time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if err := rotate("expired", "seventh"); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Fatalf("expired token = %v, want ErrRefreshTokenInvalid", err)
+			}
+		})
+	}
+}
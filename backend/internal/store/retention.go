@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Advisory lock held during a retention sweep, so replicas take turns
+const retentionLockId = 7_246_118_002
+
+// Run fn holding the retention lock
+// Returns false without running fn when another sweep holds it.
+func WithRetentionLock(ctx context.Context, db *sql.DB, fn func() error) (bool, error) {
+	// Session level lock, so it needs the same connection throughout
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", retentionLockId).Scan(&locked); err != nil {
+		return false, fmt.Errorf("locking retention: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	// Unlocked even when ctx was cancelled during the sweep
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", retentionLockId)
+	return true, fn()
+}
+
+func (s *Postgres) LockStale(ctx context.Context, cutoff time.Time, limit int) ([]models.User, error) {
+	done := TrackQuery(ctx, "users.lock_stale")
+	rows, err := s.db.QueryContext(ctx, "SELECT "+UserColumns+" FROM users WHERE updated_at < $1 AND anonymized_at IS NULL ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED", cutoff, limit)
+	if err != nil {
+		done(0)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		if err := ScanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	done(int64(len(users)))
+	return users, rows.Err()
+}
+
+func (s *Postgres) Anonymize(ctx context.Context, ids []models.ID) ([]models.User, error) {
+	values := make([]int64, len(ids))
+	for i, id := range ids {
+		values[i] = int64(id)
+	}
+
+	// Emails stay unique by embedding the id, on a domain that cannot exist
+	done := TrackQuery(ctx, "users.anonymize")
+	rows, err := s.db.QueryContext(ctx, `UPDATE users SET name = 'Anonymized user', email = 'anonymized-' || id || '@anonymized.invalid', password_hash = NULL,
+		avatar_etag = NULL, avatar_type = NULL, anonymized_at = now(), updated_at = now(), version = version + 1
+		WHERE id = ANY($1) RETURNING `+UserColumns, pq.Array(values))
+	if err != nil {
+		done(0)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		if err := ScanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	done(int64(len(users)))
+	return users, rows.Err()
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Times a read outside a transaction is retried after a transient failure
+// Writes are never retried, they may have been applied before the
+// connection dropped.
+var ReadRetries = 2
+
+// Pause before the first read retry, doubling for each one after it
+var ReadRetryDelay = 50 * time.Millisecond
+
+// Transient failures since the last query that worked
+var transientFailures atomic.Int64
+
+// Postgres error codes for a server that is shutting down or starting up
+var transientCodes = map[pq.ErrorCode]bool{
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// Whether an error is the connection failing rather than the query, so the
+// same query may work on another connection
+func IsTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && transientCodes[pqErr.Code]
+}
+
+// Whether queries have been failing on the connection, cleared by the next
+// one that works
+func Reconnecting() bool {
+	return transientFailures.Load() > 0
+}
+
+// Exponential backoff with full jitter: a random pause up to base doubled
+// for each attempt after the first, capped at max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Run a read, retrying it on transient failures unless it is part of a
+// transaction, whose connection is gone with the failure
+func retryRead(ctx context.Context, db querier, op string, fn func() error) error {
+	_, inTx := db.(*sql.Tx)
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !IsTransient(err) {
+			// A query that got an answer, even an error, had a connection
+			if !errors.Is(err, context.Canceled) {
+				transientFailures.Store(0)
+			}
+			return err
+		}
+		transientFailures.Add(1)
+		if inTx || attempt >= ReadRetries {
+			return err
+		}
+
+		delay := Backoff(attempt, ReadRetryDelay, time.Second)
+		log.Printf("Retrying %s in %s after a connection failure: %v", op, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Storage of users, behind an interface the handlers can be tested against
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+)
+
+// Errors stores return for expected failures
+var (
+	ErrNotFound   = errors.New("user not found")
+	ErrEmailTaken = errors.New("email already exists")
+	// The user changed since the version the caller had
+	ErrVersionMismatch = errors.New("user was changed by someone else")
+)
+
+// Rows of a batch that could not be stored, by index in the batch
+type RowErrors map[int]error
+
+func (e RowErrors) Error() string {
+	return fmt.Sprintf("%d rows rejected", len(e))
+}
+
+// Paging, sorting and filtering of a user list
+type ListOptions struct {
+	Limit     int
+	Offset    int
+	AfterId   models.ID
+	HasAfter  bool
+	Sort      string // id, name, email or created_at
+	Desc      bool
+	Collation string // checked with Collations.Check first
+	Query     string // substring of the name or email, case-insensitive
+	Name      string // exact match
+	Email     string // exact match, lowercase
+
+	IncludeDeleted bool // soft-deleted users too
+}
+
+// Columns users may be sorted by, mapped to the SQL they sort on
+var SortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// Users storage
+type UserStore interface {
+	// Soft-deleted users are left out of everything but List with
+	// IncludeDeleted, Restore and HardDelete.
+
+	// A page of users and the number of users matching the filters
+	List(ctx context.Context, opts ListOptions) ([]models.User, int64, error)
+	// Stream every user matching the filters to fn in id order, stopping at
+	// the first error fn returns
+	Export(ctx context.Context, opts ListOptions, fn func(models.User) error) error
+	// ErrNotFound when there is no such user
+	Get(ctx context.Context, id models.ID) (models.User, error)
+	// Get a user, soft-deleted or not, and lock the row until the transaction
+	// ends so it can be recorded before it changes
+	Lock(ctx context.Context, id models.ID) (models.User, error)
+	// ErrEmailTaken when another user has the email
+	Create(ctx context.Context, user models.User) (models.User, error)
+	// Create users in one statement, returned in the order given
+	// RowErrors when emails are taken, by other users or within the batch.
+	CreateMany(ctx context.Context, users []models.User) ([]models.User, error)
+	// Replace a user, ErrNotFound or ErrEmailTaken
+	// A version other than 0 must match the stored one, otherwise the current
+	// user is returned with ErrVersionMismatch.
+	Update(ctx context.Context, id models.ID, user models.User, version int64) (models.User, error)
+	// Set or, with nil, clear the avatar of a user, ErrNotFound like Update
+	SetAvatar(ctx context.Context, id models.ID, avatar *models.Avatar) (models.User, error)
+	// Soft delete, ids of the deleted users, empty when there was none
+	Delete(ctx context.Context, id models.ID) ([]models.ID, error)
+	// Remove the row for good, whether it was soft-deleted or not
+	HardDelete(ctx context.Context, id models.ID) ([]models.ID, error)
+	// Undo a soft delete, ErrNotFound when the user is not soft-deleted
+	Restore(ctx context.Context, id models.ID) (models.User, error)
+	// Users not changed since cutoff and not anonymized yet, at most limit
+	// of them in id order, locked until the transaction ends
+	// Rows another transaction has locked are skipped.
+	LockStale(ctx context.Context, cutoff time.Time, limit int) ([]models.User, error)
+	// Replace the name and email of users with placeholders, and drop their
+	// password and avatar, returning them as they are now
+	Anonymize(ctx context.Context, ids []models.ID) ([]models.User, error)
+	// Users created on each UTC day from since on, soft-deleted ones
+	// included, leaving out days without any
+	SignupsPerDay(ctx context.Context, since time.Time) ([]models.DailyCount, error)
+}
+
+// Password hashes of users, kept out of UserStore so they never reach a
+// user response
+type CredentialStore interface {
+	// Create a user that can log in, ErrEmailTaken like Create
+	CreateWithPassword(ctx context.Context, user models.User, passwordHash string) (models.User, error)
+	// Id and password hash of the user with an email, ErrNotFound when there
+	// is none or it has no password
+	PasswordHash(ctx context.Context, email string) (models.ID, string, error)
+}
+
+// Stores whose queries run in one transaction
+type Tx struct {
+	Users       UserStore
+	Credentials CredentialStore
+	Audit       AuditStore
+	Receipts    ReceiptStore
+}
+
+// Runs writes in transactions, so callers never handle one themselves
+type Transactor interface {
+	// Run fn with stores sharing a transaction, committed when fn returns
+	// nil and rolled back when it returns an error, which is returned
+	RunInTx(ctx context.Context, fn func(tx Tx) error) error
+}
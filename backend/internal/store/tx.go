@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Transactions on a Postgres database
+type PostgresTransactor struct {
+	db         *sql.DB
+	collations *Collations
+}
+
+func NewPostgresTransactor(db *sql.DB, collations *Collations) *PostgresTransactor {
+	return &PostgresTransactor{db: db, collations: collations}
+}
+
+func (t *PostgresTransactor) RunInTx(ctx context.Context, fn func(tx Tx) error) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	users := &Postgres{db: tx, collations: t.collations}
+	err = fn(Tx{
+		Users:       users,
+		Credentials: users,
+		Audit:       &PostgresAudit{db: tx},
+		Receipts:    &PostgresReceipts{db: tx},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage of uploaded files by key
+// Keys are built by the server, never taken from a request. Another backend,
+// like an object store, only has to implement this.
+type Storage interface {
+	// Store a file, replacing one with the same key
+	Put(ctx context.Context, key string, data []byte) error
+	// ErrUploadNotFound when there is no file with the key
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Remove a file, no error when it is already gone
+	Delete(ctx context.Context, key string) error
+}
+
+// Returned by Get for a key without a file
+var ErrUploadNotFound = errors.New("upload not found")
+
+// Whether a key only has the characters keys are built from, so it cannot
+// climb out of a directory
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, ".") {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Files stored in a directory, named by their key
+type DiskStorage struct {
+	Dir string
+}
+
+// Storage in dir, created when it does not exist yet
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating upload directory: %w", err)
+	}
+	return &DiskStorage{Dir: dir}, nil
+}
+
+func (s *DiskStorage) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid upload key %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+// Written to a temporary file first, so readers never see half a file
+func (s *DiskStorage) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *DiskStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	return data, err
+}
+
+func (s *DiskStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Files stored in the uploads table
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresStorage(db *sql.DB) *PostgresStorage {
+	return &PostgresStorage{db: db}
+}
+
+func (s *PostgresStorage) Put(ctx context.Context, key string, data []byte) error {
+	done := TrackQuery(ctx, "uploads.put")
+	_, err := s.db.ExecContext(ctx, "INSERT INTO uploads (key, data) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET data = EXCLUDED.data, created_at = now()", key, data)
+	done(1)
+	return err
+}
+
+func (s *PostgresStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := retryRead(ctx, s.db, "uploads.get", func() error {
+		done := TrackQuery(ctx, "uploads.get")
+		defer done(1)
+		return s.db.QueryRowContext(ctx, "SELECT data FROM uploads WHERE key = $1", key).Scan(&data)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	return data, err
+}
+
+func (s *PostgresStorage) Delete(ctx context.Context, key string) error {
+	done := TrackQuery(ctx, "uploads.delete")
+	result, err := s.db.ExecContext(ctx, "DELETE FROM uploads WHERE key = $1", key)
+	if err != nil {
+		done(0)
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	done(rows)
+	return nil
+}
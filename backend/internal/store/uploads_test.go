@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewDiskStorage(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(ctx, "avatar-7-abc", []byte("image")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get(ctx, "avatar-7-abc"); err != nil || string(data) != "image" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if err := s.Delete(ctx, "avatar-7-abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "avatar-7-abc"); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrUploadNotFound", err)
+	}
+	if err := s.Delete(ctx, "avatar-7-abc"); err != nil {
+		t.Fatalf("second Delete = %v, want nil", err)
+	}
+}
+
+// Keys that could reach outside the directory, or hide in it, are refused
+func TestDiskStorageRefusesKeys(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewDiskStorage(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../secret", "..", ".", ".hidden", ".upload-123", "a/b", `a\b`, "/etc/passwd", "Avatar", "avatar 7", "avatar\x00"} {
+		t.Run(key, func(t *testing.T) {
+			if err := s.Put(ctx, key, []byte("x")); err == nil {
+				t.Fatalf("Put(%q) succeeded", key)
+			}
+			if data, err := s.Get(ctx, key); err == nil || errors.Is(err, ErrUploadNotFound) {
+				t.Fatalf("Get(%q) = %q, %v, want an invalid key", key, data, err)
+			}
+			if err := s.Delete(ctx, key); err == nil {
+				t.Fatalf("Delete(%q) succeeded", key)
+			}
+		})
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "secret")); err != nil || string(data) != "secret" {
+		t.Fatalf("file outside the directory = %q, %v", data, err)
+	}
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("upload directory has %v (%v), want it empty", entries, err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+)
+
+// Timestamp format of rotated backups
+const backupTimeFormat = "20060102T150405.000"
+
+// Log file rotated by size, keeping a bounded number of backups
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	MaxAge     time.Duration
+
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+	lastBackup time.Time
+}
+
+// Open a log file for appending, creating it when missing
+func OpenRotatingFile(path string, maxSize int64, maxBackups int, maxAge time.Duration) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups, MaxAge: maxAge}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.MaxSize > 0 && f.size+int64(len(p)) > f.MaxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rotating log file: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Move the current file to a timestamped backup and start a new one
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+
+	// Backups sort in the order they were made, even when several are made
+	// within a millisecond, and never overwrite each other
+	stamp := time.Now().Truncate(time.Millisecond)
+	if !stamp.After(f.lastBackup) {
+		stamp = f.lastBackup.Add(time.Millisecond)
+	}
+	backup := f.Path + "." + stamp.Format(backupTimeFormat)
+	for _, err := os.Lstat(backup); err == nil; _, err = os.Lstat(backup) {
+		stamp = stamp.Add(time.Millisecond)
+		backup = f.Path + "." + stamp.Format(backupTimeFormat)
+	}
+	f.lastBackup = stamp
+	if err := os.Rename(f.Path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// Remove backups beyond MaxBackups or older than MaxAge
+func (f *RotatingFile) prune() error {
+	matches, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return err
+	}
+	// Other files next to the log, like one moved aside by hand, are left
+	// alone and not counted
+	var backups []string
+	stamps := map[string]time.Time{}
+	for _, match := range matches {
+		stamp, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(match, f.Path+"."), time.Local)
+		if err == nil {
+			backups = append(backups, match)
+			stamps[match] = stamp
+		}
+	}
+	// Timestamps sort chronologically, newest last
+	sort.Strings(backups)
+
+	cutoff := time.Now().Add(-f.MaxAge)
+	for i, backup := range backups {
+		tooMany := f.MaxBackups > 0 && i < len(backups)-f.MaxBackups
+		tooOld := f.MaxAge > 0 && stamps[backup].Before(cutoff)
+		if tooMany || tooOld {
+			os.Remove(backup)
+		}
+	}
+	return nil
+}
+
+// Reopen the file at Path, after an external tool like logrotate moved it
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Where logs are written
+var logOutput io.Writer = os.Stderr
+
+// Send logs to LOG_FILE when set, reopening it on SIGHUP
+// Falls back to stderr when the file cannot be opened.
+func SetupLogOutput() {
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		return
+	}
+
+	maxSize := int64(env.Int("LOG_MAX_SIZE_MB", 100)) * 1024 * 1024
+	maxAge := time.Duration(env.Int("LOG_MAX_AGE_DAYS", 0)) * 24 * time.Hour
+	f, err := OpenRotatingFile(path, maxSize, env.Int("LOG_MAX_BACKUPS", 5), maxAge)
+	if err != nil {
+		log.Printf("Warning: could not open LOG_FILE %s, logging to stderr: %v", path, err)
+		return
+	}
+	logOutput = f
+	log.SetOutput(f)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := f.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reopening log file: %v\n", err)
+			}
+		}
+	}()
+}
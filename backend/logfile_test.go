@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Rotated backups of path, oldest first
+func logBackups(t *testing.T, path string) []string {
+	t.Helper()
+	backups, err := filepath.Glob(path + ".2*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileKeepsMaxBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	// Not a backup, so it neither counts nor goes
+	if err := os.WriteFile(path+".old", []byte("kept by hand\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenRotatingFile(path, 100, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+
+	var lines []string
+	for i := 0; i < 40; i++ {
+		line := fmt.Sprintf("line %02d %s\n", i, strings.Repeat("x", 20))
+		lines = append(lines, line)
+		if n, err := f.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("Write = %d %v", n, err)
+		}
+	}
+
+	backups := logBackups(t, path)
+	if len(backups) != 3 {
+		t.Fatalf("%d backups %v, want 3", len(backups), backups)
+	}
+	if readFile(t, path+".old") != "kept by hand\n" {
+		t.Fatal("file that is not a backup changed")
+	}
+	var kept strings.Builder
+	for _, file := range append(backups, path) {
+		content := readFile(t, file)
+		if len(content) > 100 {
+			t.Fatalf("%s has %d bytes, over the maximum of 100", file, len(content))
+		}
+		if !strings.HasSuffix(content, "\n") {
+			t.Fatalf("%s ends in the middle of a line: %q", file, content)
+		}
+		kept.WriteString(content)
+	}
+	// The newest lines survive, in order, nothing lost between the files;
+	// three fit in each backup
+	all := strings.Join(lines, "")
+	if !strings.HasSuffix(all, kept.String()) || kept.Len() < 3*3*len(lines[0]) {
+		t.Fatalf("kept %q, want the last lines written", kept.String())
+	}
+}
+
+func TestRotatingFileWritesLargeLinesWhole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenRotatingFile(path, 10, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+
+	// A line over the limit goes into a file of its own rather than being cut
+	long := strings.Repeat("y", 50) + "\n"
+	f.Write([]byte("short\n"))
+	f.Write([]byte(long))
+	if got := readFile(t, path); got != long {
+		t.Fatalf("current file = %q, want the long line alone", got)
+	}
+	if backups := logBackups(t, path); len(backups) != 1 || readFile(t, backups[0]) != "short\n" {
+		t.Fatalf("backups = %v, want the short line in one", backups)
+	}
+}
+
+func TestRotatingFilePrunesOldBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	old := path + "." + time.Now().Add(-48*time.Hour).Format(backupTimeFormat)
+	unrelated := path + ".txt"
+	for _, file := range []string{old, unrelated} {
+		if err := os.WriteFile(file, []byte("x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := OpenRotatingFile(path, 10, 0, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+	f.Write([]byte("first line\n"))
+	f.Write([]byte("second line\n"))
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("backup older than the maximum age is still there (%v)", err)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Fatalf("file that is not a backup was removed: %v", err)
+	}
+	if backups := logBackups(t, path); len(backups) != 1 {
+		t.Fatalf("backups = %v, want the fresh one", backups)
+	}
+}
+
+// Existing content counts towards the size, and logrotate can move the file
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(strings.Repeat("z", 95)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenRotatingFile(path, 100, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.file.Close() }()
+	f.Write([]byte("after restart\n"))
+	if backups := logBackups(t, path); len(backups) != 1 {
+		t.Fatalf("backups = %v, want the file rotated by its existing size", backups)
+	}
+
+	moved := path + ".moved"
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after reopen\n"))
+	if got := readFile(t, path); got != "after reopen\n" {
+		t.Fatalf("reopened file = %q", got)
+	}
+	if got := readFile(t, moved); got != "after restart\n" {
+		t.Fatalf("moved file = %q, want the lines before the reopen", got)
+	}
+}
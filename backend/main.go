@@ -1,57 +1,307 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Build information, set with -ldflags "-X main.version=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	buildTime = "unknown"
 )
 
 func main() {
 	// Load environment variables from .env file
 	
+	// It is optional, in containers the environment is set directly
 	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Error loading .env file:", err)
+	}
+
+	// Write logs to LOG_FILE when configured, as JSON
+	SetupLogOutput()
+	handlers.SetupLogger(logOutput)
+
+	// Every setting is checked before anything starts
+	config, err := LoadConfig()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
+	}
+
+	migrateOnly := flag.Bool("migrate-only", false, "apply the database migrations and exit, same as the migrate up command")
+	flag.Parse()
+	args := flag.Args()
+
+	// Subcommands
+	if len(args) > 0 && args[0] == "anonymize-db" {
+		runAnonymize(config, args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "seed" {
+		runSeed(config, args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		runMigrate(config, args[1:])
+		return
+	}
+	if *migrateOnly {
+		runMigrate(config, []string{"up"})
+		return
 	}
 
 	fmt.Println("Backend Service in GoLang")
+	handlers.Version, handlers.BuildTime = version, buildTime
+
+	// Report 5xx errors and panics to Sentry when configured
+	handlers.InitErrorReporting()
+
+	// Encode ids as strings for JavaScript clients
+	models.IdsAsStrings = os.Getenv("ID_ENCODING") == "string"
+
+	// Errors as RFC 7807 problem details for every client, not only the ones
+	// that accept application/problem+json
+	switch format := os.Getenv("ERROR_FORMAT"); format {
+	case "", "json":
+	case "problem":
+		handlers.ProblemErrors = true
+	default:
+		log.Fatalf("Invalid ERROR_FORMAT %q, want json or problem", format)
+	}
+
+	// With FAST_START the port is bound before initializing, and requests
+	// get 503 until the startup phases are done
+	startup := &handlers.Startup{}
+	readiness := &handlers.Readiness{Startup: startup}
+	handler := handlers.StripBasePath(handlers.ProbeHandler(config.Probe, handlers.RequestLogger(handlers.EnableCORS(config.CORS, startup.Handler()))))
+	handlers.CORSHeaders.Allow("X-Request-ID")
+	handlers.CORSHeaders.Expose("X-Request-ID")
+	// Prometheus metrics, scraped on /metrics unless METRICS_ENABLED=false
+	metrics := handlers.MetricsEnabled()
+	if metrics {
+		handler = handlers.ServeMetrics("/metrics", handler)
+	}
+	// Requests still running when the shutdown times out have their context
+	// cancelled, and background workers stop when the shutdown starts
+	requests, cancelRequests := context.WithCancel(context.Background())
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var server *http.Server
+	if handlers.FastStart() {
+		server = StartServer(config, handler, requests)
+	}
 
 	// Connect to the database
-	db := ConnectDatabase()
+	var db *sql.DB
+	err = startup.Run("database", func() (err error) {
+		db, err = OpenDatabase(config)
+		return err
+	})
+	if err != nil {
+		log.Fatal("Could not establish a connection with the database:", err)
+	}
+	// Closed after the server has drained
 	defer db.Close()
+	readiness.DB = db
+	if metrics {
+		handlers.RegisterDBMetrics(db)
+	}
 
-	// CREATE a table in the database
-	CreateTable(db)
+	// Signed receipts for deletions
+	receipts, err := handlers.NewReceiptSigner()
+	if err != nil {
+		log.Fatalf("Error loading receipt keys: %v", err)
+	}
+
+	// Avatars are kept under UPLOAD_DIR, or in the database without one
+	var uploads store.Storage = store.NewPostgresStorage(db)
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		if uploads, err = store.NewDiskStorage(dir); err != nil {
+			log.Fatalf("Error setting up uploads: %v", err)
+		}
+	}
+	models.AvatarPrefix = handlers.BasePath() + "/api/v1"
+
+	// Independent setup runs concurrently
+	var collations *store.Collations
+	growth := handlers.NewUsersGrowthGuard(config.Growth)
+	err = startup.Parallel(map[string]func() error{
+		// Apply the schema migrations, or with AUTO_MIGRATE=false make sure
+		// they were applied
+		"schema": func() error {
+			if !config.AutoMigrate {
+				return store.CheckSchema(db)
+			}
+			return store.Migrate(db)
+		},
+		// Collations available for sorting names
+		"collations": func() error {
+			collations = store.DetectCollations(db)
+			return nil
+		},
+		// Row limits guarding against runaway inserts
+		"growth": func() error {
+			growth.Start(workers, db, config.Growth.Refresh)
+			return nil
+		},
+	})
+	if err != nil {
+		log.Fatal("Startup failed:", err)
+	}
+	handlers.CORSHeaders.Expose("Warning")
+
+	// Users stored in Postgres, with reads retried when a connection drops
+	store.ReadRetries = env.Int("DB_READ_RETRIES", 2)
+	users := store.NewPostgres(db, collations)
+
+	// Every change to a user is recorded with who made it
+	audit := store.NewPostgresAudit(db)
+
+	// Writes run in one transaction across users, audit and receipts
+	txs := store.NewPostgresTransactor(db, collations)
+	receiptStore := store.NewPostgresReceipts(db)
+
+	// Cache for the users list
+	usersCache := handlers.NewResponseCache(env.Duration("CACHE_FRESH_TTL", 5*time.Second), env.Duration("CACHE_MAX_STALE", 30*time.Second), env.Int("CACHE_LIST_MAX_ENTRIES", 1000))
+	handlers.CORSHeaders.Expose("X-Cache", "Age")
+
+	// Cache of users by id, for profile reads
+	userCache := handlers.NewUserCache()
+	// Not a worker, requests still draining at shutdown must not read
+	// stale users
+	handlers.SubscribeCacheInvalidation(context.Background(), handlers.NewInvalidationBus(db, config.DatabaseURL), usersCache, userCache)
+
+	// User changes pushed to dashboards over Server-Sent Events
+	events := handlers.NewEventHub()
+	handlers.CORSHeaders.Allow("Last-Event-ID")
+
+	// Admin routes authenticate with an API key
+	handlers.CORSHeaders.Allow("X-API-Key", "Authorization")
+
+	// Updates are conditional on the ETag of the user
+	handlers.CORSHeaders.Allow("If-Match")
+	handlers.CORSHeaders.Expose("ETag")
+
+	// Destructive writes can be previewed as a dry run
+	handlers.CORSHeaders.Allow("X-Dry-Run")
+
+	// Rate limits and idempotency keys, shared by replicas unless
+	// KV_BACKEND=memory
+	kv, err := store.OpenKeyValue(config.KV.Backend, db, config.KV.RedisURL)
+	if err != nil {
+		log.Fatalf("Error opening the %s key/value store: %v", config.KV.Backend, err)
+	}
+	store.StartKVPurge(workers, kv, config.KV.PurgeInterval)
+
+	// Creates retried with the same Idempotency-Key get the first response
+	idempotency := handlers.NewIdempotency(store.NewKVIdempotency(kv))
+	handlers.CORSHeaders.Allow("Idempotency-Key")
+	handlers.CORSHeaders.Expose("Idempotent-Replayed")
+
+	// Which email addresses may sign up
+	emails := handlers.NewEmailPolicy()
+	emails.Watch(workers, env.Duration("EMAIL_POLICY_REFRESH", 30*time.Second))
+
+	// Expensive endpoints share a small concurrency limit
+	heavy := handlers.NewHeavyAdmission()
+	handlers.CORSHeaders.Expose("X-Queue-Position", "Retry-After")
+
+	// Exports are downloaded as files named in Content-Disposition
+	handlers.CORSHeaders.Expose("Content-Disposition")
+
+	// Fault injection for resilience testing, never in production
+	var faults *handlers.FaultInjector
+	if handlers.FaultsEnabled() {
+		faults = handlers.NewFaultInjector()
+		log.Println("Fault injection is enabled")
+	}
+
+	// Per client rate limits on the API, separate for reads and writes
+	handlers.TrustProxy = config.TrustProxy
+	handlers.CORSHeaders.Expose("RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy")
+	if config.RateLimits.LegacyHeaders {
+		handlers.CORSHeaders.Expose("X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset")
+	}
+	handlers.CORSHeaders.Expose("X-DB-Queries")
+
+	// Writes to users need a bearer token from /api/go/auth/login, renewed
+	// with its refresh token at /api/go/auth/refresh
+	auth := handlers.NewAuth(store.NewPostgresRefreshTokens(db))
+	auth.Start(workers, env.Duration("REFRESH_TOKEN_PURGE_INTERVAL", time.Hour))
+	handlers.CORSHeaders.Expose("WWW-Authenticate")
+
+	// Users are served under /api/v1, and still under /api/go with
+	// deprecation headers pointing at /api/v1 until the sunset
+	sunset := time.Date(2027, time.April, 14, 0, 0, 0, 0, time.UTC)
+	if v := os.Getenv("LEGACY_API_SUNSET"); v != "" {
+		if sunset, err = time.Parse(time.DateOnly, v); err != nil {
+			log.Fatalf("Invalid LEGACY_API_SUNSET %q, want a date like 2027-04-14: %v", v, err)
+		}
+	}
+	handlers.CORSHeaders.Expose("Deprecation", "Sunset", "Link")
+
+	// Stale users are anonymized or deleted by a background sweep, stopped
+	// with the server
+	retention, err := handlers.NewRetention(db, txs, uploads, usersCache, userCache, events, growth)
+	if err != nil {
+		log.Fatalf("Invalid retention settings: %v", err)
+	}
+	retention.Start(workers)
+
+	// Clients may send older request body shapes during rollouts
+	handlers.CORSHeaders.Allow("X-Api-Shape")
+	handlers.CORSHeaders.Expose("X-Api-Shape")
+	handlers.CORSHeaders.Expose("Allow")
 
 	// Setup routes and server
+	handlers.RegisterUserExamples()
 	router := mux.NewRouter()
-	router.Handle("/", EnableCORS(http.HandlerFunc(homeHandler)))
-
-	// Test Route - Start
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		response := map[string]string{"Message": "Welcome to the Backend Service in Go!"}
-		json.NewEncoder(w).Encode(response)
+	routes := RegisterRoutes(router, app{
+		db: db, startup: startup, readiness: readiness, metrics: metrics,
+		faults: faults, limits: handlers.NewRateLimits(kv, config.RateLimits), auth: auth,
+		heavy: heavy, idempotency: idempotency, retention: retention, sunset: sunset,
+		api: userAPI{
+			db: db, txs: txs, users: users, credentials: users, audit: audit, stored: receiptStore, collations: collations,
+			cache: usersCache, userCache: userCache, events: events,
+			growth: growth, emails: emails, receipts: receipts, uploads: uploads,
+		},
 	})
-	// Test Route - End
+	if env.Bool("DEV_MODE", false) {
+		routes.LogRoutes()
+	}
+	handlers.LogUndocumentedRoutes(routes)
 
-	// Routes for the API - Start
-	router.HandleFunc("/api/go/users", getUsers(db)).Methods("GET")
-	router.HandleFunc("/api/go/users", createUsers(db)).Methods("POST")
-	router.HandleFunc("/api/go/users/{id}", getUsersId(db)).Methods("GET")
-	router.HandleFunc("/api/go/users/{id}", updateUser(db)).Methods("PUT")
-	router.HandleFunc("/api/go/users/{id}", deleteUser(db)).Methods("DELETE")
-	// Routes for the API - End
+	// Preflights and OPTIONS probes answer with the methods of their route,
+	// once every route is registered
+	handlers.CORSHeaders.RouteMethods(routes.AllowedMethods)
 
 	// Start the HTTP server
-	ListenAndServe(router)
+	startup.Serve(router)
+	if server == nil {
+		server = StartServer(config, handler, requests)
+	}
+	server.RegisterOnShutdown(events.Close)
+	server.RegisterOnShutdown(stopWorkers)
+	waitForShutdown(config.Server, server, readiness, cancelRequests)
 }
 
 // Test Database Connection
@@ -83,184 +333,70 @@ func main() {
 // 	}
 // }
 
-// Delete a user
-func deleteUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var user User
-		json.NewDecoder(r.Body).Decode(&user)
-
-		vars := mux.Vars(r)
-		id := vars["id"]
-
-		_, err := db.Exec("DELETE FROM users WHERE id=$1", id)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		w.WriteHeader(http.StatusOK)
-	}
-}
-
-// Update a user by Id
-func updateUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var user User
-		json.NewDecoder(r.Body).Decode(&user)
-
-		vars := mux.Vars(r)
-		id := vars["id"]
-
-		_, err := db.Exec("UPDATE users SET name=$1, email=$2 WHERE id=$3", user.Name, user.Email, id)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		var updatedUser User
-		err = db.QueryRow("SELECT id, name, email FROM users WHERE id=$1", id).Scan(&updatedUser.Id, &updatedUser.Name, &updatedUser.Email)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		json.NewEncoder(w).Encode(updatedUser)
-	}
-}
-
-// Create a new user
-func createUsers(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var user User
-		json.NewDecoder(r.Body).Decode(&user)
-		err := db.QueryRow("INSERT INTO users (name, email) VALUES ($1,$2) RETURNING id",user.Name,user.Email).Scan(&user.Id)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		json.NewEncoder(w).Encode(user)
-	}
-}
-
-// Get a user by Id
-func getUsersId(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
-
-		var user User
-		err := db.QueryRow("SELECT * FROM users WHERE id = $1", id).Scan(&user.Id, &user.Name, &user.Email)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-
-		json.NewEncoder(w).Encode(user)
-	}
-}
-
-// Get all users
-func getUsers(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM users")
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer rows.Close()
-
-		users := []User{}
-
-		for rows.Next() {
-			var user User
-			err := rows.Scan(&user.Id, &user.Name, &user.Email)
-			if err != nil {
-				log.Fatal(err)
-			}
-			users = append(users, user)
-		}
-
-		err = rows.Err()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		json.NewEncoder(w).Encode(users)
-	}
-}
-
-// Listen to the server
-func ListenAndServe(handler http.Handler) {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port if not specified
+// Listen to the server in the background
+// Request contexts derive from base, cancelling it cancels every request.
+func StartServer(config Config, handler http.Handler, base context.Context) *http.Server {
+	port := config.Port
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		BaseContext:       func(net.Listener) context.Context { return base },
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		ReadTimeout:       config.Server.ReadTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
 	}
-
-	log.Println("Starting server on port:", port)
-	err := http.ListenAndServe(":"+port, handler)
-	if err != nil {
-		log.Fatal("Server failed to start:", err)
-	}
-}
-
-// CORS middleware
-func EnableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, PATCH, POST, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	go func() {
+		log.Println("Starting server on port:", port)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed to start:", err)
 		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// Home handler example
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "Welcome to the Backend Service in Go!")
+	}()
+	return server
 }
 
-// Database table creation
-func CreateTable(db *sql.DB) {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name TEXT, email TEXT)")
+// Database connection, exiting when it cannot be reached
+func ConnectDatabase(config Config) *sql.DB {
+	db, err := OpenDatabase(config)
 	if err != nil {
-		log.Printf("Error creating table: %v", err)
+		log.Fatal("Could not establish a connection with the database:", err)
 	}
+	return db
 }
 
-// Database connection
-func ConnectDatabase() *sql.DB {
-	// Load database URL from the environment variables
-	databaseURL := os.Getenv("DATABASE_URL")
-	if databaseURL == "" {
-		log.Fatal("DATABASE_URL is not set in the environment variables")
-	}
-
+// Open and ping the database, with the configured pool limits
+func OpenDatabase(config Config) (*sql.DB, error) {
 	// Open a connection to the database
-	db, err := sql.Open("postgres", databaseURL)
+	db, err := sql.Open("postgres", config.DatabaseURL)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	err = db.Ping()
-	if err != nil {
-		log.Fatal("Could not establish a connection with the database:", err)
+	db.SetMaxOpenConns(config.Pool.MaxOpenConns)
+	db.SetMaxIdleConns(config.Pool.MaxIdleConns)
+	db.SetConnMaxLifetime(config.Pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.Pool.ConnMaxIdleTime)
+
+	// Postgres may be restarting, keep trying with backoff for a while
+	ctx, cancel := context.WithTimeout(context.Background(), config.Connect.Timeout)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= config.Connect.Retries || ctx.Err() != nil {
+			db.Close()
+			return nil, err
+		}
+		delay := store.Backoff(attempt, 500*time.Millisecond, 15*time.Second)
+		log.Printf("Database connection attempt %d of %d failed, retrying in %s: %v", attempt+1, config.Connect.Retries+1, delay.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, err
+		case <-time.After(delay):
+		}
 	}
-	return db
-}
-
-// User struct
-type User struct {
-	Id    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
 }
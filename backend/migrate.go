@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Apply, undo or list the schema migrations
+// Usage: migrate up | migrate down [-steps n] | migrate status
+func runMigrate(config Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate up | down [-steps n] | status")
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := fs.Int("steps", 1, "migrations to undo, newest first")
+	fs.Parse(args[1:])
+
+	db := ConnectDatabase(config)
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		if err := store.Migrate(db); err != nil {
+			log.Fatal("Migrating the database failed:", err)
+		}
+	case "down":
+		if *steps < 1 {
+			log.Fatal("-steps must be at least 1")
+		}
+		if err := store.MigrateDown(db, *steps); err != nil {
+			log.Fatal("Undoing migrations failed:", err)
+		}
+	case "status":
+		printMigrationStatus(db)
+	default:
+		log.Fatalf("Unknown migrate command %q, want up, down or status", args[0])
+	}
+}
+
+// Print every migration and when it was applied, exiting with 1 when the
+// database is not at the version this build expects
+func printMigrationStatus(db *sql.DB) {
+	status, err := store.Status(db)
+	if err != nil {
+		log.Fatal("Reading the migration status failed:", err)
+	}
+
+	current := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		switch {
+		case s.AppliedAt == nil:
+			current = false
+		case s.Changed:
+			applied = s.AppliedAt.Format(time.RFC3339) + " (changed since)"
+			current = false
+		case s.Unknown:
+			applied = s.AppliedAt.Format(time.RFC3339) + " (not in this build)"
+		default:
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+	if !current {
+		os.Exit(1)
+	}
+}
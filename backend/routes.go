@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/env"
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// What every route is served with
+// Built by main from the database and the environment, and by the tests
+// from memory stores.
+type app struct {
+	db        *sql.DB
+	startup   *handlers.Startup
+	readiness *handlers.Readiness
+	metrics   bool
+	// Nil unless FAULT_INJECTION is on
+	faults      *handlers.FaultInjector
+	limits      *handlers.RateLimits
+	auth        *handlers.Auth
+	heavy       *handlers.AdmissionClass
+	idempotency *handlers.Idempotency
+	retention   *handlers.Retention
+	// When the legacy /api/go prefix goes away
+	sunset time.Time
+
+	api userAPI
+}
+
+// Register every route of the API on a router, with its middleware
+func RegisterRoutes(router *mux.Router, a app) *handlers.RouteTable {
+	routes := handlers.NewRouteTable(router)
+	router.NotFoundHandler = http.HandlerFunc(handlers.NotFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(routes.MethodNotAllowed)
+	if a.metrics {
+		routes.Use(handlers.Mw("metrics", handlers.Metrics))
+	}
+	// Fault injection wraps the response guard so truncated bodies reach
+	// the client
+	if a.faults != nil {
+		routes.Use(handlers.Mw("faults", a.faults.Middleware))
+	}
+	// The guard wraps recover, so a panic after part of the response went
+	// out never appends an error document to it
+	routes.Use(handlers.Mw("response_guard", handlers.ResponseGuard))
+	routes.Use(handlers.Mw("recover", handlers.Recover))
+	routes.Use(handlers.Mw("rate_limit", a.limits.Middleware))
+
+	// JSON bodies are capped at MAX_BODY_BYTES
+	routes.Use(handlers.Mw("body_limit", handlers.LimitBody))
+
+	routes.Use(handlers.Mw("query_stats", handlers.QueryStats))
+
+	routes.HandleFunc("", "/", handlers.HomeHandler)
+
+	// Test Route - Start
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := map[string]string{"Message": "Welcome to the Backend Service in Go!"}
+		json.NewEncoder(w).Encode(response)
+	})
+	// Test Route - End
+
+	admin := handlers.Mw("admin", handlers.RequireAdmin)
+	writes := handlers.Mw("auth", a.auth.Middleware)
+	reads := handlers.Mw("auth_reads", a.auth.ReadMiddleware)
+	api := a.api
+	routes.HandleFunc("POST", "/api/go/auth/register", handlers.Register(api.txs, api.cache, api.userCache, api.events, api.growth, api.emails, a.auth))
+	routes.HandleFunc("POST", "/api/go/auth/login", handlers.Login(api.credentials, a.auth))
+	routes.HandleFunc("POST", "/api/go/auth/refresh", handlers.Refresh(a.auth))
+
+	// Routes for the API - Start
+	deprecatedAt := time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+	versions := []handlers.APIVersion{
+		{Version: "v1", Prefix: "/api/v1", Status: handlers.VersionCurrent},
+		{Version: "legacy", Prefix: "/api/go", Status: handlers.VersionDeprecated, DeprecatedAt: &deprecatedAt, Sunset: &a.sunset, Successor: "/api/v1"},
+	}
+	api.reads, api.writes, api.admin = reads, writes, admin
+	api.heavy = handlers.Mw("heavy_admission", a.heavy.Middleware)
+	api.idempotency = handlers.Mw("idempotency", a.idempotency.Middleware)
+	RegisterV1Routes(routes.Prefix("/api/v1"), api)
+	RegisterV1Routes(routes.Prefix("/api/go", handlers.Mw("deprecated", versions[1].Middleware)), api)
+	routes.HandleFunc("GET", "/api/versions", handlers.VersionsHandler(versions))
+	routes.Handle("GET", "/api/go/audit", handlers.GetAuditLog(api.audit), admin)
+	// The dashboard polls stats, so they are cached for a few seconds
+	statsCache := handlers.NewResponseCache(env.Duration("STATS_CACHE_TTL", 5*time.Second), 0, 16)
+	routes.Handle("GET", "/api/go/stats/users", handlers.GetUserStats(api.users, statsCache), admin)
+	// Routes for the API - End
+
+	// Fault rules
+	if a.faults != nil {
+		routes.Handle("GET", "/api/go/admin/faults", handlers.ListFaults(a.faults), admin)
+		routes.Handle("POST", "/api/go/admin/faults", handlers.CreateFault(a.faults), admin)
+		routes.Handle("DELETE", "/api/go/admin/faults/{id}", handlers.DeleteFault(a.faults), admin)
+	}
+
+	// Liveness, and readiness that fails while draining for shutdown or
+	// when the database is down
+	routes.HandleFunc("GET", "/healthz", handlers.HealthzHandler)
+	routes.HandleFunc("GET", "/livez", handlers.HealthzHandler)
+	routes.HandleFunc("GET", "/readyz", a.readiness.Handler)
+	routes.HandleFunc("GET", "/api/go/health", handlers.HealthHandler(a.db, a.startup))
+
+	// Deletion receipts
+	if api.receipts != nil {
+		routes.HandleFunc("GET", "/api/go/.well-known/receipts-key", handlers.ReceiptKeysHandler(api.receipts))
+		routes.Handle("GET", "/api/go/admin/receipts/{id}", handlers.GetReceipt(api.stored), admin)
+	}
+
+	// Growth limits
+	routes.Handle("POST", "/api/go/admin/growth/override", handlers.OverrideGrowth(api.growth), admin)
+
+	routes.Handle("POST", "/api/go/admin/retention/run", handlers.RunRetention(a.retention), admin)
+	routes.Handle("GET", "/api/go/admin/retention/status", handlers.GetRetentionStatus(a.retention), admin)
+	routes.HandleFunc("GET", "/api/go/admin/shapes", handlers.ShapeUsageHandler, admin)
+
+	// Docs
+	routes.HandleFunc("GET", "/api/go/docs/examples", handlers.ExamplesHandler)
+	routes.Handle("GET", "/api/go/openapi.json", handlers.OpenAPIHandler(routes))
+	routes.HandleFunc("GET", "/api/go/docs", handlers.DocsPageHandler)
+
+	// Middleware stack of every route, for debugging
+	// ServeMetrics in front of them only answers /metrics, so it is not
+	// listed.
+	routes.Outer("base_path", "probe", "request_log", "cors", "startup")
+	routes.Handle("GET", "/api/go/routes", handlers.RoutesHandler(routes), admin)
+	return routes
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+func TestMain(m *testing.M) {
+	handlers.RegisterUserExamples()
+	os.Exit(m.Run())
+}
+
+const testAdminKey = "test-admin-key"
+
+// Every route of the API on memory stores
+type testApp struct {
+	store   *store.Memory
+	auth    *handlers.Auth
+	routes  *handlers.RouteTable
+	handler http.Handler
+}
+
+// Register the routes main serves on memory stores
+// Options can turn on what main only serves when configured.
+func newTestApp(t *testing.T, options ...func(*app)) *testApp {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret-test-secret-test-secret")
+	t.Setenv("ADMIN_API_KEY", testAdminKey)
+	t.Setenv("BCRYPT_COST", "4")
+
+	mem := store.NewMemory()
+	kv := store.NewMemoryKV()
+	uploads, err := store.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := handlers.NewResponseCache(time.Minute, 0, 100)
+	userCache := handlers.NewUserCache()
+	events := handlers.NewEventHub()
+	growth := &handlers.GrowthGuard{Table: "users"}
+	retention, err := handlers.NewRetention(nil, mem, uploads, cache, userCache, events, growth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := handlers.NewAuth(mem.RefreshTokens())
+	startup := &handlers.Startup{}
+	// Rates of 0 turn the limits off, tests send more than a burst
+	limits := handlers.NewRateLimits(kv, handlers.RateLimitConfig{ReadBurst: 1, WriteBurst: 1})
+
+	a := app{
+		startup:     startup,
+		readiness:   &handlers.Readiness{Startup: startup},
+		metrics:     true,
+		limits:      limits,
+		auth:        auth,
+		heavy:       handlers.NewHeavyAdmission(),
+		idempotency: handlers.NewIdempotency(store.NewKVIdempotency(kv)),
+		retention:   retention,
+		sunset:      time.Now().AddDate(1, 0, 0),
+		api: userAPI{
+			txs:         mem,
+			users:       mem,
+			credentials: mem,
+			audit:       mem.Audit(),
+			stored:      mem.Receipts(),
+			cache:       cache,
+			userCache:   userCache,
+			events:      events,
+			growth:      growth,
+			emails:      handlers.NewEmailPolicy(),
+			uploads:     uploads,
+		},
+	}
+	for _, option := range options {
+		option(&a)
+	}
+	router := mux.NewRouter()
+	routes := RegisterRoutes(router, a)
+	return &testApp{store: mem, auth: auth, routes: routes, handler: router}
+}
+
+// Create a user that can log in with password
+func (a *testApp) createUser(t *testing.T, name, email, password string) models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := a.store.CreateWithPassword(context.Background(), models.User{Name: name, Email: email}, string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// Serve a request with the given headers
+func (a *testApp) do(t *testing.T, req *http.Request, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, req)
+	return w
+}
+
+// Fail unless route runs through the named middleware, in this order
+// Other middleware may come between them.
+func AssertMiddleware(t *testing.T, route handlers.RouteInfo, names ...string) {
+	t.Helper()
+	stack := route.Middleware
+	for _, name := range names {
+		i := slices.Index(stack, name)
+		if i < 0 {
+			t.Errorf("%s %s runs through %s, want %s in that order", route.Method, route.Path, strings.Join(route.Middleware, " > "), strings.Join(names, " > "))
+			return
+		}
+		stack = stack[i+1:]
+	}
+}
+
+// Admin only routes outside /admin/, after the version prefix
+var adminRoutes = []string{
+	"GET /users/snapshot",
+	"POST /users/{id}/restore",
+	"GET /users/{id}/audit",
+	"GET /audit",
+	"GET /stats/users",
+	"GET /routes",
+}
+
+// Writes anyone may send, to get an account or a token
+var publicWrites = []string{
+	"POST /auth/register",
+	"POST /auth/login",
+	"POST /auth/refresh",
+}
+
+func TestRoutesHaveTheirMiddleware(t *testing.T) {
+	routes := newTestApp(t, withOptionalRoutes(t)).routes.Routes()
+	if len(routes) == 0 {
+		t.Fatal("no routes")
+	}
+	for _, route := range routes {
+		seen := map[string]bool{}
+		for _, name := range route.Middleware {
+			if seen[name] {
+				t.Errorf("%s %s lists %s twice: %s", route.Method, route.Path, name, strings.Join(route.Middleware, " > "))
+			}
+			seen[name] = true
+		}
+		AssertMiddleware(t, route, "request_log", "metrics", "response_guard", "recover", "rate_limit", "body_limit")
+
+		path := route.Path
+		for _, prefix := range []string{"/api/v1", "/api/go"} {
+			path = strings.TrimPrefix(path, prefix)
+		}
+		key := route.Method + " " + path
+		switch {
+		case strings.HasPrefix(path, "/admin/") || slices.Contains(adminRoutes, key):
+			AssertMiddleware(t, route, "admin")
+		case route.Method != http.MethodGet && route.Method != "*" && !slices.Contains(publicWrites, key):
+			AssertMiddleware(t, route, "auth")
+		}
+		if key == "POST /users" || key == "POST /users/bulk" {
+			AssertMiddleware(t, route, "auth", "idempotency")
+		}
+		if strings.HasPrefix(route.Path, "/api/go/users") {
+			AssertMiddleware(t, route, "deprecated")
+		}
+	}
+}
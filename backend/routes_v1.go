@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// What the user routes are served with
+type userAPI struct {
+	db          *sql.DB
+	txs         store.Transactor
+	users       store.UserStore
+	credentials store.CredentialStore
+	audit       store.AuditStore
+	stored      store.ReceiptStore
+	collations  *store.Collations
+	cache       *handlers.ResponseCache
+	userCache   *handlers.UserCache
+	events      *handlers.EventHub
+	growth      *handlers.GrowthGuard
+	emails      *handlers.EmailPolicy
+	receipts    *handlers.ReceiptSigner
+	uploads     store.Storage
+
+	reads, writes, admin, heavy, idempotency handlers.NamedMiddleware
+}
+
+// Register the user routes of v1 on a route table for a version prefix
+// A v2 with other handlers or serializers gets a function of its own, so
+// both can be served side by side.
+func RegisterV1Routes(routes *handlers.RouteTable, api userAPI) {
+	routes.Handle("GET", "/users", handlers.GetUsers(api.users, api.cache, api.collations), api.reads)
+	routes.Handle("POST", "/users", handlers.CreateUsers(api.txs, api.cache, api.userCache, api.events, api.growth, api.emails), api.writes, api.idempotency)
+	routes.Handle("POST", "/users/bulk", handlers.CreateUsersBulk(api.txs, api.cache, api.userCache, api.events, api.growth, api.emails), api.writes, api.idempotency)
+	routes.Handle("GET", "/users/snapshot", handlers.SnapshotUsers(api.db), api.admin, api.heavy)
+	routes.Handle("GET", "/users/events", handlers.UserEvents(api.events), api.reads)
+	routes.Handle("GET", "/users/export", handlers.ExportUsers(api.users), api.reads, api.heavy)
+	routes.Handle("GET", "/users/{id}", handlers.GetUsersId(api.users, api.userCache), api.reads)
+	routes.Handle("PUT", "/users/{id}", handlers.UpdateUser(api.txs, api.cache, api.userCache, api.events), api.writes)
+	routes.Handle("PATCH", "/users/{id}", handlers.PatchUser(api.txs, api.cache, api.userCache, api.events), api.writes)
+	routes.Handle("DELETE", "/users/{id}", handlers.DeleteUser(api.txs, api.stored, api.cache, api.userCache, api.events, api.growth, api.receipts, api.uploads), api.writes)
+	routes.Handle("POST", "/users/{id}/restore", handlers.RestoreUser(api.txs, api.cache, api.userCache, api.events), api.admin)
+	routes.Handle("GET", "/users/{id}/audit", handlers.GetUserAudit(api.audit), api.admin)
+	routes.Handle("POST", "/users/{id}/avatar", handlers.UploadAvatar(api.txs, api.cache, api.userCache, api.events, api.uploads), api.writes)
+	routes.Handle("GET", "/users/{id}/avatar", handlers.GetAvatar(api.users, api.userCache, api.uploads), api.reads)
+	routes.Handle("DELETE", "/users/{id}/avatar", handlers.DeleteAvatar(api.txs, api.cache, api.userCache, api.events, api.uploads), api.writes)
+}
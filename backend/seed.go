@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/models"
+	"github.com/ShardenduMishra22/go-nextjs/internal/store"
+)
+
+// Users inserted per statement while seeding
+const seedBatchSize = 500
+
+// Number of users generated by each seed profile
+var seedProfiles = map[string]int{
+	"small":  100,
+	"medium": 10000,
+	"large":  100000,
+}
+
+// Value picked with a relative weight
+type weighted struct {
+	value  string
+	weight int
+}
+
+var seedFirstNames = []weighted{
+	{"James", 30}, {"Maria", 28}, {"Wei", 20}, {"Aisha", 15}, {"Carlos", 14},
+	{"Priya", 12}, {"Olga", 8}, {"Kenji", 7}, {"Fatima", 6}, {"Liam", 6},
+	{"Ingrid", 3}, {"José", 3}, {"Zoë", 2}, {"Björn", 1}, {"Nguyễn", 1},
+}
+
+var seedLastNames = []weighted{
+	{"Smith", 30}, {"Garcia", 22}, {"Wang", 20}, {"Khan", 14}, {"Müller", 10},
+	{"Silva", 10}, {"Kim", 9}, {"Novak", 6}, {"O'Brien", 4}, {"Ivanova", 4},
+	{"López", 3}, {"Andersson", 2}, {"Østergaard", 1},
+}
+
+var seedEmailDomains = []weighted{
+	{"gmail.com", 50}, {"outlook.com", 20}, {"yahoo.com", 12}, {"example.com", 10},
+	{"proton.me", 5}, {"company.io", 3},
+}
+
+// Pick a value, more often the heavier ones
+func pickWeighted(rng *rand.Rand, values []weighted) string {
+	total := 0
+	for _, v := range values {
+		total += v.weight
+	}
+	n := rng.Intn(total)
+	for _, v := range values {
+		if n < v.weight {
+			return v.value
+		}
+		n -= v.weight
+	}
+	return values[len(values)-1].value
+}
+
+// Local part of an email built from a name
+func emailLocalPart(first, last string, n int) string {
+	local := strings.ToLower(first + "." + last)
+	local = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || r == '.' {
+			return r
+		}
+		return -1
+	}, local)
+	return fmt.Sprintf("%s%d", local, n)
+}
+
+// Generate users for a profile, the same seed always giving the same users
+func generateSeedUsers(count int, seed int64) []models.User {
+	rng := rand.New(rand.NewSource(seed))
+
+	users := make([]models.User, count)
+	for i := range users {
+		first := pickWeighted(rng, seedFirstNames)
+		last := pickWeighted(rng, seedLastNames)
+		domain := pickWeighted(rng, seedEmailDomains)
+		users[i] = models.User{
+			Name:  first + " " + last,
+			Email: emailLocalPart(first, last, i+1) + "@" + domain,
+		}
+	}
+	return users
+}
+
+// Insert users in multi-row batches
+func insertSeedUsers(db *sql.DB, users []models.User) error {
+	for start := 0; start < len(users); start += seedBatchSize {
+		end := start + seedBatchSize
+		if end > len(users) {
+			end = len(users)
+		}
+
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 2*(end-start))
+		for i, user := range users[start:end] {
+			values = append(values, fmt.Sprintf("($%d, $%d)", 2*i+1, 2*i+2))
+			args = append(args, user.Name, user.Email)
+		}
+
+		// Emails are unique, so seeding twice skips the users already there
+		_, err := db.Exec("INSERT INTO users (name, email) VALUES "+strings.Join(values, ", ")+" ON CONFLICT DO NOTHING", args...)
+		if err != nil {
+			return err
+		}
+		log.Printf("Seeded %d/%d users", end, len(users))
+	}
+	return nil
+}
+
+// seed subcommand
+func runSeed(config Config, args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	profile := fs.String("profile", "small", "volume of data: small, medium or large")
+	seed := fs.Int64("seed", 1, "random seed, the same seed produces the same data")
+	fs.Parse(args)
+
+	count, ok := seedProfiles[*profile]
+	if !ok {
+		log.Fatalf("Unknown seed profile %q", *profile)
+	}
+
+	db := ConnectDatabase(config)
+	defer db.Close()
+	if err := store.Migrate(db); err != nil {
+		log.Fatal("Migrating the database failed:", err)
+	}
+
+	err := insertSeedUsers(db, generateSeedUsers(count, *seed))
+	if err != nil {
+		log.Fatal("Seeding failed:", err)
+	}
+}
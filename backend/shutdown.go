@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// Ends the process on a second signal, replaced in tests
+var exit = os.Exit
+
+// Block until SIGINT/SIGTERM, then drain and shut the server down
+func waitForShutdown(config ServerConfig, server *http.Server, readiness *handlers.Readiness, cancelRequests context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	shutdown(config, server, readiness, cancelRequests, signals)
+}
+
+// Drain and shut the server down after the first signal
+// Readiness fails first and traffic is still served for DRAIN_SECONDS so the
+// load balancer notices. Requests still running after SHUTDOWN_TIMEOUT are
+// cancelled with cancelRequests, which stops their queries too. A second
+// signal at any point cancels them, closes every connection and exits at
+// once.
+func shutdown(config ServerConfig, server *http.Server, readiness *handlers.Readiness, cancelRequests context.CancelFunc, signals <-chan os.Signal) {
+	sig := <-signals
+	drain := config.DrainPeriod
+	log.Printf("Received %s, failing readiness and draining for %s", sig, drain)
+	readiness.StartDrain()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	forced := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s again, cancelling requests and exiting now", sig)
+			cancelRequests()
+			server.Close()
+			close(forced)
+			exit(1)
+		case <-stopped:
+		}
+	}()
+
+	select {
+	case <-time.After(drain):
+		log.Println("Drain period over")
+	case <-forced:
+		return
+	}
+
+	timeout := config.ShutdownTimeout
+	log.Printf("Shutting down the server, waiting up to %s for requests in flight", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	select {
+	case <-forced:
+		return
+	default:
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Requests still running after %s, cancelling them", timeout)
+		cancelRequests()
+		err = server.Close()
+	}
+	if err != nil {
+		log.Printf("Error shutting down the server: %v", err)
+	}
+	handlers.FlushErrorReports(2 * time.Second)
+	log.Println("Server stopped")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// A server whose requests block until release is closed, and the shutdown
+// of it running in the background
+type shutdownTest struct {
+	signals   chan os.Signal
+	started   chan struct{}
+	release   chan struct{}
+	readiness *handlers.Readiness
+	cancelled atomic.Bool
+	exits     chan int
+	done      chan struct{}
+	url       string
+}
+
+func startShutdownTest(t *testing.T, config ServerConfig) *shutdownTest {
+	st := &shutdownTest{
+		signals:   make(chan os.Signal, 2),
+		started:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+		readiness: &handlers.Readiness{Startup: &handlers.Startup{}, DB: unreachableDB(t)},
+		exits:     make(chan int, 1),
+		done:      make(chan struct{}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st.started <- struct{}{}
+		<-st.release
+	}))
+	t.Cleanup(func() {
+		close(st.release)
+		server.Close()
+	})
+	st.url = server.URL
+
+	exit = func(code int) { st.exits <- code }
+	t.Cleanup(func() { exit = os.Exit })
+	go func() {
+		defer close(st.done)
+		shutdown(config, server.Config, st.readiness, func() { st.cancelled.Store(true) }, st.signals)
+	}()
+	return st
+}
+
+// Database nothing listens for, so readiness fails fast unless draining
+func unreachableDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("postgres", "postgres://127.0.0.1:1/none?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Whether readiness reports the server as draining
+func (st *shutdownTest) draining() bool {
+	w := httptest.NewRecorder()
+	st.readiness.Handler(w, httptest.NewRequest("GET", "/readyz", nil))
+	return strings.Contains(w.Body.String(), `"draining"`)
+}
+
+// Wait for the shutdown to return
+func (st *shutdownTest) wait(t *testing.T, within time.Duration) {
+	t.Helper()
+	select {
+	case <-st.done:
+	case <-time.After(within):
+		t.Fatalf("shutdown still running after %s", within)
+	}
+}
+
+func TestShutdownDrainsAndStops(t *testing.T) {
+	st := startShutdownTest(t, ServerConfig{DrainPeriod: 0, ShutdownTimeout: time.Second})
+	st.signals <- syscall.SIGTERM
+	st.wait(t, 5*time.Second)
+
+	if !st.draining() {
+		t.Fatal("readiness was not failed")
+	}
+	select {
+	case code := <-st.exits:
+		t.Fatalf("one signal exited with %d", code)
+	default:
+	}
+	if st.cancelled.Load() {
+		t.Fatal("requests were cancelled without any running")
+	}
+}
+
+func TestSecondSignalExitsDuringTheDrain(t *testing.T) {
+	st := startShutdownTest(t, ServerConfig{DrainPeriod: time.Hour, ShutdownTimeout: time.Hour})
+	st.signals <- syscall.SIGTERM
+	st.signals <- syscall.SIGINT
+
+	select {
+	case code := <-st.exits:
+		if code != 1 {
+			t.Fatalf("exit code %d, want 1", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal did not exit")
+	}
+	st.wait(t, 5*time.Second)
+	if !st.cancelled.Load() {
+		t.Fatal("requests were not cancelled")
+	}
+}
+
+func TestSecondSignalExitsWhileRequestsFinish(t *testing.T) {
+	st := startShutdownTest(t, ServerConfig{DrainPeriod: 0, ShutdownTimeout: time.Hour})
+	go http.Get(st.url)
+	<-st.started
+
+	st.signals <- syscall.SIGTERM
+	// Until the shutdown waits for the request
+	deadline := time.Now().Add(5 * time.Second)
+	for !st.draining() {
+		if time.Now().After(deadline) {
+			t.Fatal("shutdown did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-st.done:
+		t.Fatal("shutdown returned with a request running")
+	default:
+	}
+	st.signals <- syscall.SIGTERM
+
+	select {
+	case <-st.exits:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal did not exit while a request was running")
+	}
+	st.wait(t, 5*time.Second)
+	if !st.cancelled.Load() {
+		t.Fatal("the running request was not cancelled")
+	}
+}
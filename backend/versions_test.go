@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ShardenduMishra22/go-nextjs/internal/handlers"
+)
+
+// Both path families serve the same users, only the legacy one is deprecated
+func TestLegacyAndV1PathsServeTheSamePayloads(t *testing.T) {
+	a := newTestApp(t)
+	ada := a.createUser(t, "Ada Lovelace", "ada@example.com", "correct horse")
+	a.createUser(t, "Grace Hopper", "grace@example.com", "battery staple")
+
+	paths := []string{
+		"/users",
+		"/users?sort=-name&limit=1",
+		"/users?q=grace",
+		"/users/" + ada.Id.String(),
+		"/users/999",
+		"/users/not-a-number",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			v1 := a.send(t, "GET", "/api/v1"+path, "", "X-API-Key", testAdminKey)
+			legacy := a.send(t, "GET", "/api/go"+path, "", "X-API-Key", testAdminKey)
+			if v1.Code != legacy.Code || v1.Body.String() != legacy.Body.String() {
+				t.Fatalf("v1 = %d %s\nlegacy = %d %s", v1.Code, v1.Body, legacy.Code, legacy.Body)
+			}
+			for _, name := range []string{"Content-Type", "ETag"} {
+				if got, want := legacy.Header().Get(name), v1.Header().Get(name); got != want {
+					t.Fatalf("legacy %s = %q, v1 %q", name, got, want)
+				}
+			}
+
+			for _, name := range []string{"Deprecation", "Sunset", "Link"} {
+				if v := v1.Header().Get(name); v != "" {
+					t.Fatalf("v1 has %s: %s", name, v)
+				}
+				if legacy.Header().Get(name) == "" {
+					t.Fatalf("legacy path has no %s header", name)
+				}
+			}
+			if link := legacy.Header().Get("Link"); !strings.Contains(link, "</api/v1"+path+`>; rel="successor-version"`) || !strings.Contains(link, `</api/versions>; rel="deprecation"`) {
+				t.Fatalf("legacy Link = %q, want the v1 path and the versions list", link)
+			}
+		})
+	}
+
+	versions := decodeBody[struct {
+		Versions []handlers.APIVersion `json:"versions"`
+	}](t, a.send(t, "GET", "/api/versions", "")).Versions
+	if len(versions) != 2 || versions[0].Status != handlers.VersionCurrent || versions[1].Status != handlers.VersionDeprecated || versions[1].Successor != "/api/v1" {
+		t.Fatalf("versions = %+v", versions)
+	}
+}